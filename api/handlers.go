@@ -1,131 +1,460 @@
 package api
 
 import (
+	"encoding/gob"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/blu-fi-tech-inc/blufi-network/consensus"
 	"github.com/blu-fi-tech-inc/blufi-network/core"
 	"github.com/blu-fi-tech-inc/blufi-network/types"
-	"github.com/blu-fi-tech-inc/blufi-network/utils"
+	"github.com/go-kit/log"
 	"github.com/gorilla/mux"
 )
 
+// maxPendingTxs is the number of mempool transactions listed by the pending
+// transactions route.
+const maxPendingTxs = 1000
+
 // API struct holds the necessary dependencies for API handlers.
 type API struct {
-	txPool       *core.TxPool
-	encoder      core.Encoder
-	decoder      core.Decoder
+	txPool       TxPool
 	stakeManager *consensus.StakeManager
-	pos          *consensus.PoS
+	chain        *core.Blockchain
+	peerAdmin    PeerAdmin
+	syncProgress SyncProgress
+	txSubmitter  TxSubmitter
+}
+
+// TxPool gives the API access to the transactions waiting in the mempool of
+// the node. It is implemented by network.TxPool.
+type TxPool interface {
+	Pending(n int) []*core.Transaction
+}
+
+// PendingTx describes a transaction waiting in the mempool.
+type PendingTx struct {
+	Hash  string `json:"hash"`
+	From  string `json:"from"`
+	To    string `json:"to"`
+	Value uint64 `json:"value"`
+	Nonce uint64 `json:"nonce"`
+	Fee   uint64 `json:"fee"`
+}
+
+// UnbondingRelease is unstaked funds that return to the balance of their
+// account at the given height.
+type UnbondingRelease struct {
+	Amount uint64 `json:"amount"`
+	Height uint32 `json:"height"`
+}
+
+// BannedPeer is a peer host banned by the node for misbehaving.
+type BannedPeer struct {
+	Host   string    `json:"host"`
+	Until  time.Time `json:"until"`
+	Reason string    `json:"reason"`
+}
+
+// PeerAdmin gives the API access to the peer bans of the node.
+type PeerAdmin interface {
+	BannedPeers() []BannedPeer
+	UnbanPeer(host string) bool
+}
+
+// SyncStatus describes the progress of block sync with the peers of the node
+// and the latest block finalized by the validators.
+type SyncStatus struct {
+	Syncing         bool   `json:"syncing"`
+	Height          uint32 `json:"height"`
+	TargetHeight    uint32 `json:"targetHeight"`
+	HeadersHeight   uint32 `json:"headersHeight"`
+	Downloaded      int    `json:"downloaded"`
+	InFlight        int    `json:"inFlight"`
+	Peers           int    `json:"peers"`
+	FinalizedHeight uint32 `json:"finalizedHeight"` // Height of the latest finalized block.
+	FinalizedHash   string `json:"finalizedHash"`   // Hash of the latest finalized block.
+}
+
+// SyncProgress gives the API access to the sync progress of the node.
+type SyncProgress interface {
+	SyncStatus() SyncStatus
+}
+
+// TxSubmitter accepts the transactions submitted to the API. Errors that
+// implement RejectReason() string are reported to the caller with that reason.
+type TxSubmitter interface {
+	SubmitTx(tx *core.Transaction) error
+}
+
+// TxRejection is returned to callers whose transaction was not accepted.
+type TxRejection struct {
+	Hash   string `json:"hash"`
+	Reason string `json:"reason"`
+	Error  string `json:"error"`
 }
 
 // NewAPI initializes a new API instance.
-func NewAPI(txPool *core.TxPool, encoder core.Encoder, decoder core.Decoder, stakeManager *consensus.StakeManager, pos *consensus.PoS) *API {
+func NewAPI(txPool TxPool, stakeManager *consensus.StakeManager, chain *core.Blockchain) *API {
 	return &API{
 		txPool:       txPool,
-		encoder:      encoder,
-		decoder:      decoder,
 		stakeManager: stakeManager,
-		pos:          pos,
+		chain:        chain,
 	}
 }
 
-// RegisterRoutes registers all API routes with the provided router.
+// SetPeerAdmin sets the node whose peer bans are served by the API. The peer
+// routes respond with 503 until it is set.
+func (a *API) SetPeerAdmin(p PeerAdmin) {
+	a.peerAdmin = p
+}
+
+// SetSyncProgress sets the node whose sync progress is served by the API. The
+// sync route responds with 503 until it is set.
+func (a *API) SetSyncProgress(p SyncProgress) {
+	a.syncProgress = p
+}
+
+// SetTxSubmitter sets the node new transactions are submitted to. The
+// transaction route responds with 503 until it is set.
+func (a *API) SetTxSubmitter(s TxSubmitter) {
+	a.txSubmitter = s
+}
+
+// RegisterRoutes registers the public API routes with the provided router.
 func (a *API) RegisterRoutes(r *mux.Router) {
 	r.HandleFunc("/transactions", a.handleNewTransaction).Methods("POST")
 	r.HandleFunc("/transactions/pending", a.handleGetPendingTransactions).Methods("GET")
 	r.HandleFunc("/blocks", a.handleNewBlock).Methods("POST")
-	r.HandleFunc("/stake", a.handleAddStake).Methods("POST")
 	r.HandleFunc("/stake/{address}", a.handleGetStake).Methods("GET")
+	r.HandleFunc("/accounts/{address}/nonce", a.handleGetNonce).Methods("GET")
+	r.HandleFunc("/transactions/{hash}/proof", a.handleGetTxProof).Methods("GET")
+	r.HandleFunc("/contracts/{address}/code", a.handleGetCode).Methods("GET")
+	r.HandleFunc("/contracts/{address}/storage/{key}", a.handleGetStorage).Methods("GET")
+	r.HandleFunc("/peers/banned", a.handleGetBannedPeers).Methods("GET")
+	r.HandleFunc("/sync", a.handleGetSyncStatus).Methods("GET")
+}
+
+// RegisterAdminRoutes registers the routes reserved to the operator of the
+// node, like lifting peer bans, with the provided router. They must not be
+// reachable from other hosts, see ServerConfig.AdminListenAddr.
+func (a *API) RegisterAdminRoutes(r *mux.Router) {
+	r.HandleFunc("/peers/banned/{host}", a.handleUnbanPeer).Methods("DELETE")
 }
 
-// handleNewTransaction handles incoming POST requests to create a new transaction.
+// handleNewTransaction handles incoming POST requests to create a new
+// transaction. The transaction is gob encoded, like on the wire.
 func (a *API) handleNewTransaction(w http.ResponseWriter, r *http.Request) {
 	var tx core.Transaction
-	err := json.NewDecoder(r.Body).Decode(&tx)
+	err := tx.Decode(gob.NewDecoder(r.Body))
 	if err != nil {
 		http.Error(w, fmt.Sprintf("error decoding transaction: %v", err), http.StatusBadRequest)
 		return
 	}
 
-	// Add transaction to the transaction pool
-	a.txPool.Add(&tx)
+	if a.txSubmitter == nil {
+		http.Error(w, "transaction submission not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	if err := a.txSubmitter.SubmitTx(&tx); err != nil {
+		reason := "invalid"
+		var rejected interface{ RejectReason() string }
+		if errors.As(err, &rejected) {
+			reason = rejected.RejectReason()
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(TxRejection{
+			Hash:   tx.Hash(core.TxHasher{}).String(),
+			Reason: reason,
+			Error:  err.Error(),
+		})
+		return
+	}
 
 	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(tx.Hash(core.TxHasher{}))
+	json.NewEncoder(w).Encode(tx.Hash(core.TxHasher{}).String())
 }
 
 // handleGetPendingTransactions handles incoming GET requests to fetch pending transactions.
 func (a *API) handleGetPendingTransactions(w http.ResponseWriter, r *http.Request) {
-	pendingTxs := a.txPool.Pending()
+	pendingTxs := []PendingTx{}
+	for _, tx := range a.txPool.Pending(maxPendingTxs) {
+		from, _ := tx.From.Address()
+		pendingTxs = append(pendingTxs, PendingTx{
+			Hash:  tx.Hash(core.TxHasher{}).String(),
+			From:  from.String(),
+			To:    tx.To.String(),
+			Value: tx.Value,
+			Nonce: tx.Nonce,
+			Fee:   tx.MaxFee(),
+		})
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(pendingTxs)
 }
 
-// handleNewBlock handles incoming POST requests to create a new block.
+// handleNewBlock handles incoming POST requests to create a new block. The
+// block is gob encoded, like on the wire.
 func (a *API) handleNewBlock(w http.ResponseWriter, r *http.Request) {
-	var block types.Block
-	err := json.NewDecoder(r.Body).Decode(&block)
+	block := new(core.Block)
+	err := block.Decode(gob.NewDecoder(r.Body))
 	if err != nil {
 		http.Error(w, fmt.Sprintf("error decoding block: %v", err), http.StatusBadRequest)
 		return
 	}
 
-	// Validate and add the block using PoS
-	if !a.pos.AddBlock(&block) {
-		http.Error(w, "block validation failed", http.StatusBadRequest)
+	// The chain checks the block, including its proposer, before adding it.
+	if err := a.chain.AddBlock(block); err != nil {
+		http.Error(w, fmt.Sprintf("block validation failed: %v", err), http.StatusBadRequest)
 		return
 	}
 
 	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(block)
+	json.NewEncoder(w).Encode(block.Hash(core.BlockHasher{}).String())
 }
 
-// handleAddStake handles incoming POST requests to add stake to an address.
-func (a *API) handleAddStake(w http.ResponseWriter, r *http.Request) {
-	var payload struct {
-		Address string `json:"address"`
-		Amount  uint64 `json:"amount"`
+// handleGetStake handles incoming GET requests to fetch the stake bonded by an
+// address, its funds still unbonding and whether it was jailed for
+// double-signing. Stake is bonded and unbonded with StakeTx and UnstakeTx
+// transactions.
+func (a *API) handleGetStake(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	address, err := decodeAddress(vars["address"])
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error decoding address: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	var (
+		unbonding uint64
+		releases  = []UnbondingRelease{}
+	)
+	for _, entry := range a.chain.Unbonding(address) {
+		unbonding += entry.Amount
+		releases = append(releases, UnbondingRelease{Amount: entry.Amount, Height: entry.Height})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Address   string             `json:"address"`
+		Stake     uint64             `json:"stake"`
+		Unbonding uint64             `json:"unbonding"`
+		Releases  []UnbondingRelease `json:"releases"`
+		Jailed    bool               `json:"jailed"`
+	}{
+		Address:   address.String(),
+		Stake:     a.stakeManager.GetStake(address),
+		Unbonding: unbonding,
+		Releases:  releases,
+		Jailed:    a.stakeManager.IsJailed(address),
+	})
+}
+
+// handleGetNonce handles incoming GET requests to fetch the nonce expected on
+// the next transaction sent by an address.
+func (a *API) handleGetNonce(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	address, err := decodeAddress(vars["address"])
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error decoding address: %v", err), http.StatusBadRequest)
+		return
 	}
-	err := json.NewDecoder(r.Body).Decode(&payload)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Address string `json:"address"`
+		Nonce   uint64 `json:"nonce"`
+	}{
+		Address: address.String(),
+		Nonce:   a.chain.NextNonce(address),
+	})
+}
+
+// handleGetTxProof handles incoming GET requests to fetch a Merkle proof that a
+// transaction is included in a block.
+func (a *API) handleGetTxProof(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	hash, err := decodeHash(vars["hash"])
 	if err != nil {
-		http.Error(w, fmt.Sprintf("error decoding payload: %v", err), http.StatusBadRequest)
+		http.Error(w, fmt.Sprintf("error decoding hash: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	if _, err := a.chain.GetTxByHash(hash); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
 		return
 	}
 
-	err = a.stakeManager.AddStake(payload.Address, payload.Amount)
+	block, proof, err := a.chain.GetTxProof(hash)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("error adding stake: %v", err), http.StatusInternalServerError)
+		http.Error(w, fmt.Sprintf("error building proof: %v", err), http.StatusUnprocessableEntity)
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
+	siblings := make([]string, len(proof.Siblings))
+	for i, sibling := range proof.Siblings {
+		siblings[i] = sibling.String()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		TxHash    string   `json:"txHash"`
+		BlockHash string   `json:"blockHash"`
+		Height    uint32   `json:"height"`
+		TxRoot    string   `json:"txRoot"`
+		Index     uint32   `json:"index"`
+		Total     uint32   `json:"total"`
+		Siblings  []string `json:"siblings"`
+	}{
+		TxHash:    proof.TxHash.String(),
+		BlockHash: block.Hash(core.BlockHasher{}).String(),
+		Height:    block.Height,
+		TxRoot:    block.DataHash.String(),
+		Index:     proof.Index,
+		Total:     proof.Total,
+		Siblings:  siblings,
+	})
 }
 
-// handleGetStake handles incoming GET requests to fetch the stake of an address.
-func (a *API) handleGetStake(w http.ResponseWriter, r *http.Request) {
+// handleGetCode handles incoming GET requests to fetch the code of a contract.
+func (a *API) handleGetCode(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
-	address := vars["address"]
 
-	stake, err := a.stakeManager.GetStake(address)
+	address, err := decodeAddress(vars["address"])
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error decoding address: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	code, err := a.chain.GetCode(address)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("error fetching stake: %v", err), http.StatusInternalServerError)
+		http.Error(w, fmt.Sprintf("no contract at address (%s)", address), http.StatusNotFound)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(stake)
+	json.NewEncoder(w).Encode(struct {
+		Address string `json:"address"`
+		Code    string `json:"code"`
+	}{
+		Address: address.String(),
+		Code:    hex.EncodeToString(code),
+	})
 }
 
-// Middleware example: Logging middleware for request tracing.
-func LoggingMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Log request details
-		utils.Log(fmt.Sprintf("Request received: %s %s", r.Method, r.URL.Path))
-		// Call the next handler
-		next.ServeHTTP(w, r)
+// handleGetStorage handles incoming GET requests to fetch an entry of the
+// storage of a contract. The key is hex encoded.
+func (a *API) handleGetStorage(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	address, err := decodeAddress(vars["address"])
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error decoding address: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	key, err := hex.DecodeString(vars["key"])
+	if err != nil || len(key) == 0 {
+		http.Error(w, "error decoding key: expected non-empty hex", http.StatusBadRequest)
+		return
+	}
+
+	value, err := a.chain.GetStorage(address, key)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("no storage entry (%s) for contract (%s)", vars["key"], address), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Address string `json:"address"`
+		Key     string `json:"key"`
+		Value   string `json:"value"`
+	}{
+		Address: address.String(),
+		Key:     hex.EncodeToString(key),
+		Value:   hex.EncodeToString(value),
 	})
 }
+
+// handleGetBannedPeers handles incoming GET requests to list the banned peers.
+func (a *API) handleGetBannedPeers(w http.ResponseWriter, r *http.Request) {
+	if a.peerAdmin == nil {
+		http.Error(w, "peer administration not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(a.peerAdmin.BannedPeers())
+}
+
+// handleUnbanPeer handles incoming DELETE requests to lift the ban of a peer
+// host.
+func (a *API) handleUnbanPeer(w http.ResponseWriter, r *http.Request) {
+	if a.peerAdmin == nil {
+		http.Error(w, "peer administration not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	host := mux.Vars(r)["host"]
+	if !a.peerAdmin.UnbanPeer(host) {
+		http.Error(w, fmt.Sprintf("peer (%s) is not banned", host), http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleGetSyncStatus handles incoming GET requests for the sync progress.
+func (a *API) handleGetSyncStatus(w http.ResponseWriter, r *http.Request) {
+	if a.syncProgress == nil {
+		http.Error(w, "sync status not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(a.syncProgress.SyncStatus())
+}
+
+// decodeHash parses a hex encoded hash.
+func decodeHash(s string) (types.Hash, error) {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return types.Hash{}, err
+	}
+
+	return types.HashFromBytes(b)
+}
+
+// decodeAddress parses a hex encoded address.
+func decodeAddress(s string) (types.Address, error) {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return types.Address{}, err
+	}
+
+	return types.AddressFromBytes(b)
+}
+
+// LoggingMiddleware logs the requests served by the API for request tracing.
+func LoggingMiddleware(logger log.Logger) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// Log request details
+			logger.Log("msg", "request received", "method", r.Method, "path", r.URL.Path)
+			// Call the next handler
+			next.ServeHTTP(w, r)
+		})
+	}
+}
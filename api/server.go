@@ -1,35 +1,120 @@
 package api
 
 import (
-	"log"
+	"errors"
+	"fmt"
+	"net"
 	"net/http"
 
 	"github.com/blu-fi-tech-inc/blufi-network/consensus"
 	"github.com/blu-fi-tech-inc/blufi-network/core"
+	"github.com/go-kit/log"
 	"github.com/gorilla/mux"
 )
 
+// ErrAdminNotLocal is returned by Start when the admin routes would be
+// reachable from other hosts.
+var ErrAdminNotLocal = errors.New("admin API must listen on a loopback address")
+
+// ServerConfig configures the API server.
+type ServerConfig struct {
+	Logger     log.Logger
+	ListenAddr string
+	// AdminListenAddr is where the admin routes, reserved to the operator of
+	// the node, are served. It must be a loopback address, like
+	// 127.0.0.1:9001. The admin routes are not served if it is empty.
+	AdminListenAddr string
+}
+
 // Server represents the API server.
 type Server struct {
+	ServerConfig
 	api *API
 }
 
-// NewServer initializes a new API server instance.
-func NewServer(txPool *core.TxPool, encoder core.Encoder, decoder core.Decoder, stakeManager *consensus.StakeManager, pos *consensus.PoS) *Server {
-	api := NewAPI(txPool, encoder, decoder, stakeManager, pos)
-	return &Server{api: api}
+// NewServer initializes a new API server instance serving the chain, its
+// stake table and the mempool of the node.
+func NewServer(cfg ServerConfig, chain *core.Blockchain, stakeManager *consensus.StakeManager, txPool TxPool) *Server {
+	if cfg.Logger == nil {
+		cfg.Logger = log.NewNopLogger()
+	}
+
+	return &Server{
+		ServerConfig: cfg,
+		api:          NewAPI(txPool, stakeManager, chain),
+	}
+}
+
+// SetPeerAdmin sets the node whose peer bans are served by the API. It must be
+// called before Start.
+func (s *Server) SetPeerAdmin(p PeerAdmin) {
+	s.api.SetPeerAdmin(p)
+}
+
+// SetSyncProgress sets the node whose sync progress is served by the API. It
+// must be called before Start.
+func (s *Server) SetSyncProgress(p SyncProgress) {
+	s.api.SetSyncProgress(p)
 }
 
-// Start initializes and starts the API server.
-func (s *Server) Start(port string) {
+// SetTxSubmitter sets the node new transactions are submitted to. It must be
+// called before Start.
+func (s *Server) SetTxSubmitter(t TxSubmitter) {
+	s.api.SetTxSubmitter(t)
+}
+
+// Handler returns the router serving the API routes.
+func (s *Server) Handler() http.Handler {
 	r := mux.NewRouter()
 	s.api.RegisterRoutes(r)
 
 	// Add logging middleware
-	r.Use(LoggingMiddleware)
+	r.Use(LoggingMiddleware(s.Logger))
+
+	return r
+}
 
-	log.Printf("Starting server on %s", port)
-	if err := http.ListenAndServe(port, r); err != nil {
-		log.Fatalf("Could not start server: %s", err)
+// AdminHandler returns the router serving the admin API routes.
+func (s *Server) AdminHandler() http.Handler {
+	r := mux.NewRouter()
+	s.api.RegisterAdminRoutes(r)
+
+	r.Use(LoggingMiddleware(s.Logger))
+
+	return r
+}
+
+// Start serves the API on the configured addresses. It blocks until one of
+// the servers fails.
+func (s *Server) Start() error {
+	errCh := make(chan error, 2)
+
+	if len(s.AdminListenAddr) > 0 {
+		if !isLoopback(s.AdminListenAddr) {
+			return fmt.Errorf("%w: (%s)", ErrAdminNotLocal, s.AdminListenAddr)
+		}
+
+		s.Logger.Log("msg", "starting admin API server", "addr", s.AdminListenAddr)
+		go func() {
+			errCh <- http.ListenAndServe(s.AdminListenAddr, s.AdminHandler())
+		}()
 	}
+
+	s.Logger.Log("msg", "starting API server", "addr", s.ListenAddr)
+	go func() {
+		errCh <- http.ListenAndServe(s.ListenAddr, s.Handler())
+	}()
+
+	return <-errCh
+}
+
+// isLoopback reports whether addr only accepts connections from this host.
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
 }
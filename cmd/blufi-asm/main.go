@@ -0,0 +1,81 @@
+// Command blufi-asm assembles VM assembly into hex encoded bytecode, ready to
+// be used as Transaction.Data, and disassembles bytecode back into assembly.
+//
+// Usage:
+//
+//	blufi-asm [file]       assemble file, or stdin, and print the bytecode as hex
+//	blufi-asm -d [file]    disassemble hex bytecode from file, or stdin
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/blu-fi-tech-inc/blufi-network/core/asm"
+)
+
+func main() {
+	disassemble := flag.Bool("d", false, "disassemble hex bytecode instead of assembling")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: blufi-asm [-d] [file]\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() > 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	name, input, err := readInput(flag.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	if *disassemble {
+		code, err := hex.DecodeString(strings.TrimSpace(string(input)))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: invalid hex: %v\n", name, err)
+			os.Exit(1)
+		}
+
+		src, err := asm.Disassemble(code)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
+			os.Exit(1)
+		}
+
+		fmt.Print(src)
+		return
+	}
+
+	code, err := asm.Assemble(string(input))
+	if err != nil {
+		var asmErr *asm.Error
+		if errors.As(err, &asmErr) {
+			fmt.Fprintf(os.Stderr, "%s:%d: %s\n", name, asmErr.Line, asmErr.Msg)
+		} else {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
+		}
+		os.Exit(1)
+	}
+
+	fmt.Println(hex.EncodeToString(code))
+}
+
+// readInput reads the named file, or stdin if name is empty.
+func readInput(name string) (string, []byte, error) {
+	if name == "" {
+		input, err := io.ReadAll(os.Stdin)
+		return "<stdin>", input, err
+	}
+
+	input, err := os.ReadFile(name)
+	return name, input, err
+}
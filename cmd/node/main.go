@@ -1,181 +1,206 @@
 package main
 
 import (
-    "bytes"
-    "encoding/json"
-    "fmt"
-    "log"
-    "net/http"
-    "time"
-
-    "github.com/blu-fi-tech-inc/blufi-network/consensus"
-    "github.com/blu-fi-tech-inc/blufi-network/core"
-    "github.com/blu-fi-tech-inc/blufi-network/crypto"
-    "github.com/blu-fi-tech-inc/blufi-network/network"
-    "github.com/blu-fi-tech-inc/blufi-network/types"
-    "github.com/blu-fi-tech-inc/blufi-network/utils"
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"path/filepath"
+	"time"
+
+	"github.com/blu-fi-tech-inc/blufi-network/core"
+	"github.com/blu-fi-tech-inc/blufi-network/crypto"
+	"github.com/blu-fi-tech-inc/blufi-network/network"
+	"github.com/blu-fi-tech-inc/blufi-network/types"
+	"github.com/blu-fi-tech-inc/blufi-network/utils"
 )
 
 func main() {
-    validatorPrivKey := crypto.GeneratePrivateKey()
+	validatorPrivKey, validatorPubKey, err := crypto.GenerateKeyPair()
+	if err != nil {
+		log.Fatalf("Failed to generate validator key: %v", err)
+	}
 
-    stakeManager := consensus.NewStakeManager()
-    pos := consensus.NewPoS([]crypto.PublicKey{validatorPrivKey.PublicKey()})
+	validatorAddress, err := validatorPubKey.Address()
+	if err != nil {
+		log.Fatalf("Failed to derive validator address: %v", err)
+	}
 
-    localNode := makeServer("LOCAL_NODE", &validatorPrivKey, ":3000", []string{":4000"}, ":9000", stakeManager, pos, "BluFi Network")
-    go localNode.Start()
+	// Every node starts from the same genesis state, with the local node as
+	// the only validator.
+	genesisStakes := map[types.Address]uint64{validatorAddress: 1000}
 
-    remoteNode := makeServer("REMOTE_NODE", nil, ":4000", []string{":5000"}, "", stakeManager, pos, "BluFi Network")
-    go remoteNode.Start()
+	localNode := makeServer("LOCAL_NODE", validatorPrivKey, ":3000", []string{":4000"}, ":9000", "127.0.0.1:9001", genesisStakes, "BluFi Network")
+	go localNode.Start()
 
-    remoteNodeB := makeServer("REMOTE_NODE_B", nil, ":5000", nil, "", stakeManager, pos, "BluFi Network")
-    go remoteNodeB.Start()
+	remoteNode := makeServer("REMOTE_NODE", nil, ":4000", []string{":5000"}, "", "", genesisStakes, "BluFi Network")
+	go remoteNode.Start()
 
-    go func() {
-        time.Sleep(11 * time.Second)
-        lateNode := makeServer("LATE_NODE", nil, ":6000", []string{":4000"}, "", stakeManager, pos, "BluFi Network")
-        go lateNode.Start()
-    }()
+	remoteNodeB := makeServer("REMOTE_NODE_B", nil, ":5000", nil, "", "", genesisStakes, "BluFi Network")
+	go remoteNodeB.Start()
 
-    // Keep the main function running
-    select {}
+	go func() {
+		time.Sleep(11 * time.Second)
+		lateNode := makeServer("LATE_NODE", nil, ":6000", []string{":4000"}, "", "", genesisStakes, "BluFi Network")
+		go lateNode.Start()
+	}()
+
+	// Keep the main function running
+	select {}
 }
 
 // sendTransaction sends a transaction from one node to another
-func sendTransaction(privKey crypto.PrivateKey) error {
-    toPrivKey := crypto.GeneratePrivateKey()
-    tx := core.NewTransaction(nil)
-    tx.To = toPrivKey.PublicKey()
-    tx.Value = 666
-
-    if err := tx.Sign(privKey); err != nil {
-        return err
-    }
-
-    buf := &bytes.Buffer{}
-    if err := tx.Encode(core.NewGobTxEncoder(buf)); err != nil {
-        return err
-    }
-
-    req, err := http.NewRequest("POST", "http://localhost:9000/tx", buf)
-    if err != nil {
-        return err
-    }
-
-    client := http.Client{}
-    resp, err := client.Do(req)
-    if err != nil {
-        return err
-    }
-    defer resp.Body.Close()
-
-    if resp.StatusCode != http.StatusOK {
-        return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
-    }
-
-    return nil
+func sendTransaction(privKey *crypto.PrivateKey) error {
+	_, toPubKey, err := crypto.GenerateKeyPair()
+	if err != nil {
+		return err
+	}
+	tx := core.NewTransaction(nil)
+	toAddress, err := toPubKey.Address()
+	if err != nil {
+		return err
+	}
+	tx.To = toAddress
+	tx.Value = 666
+
+	if err := tx.Sign(privKey); err != nil {
+		return err
+	}
+
+	buf := &bytes.Buffer{}
+	if err := tx.Encode(gob.NewEncoder(buf)); err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", "http://localhost:9000/transactions", buf)
+	if err != nil {
+		return err
+	}
+
+	client := http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	return nil
 }
 
 // makeServer creates and initializes a server with the given options
-func makeServer(id string, pk *crypto.PrivateKey, addr string, seedNodes []string, apiListenAddr string, stakeManager *consensus.StakeManager, pos *consensus.PoS, blockchainName string) *network.Server {
-    opts := network.ServerOpts{
-        APIListenAddr:  apiListenAddr,
-        SeedNodes:      seedNodes,
-        ListenAddr:     addr,
-        PrivateKey:     pk,
-        ID:             id,
-        StakeManager:   stakeManager,
-        PoS:            pos,
-        BlockchainName: blockchainName,
-    }
-
-    s, err := network.NewServer(opts)
-    if err != nil {
-        log.Fatalf("Failed to create server %s: %v", id, err)
-    }
-
-    return s
+func makeServer(id string, pk *crypto.PrivateKey, addr string, seedNodes []string, apiListenAddr string, apiAdminListenAddr string, genesisStakes map[types.Address]uint64, blockchainName string) *network.Server {
+	opts := network.ServerOpts{
+		APIListenAddr:      apiListenAddr,
+		SeedNodes:          seedNodes,
+		ListenAddr:         addr,
+		PrivateKey:         pk,
+		ID:                 id,
+		GenesisStakes:      genesisStakes,
+		APIAdminListenAddr: apiAdminListenAddr,
+		BlockchainName:     blockchainName,
+		DataDir:            filepath.Join("data", id),
+	}
+
+	s, err := network.NewServer(opts)
+	if err != nil {
+		log.Fatalf("Failed to create server %s: %v", id, err)
+	}
+
+	return s
 }
 
 // createCollectionTx creates a collection transaction and sends it to the network
-func createCollectionTx(privKey crypto.PrivateKey) types.Hash {
-    tx := core.NewTransaction(nil)
-    tx.TxInner = core.CollectionTx{
-        Fee:      200,
-        MetaData: []byte("chicken and egg collection!"),
-    }
-    if err := tx.Sign(privKey); err != nil {
-        log.Fatalf("Failed to sign collection transaction: %v", err)
-    }
-
-    buf := &bytes.Buffer{}
-    if err := tx.Encode(core.NewGobTxEncoder(buf)); err != nil {
-        log.Fatalf("Failed to encode collection transaction: %v", err)
-    }
-
-    req, err := http.NewRequest("POST", "http://localhost:9000/tx", buf)
-    if err != nil {
-        log.Fatalf("Failed to create HTTP request: %v", err)
-    }
-
-    client := http.Client{}
-    resp, err := client.Do(req)
-    if err != nil {
-        log.Fatalf("Failed to send HTTP request: %v", err)
-    }
-    defer resp.Body.Close()
-
-    if resp.StatusCode != http.StatusOK {
-        log.Fatalf("Unexpected status code: %d", resp.StatusCode)
-    }
-
-    return tx.Hash(core.TxHasher{})
+func createCollectionTx(privKey *crypto.PrivateKey) types.Hash {
+	tx := core.NewTransaction(nil)
+	tx.TxInner = core.CollectionTx{
+		Fee:      200,
+		MetaData: []byte("chicken and egg collection!"),
+	}
+	if err := tx.Sign(privKey); err != nil {
+		log.Fatalf("Failed to sign collection transaction: %v", err)
+	}
+
+	buf := &bytes.Buffer{}
+	if err := tx.Encode(gob.NewEncoder(buf)); err != nil {
+		log.Fatalf("Failed to encode collection transaction: %v", err)
+	}
+
+	req, err := http.NewRequest("POST", "http://localhost:9000/transactions", buf)
+	if err != nil {
+		log.Fatalf("Failed to create HTTP request: %v", err)
+	}
+
+	client := http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		log.Fatalf("Failed to send HTTP request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		log.Fatalf("Unexpected status code: %d", resp.StatusCode)
+	}
+
+	return tx.Hash(core.TxHasher{})
 }
 
 // nftMinter mints an NFT and sends the transaction to the network
-func nftMinter(privKey crypto.PrivateKey, collection types.Hash) {
-    metaData := map[string]interface{}{
-        "power":  8,
-        "health": 100,
-        "color":  "green",
-        "rare":   "yes",
-    }
-
-    metaBuf := new(bytes.Buffer)
-    if err := json.NewEncoder(metaBuf).Encode(metaData); err != nil {
-        log.Fatalf("Failed to encode metadata: %v", err)
-    }
-
-    tx := core.NewTransaction(nil)
-    tx.TxInner = core.MintTx{
-        Fee:             200,
-        NFT:             utils.RandomHash(),
-        MetaData:        metaBuf.Bytes(),
-        Collection:      collection,
-        CollectionOwner: privKey.PublicKey(),
-    }
-    if err := tx.Sign(privKey); err != nil {
-        log.Fatalf("Failed to sign mint transaction: %v", err)
-    }
-
-    buf := &bytes.Buffer{}
-    if err := tx.Encode(core.NewGobTxEncoder(buf)); err != nil {
-        log.Fatalf("Failed to encode mint transaction: %v", err)
-    }
-
-    req, err := http.NewRequest("POST", "http://localhost:9000/tx", buf)
-    if err != nil {
-        log.Fatalf("Failed to create HTTP request: %v", err)
-    }
-
-    client := http.Client{}
-    resp, err := client.Do(req)
-    if err != nil {
-        log.Fatalf("Failed to send HTTP request: %v", err)
-    }
-    defer resp.Body.Close()
-
-    if resp.StatusCode != http.StatusOK {
-        log.Fatalf("Unexpected status code: %d", resp.StatusCode)
-    }
+func nftMinter(privKey *crypto.PrivateKey, collection types.Hash) {
+	metaData := map[string]interface{}{
+		"power":  8,
+		"health": 100,
+		"color":  "green",
+		"rare":   "yes",
+	}
+
+	metaBuf := new(bytes.Buffer)
+	if err := json.NewEncoder(metaBuf).Encode(metaData); err != nil {
+		log.Fatalf("Failed to encode metadata: %v", err)
+	}
+
+	// The owner of the collection signs off on the mint.
+	collectionSig, err := privKey.Sign(collection[:])
+	if err != nil {
+		log.Fatalf("Failed to sign collection: %v", err)
+	}
+
+	tx := core.NewTransaction(nil)
+	tx.TxInner = core.MintTx{
+		Fee:             200,
+		NFT:             utils.RandomHash(),
+		MetaData:        metaBuf.Bytes(),
+		Collection:      collection,
+		CollectionOwner: crypto.PublicKey{PublicKey: &privKey.PublicKey},
+		Signature:       collectionSig,
+	}
+	if err := tx.Sign(privKey); err != nil {
+		log.Fatalf("Failed to sign mint transaction: %v", err)
+	}
+
+	buf := &bytes.Buffer{}
+	if err := tx.Encode(gob.NewEncoder(buf)); err != nil {
+		log.Fatalf("Failed to encode mint transaction: %v", err)
+	}
+
+	req, err := http.NewRequest("POST", "http://localhost:9000/transactions", buf)
+	if err != nil {
+		log.Fatalf("Failed to create HTTP request: %v", err)
+	}
+
+	client := http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		log.Fatalf("Failed to send HTTP request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		log.Fatalf("Unexpected status code: %d", resp.StatusCode)
+	}
 }
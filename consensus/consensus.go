@@ -1,111 +1,83 @@
 package consensus
 
 import (
-	"github.com/blu-fi-tech-inc/blufi-network/types"
+	"time"
+
 	"github.com/blu-fi-tech-inc/blufi-network/core"
-	"github.com/blu-fi-tech-inc/blufi-network/crypto"
-	"errors"
+	"github.com/blu-fi-tech-inc/blufi-network/types"
 )
 
-// Consensus represents the consensus mechanism
-type Consensus struct {
+// PoS is the proof of stake consensus engine. The proposer of every block is
+// drawn by stake from the validators bonded at its parent, see Schedule. It
+// implements core.Engine.
+type PoS struct {
 	stakeManager *StakeManager
-	pos          *PoS
+	slotDuration time.Duration
 }
 
-// NewConsensus initializes a new consensus mechanism
-func NewConsensus(sm *StakeManager, pos *PoS) *Consensus {
-	return &Consensus{
-		stakeManager: sm,
-		pos:          pos,
+// NewPoS creates a PoS engine over the stakes of the given StakeManager. Every
+// proposer gets slotDuration to propose before the next one in the schedule
+// may; DefaultSlotDuration is used if it is zero. All nodes of a network must
+// use the same slot duration.
+func NewPoS(stakeManager *StakeManager, slotDuration time.Duration) *PoS {
+	if slotDuration == 0 {
+		slotDuration = DefaultSlotDuration
 	}
-}
 
-// ValidateBlock checks if a block is valid
-func (c *Consensus) ValidateBlock(block *types.Block) bool {
-	validators, err := c.pos.SelectValidators(10) // Assume 10 validators
-	if err != nil {
-		return false
-	}
-
-	for _, validator := range validators {
-		if validator.Address == block.Proposer {
-			return true
-		}
+	return &PoS{
+		stakeManager: stakeManager,
+		slotDuration: slotDuration,
 	}
-	return false
 }
 
-// AddBlock adds a block to the blockchain if valid
-func (c *Consensus) AddBlock(block *types.Block) bool {
-	if c.ValidateBlock(block) {
-		// Add block to the blockchain
-		if err := core.GetBlockchain().AddBlock(block); err != nil {
-			return false
-		}
-		return true
-	}
-	return false
+// StakeManager returns the stake table the engine draws proposers from.
+func (pos *PoS) StakeManager() *StakeManager {
+	return pos.stakeManager
 }
 
-// PoS represents the Proof of Stake consensus mechanism
-type PoS struct {
-	validatorSet []crypto.PublicKey
+// Schedule returns the proposer schedule for the current stake table.
+func (pos *PoS) Schedule() *Schedule {
+	return NewSchedule(pos.stakeManager.Validators())
 }
 
-// NewPoS initializes a new PoS instance
-func NewPoS(validators []crypto.PublicKey) *PoS {
-	return &PoS{
-		validatorSet: validators,
+// scheduleAt returns the proposer schedule for the blocks on top of parent,
+// drawn from the stake table in the state after it.
+func (pos *PoS) scheduleAt(parent *core.Header) (*Schedule, error) {
+	validators, err := pos.stakeManager.ValidatorsAt(core.BlockHasher{}.Hash(parent))
+	if err != nil {
+		return nil, err
 	}
-}
 
-// SelectValidators selects a set of validators for block validation
-func (pos *PoS) SelectValidators(numValidators int) ([]Validator, error) {
-	if len(pos.validatorSet) < numValidators {
-		return nil, errors.New("not enough validators")
-	}
-	var validators []Validator
-	for i := 0; i < numValidators; i++ {
-		validators = append(validators, Validator{
-			Address: pos.validatorSet[i].Address(),
-		})
-	}
-	return validators, nil
+	return NewSchedule(validators), nil
 }
 
-// SelectValidator selects a validator for the given block
-func (pos *PoS) SelectValidator(block *core.Block, chain *core.Blockchain) error {
-	validators, err := pos.SelectValidators(1)
+// Proposer implements core.Engine.
+func (pos *PoS) Proposer(parent *core.Header, timestamp int64) (types.Address, error) {
+	slot, err := Slot(parent, timestamp, pos.slotDuration)
 	if err != nil {
-		return err
+		return types.Address{}, err
 	}
 
-	block.Proposer = validators[0].Address
-	return nil
-}
-
-// Validator represents a blockchain validator
-type Validator struct {
-	Address crypto.Address
-}
+	schedule, err := pos.scheduleAt(parent)
+	if err != nil {
+		return types.Address{}, err
+	}
 
-// StakeManager manages the staking process
-type StakeManager struct {
-	// Implementation details omitted for brevity
+	return schedule.Proposer(core.NextSeed(parent), parent.Height+1, slot)
 }
 
-// NewStakeManager initializes a new StakeManager instance
-func NewStakeManager() *StakeManager {
-	return &StakeManager{}
-}
+// VerifyProposer implements core.Engine.
+func (pos *PoS) VerifyProposer(parent *core.Header, b *core.Block) error {
+	schedule, err := pos.scheduleAt(parent)
+	if err != nil {
+		return err
+	}
 
-// AddStake adds stake for a given address
-func (sm *StakeManager) AddStake(address crypto.Address, amount int64) {
-	// Implementation details omitted for brevity
+	return schedule.VerifyProposer(parent, b, pos.slotDuration)
 }
 
-// RemoveStake removes stake for a given address
-func (sm *StakeManager) RemoveStake(address crypto.Address, amount int64) {
-	// Implementation details omitted for brevity
+// SelectValidators returns numValidators distinct validators for the block
+// following parent, drawn by stake. Jailed validators are never selected.
+func (pos *PoS) SelectValidators(parent *core.Header, numValidators int) ([]Validator, error) {
+	return pos.Schedule().Committee(core.NextSeed(parent), parent.Height+1, numValidators)
 }
@@ -0,0 +1,536 @@
+package consensus
+
+import (
+	"errors"
+	"fmt"
+	"math/bits"
+	"sync"
+	"time"
+
+	"github.com/blu-fi-tech-inc/blufi-network/core"
+	"github.com/blu-fi-tech-inc/blufi-network/crypto"
+	"github.com/blu-fi-tech-inc/blufi-network/types"
+)
+
+// DefaultRoundTimeout is how long the first finality round of a height lasts
+// before validators move on to the next round. Each later round of the same
+// height lasts one timeout longer than the previous one.
+const DefaultRoundTimeout = 10 * time.Second
+
+// maxVoteLookahead bounds how many heights and rounds past the current ones
+// votes are kept for, so that validators cannot make nodes store votes for
+// arbitrary heights and rounds.
+const maxVoteLookahead = 64
+
+var (
+	// ErrKnownVote is returned for votes that were already added.
+	ErrKnownVote = errors.New("vote already known")
+
+	// ErrVoteOutOfRange is returned for votes for heights that are already
+	// finalized or too far ahead.
+	ErrVoteOutOfRange = errors.New("vote out of range")
+)
+
+// FinalityChain is the chain a Finalizer votes on. It is implemented by
+// core.Blockchain.
+type FinalityChain interface {
+	Height() uint32
+	GetBlock(height uint32) (*core.Block, error)
+	GetBlockByHash(hash types.Hash) (*core.Block, error)
+	HasBlockHash(hash types.Hash) bool
+	Finalized() *core.Header
+	Finalize(hash types.Hash) error
+}
+
+// Finalizer runs the finality rounds of the validator set on top of the
+// chain. Heights are finalized one after the other. In each round validators
+// prevote the block at the height, precommit a block that more than two
+// thirds of the stake prevoted and finalize a block that more than two thirds
+// of the stake precommitted. A validator that precommitted a block is locked
+// on it and prevotes it in later rounds of the height, until more than two
+// thirds of the stake prevote another block in a later round.
+//
+// The votes for a block are weighed with the stake table in the state after
+// its parent, so that nodes at different tips of the chain agree on the
+// quorums.
+//
+// Nodes without a validator key follow the votes of the others and finalize
+// blocks without voting.
+type Finalizer struct {
+	lock sync.Mutex
+
+	chain        FinalityChain
+	stakeManager *StakeManager
+	privKey      *crypto.PrivateKey
+	address      types.Address
+	roundTimeout time.Duration
+
+	height      uint32 // lowest height that is not final
+	round       uint32
+	roundStart  time.Time
+	locked      *types.Hash
+	lockedRound uint32
+	sent        map[voteStep]bool // steps of the height this validator voted in
+	stakes      *stakeTable       // stake table of the height, see currentStakes
+	heights     map[uint32]*heightVotes
+	certificate *QuorumCertificate
+}
+
+type voteStep struct {
+	round    uint32
+	voteType VoteType
+}
+
+// heightVotes holds the votes for a height.
+type heightVotes struct {
+	rounds map[uint32]*roundVotes
+}
+
+type roundVotes struct {
+	votes map[VoteType]map[types.Address]*Vote
+}
+
+// stakeTable is the stake of the validators voting on a block: the stake
+// table in the state after the parent of the block.
+type stakeTable struct {
+	stakes map[types.Address]uint64
+	total  uint64
+}
+
+// NewFinalizer creates a finalizer for the chain. privKey is the key the node
+// votes with, or nil if the node is not a validator. A zero roundTimeout
+// selects DefaultRoundTimeout.
+func NewFinalizer(chain FinalityChain, stakeManager *StakeManager, privKey *crypto.PrivateKey, roundTimeout time.Duration) *Finalizer {
+	if roundTimeout == 0 {
+		roundTimeout = DefaultRoundTimeout
+	}
+
+	f := &Finalizer{
+		chain:        chain,
+		stakeManager: stakeManager,
+		privKey:      privKey,
+		roundTimeout: roundTimeout,
+		heights:      make(map[uint32]*heightVotes),
+	}
+	if privKey != nil {
+		pubKey := crypto.PublicKey{PublicKey: &privKey.PublicKey}
+		f.address, _ = pubKey.Address()
+	}
+	f.startHeight(chain.Finalized().Height+1, time.Now())
+
+	return f
+}
+
+// Round returns the height being finalized and the current round.
+func (f *Finalizer) Round() (height, round uint32) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	return f.height, f.round
+}
+
+// Certificate returns the quorum certificate of the last block this finalizer
+// finalized, or nil if it has not finalized any.
+func (f *Finalizer) Certificate() *QuorumCertificate {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	return f.certificate
+}
+
+// AddVote adds a vote of another validator. It returns the votes this
+// validator casts in response, which are already added and must be sent to
+// the other validators.
+func (f *Finalizer) AddVote(v *Vote) ([]*Vote, error) {
+	if err := v.Verify(); err != nil {
+		return nil, err
+	}
+	address, err := v.Validator.Address()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidVote, err)
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if v.Height < f.height || v.Height > f.height+maxVoteLookahead || v.Round > f.round+maxVoteLookahead {
+		return nil, fmt.Errorf(
+			"%w: %s at height (%d) round (%d), finalizing height (%d) round (%d)",
+			ErrVoteOutOfRange,
+			v.Type,
+			v.Height,
+			v.Round,
+			f.height,
+			f.round,
+		)
+	}
+
+	if !f.isVoter(address, v.Height) {
+		return nil, fmt.Errorf("%w: (%s)", ErrUnknownValidator, address)
+	}
+	if err := f.votesAt(v.Height).roundVotes(v.Round).add(v, address); err != nil {
+		return nil, err
+	}
+
+	return f.advance(time.Now()), nil
+}
+
+// Tick moves the finalizer forward: it picks up blocks and finalizations of
+// the chain, and starts the next round when the current one timed out. It
+// must be called periodically and after the chain changed. It returns the
+// votes this validator casts, which must be sent to the other validators.
+func (f *Finalizer) Tick(now time.Time) []*Vote {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if f.chain.Height() < f.height {
+		// Rounds only run once there is a block to vote on.
+		f.roundStart = now
+	} else if now.Sub(f.roundStart) >= f.roundTimeout*time.Duration(f.round+1) {
+		f.round++
+		f.roundStart = now
+	}
+
+	return f.advance(now)
+}
+
+// advance applies everything the votes and the chain allow: it finalizes
+// blocks, catches up with later rounds and casts the votes of this validator.
+func (f *Finalizer) advance(now time.Time) []*Vote {
+	var votes []*Vote
+	for {
+		if finalized := f.chain.Finalized().Height; finalized >= f.height {
+			f.startHeight(finalized+1, now)
+			continue
+		}
+		if f.commit(now) {
+			continue
+		}
+		f.skipRound(now)
+
+		vote := f.nextVote()
+		if vote == nil {
+			return votes
+		}
+		votes = append(votes, vote)
+	}
+}
+
+// startHeight resets the rounds for the given height and drops the votes of
+// lower heights.
+func (f *Finalizer) startHeight(height uint32, now time.Time) {
+	f.height = height
+	f.round = 0
+	f.roundStart = now
+	f.locked = nil
+	f.lockedRound = 0
+	f.sent = make(map[voteStep]bool)
+	f.stakes = nil
+
+	for h := range f.heights {
+		if h < height {
+			delete(f.heights, h)
+		}
+	}
+}
+
+// commit finalizes a block that more than two thirds of the stake
+// precommitted in a round. The block must be known to the chain. Quorums for
+// later heights than the current one are taken as well, which lets nodes that
+// missed the votes of a height catch up with the validators.
+func (f *Finalizer) commit(now time.Time) bool {
+	var (
+		qc    *QuorumCertificate
+		votes map[types.Address]*Vote
+	)
+	for height, hv := range f.heights {
+		if height < f.height || (qc != nil && height < qc.Height) {
+			continue
+		}
+		for round, rv := range hv.rounds {
+			hash, ok := f.precommitQuorum(height, rv)
+			if !ok {
+				continue
+			}
+			qc = &QuorumCertificate{Height: height, Round: round, BlockHash: hash}
+			votes = rv.votes[VotePrecommit]
+			break
+		}
+	}
+	if qc == nil {
+		return false
+	}
+	if err := f.chain.Finalize(qc.BlockHash); err != nil {
+		return false
+	}
+
+	for _, v := range votes {
+		if v.BlockHash == qc.BlockHash {
+			qc.Votes = append(qc.Votes, v)
+		}
+	}
+	f.certificate = qc
+	f.startHeight(qc.Height+1, now)
+
+	return true
+}
+
+// precommitQuorum returns the block of the height that more than two thirds of
+// the stake precommitted in a round. The block must be known to the chain, to
+// weigh the precommits with the stake table of its parent.
+func (f *Finalizer) precommitQuorum(height uint32, rv *roundVotes) (types.Hash, bool) {
+	seen := make(map[types.Hash]bool)
+	for _, v := range rv.votes[VotePrecommit] {
+		hash := v.BlockHash
+		if seen[hash] {
+			continue
+		}
+		seen[hash] = true
+
+		b, err := f.chain.GetBlockByHash(hash)
+		if err != nil || b.Height != height {
+			continue
+		}
+
+		st := f.currentStakes()
+		if height > f.height {
+			st = f.stakeTable(b.PrevBlockHash)
+		}
+		if hasQuorum(rv.weights(VotePrecommit, st)[hash], st.total) {
+			return hash, true
+		}
+	}
+
+	return types.Hash{}, false
+}
+
+// skipRound moves to the latest later round of the height that validators
+// holding more than a third of the stake already voted in. At least one of
+// them is honest, so the current round is over.
+func (f *Finalizer) skipRound(now time.Time) {
+	st := f.currentStakes()
+	for round, rv := range f.votesAt(f.height).rounds {
+		if round > f.round && hasThird(rv.voterWeight(st), st.total) {
+			f.round = round
+			f.roundStart = now
+		}
+	}
+}
+
+// nextVote casts the next vote of this validator in the current round, or
+// returns nil if there is nothing to vote for yet.
+func (f *Finalizer) nextVote() *Vote {
+	hv := f.votesAt(f.height)
+	st := f.currentStakes()
+	if f.privKey == nil || st.stakes[f.address] == 0 {
+		return nil
+	}
+
+	if !f.sent[voteStep{f.round, VotePrevote}] {
+		hash, ok := f.prevoteTarget(hv, st)
+		if !ok {
+			return nil
+		}
+		return f.cast(hv, VotePrevote, hash)
+	}
+
+	if !f.sent[voteStep{f.round, VotePrecommit}] {
+		hash, ok := hv.roundVotes(f.round).quorum(VotePrevote, st)
+		if !ok || !f.chain.HasBlockHash(hash) {
+			return nil
+		}
+		f.locked = &hash
+		f.lockedRound = f.round
+		return f.cast(hv, VotePrecommit, hash)
+	}
+
+	return nil
+}
+
+// prevoteTarget returns the block to prevote in the current round: the locked
+// block, unless another block got a prevote quorum in a later round, or else
+// the canonical block at the height.
+func (f *Finalizer) prevoteTarget(hv *heightVotes, st *stakeTable) (types.Hash, bool) {
+	if f.locked != nil {
+		for round, rv := range hv.rounds {
+			if round <= f.lockedRound || round > f.round {
+				continue
+			}
+			if hash, ok := rv.quorum(VotePrevote, st); ok && hash != *f.locked {
+				f.locked = nil
+				break
+			}
+		}
+	}
+	if f.locked != nil {
+		return *f.locked, true
+	}
+
+	b, err := f.chain.GetBlock(f.height)
+	if err != nil {
+		return types.Hash{}, false
+	}
+
+	return b.Hash(core.BlockHasher{}), true
+}
+
+// cast signs and adds a vote of this validator in the current round.
+func (f *Finalizer) cast(hv *heightVotes, t VoteType, hash types.Hash) *Vote {
+	f.sent[voteStep{f.round, t}] = true
+
+	v := NewVote(t, f.height, f.round, hash)
+	if err := v.Sign(f.privKey); err != nil {
+		return nil
+	}
+	if err := hv.roundVotes(f.round).add(v, f.address); err != nil {
+		return nil
+	}
+
+	return v
+}
+
+// currentStakes returns the stake table of the height being finalized: the
+// table in the state after the last finalized block, the parent of every block
+// that can be finalized at the height.
+func (f *Finalizer) currentStakes() *stakeTable {
+	if f.stakes == nil {
+		f.stakes = f.stakeTable(core.BlockHasher{}.Hash(f.chain.Finalized()))
+	}
+
+	return f.stakes
+}
+
+// stakeTable returns the stake table in the state after the block with the
+// given hash. It is empty if the chain does not know the table.
+func (f *Finalizer) stakeTable(hash types.Hash) *stakeTable {
+	st := &stakeTable{stakes: make(map[types.Address]uint64)}
+
+	validators, err := f.stakeManager.ValidatorsAt(hash)
+	if err != nil {
+		return st
+	}
+	for _, v := range validators {
+		st.stakes[v.Address] = v.Stake
+		st.total += v.Stake
+	}
+
+	return st
+}
+
+// isVoter reports whether votes of the validator at the given height are
+// kept. The stake table of the heights after the current one is not settled
+// until their parents are final, so their votes are kept if the validator is
+// bonded now or at the canonical block below the height, and weighed once the
+// block voted for is known.
+func (f *Finalizer) isVoter(address types.Address, height uint32) bool {
+	if f.currentStakes().stakes[address] > 0 {
+		return true
+	}
+	if height == f.height {
+		return false
+	}
+
+	b, err := f.chain.GetBlock(height - 1)
+	if err != nil {
+		return false
+	}
+
+	return f.stakeTable(b.Hash(core.BlockHasher{})).stakes[address] > 0
+}
+
+// votesAt returns the votes for a height.
+func (f *Finalizer) votesAt(height uint32) *heightVotes {
+	hv, ok := f.heights[height]
+	if !ok {
+		hv = &heightVotes{
+			rounds: make(map[uint32]*roundVotes),
+		}
+		f.heights[height] = hv
+	}
+
+	return hv
+}
+
+func (hv *heightVotes) roundVotes(round uint32) *roundVotes {
+	rv, ok := hv.rounds[round]
+	if !ok {
+		rv = &roundVotes{
+			votes: map[VoteType]map[types.Address]*Vote{
+				VotePrevote:   make(map[types.Address]*Vote),
+				VotePrecommit: make(map[types.Address]*Vote),
+			},
+		}
+		hv.rounds[round] = rv
+	}
+
+	return rv
+}
+
+// add adds the vote of the validator with the given address. Only the first
+// vote of a validator in each step counts.
+func (rv *roundVotes) add(v *Vote, address types.Address) error {
+	if prev, ok := rv.votes[v.Type][address]; ok {
+		if prev.BlockHash == v.BlockHash {
+			return ErrKnownVote
+		}
+		return fmt.Errorf(
+			"%w: %s of (%s) at height (%d) round (%d) for (%s) and (%s)",
+			ErrConflictingVote,
+			v.Type,
+			address,
+			v.Height,
+			v.Round,
+			prev.BlockHash,
+			v.BlockHash,
+		)
+	}
+
+	rv.votes[v.Type][address] = v
+
+	return nil
+}
+
+// weights returns the stake that voted for each block in the given step.
+func (rv *roundVotes) weights(t VoteType, st *stakeTable) map[types.Hash]uint64 {
+	weights := make(map[types.Hash]uint64)
+	for address, v := range rv.votes[t] {
+		weights[v.BlockHash] += st.stakes[address]
+	}
+
+	return weights
+}
+
+// voterWeight returns the stake of the validators that voted in the round.
+func (rv *roundVotes) voterWeight(st *stakeTable) uint64 {
+	voters := make(map[types.Address]bool)
+	for _, votes := range rv.votes {
+		for address := range votes {
+			voters[address] = true
+		}
+	}
+
+	var weight uint64
+	for address := range voters {
+		weight += st.stakes[address]
+	}
+
+	return weight
+}
+
+// quorum returns the block that more than two thirds of the stake voted for in
+// the given step.
+func (rv *roundVotes) quorum(t VoteType, st *stakeTable) (types.Hash, bool) {
+	for hash, weight := range rv.weights(t, st) {
+		if hasQuorum(weight, st.total) {
+			return hash, true
+		}
+	}
+
+	return types.Hash{}, false
+}
+
+// hasThird reports whether weight is more than a third of total.
+func hasThird(weight, total uint64) bool {
+	hi, lo := bits.Mul64(weight, 3)
+	return hi > 0 || lo > total
+}
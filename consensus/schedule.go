@@ -0,0 +1,186 @@
+package consensus
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/blu-fi-tech-inc/blufi-network/core"
+	"github.com/blu-fi-tech-inc/blufi-network/types"
+)
+
+// DefaultSlotDuration is the time each proposer of a height gets before the
+// next one in the schedule may propose instead.
+const DefaultSlotDuration = 5 * time.Second
+
+// maxClockDrift is how far in the future a block timestamp may be. Without a
+// bound, backup proposers could claim their slot before it started.
+const maxClockDrift = time.Second
+
+var (
+	// ErrNoValidators is returned when the stake table holds no stake.
+	ErrNoValidators = errors.New("no validators")
+
+	// ErrNotEnoughValidators is returned when more validators are requested
+	// than hold stake.
+	ErrNotEnoughValidators = errors.New("not enough validators")
+
+	// ErrWrongProposer is returned for blocks signed by a validator that was
+	// not scheduled to propose them.
+	ErrWrongProposer = errors.New("block not signed by the scheduled proposer")
+
+	// ErrInvalidTimestamp is returned for blocks timestamped before their
+	// parent or too far in the future.
+	ErrInvalidTimestamp = errors.New("invalid block timestamp")
+)
+
+// Schedule assigns the proposers of each height and slot from a stake table.
+// Proposers are drawn with probability proportional to their stake, using
+// only the seed the block derives from its parent, the height and the slot, so
+// every node with the same stake table computes the same schedule.
+type Schedule struct {
+	validators []Validator // ordered by address
+	total      uint64
+}
+
+// NewSchedule creates a schedule for the given validators. Validators without
+// stake are never scheduled.
+func NewSchedule(validators []Validator) *Schedule {
+	s := &Schedule{}
+	for _, v := range validators {
+		if v.Stake == 0 {
+			continue
+		}
+		s.validators = append(s.validators, v)
+		s.total += v.Stake
+	}
+	sortValidators(s.validators)
+
+	return s
+}
+
+// Len returns the number of validators holding stake.
+func (s *Schedule) Len() int {
+	return len(s.validators)
+}
+
+// Proposer returns the address of the validator allowed to propose the block
+// at the given height and slot. seed is the seed carried by the block, see
+// core.NextSeed.
+func (s *Schedule) Proposer(seed types.Hash, height, slot uint32) (types.Address, error) {
+	if s.total == 0 {
+		return types.Address{}, ErrNoValidators
+	}
+
+	target := draw(seed, height, slot, 0) % s.total
+	return pick(s.validators, target).Address, nil
+}
+
+// Committee returns n distinct validators for the given height, drawn by
+// stake in the order of the draws.
+func (s *Schedule) Committee(seed types.Hash, height uint32, n int) ([]Validator, error) {
+	if n > len(s.validators) {
+		return nil, fmt.Errorf("%w: have (%d), want (%d)", ErrNotEnoughValidators, len(s.validators), n)
+	}
+
+	remaining := append([]Validator(nil), s.validators...)
+	total := s.total
+
+	committee := make([]Validator, 0, n)
+	for i := 0; i < n; i++ {
+		target := draw(seed, height, 0, uint32(i)) % total
+		v := pick(remaining, target)
+		committee = append(committee, v)
+
+		for j := range remaining {
+			if remaining[j].Address == v.Address {
+				remaining = append(remaining[:j], remaining[j+1:]...)
+				break
+			}
+		}
+		total -= v.Stake
+	}
+
+	return committee, nil
+}
+
+// VerifyProposer checks that b was signed by the validator scheduled for its
+// height, in the slot its timestamp falls into. prev is the header of the
+// parent of b.
+func (s *Schedule) VerifyProposer(prev *core.Header, b *core.Block, slotDuration time.Duration) error {
+	if b.Timestamp > time.Now().Add(maxClockDrift).UnixNano() {
+		return fmt.Errorf("%w: block (%s) is from the future", ErrInvalidTimestamp, b.Hash(core.BlockHasher{}))
+	}
+
+	slot, err := Slot(prev, b.Timestamp, slotDuration)
+	if err != nil {
+		return err
+	}
+
+	want, err := s.Proposer(core.NextSeed(prev), prev.Height+1, slot)
+	if err != nil {
+		return err
+	}
+
+	signer, err := b.Validator.Address()
+	if err != nil {
+		return err
+	}
+
+	if signer != want {
+		return fmt.Errorf(
+			"%w: block (%s) at height (%d) slot (%d) signed by (%s), scheduled (%s)",
+			ErrWrongProposer,
+			b.Hash(core.BlockHasher{}),
+			b.Height,
+			slot,
+			signer,
+			want,
+		)
+	}
+
+	return nil
+}
+
+// Slot returns the slot a block with the given timestamp falls into, counted
+// in slotDuration steps from the timestamp of its parent.
+func Slot(prev *core.Header, timestamp int64, slotDuration time.Duration) (uint32, error) {
+	if timestamp < prev.Timestamp {
+		return 0, fmt.Errorf("%w: (%d) before parent (%d)", ErrInvalidTimestamp, timestamp, prev.Timestamp)
+	}
+
+	slot := (timestamp - prev.Timestamp) / int64(slotDuration)
+	if slot > int64(^uint32(0)) {
+		return 0, fmt.Errorf("%w: (%d) too far after parent (%d)", ErrInvalidTimestamp, timestamp, prev.Timestamp)
+	}
+
+	return uint32(slot), nil
+}
+
+// pick returns the validator whose cumulative stake range contains target.
+func pick(validators []Validator, target uint64) Validator {
+	var cumulative uint64
+	for _, v := range validators {
+		cumulative += v.Stake
+		if cumulative > target {
+			return v
+		}
+	}
+
+	return validators[len(validators)-1]
+}
+
+// draw derives a pseudo-random number from the seed, height, slot and the
+// index of the draw.
+func draw(seed types.Hash, height, slot, i uint32) uint64 {
+	buf := make([]byte, len(seed)+12)
+	copy(buf, seed[:])
+	binary.BigEndian.PutUint32(buf[len(seed):], height)
+	binary.BigEndian.PutUint32(buf[len(seed)+4:], slot)
+	binary.BigEndian.PutUint32(buf[len(seed)+8:], i)
+
+	h := sha256.Sum256(buf)
+	return binary.BigEndian.Uint64(h[:8])
+}
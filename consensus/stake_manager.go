@@ -1,67 +1,94 @@
 package consensus
 
 import (
-	"errors"
-	"sync"
+	"bytes"
+	"sort"
+
+	"github.com/blu-fi-tech-inc/blufi-network/types"
 )
 
-// Stake represents the amount of stake held by a stakeholder.
-type Stake struct {
-	Amount uint64
+// Validator is an address with stake bonded on chain.
+type Validator struct {
+	Address types.Address
+	Stake   uint64
 }
 
-// Stakeholder represents an individual stakeholder with an address and their stake.
-type Stakeholder struct {
-	Address string
-	Stake   Stake
+// StakeSource gives access to the stakes bonded on chain, at the tip and in
+// the state after a given block. It is implemented by core.Blockchain.
+type StakeSource interface {
+	Stake(address types.Address) uint64
+	Stakes() map[types.Address]uint64
+	Jailed(address types.Address) bool
+	StakesAt(hash types.Hash) (map[types.Address]uint64, error)
 }
 
-// StakeManager manages the stakes of all stakeholders in the system.
+// StakeManager serves the stake table of the validators. Stakes are part of
+// the state of the chain, so every node following the same chain sees the
+// same stake table. Validators caught signing two blocks at the same height
+// are slashed and jailed on chain by a core.EvidenceTx, and left out of the
+// table from then on.
 type StakeManager struct {
-	stakeholders map[string]Stakeholder
-	mu           sync.RWMutex
+	source StakeSource
 }
 
-// NewStakeManager creates a new instance of StakeManager.
-func NewStakeManager() *StakeManager {
+// NewStakeManager creates a StakeManager reading the stakes from the given
+// source.
+func NewStakeManager(source StakeSource) *StakeManager {
 	return &StakeManager{
-		stakeholders: make(map[string]Stakeholder),
+		source: source,
 	}
 }
 
-// AddStake adds a given amount of stake to the specified address.
-func (sm *StakeManager) AddStake(address string, amount uint64) error {
-	sm.mu.Lock()
-	defer sm.mu.Unlock()
-	stakeholder, exists := sm.stakeholders[address]
-	if !exists {
-		stakeholder = Stakeholder{Address: address, Stake: Stake{Amount: amount}}
-	} else {
-		stakeholder.Stake.Amount += amount
-	}
-	sm.stakeholders[address] = stakeholder
-	return nil
+// GetStake returns the stake bonded by the given address, zero if it is not a
+// validator.
+func (sm *StakeManager) GetStake(address types.Address) uint64 {
+	return sm.source.Stake(address)
 }
 
-// GetStake returns the stake of the specified address.
-func (sm *StakeManager) GetStake(address string) (Stake, error) {
-	sm.mu.RLock()
-	defer sm.mu.RUnlock()
-	stakeholder, exists := sm.stakeholders[address]
-	if !exists {
-		return Stake{}, errors.New("stakeholder not found")
+// IsJailed reports whether the given address was jailed for double-signing.
+func (sm *StakeManager) IsJailed(address types.Address) bool {
+	return sm.source.Jailed(address)
+}
+
+// Validators returns the validators with stake bonded that are not jailed,
+// ordered by address.
+func (sm *StakeManager) Validators() []Validator {
+	stakes := sm.source.Stakes()
+
+	validators := make([]Validator, 0, len(stakes))
+	for address, stake := range stakes {
+		if stake == 0 || sm.source.Jailed(address) {
+			continue
+		}
+		validators = append(validators, Validator{Address: address, Stake: stake})
 	}
-	return stakeholder.Stake, nil
+	sortValidators(validators)
+
+	return validators
 }
 
-// GetStakeholders returns a copy of all stakeholders.
-func (sm *StakeManager) GetStakeholders() map[string]Stakeholder {
-	sm.mu.RLock()
-	defer sm.mu.RUnlock()
+// ValidatorsAt returns the validators with stake bonded that are not jailed in
+// the state after the block with the given hash, ordered by address. Unlike
+// Validators, it does not depend on the tip of the chain, so every node
+// computes the same table for the same block.
+func (sm *StakeManager) ValidatorsAt(hash types.Hash) ([]Validator, error) {
+	stakes, err := sm.source.StakesAt(hash)
+	if err != nil {
+		return nil, err
+	}
 
-	copy := make(map[string]Stakeholder)
-	for k, v := range sm.stakeholders {
-		copy[k] = v
+	validators := make([]Validator, 0, len(stakes))
+	for address, stake := range stakes {
+		validators = append(validators, Validator{Address: address, Stake: stake})
 	}
-	return copy
+	sortValidators(validators)
+
+	return validators, nil
+}
+
+// sortValidators orders validators by address.
+func sortValidators(validators []Validator) {
+	sort.Slice(validators, func(i, j int) bool {
+		return bytes.Compare(validators[i].Address[:], validators[j].Address[:]) < 0
+	})
 }
@@ -0,0 +1,179 @@
+package consensus
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/bits"
+
+	"github.com/blu-fi-tech-inc/blufi-network/crypto"
+	"github.com/blu-fi-tech-inc/blufi-network/types"
+)
+
+// VoteType is the step of a finality round a vote is cast in.
+type VoteType uint8
+
+const (
+	VotePrevote   VoteType = 0x1
+	VotePrecommit VoteType = 0x2
+)
+
+// String returns the name of the vote type.
+func (t VoteType) String() string {
+	switch t {
+	case VotePrevote:
+		return "prevote"
+	case VotePrecommit:
+		return "precommit"
+	default:
+		return fmt.Sprintf("VoteType(%d)", uint8(t))
+	}
+}
+
+// voteDomain separates the signatures of votes from those of blocks and
+// transactions made with the same key.
+var voteDomain = []byte("blufi-vote")
+
+var (
+	// ErrInvalidVote is returned for votes that are malformed or not signed
+	// by their validator.
+	ErrInvalidVote = errors.New("invalid vote")
+
+	// ErrUnknownValidator is returned for votes of addresses without stake.
+	ErrUnknownValidator = errors.New("vote from unknown validator")
+
+	// ErrConflictingVote is returned when a validator votes for two
+	// different blocks in the same step of a round.
+	ErrConflictingVote = errors.New("conflicting vote")
+
+	// ErrInvalidQuorum is returned for quorum certificates without votes
+	// from more than two thirds of the stake.
+	ErrInvalidQuorum = errors.New("invalid quorum certificate")
+)
+
+// Vote is the signed vote of a validator for a block in a step of a finality
+// round.
+type Vote struct {
+	Type      VoteType
+	Height    uint32
+	Round     uint32
+	BlockHash types.Hash
+	Validator crypto.PublicKey
+	Signature []byte
+}
+
+// NewVote creates an unsigned vote.
+func NewVote(t VoteType, height, round uint32, blockHash types.Hash) *Vote {
+	return &Vote{
+		Type:      t,
+		Height:    height,
+		Round:     round,
+		BlockHash: blockHash,
+	}
+}
+
+// signBytes returns the data the validator signs.
+func (v *Vote) signBytes() []byte {
+	buf := bytes.NewBuffer(append([]byte{}, voteDomain...))
+	buf.WriteByte(byte(v.Type))
+	binary.Write(buf, binary.BigEndian, v.Height)
+	binary.Write(buf, binary.BigEndian, v.Round)
+	buf.Write(v.BlockHash[:])
+
+	return buf.Bytes()
+}
+
+// Sign signs the vote with the key of a validator.
+func (v *Vote) Sign(privKey *crypto.PrivateKey) error {
+	v.Validator = crypto.PublicKey{PublicKey: &privKey.PublicKey}
+
+	hash := sha256.Sum256(v.signBytes())
+	sig, err := privKey.Sign(hash[:])
+	if err != nil {
+		return err
+	}
+	v.Signature = sig
+
+	return nil
+}
+
+// Verify checks that the vote is well formed and signed by its validator.
+func (v *Vote) Verify() error {
+	if v.Type != VotePrevote && v.Type != VotePrecommit {
+		return fmt.Errorf("%w: unknown type (%s)", ErrInvalidVote, v.Type)
+	}
+	if v.Validator.PublicKey == nil || v.Signature == nil {
+		return fmt.Errorf("%w: not signed", ErrInvalidVote)
+	}
+
+	hash := sha256.Sum256(v.signBytes())
+	if !crypto.VerifySignature(&v.Validator, hash[:], v.Signature) {
+		return fmt.Errorf("%w: bad signature on %s at height (%d) round (%d)", ErrInvalidVote, v.Type, v.Height, v.Round)
+	}
+
+	return nil
+}
+
+// Hash identifies the vote: its content and validator, without the
+// signature.
+func (v *Vote) Hash() types.Hash {
+	return types.Hash(sha256.Sum256(append(v.signBytes(), v.Validator.Bytes()...)))
+}
+
+// QuorumCertificate proves that validators holding more than two thirds of the
+// stake precommitted a block in the same round.
+type QuorumCertificate struct {
+	Height    uint32
+	Round     uint32
+	BlockHash types.Hash
+	Votes     []*Vote
+}
+
+// Verify checks that the certificate carries valid precommits for its block
+// from more than two thirds of the stake of the given validators.
+func (qc *QuorumCertificate) Verify(validators []Validator) error {
+	stakes := make(map[types.Address]uint64, len(validators))
+	var total uint64
+	for _, v := range validators {
+		stakes[v.Address] = v.Stake
+		total += v.Stake
+	}
+
+	seen := make(map[types.Address]bool)
+	var weight uint64
+	for _, vote := range qc.Votes {
+		if vote.Type != VotePrecommit || vote.Height != qc.Height || vote.Round != qc.Round || vote.BlockHash != qc.BlockHash {
+			return fmt.Errorf("%w: vote does not match the certificate", ErrInvalidQuorum)
+		}
+		if err := vote.Verify(); err != nil {
+			return err
+		}
+
+		address, err := vote.Validator.Address()
+		if err != nil {
+			return err
+		}
+		if seen[address] {
+			continue
+		}
+		seen[address] = true
+		weight += stakes[address]
+	}
+
+	if !hasQuorum(weight, total) {
+		return fmt.Errorf("%w: (%d) of (%d) stake", ErrInvalidQuorum, weight, total)
+	}
+
+	return nil
+}
+
+// hasQuorum reports whether weight is more than two thirds of total. The
+// products are computed on 128 bits so that large stakes do not overflow.
+func hasQuorum(weight, total uint64) bool {
+	wHi, wLo := bits.Mul64(weight, 3)
+	tHi, tLo := bits.Mul64(total, 2)
+
+	return wHi > tHi || (wHi == tHi && wLo > tLo)
+}
@@ -3,6 +3,7 @@ package core
 import (
 	"errors"
 	"fmt"
+	"sort"
 	"sync"
 
 	"github.com/blu-fi-tech-inc/blufi-network/types"
@@ -11,11 +12,42 @@ import (
 var (
 	ErrAccountNotFound     = errors.New("account not found")
 	ErrInsufficientBalance = errors.New("insufficient account balance")
+	ErrInvalidNonce        = errors.New("invalid account nonce")
+	ErrInsufficientStake   = errors.New("insufficient account stake")
+	ErrJailed              = errors.New("validator is jailed")
+	ErrNotGenesis          = errors.New("state is already used by a chain")
 )
 
 type Account struct {
 	Address types.Address
 	Balance uint64
+	Nonce   uint64 // Nonce expected on the next transaction sent by the account
+	Stake   uint64 // Stake bonded by the account as a validator
+
+	// Unstaked funds not yet returned to the balance, one entry per release
+	// height in increasing order. The slice is replaced, never modified in
+	// place, so copies of the account do not share changes.
+	Unbonding []UnbondingEntry
+
+	Jailed bool // Slashed for double-signing, never scheduled again
+}
+
+// UnbondingEntry is unstaked funds that return to the balance of their account
+// at the release height.
+type UnbondingEntry struct {
+	Amount uint64
+	Height uint32
+}
+
+// UnbondingTotal returns the unstaked funds of the account not yet returned to
+// its balance.
+func (a *Account) UnbondingTotal() uint64 {
+	var total uint64
+	for _, entry := range a.Unbonding {
+		total += entry.Amount
+	}
+
+	return total
 }
 
 func (a *Account) String() string {
@@ -25,6 +57,7 @@ func (a *Account) String() string {
 type AccountState struct {
 	mu       sync.RWMutex
 	accounts map[types.Address]*Account
+	journal  *Journal // Records changes for reverting, nil if not journaled
 }
 
 func NewAccountState() *AccountState {
@@ -33,10 +66,62 @@ func NewAccountState() *AccountState {
 	}
 }
 
+// Copy returns a deep copy of the account state.
+func (s *AccountState) Copy() *AccountState {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	cp := NewAccountState()
+	for address, account := range s.accounts {
+		acc := *account
+		cp.accounts[address] = &acc
+	}
+
+	return cp
+}
+
+// SetJournal attaches a journal that records every change to the state.
+func (s *AccountState) SetJournal(j *Journal) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.journal = j
+}
+
+// journalAccount records the current value of an account so that it can be
+// restored on revert. It must be called with the lock held, before the change.
+func (s *AccountState) journalAccount(address types.Address) {
+	if s.journal == nil {
+		return
+	}
+
+	var (
+		prev, existed = s.accounts[address]
+		saved         Account
+	)
+	if existed {
+		saved = *prev
+	}
+
+	s.journal.append(func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		if existed {
+			acc := saved
+			s.accounts[address] = &acc
+		} else {
+			delete(s.accounts, address)
+		}
+	})
+}
+
 func (s *AccountState) CreateAccount(address types.Address) *Account {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	s.journalAccount(address)
+
 	acc := &Account{Address: address}
 	s.accounts[address] = acc
 	return acc
@@ -70,6 +155,285 @@ func (s *AccountState) GetBalance(address types.Address) (uint64, error) {
 	return account.Balance, nil
 }
 
+// GetNonce returns the nonce expected on the next transaction sent by the
+// given address. Unknown accounts start at 0.
+func (s *AccountState) GetNonce(address types.Address) uint64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	account, err := s.getAccountWithoutLock(address)
+	if err != nil {
+		return 0
+	}
+
+	return account.Nonce
+}
+
+// UseNonce consumes nonce for the given address if it is the next expected one.
+func (s *AccountState) UseNonce(address types.Address, nonce uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	account, ok := s.accounts[address]
+	if !ok {
+		account = &Account{Address: address}
+	}
+
+	if account.Nonce != nonce {
+		return fmt.Errorf("%w: account (%s) expected (%d), got (%d)", ErrInvalidNonce, address, account.Nonce, nonce)
+	}
+
+	s.journalAccount(address)
+	s.accounts[address] = account
+	account.Nonce++
+
+	return nil
+}
+
+// AddBalance credits amount to the given address, creating its account if
+// needed.
+func (s *AccountState) AddBalance(address types.Address, amount uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.journalAccount(address)
+
+	account, ok := s.accounts[address]
+	if !ok {
+		account = &Account{Address: address}
+		s.accounts[address] = account
+	}
+	account.Balance += amount
+}
+
+// SubBalance debits amount from the given address.
+func (s *AccountState) SubBalance(address types.Address, amount uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	account, err := s.getAccountWithoutLock(address)
+	if err != nil {
+		if amount == 0 {
+			return nil
+		}
+		return fmt.Errorf("%w: account (%s)", ErrInsufficientBalance, address)
+	}
+	if account.Balance < amount {
+		return fmt.Errorf("%w: account (%s) has (%d), needs (%d)", ErrInsufficientBalance, address, account.Balance, amount)
+	}
+
+	s.journalAccount(address)
+	account.Balance -= amount
+
+	return nil
+}
+
+// GetStake returns the stake bonded by the given address, zero for unknown
+// accounts.
+func (s *AccountState) GetStake(address types.Address) uint64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	account, err := s.getAccountWithoutLock(address)
+	if err != nil {
+		return 0
+	}
+
+	return account.Stake
+}
+
+// Stakes returns the stake of every account with stake bonded.
+func (s *AccountState) Stakes() map[types.Address]uint64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	stakes := make(map[types.Address]uint64)
+	for address, account := range s.accounts {
+		if account.Stake > 0 {
+			stakes[address] = account.Stake
+		}
+	}
+
+	return stakes
+}
+
+// ValidatorStakes returns the stake of every account with stake bonded that is
+// not jailed.
+func (s *AccountState) ValidatorStakes() map[types.Address]uint64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	stakes := make(map[types.Address]uint64)
+	for address, account := range s.accounts {
+		if account.Stake > 0 && !account.Jailed {
+			stakes[address] = account.Stake
+		}
+	}
+
+	return stakes
+}
+
+// AddGenesisStake bonds amount as stake of the given address in the genesis
+// state, creating its account if needed. The amount does not come out of the
+// balance of the account, so it fails once the state is used by a Blockchain;
+// stake is bonded from the balance with Bond afterwards.
+func (s *AccountState) AddGenesisStake(address types.Address, amount uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.journal != nil {
+		return fmt.Errorf("%w: cannot add stake to (%s)", ErrNotGenesis, address)
+	}
+
+	account, ok := s.accounts[address]
+	if !ok {
+		account = &Account{Address: address}
+		s.accounts[address] = account
+	}
+	account.Stake += amount
+
+	return nil
+}
+
+// Bond moves amount from the balance of the given address to its stake.
+// Jailed validators cannot bond.
+func (s *AccountState) Bond(address types.Address, amount uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	account, err := s.getAccountWithoutLock(address)
+	if err == nil && account.Jailed {
+		return fmt.Errorf("%w: account (%s) cannot bond", ErrJailed, address)
+	}
+	if err != nil || account.Balance < amount {
+		return fmt.Errorf("%w: account (%s) cannot bond (%d)", ErrInsufficientBalance, address, amount)
+	}
+
+	s.journalAccount(address)
+	account.Balance -= amount
+	account.Stake += amount
+
+	return nil
+}
+
+// Unbond moves amount from the stake of the given address to its unbonding
+// funds, which return to its balance at the release height. Funds unbonding
+// from earlier keep their own release height.
+func (s *AccountState) Unbond(address types.Address, amount uint64, release uint32) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	account, err := s.getAccountWithoutLock(address)
+	if err != nil || account.Stake < amount {
+		return fmt.Errorf("%w: account (%s) cannot unbond (%d)", ErrInsufficientStake, address, amount)
+	}
+
+	s.journalAccount(address)
+	account.Stake -= amount
+
+	unbonding := make([]UnbondingEntry, 0, len(account.Unbonding)+1)
+	for _, entry := range account.Unbonding {
+		if entry.Height == release {
+			amount += entry.Amount
+			continue
+		}
+		unbonding = append(unbonding, entry)
+	}
+	i := sort.Search(len(unbonding), func(i int) bool { return unbonding[i].Height > release })
+	unbonding = append(unbonding[:i], append([]UnbondingEntry{{Amount: amount, Height: release}}, unbonding[i:]...)...)
+	account.Unbonding = unbonding
+
+	return nil
+}
+
+// ReleaseUnbonded returns the unbonding funds due at or before the given
+// height to the balance of their accounts.
+func (s *AccountState) ReleaseUnbonded(height uint32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for address, account := range s.accounts {
+		due := 0
+		for due < len(account.Unbonding) && account.Unbonding[due].Height <= height {
+			due++
+		}
+		if due == 0 {
+			continue
+		}
+
+		s.journalAccount(address)
+		for _, entry := range account.Unbonding[:due] {
+			account.Balance += entry.Amount
+		}
+		if due == len(account.Unbonding) {
+			account.Unbonding = nil
+		} else {
+			account.Unbonding = append([]UnbondingEntry(nil), account.Unbonding[due:]...)
+		}
+	}
+}
+
+// SubStake removes amount from the stake of the given address.
+func (s *AccountState) SubStake(address types.Address, amount uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	account, err := s.getAccountWithoutLock(address)
+	if err != nil || account.Stake < amount {
+		return fmt.Errorf("%w: account (%s) has less than (%d)", ErrInsufficientStake, address, amount)
+	}
+
+	s.journalAccount(address)
+	account.Stake -= amount
+
+	return nil
+}
+
+// IsJailed reports whether the given address was jailed for double-signing.
+func (s *AccountState) IsJailed(address types.Address) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	account, err := s.getAccountWithoutLock(address)
+	if err != nil {
+		return false
+	}
+
+	return account.Jailed
+}
+
+// Slash burns percent of the bonded and unbonding stake of the given address
+// and jails it. It returns the amount burned.
+func (s *AccountState) Slash(address types.Address, percent uint64) (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	account, err := s.getAccountWithoutLock(address)
+	if err != nil {
+		return 0, err
+	}
+	if account.Jailed {
+		return 0, fmt.Errorf("%w: account (%s)", ErrJailed, address)
+	}
+
+	s.journalAccount(address)
+	slashed := percentOf(account.Stake, percent)
+	account.Stake -= slashed
+
+	if len(account.Unbonding) > 0 {
+		unbonding := make([]UnbondingEntry, len(account.Unbonding))
+		for i, entry := range account.Unbonding {
+			cut := percentOf(entry.Amount, percent)
+			unbonding[i] = UnbondingEntry{Amount: entry.Amount - cut, Height: entry.Height}
+			slashed += cut
+		}
+		account.Unbonding = unbonding
+	}
+	account.Jailed = true
+
+	return slashed, nil
+}
+
 func (s *AccountState) Transfer(from, to types.Address, amount uint64) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -79,6 +443,9 @@ func (s *AccountState) Transfer(from, to types.Address, amount uint64) error {
 		return err
 	}
 
+	s.journalAccount(from)
+	s.journalAccount(to)
+
 	// Check for a special address that bypasses the balance check
 	if fromAccount.Address.String() != "996fb92427ae41e4649b934ca495991b7852b855" {
 		if fromAccount.Balance < amount {
@@ -0,0 +1,293 @@
+// Package asm converts between VM bytecode and a readable assembly language.
+//
+// A program holds one instruction per line, optionally preceded by a label.
+// Comments start with ';':
+//
+//	    push 10        ; ints, in decimal or 0x hex
+//	loop:              ; a label assembles to jumpdest
+//	    dup 1
+//	    push "count"   ; bytes, assembles to pushb, push and pack
+//	    get
+//	    add
+//	    push "count"
+//	    store
+//	    push loop      ; the offset of a label
+//	    jump
+//
+// Mnemonics are the names of the instructions in lowercase, see Mnemonic.
+package asm
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/blu-fi-tech-inc/blufi-network/core"
+)
+
+var mnemonics = map[core.Instruction]string{
+	core.InstrStop:      "stop",
+	core.InstrPushInt:   "push",
+	core.InstrAdd:       "add",
+	core.InstrPushByte:  "pushb",
+	core.InstrPack:      "pack",
+	core.InstrSub:       "sub",
+	core.InstrStore:     "store",
+	core.InstrGet:       "get",
+	core.InstrMul:       "mul",
+	core.InstrDiv:       "div",
+	core.InstrMod:       "mod",
+	core.InstrLt:        "lt",
+	core.InstrGt:        "gt",
+	core.InstrEq:        "eq",
+	core.InstrNot:       "not",
+	core.InstrAnd:       "and",
+	core.InstrOr:        "or",
+	core.InstrJump:      "jump",
+	core.InstrJumpIf:    "jumpi",
+	core.InstrJumpDest:  "jumpdest",
+	core.InstrDup:       "dup",
+	core.InstrSwap:      "swap",
+	core.InstrPop:       "pop",
+	core.InstrSha256:    "sha256",
+	core.InstrCaller:    "caller",
+	core.InstrCallValue: "callvalue",
+	core.InstrHeight:    "height",
+	core.InstrCallData:  "calldata",
+}
+
+var instructions = make(map[string]core.Instruction, len(mnemonics))
+
+func init() {
+	for instr, name := range mnemonics {
+		instructions[name] = instr
+	}
+}
+
+// Mnemonic returns the assembly name of an instruction.
+func Mnemonic(instr core.Instruction) (string, bool) {
+	name, ok := mnemonics[instr]
+	return name, ok
+}
+
+// Error is an assembly error at a line of the source, starting at 1.
+type Error struct {
+	Line int
+	Msg  string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Msg)
+}
+
+func errorf(line int, format string, args ...interface{}) error {
+	return &Error{Line: line, Msg: fmt.Sprintf(format, args...)}
+}
+
+// statement is a parsed source line.
+type statement struct {
+	line     int
+	label    string
+	instr    core.Instruction
+	hasInstr bool
+	operand  string
+}
+
+// Assemble turns assembly source into VM bytecode.
+func Assemble(src string) ([]byte, error) {
+	stmts, err := parse(src)
+	if err != nil {
+		return nil, err
+	}
+
+	// First pass: lay out the code to find the offset of every label.
+	labels := make(map[string]int)
+	offset := 0
+	for _, st := range stmts {
+		if st.label != "" {
+			if _, ok := labels[st.label]; ok {
+				return nil, errorf(st.line, "label %q already defined", st.label)
+			}
+			labels[st.label] = offset
+			offset++
+		}
+		if st.hasInstr {
+			size, err := st.size()
+			if err != nil {
+				return nil, err
+			}
+			offset += size
+		}
+	}
+
+	// Second pass: emit the code.
+	code := make([]byte, 0, offset)
+	for _, st := range stmts {
+		if st.label != "" {
+			code = append(code, byte(core.InstrJumpDest))
+		}
+		if st.hasInstr {
+			if code, err = st.emit(code, labels); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return code, nil
+}
+
+func parse(src string) ([]*statement, error) {
+	stmts := []*statement{}
+
+	for i, raw := range strings.Split(src, "\n") {
+		line := i + 1
+		text := strings.TrimSpace(stripComment(raw))
+		if text == "" {
+			continue
+		}
+
+		st := &statement{line: line}
+
+		// A label is a leading identifier followed by ':'.
+		if colon := strings.IndexAny(text, ": \t\""); colon >= 0 && text[colon] == ':' {
+			label := strings.TrimSpace(text[:colon])
+			if !isIdent(label) {
+				return nil, errorf(line, "invalid label %q", label)
+			}
+			st.label = label
+			text = strings.TrimSpace(text[colon+1:])
+		}
+
+		if text != "" {
+			// The operand is the rest of the line, which keeps the spaces
+			// of string literals.
+			name := strings.Fields(text)[0]
+			operand := text[len(name):]
+			instr, ok := instructions[strings.ToLower(name)]
+			if !ok {
+				return nil, errorf(line, "unknown instruction %q", name)
+			}
+			st.instr = instr
+			st.hasInstr = true
+			st.operand = strings.TrimSpace(operand)
+		}
+
+		stmts = append(stmts, st)
+	}
+
+	return stmts, nil
+}
+
+// stripComment removes a ';' comment that is not inside a string literal.
+func stripComment(s string) string {
+	inString := false
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			if inString {
+				i++
+			}
+		case '"':
+			inString = !inString
+		case ';':
+			if !inString {
+				return s[:i]
+			}
+		}
+	}
+	return s
+}
+
+func isIdent(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i, r := range s {
+		if r != '_' && !unicode.IsLetter(r) && (i == 0 || !unicode.IsDigit(r)) {
+			return false
+		}
+	}
+	return true
+}
+
+// size returns the number of bytes the instruction assembles to.
+func (st *statement) size() (int, error) {
+	if st.instr.ImmediateSize() == 0 && st.operand != "" {
+		return 0, errorf(st.line, "%s takes no operand", mnemonics[st.instr])
+	}
+	if st.instr.ImmediateSize() > 0 && st.operand == "" {
+		return 0, errorf(st.line, "%s needs an operand", mnemonics[st.instr])
+	}
+
+	if st.instr == core.InstrPushInt && strings.HasPrefix(st.operand, "\"") {
+		b, err := st.bytesOperand()
+		if err != nil {
+			return 0, err
+		}
+		// pushb for each byte, push of the length, pack
+		return 2*len(b) + 9 + 1, nil
+	}
+
+	return 1 + st.instr.ImmediateSize(), nil
+}
+
+func (st *statement) bytesOperand() ([]byte, error) {
+	s, err := strconv.Unquote(st.operand)
+	if err != nil {
+		return nil, errorf(st.line, "invalid string %s", st.operand)
+	}
+	return []byte(s), nil
+}
+
+func (st *statement) emit(code []byte, labels map[string]int) ([]byte, error) {
+	switch st.instr {
+	case core.InstrPushInt:
+		if strings.HasPrefix(st.operand, "\"") {
+			b, err := st.bytesOperand()
+			if err != nil {
+				return nil, err
+			}
+			// InstrPack pops the first byte first, so push them in reverse.
+			for i := len(b) - 1; i >= 0; i-- {
+				code = append(code, byte(core.InstrPushByte), b[i])
+			}
+			code = appendPushInt(code, int64(len(b)))
+			return append(code, byte(core.InstrPack)), nil
+		}
+
+		if offset, ok := labels[st.operand]; ok {
+			return appendPushInt(code, int64(offset)), nil
+		}
+		if isIdent(st.operand) {
+			return nil, errorf(st.line, "undefined label %q", st.operand)
+		}
+
+		v, err := strconv.ParseInt(st.operand, 0, 64)
+		if err != nil {
+			return nil, errorf(st.line, "invalid int %q", st.operand)
+		}
+		return appendPushInt(code, v), nil
+
+	case core.InstrPushByte, core.InstrDup, core.InstrSwap:
+		v, err := strconv.ParseUint(st.operand, 0, 8)
+		if err != nil {
+			return nil, errorf(st.line, "invalid byte %q", st.operand)
+		}
+		if st.instr != core.InstrPushByte && v == 0 {
+			return nil, errorf(st.line, "%s needs an operand of at least 1", mnemonics[st.instr])
+		}
+		return append(code, byte(st.instr), byte(v)), nil
+
+	default:
+		return append(code, byte(st.instr)), nil
+	}
+}
+
+func appendPushInt(code []byte, v int64) []byte {
+	code = append(code, byte(core.InstrPushInt))
+	for shift := 56; shift >= 0; shift -= 8 {
+		code = append(code, byte(uint64(v)>>shift))
+	}
+	return code
+}
@@ -0,0 +1,47 @@
+package asm
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+
+	"github.com/blu-fi-tech-inc/blufi-network/core"
+)
+
+// Disassemble turns VM bytecode into assembly source. Every jumpdest becomes
+// a label named after its offset, so assembling the result gives back the
+// same bytecode.
+func Disassemble(code []byte) (string, error) {
+	var b strings.Builder
+
+	for ip := 0; ip < len(code); {
+		instr := core.Instruction(code[ip])
+		name, ok := mnemonics[instr]
+		if !ok {
+			return "", fmt.Errorf("offset %d: unknown instruction 0x%02x", ip, code[ip])
+		}
+
+		next := ip + 1 + instr.ImmediateSize()
+		if next > len(code) {
+			return "", fmt.Errorf("offset %d: %s has a truncated operand", ip, name)
+		}
+		imm := code[ip+1 : next]
+
+		switch instr {
+		case core.InstrJumpDest:
+			fmt.Fprintf(&b, "L%d:\n", ip)
+		case core.InstrPushInt:
+			fmt.Fprintf(&b, "    %s %d\n", name, int64(binary.BigEndian.Uint64(imm)))
+		case core.InstrPushByte:
+			fmt.Fprintf(&b, "    %s 0x%02x\n", name, imm[0])
+		case core.InstrDup, core.InstrSwap:
+			fmt.Fprintf(&b, "    %s %d\n", name, imm[0])
+		default:
+			fmt.Fprintf(&b, "    %s\n", name)
+		}
+
+		ip = next
+	}
+
+	return b.String(), nil
+}
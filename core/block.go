@@ -3,6 +3,7 @@ package core
 import (
 	"bytes"
 	"crypto/sha256"
+	"encoding/binary"
 	"encoding/gob"
 	"fmt"
 	"time"
@@ -11,13 +12,33 @@ import (
 	"github.com/blu-fi-tech-inc/blufi-network/types"
 )
 
+// Block versions. Header.DataHash is the Merkle root of the tx hashes of every
+// version.
+const (
+	BlockVersionLegacy uint32 = 1 // Only used by the genesis block
+	BlockVersionMerkle uint32 = 2 // Only used by blocks from before state roots
+
+	// BlockVersionStateRoot blocks also commit to the state after applying them.
+	BlockVersionStateRoot uint32 = 3
+
+	// BlockVersionSeed blocks also carry the seed of the proposer schedule,
+	// see NextSeed.
+	BlockVersionSeed uint32 = 4
+
+	// MinBlockVersion is the oldest version of the blocks following the
+	// genesis block. Only the genesis block may be older.
+	MinBlockVersion = BlockVersionSeed
+)
+
 // Header represents the header of a block.
 type Header struct {
 	Version       uint32     // Version of the block
-	DataHash      types.Hash // Hash of the block's data
+	DataHash      types.Hash // Hash of the block's data, see the block versions
 	PrevBlockHash types.Hash // Hash of the previous block's header
 	Height        uint32     // Height of the block in the blockchain
 	Timestamp     int64      // Timestamp when the block was created
+	StateRoot     types.Hash // Root of the state after applying the block
+	Seed          types.Hash // Seed the proposers of the next block are drawn with
 }
 
 // Bytes serializes the header into a byte slice using gob encoding.
@@ -32,8 +53,8 @@ func (h *Header) Bytes() []byte {
 
 // Block represents a block in the blockchain.
 type Block struct {
-	*Header                 // Pointer to the block's header
-	Transactions []*Transaction  // List of transactions in the block
+	*Header                       // Pointer to the block's header
+	Transactions []*Transaction   // List of transactions in the block
 	Validator    crypto.PublicKey // Public key of the validator who created the block
 	Signature    []byte
 	hash         types.Hash
@@ -49,36 +70,40 @@ func NewBlock(h *Header, txx []*Transaction) (*Block, error) {
 
 // NewBlockFromPrevHeader creates a new block based on the previous block's header and given transactions.
 func NewBlockFromPrevHeader(prevHeader *Header, txx []*Transaction) (*Block, error) {
-	dataHash, err := CalculateDataHash(txx)
-	if err != nil {
-		return nil, err
-	}
-
 	header := &Header{
-		Version:       1,
+		Version:       BlockVersionSeed,
 		Height:        prevHeader.Height + 1,
-		DataHash:      dataHash,
+		DataHash:      CalculateTxRoot(txx),
 		PrevBlockHash: BlockHasher{}.Hash(prevHeader),
 		Timestamp:     time.Now().UnixNano(),
+		Seed:          NextSeed(prevHeader),
 	}
 
 	return NewBlock(header, txx)
 }
 
+// NextSeed returns the seed of the block following prev. Seeds form a hash
+// chain over the heights, so they do not depend on the contents of blocks and a
+// proposer cannot grind transactions or timestamps to pick the next proposers.
+func NextSeed(prev *Header) types.Hash {
+	buf := make([]byte, len(prev.Seed)+4)
+	copy(buf, prev.Seed[:])
+	binary.BigEndian.PutUint32(buf[len(prev.Seed):], prev.Height)
+
+	return types.Hash(sha256.Sum256(buf))
+}
+
 // AddTransaction adds a transaction to the block and recalculates the block's data hash.
 func (b *Block) AddTransaction(tx *Transaction) {
 	b.Transactions = append(b.Transactions, tx)
-	hash, err := CalculateDataHash(b.Transactions)
-	if err != nil {
-		// Handle the error appropriately in production code
-		return
-	}
-	b.DataHash = hash
+	b.DataHash = CalculateTxRoot(b.Transactions)
 }
 
-// Sign signs the block with the given private key.
+// Sign signs the block with the given private key. The hash of the header is
+// signed, since ECDSA only covers the first 32 bytes of its input.
 func (b *Block) Sign(privKey *crypto.PrivateKey) error {
-	sig, err := privKey.Sign(b.Header.Bytes())
+	hash := BlockHasher{}.Hash(b.Header)
+	sig, err := privKey.Sign(hash[:])
 	if err != nil {
 		return err
 	}
@@ -92,11 +117,12 @@ func (b *Block) Sign(privKey *crypto.PrivateKey) error {
 // Verify verifies the integrity and validity of the block.
 func (b *Block) Verify() error {
 	if b.Signature == nil {
-		return fmt.Errorf("block has no signature")
+		return fmt.Errorf("%w: block has no signature", ErrInvalidSignature)
 	}
 
-	if !crypto.VerifySignature(&b.Validator, b.Header.Bytes(), b.Signature) {
-		return fmt.Errorf("block has an invalid signature")
+	hash := BlockHasher{}.Hash(b.Header)
+	if !crypto.VerifySignature(&b.Validator, hash[:], b.Signature) {
+		return fmt.Errorf("%w: block (%s)", ErrInvalidSignature, b.Hash(BlockHasher{}))
 	}
 
 	for _, tx := range b.Transactions {
@@ -105,12 +131,7 @@ func (b *Block) Verify() error {
 		}
 	}
 
-	dataHash, err := CalculateDataHash(b.Transactions)
-	if err != nil {
-		return err
-	}
-
-	if dataHash != b.DataHash {
+	if CalculateTxRoot(b.Transactions) != b.DataHash {
 		return fmt.Errorf("block (%s) has an invalid data hash", b.Hash(BlockHasher{}))
 	}
 
@@ -136,15 +157,14 @@ func (b *Block) Hash(hasher Hasher) types.Hash {
 	return b.hash
 }
 
-// CalculateDataHash computes the hash of the block's data (transactions).
-func CalculateDataHash(txx []*Transaction) (types.Hash, error) {
-	buf := &bytes.Buffer{}
-
-	for _, tx := range txx {
-		if err := tx.Encode(gob.NewEncoder(buf)); err != nil {
-			return types.Hash{}, err
+// TxProof returns a Merkle proof that the transaction with the given hash is
+// included in the block.
+func (b *Block) TxProof(hash types.Hash) (*MerkleProof, error) {
+	for i, tx := range b.Transactions {
+		if tx.Hash(TxHasher{}) == hash {
+			return NewMerkleProof(b.Transactions, i)
 		}
 	}
 
-	return types.Hash(sha256.Sum256(buf.Bytes())), nil
+	return nil, fmt.Errorf("tx (%s) not found in block (%s)", hash, b.Hash(BlockHasher{}))
 }
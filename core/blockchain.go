@@ -8,22 +8,49 @@ import (
 	"github.com/go-kit/log"
 )
 
-type Blockchain struct {
-	logger          log.Logger
-	store           Store
-	lock            sync.RWMutex
-	headers         []*Header
-	blocks          []*Block
-	txStore         map[types.Hash]*Transaction
-	blockStore      map[types.Hash]*Block
+// TxExecutionError is returned when a transaction of a block fails to execute.
+// The block is rejected and none of its state changes are applied.
+type TxExecutionError struct {
+	Block types.Hash // Hash of the rejected block
+	Index int        // Position of the failing transaction in the block
+	Tx    types.Hash // Hash of the failing transaction
+	Err   error      // Reason the transaction failed
+}
+
+func (e *TxExecutionError) Error() string {
+	return fmt.Sprintf("block (%s) tx %d (%s) failed: %s", e.Block, e.Index, e.Tx, e.Err)
+}
 
-	accountState    *AccountState
+func (e *TxExecutionError) Unwrap() error {
+	return e.Err
+}
+
+type Blockchain struct {
+	logger       log.Logger
+	store        Store
+	lock         sync.RWMutex
+	headers      []*Header
+	blocks       []*Block
+	txStore      map[types.Hash]*Transaction
+	txBlocks     map[types.Hash]*Block // block containing each tx
+	receipts     map[types.Hash]*Receipt
+	tree         map[types.Hash]*blockNode // every known block, canonical or not
+	undoLogs     map[types.Hash]UndoLog    // state undo of recent canonical blocks
+	finalized    *blockNode                // last finalized block, see Finalize
+	forkChoice   ForkChoice
+	reorgHandler ReorgHandler
+	subscribers  []ChainSubscriber
+
+	accountState *AccountState
 
 	stateLock       sync.RWMutex
 	collectionState map[types.Hash]*CollectionTx
 	mintState       map[types.Hash]*MintTx
 	validator       Validator
+	engine          Engine
 	contractState   *State
+	journal         *Journal // undo log of state changes not yet committed
+	failed          error    // ErrChainCorrupted once set, see reorg
 }
 
 // NewBlockchain creates a new Blockchain instance.
@@ -34,17 +61,38 @@ func NewBlockchain(store Store, l log.Logger, accountState *AccountState, genesi
 		accountState:    accountState,
 		collectionState: make(map[types.Hash]*CollectionTx),
 		mintState:       make(map[types.Hash]*MintTx),
-		blockStore:      make(map[types.Hash]*Block),
+		tree:            make(map[types.Hash]*blockNode),
+		undoLogs:        make(map[types.Hash]UndoLog),
+		forkChoice:      LongestChain{},
 		txStore:         make(map[types.Hash]*Transaction),
+		txBlocks:        make(map[types.Hash]*Block),
+		receipts:        make(map[types.Hash]*Receipt),
 		contractState:   NewState(),
 		headers:         []*Header{},
 		blocks:          []*Block{},
+		journal:         NewJournal(),
 	}
 	bc.validator = NewBlockValidator(bc)
+	bc.accountState.SetJournal(bc.journal)
+	bc.contractState.SetJournal(bc.journal)
+
+	head, ok, err := bc.storedHeight()
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		if err := bc.loadFromStore(genesis, head); err != nil {
+			return nil, err
+		}
+	} else if err := bc.addBlockWithoutValidation(genesis); err != nil {
+		return nil, err
+	}
 
-	if err := bc.addBlockWithoutValidation(genesis); err != nil {
+	bc.finalized = bc.tree[genesis.Hash(BlockHasher{})]
+	if err := bc.loadFinalized(); err != nil {
 		return nil, err
 	}
+
 	return bc, nil
 }
 
@@ -53,6 +101,60 @@ func (bc *Blockchain) SetValidator(v Validator) {
 	bc.validator = v
 }
 
+// SetEngine sets the consensus engine checking the proposer of every block
+// added after the genesis block. Without an engine any signer may propose.
+func (bc *Blockchain) SetEngine(e Engine) {
+	bc.engine = e
+}
+
+// verifyProposer checks the proposer of b with the consensus engine. It must
+// be called while the state is at parent.
+func (bc *Blockchain) verifyProposer(parent *Header, b *Block) error {
+	if bc.engine == nil {
+		return nil
+	}
+
+	return bc.engine.VerifyProposer(parent, b)
+}
+
+// SetForkChoice sets the rule used to pick the canonical chain. It must be set
+// before blocks other than the genesis block are added.
+func (bc *Blockchain) SetForkChoice(fc ForkChoice) {
+	bc.forkChoice = fc
+}
+
+// SetReorgHandler sets the handler notified of transactions orphaned by a
+// chain reorganization.
+func (bc *Blockchain) SetReorgHandler(h ReorgHandler) {
+	bc.reorgHandler = h
+}
+
+// Subscribe registers a subscriber notified of every change of the canonical
+// chain. Subscribers are called after the change, in the order they
+// subscribed, from the goroutine adding the block.
+func (bc *Blockchain) Subscribe(s ChainSubscriber) {
+	bc.lock.Lock()
+	defer bc.lock.Unlock()
+
+	bc.subscribers = append(bc.subscribers, s)
+}
+
+// notify sends a chain event to the subscribers. It must be called without
+// the locks held, so subscribers can query the chain.
+func (bc *Blockchain) notify(ev *ChainEvent) {
+	if len(ev.Orphaned) > 0 && bc.reorgHandler != nil {
+		bc.reorgHandler(ev.Orphaned)
+	}
+
+	bc.lock.RLock()
+	subscribers := bc.subscribers
+	bc.lock.RUnlock()
+
+	for _, s := range subscribers {
+		s(*ev)
+	}
+}
+
 // AddBlock adds a block to the blockchain after validation.
 func (bc *Blockchain) AddBlock(b *Block) error {
 	if err := bc.validator.ValidateBlock(b); err != nil {
@@ -63,24 +165,24 @@ func (bc *Blockchain) AddBlock(b *Block) error {
 }
 
 // handleNativeTransfer processes native token transfers.
-func (bc *Blockchain) handleNativeTransfer(tx *Transaction) error {
+func (bc *Blockchain) handleNativeTransfer(tx *Transaction, to types.Address) error {
 	bc.logger.Log(
 		"msg", "handle native token transfer",
 		"from", tx.From,
-		"to", tx.To,
+		"to", to,
 		"value", tx.Value,
 	)
 
-	fromAddr, err := tx.From.Address()
-	if err != nil {
-		return err
+	if to.IsZero() {
+		return fmt.Errorf("tx (%s) transfers to the zero address", tx.Hash(TxHasher{}))
 	}
-	toAddr, err := tx.To.Address()
+
+	fromAddr, err := tx.From.Address()
 	if err != nil {
 		return err
 	}
 
-	return bc.accountState.Transfer(fromAddr, toAddr, tx.Value)
+	return bc.accountState.Transfer(fromAddr, to, tx.Value)
 }
 
 // handleNativeNFT processes native NFT transactions.
@@ -89,14 +191,14 @@ func (bc *Blockchain) handleNativeNFT(tx *Transaction) error {
 
 	switch t := tx.TxInner.(type) {
 	case CollectionTx:
-		bc.collectionState[hash] = &t
+		journalMapPut(bc.journal, bc.collectionState, hash, &t)
 		bc.logger.Log("msg", "created new NFT collection", "hash", hash)
 	case MintTx:
-		_, ok := bc.collectionState[t.Collection];
+		_, ok := bc.collectionState[t.Collection]
 		if !ok {
 			return fmt.Errorf("collection (%s) does not exist on the blockchain", t.Collection)
 		}
-		bc.mintState[hash] = &t
+		journalMapPut(bc.journal, bc.mintState, hash, &t)
 
 		bc.logger.Log("msg", "created new NFT mint", "NFT", t.NFT, "collection", t.Collection)
 	default:
@@ -106,17 +208,50 @@ func (bc *Blockchain) handleNativeNFT(tx *Transaction) error {
 	return nil
 }
 
+// handleStaking bonds or unbonds stake of the sender of a staking
+// transaction. Unbonded stake returns to the balance UnbondingPeriod blocks
+// after the block including the transaction.
+func (bc *Blockchain) handleStaking(tx *Transaction, from types.Address, ctx *execContext) error {
+	switch t := tx.TxInner.(type) {
+	case StakeTx:
+		if err := bc.accountState.Bond(from, t.Amount); err != nil {
+			return err
+		}
+		bc.logger.Log("msg", "bonded stake", "address", from, "amount", t.Amount)
+	case UnstakeTx:
+		release := ctx.height + UnbondingPeriod
+		if err := bc.accountState.Unbond(from, t.Amount, release); err != nil {
+			return err
+		}
+		bc.logger.Log("msg", "unbonding stake", "address", from, "amount", t.Amount, "release", release)
+	default:
+		return fmt.Errorf("unsupported tx type %T", t)
+	}
+
+	return nil
+}
+
 // GetBlockByHash retrieves a block by its hash.
 func (bc *Blockchain) GetBlockByHash(hash types.Hash) (*Block, error) {
 	bc.lock.RLock()
 	defer bc.lock.RUnlock()
 
-	block, ok := bc.blockStore[hash]
+	node, ok := bc.tree[hash]
 	if !ok {
 		return nil, fmt.Errorf("block with hash (%s) not found", hash)
 	}
 
-	return block, nil
+	return node.block, nil
+}
+
+// HasBlockHash checks if a block with the given hash is known, either on the
+// canonical chain or on a fork.
+func (bc *Blockchain) HasBlockHash(hash types.Hash) bool {
+	bc.lock.RLock()
+	defer bc.lock.RUnlock()
+
+	_, ok := bc.tree[hash]
+	return ok
 }
 
 // GetBlock retrieves a block by its height.
@@ -156,6 +291,54 @@ func (bc *Blockchain) GetTxByHash(hash types.Hash) (*Transaction, error) {
 	return tx, nil
 }
 
+// GetReceipt returns the receipt of a transaction on the canonical chain.
+func (bc *Blockchain) GetReceipt(hash types.Hash) (*Receipt, error) {
+	bc.lock.RLock()
+	defer bc.lock.RUnlock()
+
+	receipt, ok := bc.receipts[hash]
+	if !ok {
+		return nil, fmt.Errorf("could not find receipt of tx with hash (%s)", hash)
+	}
+
+	return receipt, nil
+}
+
+// GetCode returns the code of the contract deployed at the given address.
+func (bc *Blockchain) GetCode(contract types.Address) ([]byte, error) {
+	bc.stateLock.RLock()
+	defer bc.stateLock.RUnlock()
+
+	return bc.contractState.GetCode(contract)
+}
+
+// GetStorage returns an entry of the storage of a contract.
+func (bc *Blockchain) GetStorage(contract types.Address, key []byte) ([]byte, error) {
+	bc.stateLock.RLock()
+	defer bc.stateLock.RUnlock()
+
+	return bc.contractState.GetStorage(contract, key)
+}
+
+// GetTxProof returns the block containing the transaction with the given hash
+// together with a Merkle proof of its inclusion.
+func (bc *Blockchain) GetTxProof(hash types.Hash) (*Block, *MerkleProof, error) {
+	bc.lock.RLock()
+	block, ok := bc.txBlocks[hash]
+	bc.lock.RUnlock()
+
+	if !ok {
+		return nil, nil, fmt.Errorf("could not find tx with hash (%s)", hash)
+	}
+
+	proof, err := block.TxProof(hash)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return block, proof, nil
+}
+
 // HasBlock checks if a block exists at a given height.
 func (bc *Blockchain) HasBlock(height uint32) bool {
 	bc.lock.RLock()
@@ -163,6 +346,74 @@ func (bc *Blockchain) HasBlock(height uint32) bool {
 	return height <= uint32(len(bc.headers)-1)
 }
 
+// NextNonce returns the nonce expected on the next transaction sent by the
+// given address.
+func (bc *Blockchain) NextNonce(address types.Address) uint64 {
+	return bc.accountState.GetNonce(address)
+}
+
+// Balance returns the balance of the given address, zero for unknown
+// accounts.
+func (bc *Blockchain) Balance(address types.Address) uint64 {
+	balance, err := bc.accountState.GetBalance(address)
+	if err != nil {
+		return 0
+	}
+
+	return balance
+}
+
+// Stake returns the stake bonded by the given address at the tip of the chain.
+func (bc *Blockchain) Stake(address types.Address) uint64 {
+	return bc.accountState.GetStake(address)
+}
+
+// Unbonding returns the unstaked funds of the given address that have not
+// returned to its balance yet, by release height.
+func (bc *Blockchain) Unbonding(address types.Address) []UnbondingEntry {
+	account, err := bc.accountState.GetAccount(address)
+	if err != nil {
+		return nil
+	}
+
+	return append([]UnbondingEntry(nil), account.Unbonding...)
+}
+
+// Jailed reports whether the given address was jailed for double-signing at
+// the tip of the chain.
+func (bc *Blockchain) Jailed(address types.Address) bool {
+	return bc.accountState.IsJailed(address)
+}
+
+// Stakes returns the stake bonded by every validator at the tip of the chain.
+func (bc *Blockchain) Stakes() map[types.Address]uint64 {
+	return bc.accountState.Stakes()
+}
+
+// StakesAt returns the stake of every validator that is not jailed in the
+// state after the block with the given hash. It is known for the blocks
+// applied since the last finalized one, including it, whether they are
+// canonical or not.
+func (bc *Blockchain) StakesAt(hash types.Hash) (map[types.Address]uint64, error) {
+	bc.lock.RLock()
+	defer bc.lock.RUnlock()
+
+	node, ok := bc.tree[hash]
+	if !ok {
+		return nil, fmt.Errorf("block with hash (%s) not found", hash)
+	}
+	if node.stakes == nil {
+		return nil, fmt.Errorf("%w: block (%s) at height (%d)", ErrNoStakeTable, hash, node.block.Height)
+	}
+
+	stakes := make(map[types.Address]uint64, len(node.stakes))
+	for address, stake := range node.stakes {
+		stakes[address] = stake
+	}
+
+	return stakes, nil
+}
+
 // Height returns the current height of the blockchain.
 func (bc *Blockchain) Height() uint32 {
 	bc.lock.RLock()
@@ -171,67 +422,401 @@ func (bc *Blockchain) Height() uint32 {
 	return uint32(len(bc.headers) - 1)
 }
 
-// handleTransaction processes a transaction.
-func (bc *Blockchain) handleTransaction(tx *Transaction) error {
-	if len(tx.Data) > 0 {
-		bc.logger.Log("msg", "executing code", "len", len(tx.Data), "hash", tx.Hash(TxHasher{}))
+// handleTransaction processes a transaction. Failing code does not make the
+// transaction invalid: its changes are reverted, all of its gas is used and the
+// failure is recorded in the receipt. Any other failure is returned as an
+// error.
+func (bc *Blockchain) handleTransaction(tx *Transaction, ctx *execContext) (*Receipt, error) {
+	receipt := &Receipt{TxHash: tx.Hash(TxHasher{})}
+
+	// Every signed transaction consumes the next nonce of its sender, so it can
+	// never be replayed.
+	var from types.Address
+	if tx.From.PublicKey != nil {
+		var err error
+		if from, err = tx.From.Address(); err != nil {
+			return nil, err
+		}
+		if err := bc.accountState.UseNonce(from, tx.Nonce); err != nil {
+			return nil, err
+		}
+	}
 
-		vm := NewVM(tx.Data, bc.contractState)
-		if err := vm.Run(); err != nil {
-			return err
+	if err := bc.payFee(tx, from, ctx); err != nil {
+		return nil, err
+	}
+
+	gas, err := bc.buyGas(tx, from)
+	if err != nil {
+		return nil, err
+	}
+
+	// A transaction without a recipient deploys its data as a contract, one
+	// sent to a contract runs its code with the data as input.
+	to := tx.To
+	snapshot := bc.journal.Snapshot()
+	switch {
+	case tx.IsContractCreation():
+		to = ContractAddress(from, tx.Nonce)
+		receipt.ContractAddress = to
+		gas, receipt.Err = bc.deployContract(to, tx.Data, gas)
+	case bc.contractState.HasCode(to):
+		callCtx := CallContext{
+			Address: to,
+			Caller:  from,
+			Value:   tx.Value,
+			Height:  ctx.height,
+			Input:   tx.Data,
 		}
+		gas, receipt.Err = bc.callContract(callCtx, gas)
+	}
+	if receipt.Err != nil {
+		bc.journal.RevertToSnapshot(snapshot)
+		bc.logger.Log("msg", "contract execution failed", "hash", receipt.TxHash, "err", receipt.Err)
+
+		gas = 0
 	}
 
-	if tx.TxInner != nil {
-		switch tx.TxInner.(type) {
-		case *CollectionTx, *MintTx:
-			if err := bc.handleNativeNFT(tx); err != nil {
-				return err
+	if receipt.Err == nil {
+		if tx.TxInner != nil {
+			switch tx.TxInner.(type) {
+			case CollectionTx, MintTx:
+				if err := bc.handleNativeNFT(tx); err != nil {
+					return nil, err
+				}
+			case StakeTx, UnstakeTx:
+				if err := bc.handleStaking(tx, from, ctx); err != nil {
+					return nil, err
+				}
+			case EvidenceTx:
+				if err := bc.handleEvidence(tx.TxInner.(EvidenceTx), from); err != nil {
+					return nil, err
+				}
+			default:
+				return nil, fmt.Errorf("unsupported tx type %T", tx.TxInner)
 			}
-		default:
-			return fmt.Errorf("unsupported tx type %T", tx.TxInner)
 		}
-	}
 
-	if tx.Value > 0 {
-		if err := bc.handleNativeTransfer(tx); err != nil {
-			return err
+		if tx.Value > 0 {
+			if err := bc.handleNativeTransfer(tx, to); err != nil {
+				return nil, err
+			}
 		}
 	}
 
+	receipt.GasUsed = tx.GasLimit - gas
+	bc.refundGas(tx, from, gas, ctx)
+
+	return receipt, nil
+}
+
+// deployContract stores code at the given address and returns the gas left.
+func (bc *Blockchain) deployContract(address types.Address, code []byte, gas uint64) (uint64, error) {
+	if bc.contractState.HasCode(address) {
+		return gas, fmt.Errorf("%w: (%s)", ErrContractExists, address)
+	}
+
+	cost := uint64(len(code)) * GasCodeByte
+	if cost > gas {
+		return 0, ErrOutOfGas
+	}
+
+	bc.logger.Log("msg", "deploying contract", "address", address, "len", len(code))
+
+	return gas - cost, bc.contractState.PutCode(address, code)
+}
+
+// callContract runs the code of the contract in the call context and returns
+// the gas left.
+func (bc *Blockchain) callContract(ctx CallContext, gas uint64) (uint64, error) {
+	code, err := bc.contractState.GetCode(ctx.Address)
+	if err != nil {
+		return gas, err
+	}
+
+	bc.logger.Log("msg", "executing code", "contract", ctx.Address, "len", len(code), "gas", gas)
+
+	vm := NewVM(code, bc.contractState, ctx, gas)
+	if err := vm.Run(); err != nil {
+		return 0, err
+	}
+
+	return vm.GasLeft(), nil
+}
+
+// payFee moves the inclusion fee of the transaction from the sender to the
+// coinbase of the block. The fee is paid even if the code of the transaction
+// fails.
+func (bc *Blockchain) payFee(tx *Transaction, from types.Address, ctx *execContext) error {
+	fee := tx.InclusionFee()
+	if fee == 0 {
+		return nil
+	}
+
+	if tx.From.PublicKey == nil {
+		return fmt.Errorf("unsigned tx (%s) cannot pay a fee", tx.Hash(TxHasher{}))
+	}
+	if err := bc.accountState.SubBalance(from, fee); err != nil {
+		return err
+	}
+	if ctx.coinbase != nil {
+		bc.accountState.AddBalance(*ctx.coinbase, fee)
+	}
+
 	return nil
 }
 
+// buyGas charges the sender for the full gas limit of the transaction and
+// returns the gas left for its code after the intrinsic cost.
+func (bc *Blockchain) buyGas(tx *Transaction, from types.Address) (uint64, error) {
+	if tx.GasLimit > MaxTxGas {
+		return 0, fmt.Errorf("%w: (%d), max (%d)", ErrGasLimitTooHigh, tx.GasLimit, MaxTxGas)
+	}
+
+	intrinsic := IntrinsicGas(tx)
+	if tx.GasLimit < intrinsic {
+		return 0, fmt.Errorf("%w: limit (%d), intrinsic (%d)", ErrIntrinsicGas, tx.GasLimit, intrinsic)
+	}
+
+	fee, err := gasFee(tx.GasLimit, tx.GasPrice)
+	if err != nil {
+		return 0, err
+	}
+	if fee > 0 {
+		if tx.From.PublicKey == nil {
+			return 0, fmt.Errorf("unsigned tx (%s) cannot pay for gas", tx.Hash(TxHasher{}))
+		}
+		if err := bc.accountState.SubBalance(from, fee); err != nil {
+			return 0, err
+		}
+	}
+
+	return tx.GasLimit - intrinsic, nil
+}
+
+// refundGas returns the unused gas to the sender and pays the used gas to the
+// coinbase of the block.
+func (bc *Blockchain) refundGas(tx *Transaction, from types.Address, gasLeft uint64, ctx *execContext) {
+	if tx.GasPrice == 0 {
+		return
+	}
+
+	// Both fees are at most GasLimit * GasPrice, which was checked in buyGas.
+	if refund := gasLeft * tx.GasPrice; refund > 0 {
+		bc.accountState.AddBalance(from, refund)
+	}
+	if paid := (tx.GasLimit - gasLeft) * tx.GasPrice; paid > 0 && ctx.coinbase != nil {
+		bc.accountState.AddBalance(*ctx.coinbase, paid)
+	}
+}
+
 // addBlockWithoutValidation adds a block to the blockchain without validation.
+// A block extending the canonical chain is applied right away; if any of its
+// transactions fails it is rejected with a *TxExecutionError and the state is
+// left untouched. A block on a fork is kept in the block tree and triggers a
+// reorganization once its branch is preferred by the fork choice.
 func (bc *Blockchain) addBlockWithoutValidation(b *Block) error {
 	bc.stateLock.Lock()
-	defer bc.stateLock.Unlock()
 
-	for _, tx := range b.Transactions {
-		if err := bc.handleTransaction(tx); err != nil {
-			bc.logger.Log("error", err.Error())
-			continue
+	if bc.failed != nil {
+		bc.stateLock.Unlock()
+		return bc.failed
+	}
+
+	bc.lock.RLock()
+	parent, hasParent := bc.tree[b.PrevBlockHash]
+	tip := bc.tipNode()
+	bc.lock.RUnlock()
+
+	if tip != nil && !hasParent {
+		bc.stateLock.Unlock()
+		return fmt.Errorf("%w: block (%s) parent (%s)", ErrUnknownParent, b.Hash(BlockHasher{}), b.PrevBlockHash)
+	}
+
+	node := bc.newNode(b, parent)
+
+	if tip == nil || parent == tip {
+		err := bc.connectBlock(node, true)
+		bc.stateLock.Unlock()
+		if err != nil {
+			return err
 		}
+
+		bc.logger.Log(
+			"msg", "new block",
+			"hash", b.Hash(BlockHasher{}),
+			"height", b.Height,
+			"transactions", len(b.Transactions),
+		)
+
+		bc.notify(&ChainEvent{Connected: []*Block{b}})
+
+		return nil
 	}
 
-	defer bc.lock.Unlock()
+	ev, err := bc.addForkBlock(node, tip)
+	bc.stateLock.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if ev != nil {
+		bc.notify(ev)
+	}
+
+	return nil
+}
+
+// connectBlock executes a block on top of the current state, checks its state
+// root and appends it to the canonical chain, optionally persisting it. On
+// failure every state change of the block is reverted. It must be called with
+// the state lock held.
+func (bc *Blockchain) connectBlock(node *blockNode, persist bool) error {
+	b := node.block
+	hash := b.Hash(BlockHasher{})
+
+	if err := checkVersion(b); err != nil {
+		return err
+	}
+
+	snapshot := bc.journal.Snapshot()
+	receipts, err := bc.executeBlock(b)
+	if err != nil {
+		return err
+	}
+
+	// Only the genesis block may predate state roots, see checkVersion.
+	if b.Version >= BlockVersionStateRoot {
+		if root := CalculateStateRoot(bc.accountState, bc.contractState, bc.collectionState, bc.mintState); root != b.StateRoot {
+			bc.journal.RevertToSnapshot(snapshot)
+			return fmt.Errorf("%w: block (%s) claims (%s), computed (%s)", ErrInvalidStateRoot, hash, b.StateRoot, root)
+		}
+	}
+
+	if persist {
+		if err := bc.persistBlock(b); err != nil {
+			bc.journal.RevertToSnapshot(snapshot)
+			return err
+		}
+	}
+
+	stakes := bc.accountState.ValidatorStakes()
 
 	bc.lock.Lock()
+	defer bc.lock.Unlock()
+
+	bc.undoLogs[hash] = bc.journal.Commit(snapshot)
+	node.stakes = stakes
+	bc.insertNode(node)
 	bc.headers = append(bc.headers, b.Header)
 	bc.blocks = append(bc.blocks, b)
-	bc.blockStore[b.Hash(BlockHasher{})] = b
 
-	for _, tx := range b.Transactions {
-		bc.txStore[tx.Hash(TxHasher{})] = tx
+	for i, tx := range b.Transactions {
+		txHash := tx.Hash(TxHasher{})
+		bc.txStore[txHash] = tx
+		bc.txBlocks[txHash] = b
+		bc.receipts[txHash] = receipts[i]
 	}
-	bc.lock.Unlock()
 
-	bc.logger.Log(
-		"msg", "new block",
-		"hash", b.Hash(BlockHasher{}),
-		"height", b.Height,
-		"transactions", len(b.Transactions),
-	)
+	// Only the most recent blocks can be unwound by a reorg.
+	if len(bc.blocks) > MaxReorgDepth {
+		old := bc.blocks[len(bc.blocks)-MaxReorgDepth-1]
+		delete(bc.undoLogs, old.Hash(BlockHasher{}))
+	}
+
+	return nil
+}
+
+// executeBlock runs the transactions of a block against the state and returns
+// their receipts. It must be called with the state lock held. If a transaction
+// fails, the changes of the whole block are reverted and a *TxExecutionError is
+// returned.
+func (bc *Blockchain) executeBlock(b *Block) ([]*Receipt, error) {
+	snapshot := bc.journal.Snapshot()
+	ctx := newExecContext(b)
+
+	// Unstaked funds return to their owners before the transactions of the
+	// block run, so they can be spent in it.
+	bc.accountState.ReleaseUnbonded(b.Height)
+
+	receipts := make([]*Receipt, len(b.Transactions))
+	for i, tx := range b.Transactions {
+		receipt, err := bc.handleTransaction(tx, ctx)
+		if err != nil {
+			bc.journal.RevertToSnapshot(snapshot)
+
+			return nil, &TxExecutionError{
+				Block: b.Hash(BlockHasher{}),
+				Index: i,
+				Tx:    tx.Hash(TxHasher{}),
+				Err:   err,
+			}
+		}
+		receipts[i] = receipt
+	}
+
+	return receipts, nil
+}
+
+// applyTransaction executes a single transaction atomically: if it fails all
+// of its changes are reverted. It must be called with the state lock held.
+func (bc *Blockchain) applyTransaction(tx *Transaction, ctx *execContext) error {
+	snapshot := bc.journal.Snapshot()
+
+	if _, err := bc.handleTransaction(tx, ctx); err != nil {
+		bc.journal.RevertToSnapshot(snapshot)
+		return err
+	}
+
+	return nil
+}
+
+// FilterExecutable returns the transactions, in order, that execute
+// successfully on top of the current state when applied one after another.
+// The chain itself is unchanged.
+func (bc *Blockchain) FilterExecutable(txx []*Transaction) []*Transaction {
+	bc.stateLock.Lock()
+	defer bc.stateLock.Unlock()
+
+	snapshot := bc.journal.Snapshot()
+	defer bc.journal.RevertToSnapshot(snapshot)
+
+	// The block is not built yet, so its gas fees are burned here.
+	ctx := &execContext{height: bc.Height() + 1}
+	bc.accountState.ReleaseUnbonded(ctx.height)
+
+	executable := []*Transaction{}
+	for _, tx := range txx {
+		if err := bc.applyTransaction(tx, ctx); err != nil {
+			bc.logger.Log("msg", "dropping failing tx", "hash", tx.Hash(TxHasher{}), "err", err)
+			continue
+		}
+		executable = append(executable, tx)
+	}
+
+	return executable
+}
+
+// StateRoot returns the root of the current account and contract state.
+func (bc *Blockchain) StateRoot() types.Hash {
+	bc.stateLock.RLock()
+	defer bc.stateLock.RUnlock()
+
+	return CalculateStateRoot(bc.accountState, bc.contractState, bc.collectionState, bc.mintState)
+}
+
+// ComputeStateRoot returns the state root the chain would have after applying
+// the given block on top of the current state. The chain itself is unchanged.
+func (bc *Blockchain) ComputeStateRoot(b *Block) (types.Hash, error) {
+	bc.stateLock.Lock()
+	defer bc.stateLock.Unlock()
+
+	snapshot := bc.journal.Snapshot()
+	defer bc.journal.RevertToSnapshot(snapshot)
+
+	if _, err := bc.executeBlock(b); err != nil {
+		return types.Hash{}, err
+	}
 
-	return bc.store.Put(b.Hash(BlockHasher{}).String() byte)
+	return CalculateStateRoot(bc.accountState, bc.contractState, bc.collectionState, bc.mintState), nil
 }
@@ -0,0 +1,160 @@
+package core
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+
+	"github.com/blu-fi-tech-inc/blufi-network/types"
+)
+
+// Keys used by the Blockchain inside its Store. Blocks are stored by hash,
+// the canonical chain is an index from height to block hash, the head key
+// holds the height of the last committed block and the finalized key the hash
+// of the last finalized block.
+const (
+	storeHeadKey      = "head"
+	storeFinalizedKey = "finalized"
+)
+
+func storeBlockKey(hash types.Hash) string {
+	return "block/" + hash.String()
+}
+
+func storeHeightKey(height uint32) string {
+	return fmt.Sprintf("height/%d", height)
+}
+
+// persistBlock writes a canonical block, its height index and the new head to
+// the store. The head is written last so a crash in between leaves the
+// previous head intact.
+func (bc *Blockchain) persistBlock(b *Block) error {
+	if err := bc.persistBlockBody(b); err != nil {
+		return err
+	}
+
+	hash := b.Hash(BlockHasher{})
+	if err := bc.store.Put(storeHeightKey(b.Height), hash.ToSlice()); err != nil {
+		return err
+	}
+
+	head := make([]byte, 4)
+	binary.BigEndian.PutUint32(head, b.Height)
+
+	return bc.store.Put(storeHeadKey, head)
+}
+
+// persistBlockBody writes a block to the store without making it canonical.
+func (bc *Blockchain) persistBlockBody(b *Block) error {
+	buf := &bytes.Buffer{}
+	if err := b.Encode(gob.NewEncoder(buf)); err != nil {
+		return err
+	}
+
+	return bc.store.Put(storeBlockKey(b.Hash(BlockHasher{})), buf.Bytes())
+}
+
+// storedHeight returns the height of the last block committed to the store.
+// ok is false if the store does not hold a chain yet.
+func (bc *Blockchain) storedHeight() (height uint32, ok bool, err error) {
+	head, err := bc.store.Get(storeHeadKey)
+	if errors.Is(err, ErrKeyNotFound) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	if len(head) != 4 {
+		return 0, false, fmt.Errorf("invalid stored head length (%d)", len(head))
+	}
+
+	return binary.BigEndian.Uint32(head), true, nil
+}
+
+// readBlock loads the canonical block at the given height from the store.
+func (bc *Blockchain) readBlock(height uint32) (*Block, error) {
+	hashBytes, err := bc.store.Get(storeHeightKey(height))
+	if err != nil {
+		return nil, err
+	}
+	hash, err := types.HashFromBytes(hashBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := bc.store.Get(storeBlockKey(hash))
+	if err != nil {
+		return nil, err
+	}
+
+	b := new(Block)
+	if err := b.Decode(gob.NewDecoder(bytes.NewReader(data))); err != nil {
+		return nil, err
+	}
+	if b.Hash(BlockHasher{}) != hash {
+		return nil, fmt.Errorf("stored block at height (%d) does not match its hash (%s)", height, hash)
+	}
+
+	return b, nil
+}
+
+// loadFinalized restores the last finalized block from the store. It must be a
+// block of the restored canonical chain.
+func (bc *Blockchain) loadFinalized() error {
+	hashBytes, err := bc.store.Get(storeFinalizedKey)
+	if errors.Is(err, ErrKeyNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	hash, err := types.HashFromBytes(hashBytes)
+	if err != nil {
+		return err
+	}
+
+	node, ok := bc.tree[hash]
+	if !ok || !bc.isCanonical(node) {
+		return fmt.Errorf("stored finalized block (%s) is not on the stored chain", hash)
+	}
+	bc.finalized = node
+	pruneStakes(node)
+
+	return nil
+}
+
+// loadFromStore rebuilds the chain from the store, replaying every block up to
+// head against the account and contract state.
+func (bc *Blockchain) loadFromStore(genesis *Block, head uint32) error {
+	for height := uint32(0); height <= head; height++ {
+		b, err := bc.readBlock(height)
+		if err != nil {
+			return fmt.Errorf("failed to load block at height (%d): %w", height, err)
+		}
+
+		if height == 0 && b.Hash(BlockHasher{}) != genesis.Hash(BlockHasher{}) {
+			return fmt.Errorf(
+				"stored genesis block (%s) does not match (%s)",
+				b.Hash(BlockHasher{}),
+				genesis.Hash(BlockHasher{}),
+			)
+		}
+
+		bc.stateLock.Lock()
+		bc.lock.RLock()
+		parent := bc.tipNode()
+		bc.lock.RUnlock()
+		err = bc.connectBlock(bc.newNode(b, parent), false)
+		bc.stateLock.Unlock()
+
+		if err != nil {
+			return fmt.Errorf("failed to replay block at height (%d): %w", height, err)
+		}
+	}
+
+	bc.logger.Log("msg", "restored blockchain from store", "height", head)
+
+	return nil
+}
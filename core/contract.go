@@ -0,0 +1,72 @@
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+
+	"github.com/blu-fi-tech-inc/blufi-network/types"
+)
+
+// GasCodeByte is the gas charged per byte of code stored by a deployment.
+const GasCodeByte uint64 = 200
+
+// ErrContractExists is returned when deploying to an address that already holds
+// code.
+var ErrContractExists = errors.New("contract already exists")
+
+// ContractAddress derives the address of the contract deployed by the
+// transaction of sender with the given nonce.
+func ContractAddress(sender types.Address, nonce uint64) types.Address {
+	buf := make([]byte, len(sender)+8)
+	copy(buf, sender[:])
+	binary.BigEndian.PutUint64(buf[len(sender):], nonce)
+
+	hash := sha256.Sum256(buf)
+
+	var address types.Address
+	copy(address[:], hash[:len(address)])
+	return address
+}
+
+// Contracts live in State under prefixed keys: "c" + address holds the code of
+// a contract and "s" + address + key an entry of its storage, so contracts can
+// only touch their own storage.
+func codeKey(contract types.Address) []byte {
+	return append([]byte("c"), contract[:]...)
+}
+
+func storageKey(contract types.Address, key []byte) []byte {
+	k := append([]byte("s"), contract[:]...)
+	return append(k, key...)
+}
+
+// PutCode stores the code of a contract.
+func (s *State) PutCode(contract types.Address, code []byte) error {
+	return s.Put(codeKey(contract), code)
+}
+
+// GetCode returns the code of a contract.
+func (s *State) GetCode(contract types.Address) ([]byte, error) {
+	return s.Get(codeKey(contract))
+}
+
+// HasCode checks if code is deployed at the given address.
+func (s *State) HasCode(contract types.Address) bool {
+	_, err := s.GetCode(contract)
+	return err == nil
+}
+
+// PutStorage sets a storage entry of a contract.
+func (s *State) PutStorage(contract types.Address, key, value []byte) error {
+	if len(key) == 0 {
+		return errors.New("key cannot be empty")
+	}
+
+	return s.Put(storageKey(contract, key), value)
+}
+
+// GetStorage returns a storage entry of a contract.
+func (s *State) GetStorage(contract types.Address, key []byte) ([]byte, error) {
+	return s.Get(storageKey(contract, key))
+}
@@ -0,0 +1,150 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"math/bits"
+
+	"github.com/blu-fi-tech-inc/blufi-network/crypto"
+	"github.com/blu-fi-tech-inc/blufi-network/types"
+)
+
+// A validator that signs two different blocks at the same height loses
+// SlashPercent of its bonded and unbonding stake and is jailed for good.
+// EvidenceRewardPercent of the slashed funds go to the sender of the
+// evidence, the rest is burned.
+const (
+	SlashPercent          uint64 = 50
+	EvidenceRewardPercent uint64 = 10
+)
+
+// ErrInvalidEvidence is returned for evidence that does not prove that a
+// validator signed two blocks at the same height.
+var ErrInvalidEvidence = errors.New("invalid double-sign evidence")
+
+// SignedHeader is a block header with the signature of its validator.
+type SignedHeader struct {
+	Header    *Header
+	Validator crypto.PublicKey
+	Signature []byte
+}
+
+// NewSignedHeader returns the signed header of a block.
+func NewSignedHeader(b *Block) *SignedHeader {
+	return &SignedHeader{
+		Header:    b.Header,
+		Validator: b.Validator,
+		Signature: b.Signature,
+	}
+}
+
+// Verify checks that the header is signed by its validator, like
+// Block.Verify.
+func (h *SignedHeader) Verify() error {
+	if h.Header == nil || h.Validator.PublicKey == nil || h.Signature == nil {
+		return fmt.Errorf("%w: header not signed", ErrInvalidSignature)
+	}
+	hash := BlockHasher{}.Hash(h.Header)
+	if !crypto.VerifySignature(&h.Validator, hash[:], h.Signature) {
+		return fmt.Errorf("%w: header (%s)", ErrInvalidSignature, hash)
+	}
+
+	return nil
+}
+
+// EvidenceTx proves that a validator signed two different blocks at the same
+// height. Including it in a block slashes and jails the validator.
+type EvidenceTx struct {
+	First  *SignedHeader
+	Second *SignedHeader
+}
+
+// NewEvidenceTx creates the evidence that the validator of a and b signed
+// both.
+func NewEvidenceTx(a, b *Block) EvidenceTx {
+	return EvidenceTx{
+		First:  NewSignedHeader(a),
+		Second: NewSignedHeader(b),
+	}
+}
+
+// Verify checks that both headers are signed by the same validator, at the
+// same height, and differ.
+func (e EvidenceTx) Verify() error {
+	if e.First == nil || e.Second == nil {
+		return fmt.Errorf("%w: missing header", ErrInvalidEvidence)
+	}
+	if err := e.First.Verify(); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidEvidence, err)
+	}
+	if err := e.Second.Verify(); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidEvidence, err)
+	}
+
+	first, err := e.First.Validator.Address()
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidEvidence, err)
+	}
+	second, err := e.Second.Validator.Address()
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidEvidence, err)
+	}
+	if first != second {
+		return fmt.Errorf("%w: headers signed by (%s) and (%s)", ErrInvalidEvidence, first, second)
+	}
+
+	if e.First.Header.Height != e.Second.Header.Height {
+		return fmt.Errorf("%w: headers at heights (%d) and (%d)", ErrInvalidEvidence, e.First.Header.Height, e.Second.Header.Height)
+	}
+	hasher := BlockHasher{}
+	if hasher.Hash(e.First.Header) == hasher.Hash(e.Second.Header) {
+		return fmt.Errorf("%w: headers are the same", ErrInvalidEvidence)
+	}
+
+	return nil
+}
+
+// Offender returns the address of the validator that signed both headers. The
+// evidence must have been verified.
+func (e EvidenceTx) Offender() (types.Address, error) {
+	return e.First.Validator.Address()
+}
+
+// handleEvidence slashes and jails the validator convicted by an evidence
+// transaction and rewards its sender.
+func (bc *Blockchain) handleEvidence(evidence EvidenceTx, from types.Address) error {
+	offender, err := evidence.Offender()
+	if err != nil {
+		return err
+	}
+
+	if bc.Stake(offender) == 0 && len(bc.Unbonding(offender)) == 0 {
+		return fmt.Errorf("%w: validator (%s) has no stake", ErrInvalidEvidence, offender)
+	}
+
+	slashed, err := bc.accountState.Slash(offender, SlashPercent)
+	if err != nil {
+		return err
+	}
+	reward := percentOf(slashed, EvidenceRewardPercent)
+	bc.accountState.AddBalance(from, reward)
+
+	bc.logger.Log(
+		"msg", "slashed validator for double-signing",
+		"validator", offender,
+		"height", evidence.First.Header.Height,
+		"slashed", slashed,
+		"reward", reward,
+	)
+
+	return nil
+}
+
+// percentOf returns percent of amount, rounded down. percent must not exceed
+// 100.
+func percentOf(amount, percent uint64) uint64 {
+	hi, lo := bits.Mul64(amount, percent)
+	q, _ := bits.Div64(hi, lo, 100)
+
+	return q
+}
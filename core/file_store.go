@@ -0,0 +1,334 @@
+package core
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// DefaultMaxSegmentSize is the size after which the active segment file is
+	// sealed and a new one is started.
+	DefaultMaxSegmentSize int64 = 64 << 20
+
+	segmentExt        = ".seg"
+	recordHeaderSize  = 12
+	tombstoneValueLen = ^uint32(0)
+)
+
+var (
+	ErrKeyNotFound    = errors.New("key not found")
+	ErrEmptyKey       = errors.New("key cannot be empty")
+	ErrCorruptSegment = errors.New("corrupt segment file")
+)
+
+// indexEntry points at the value of a key inside a segment file.
+type indexEntry struct {
+	segment uint32
+	offset  int64
+	size    uint32
+}
+
+// FileStore is a Store backed by append-only segment files on disk.
+//
+// Every Put and Delete appends a checksummed record to the active segment.
+// An in-memory index from key to record location is rebuilt by scanning the
+// segments when the store is opened. A torn record at the tail of the last
+// segment (e.g. after a crash) is truncated away.
+type FileStore struct {
+	mu             sync.RWMutex
+	dir            string
+	maxSegmentSize int64
+	segments       map[uint32]*os.File
+	active         *os.File
+	activeID       uint32
+	activeSize     int64
+	index          map[string]indexEntry
+}
+
+// NewFileStore opens (or creates) a FileStore in the given directory.
+func NewFileStore(dir string) (*FileStore, error) {
+	return NewFileStoreWithSegmentSize(dir, DefaultMaxSegmentSize)
+}
+
+// NewFileStoreWithSegmentSize opens (or creates) a FileStore in the given
+// directory, rolling over to a new segment once maxSegmentSize is reached.
+func NewFileStoreWithSegmentSize(dir string, maxSegmentSize int64) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	s := &FileStore{
+		dir:            dir,
+		maxSegmentSize: maxSegmentSize,
+		segments:       make(map[uint32]*os.File),
+		index:          make(map[string]indexEntry),
+	}
+
+	ids, err := s.segmentIDs()
+	if err != nil {
+		return nil, err
+	}
+
+	for i, id := range ids {
+		f, err := os.OpenFile(s.segmentPath(id), os.O_RDWR, 0o644)
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+		s.segments[id] = f
+
+		size, err := s.loadSegment(id, f, i == len(ids)-1)
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+
+		s.active = f
+		s.activeID = id
+		s.activeSize = size
+	}
+
+	if s.active == nil {
+		if err := s.newSegment(0); err != nil {
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+// Get retrieves a value by key.
+func (s *FileStore) Get(key string) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	entry, ok := s.index[key]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrKeyNotFound, key)
+	}
+
+	value := make([]byte, entry.size)
+	if _, err := s.segments[entry.segment].ReadAt(value, entry.offset); err != nil {
+		return nil, err
+	}
+
+	return value, nil
+}
+
+// Put stores a value by key.
+func (s *FileStore) Put(key string, value []byte) error {
+	if len(key) == 0 {
+		return ErrEmptyKey
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	offset, err := s.append(key, value, uint32(len(value)))
+	if err != nil {
+		return err
+	}
+
+	s.index[key] = indexEntry{
+		segment: s.activeID,
+		offset:  offset,
+		size:    uint32(len(value)),
+	}
+
+	return s.maybeRollover()
+}
+
+// Delete removes a value by key.
+func (s *FileStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.index[key]; !ok {
+		return nil
+	}
+
+	if _, err := s.append(key, nil, tombstoneValueLen); err != nil {
+		return err
+	}
+	delete(s.index, key)
+
+	return s.maybeRollover()
+}
+
+// Close closes all open segment files.
+func (s *FileStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var firstErr error
+	for id, f := range s.segments {
+		if err := f.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		delete(s.segments, id)
+	}
+	s.active = nil
+
+	return firstErr
+}
+
+// append writes a single record to the active segment and syncs it to disk.
+// It returns the offset of the value inside the segment.
+func (s *FileStore) append(key string, value []byte, valueLen uint32) (int64, error) {
+	rec := make([]byte, recordHeaderSize+len(key)+len(value))
+	binary.LittleEndian.PutUint32(rec[4:8], uint32(len(key)))
+	binary.LittleEndian.PutUint32(rec[8:12], valueLen)
+	copy(rec[recordHeaderSize:], key)
+	copy(rec[recordHeaderSize+len(key):], value)
+	binary.LittleEndian.PutUint32(rec[0:4], crc32.ChecksumIEEE(rec[4:]))
+
+	if _, err := s.active.WriteAt(rec, s.activeSize); err != nil {
+		return 0, err
+	}
+	if err := s.active.Sync(); err != nil {
+		return 0, err
+	}
+
+	offset := s.activeSize + recordHeaderSize + int64(len(key))
+	s.activeSize += int64(len(rec))
+
+	return offset, nil
+}
+
+// loadSegment scans a segment and adds its records to the index. If last is
+// true a torn or corrupt tail is truncated, otherwise it is reported as an
+// error. It returns the size of the valid part of the segment.
+func (s *FileStore) loadSegment(id uint32, f *os.File, last bool) (int64, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	var (
+		offset int64
+		size   = info.Size()
+		header = make([]byte, recordHeaderSize)
+	)
+
+	for {
+		if _, err := f.ReadAt(header, offset); err != nil {
+			if err == io.EOF && offset == size {
+				return offset, nil
+			}
+			return s.truncateTail(id, f, offset, last)
+		}
+
+		keyLen := binary.LittleEndian.Uint32(header[4:8])
+		valueLen := binary.LittleEndian.Uint32(header[8:12])
+
+		bodyLen := int64(keyLen)
+		if valueLen != tombstoneValueLen {
+			bodyLen += int64(valueLen)
+		}
+		// A corrupt length must not make us allocate more than the segment
+		// holds.
+		if bodyLen > size-offset-recordHeaderSize {
+			return s.truncateTail(id, f, offset, last)
+		}
+
+		body := make([]byte, bodyLen)
+		if _, err := f.ReadAt(body, offset+recordHeaderSize); err != nil {
+			return s.truncateTail(id, f, offset, last)
+		}
+
+		crc := crc32.NewIEEE()
+		crc.Write(header[4:])
+		crc.Write(body)
+		if crc.Sum32() != binary.LittleEndian.Uint32(header[0:4]) {
+			return s.truncateTail(id, f, offset, last)
+		}
+
+		key := string(body[:keyLen])
+		if valueLen == tombstoneValueLen {
+			delete(s.index, key)
+		} else {
+			s.index[key] = indexEntry{
+				segment: id,
+				offset:  offset + recordHeaderSize + int64(keyLen),
+				size:    valueLen,
+			}
+		}
+
+		offset += recordHeaderSize + bodyLen
+	}
+}
+
+// truncateTail drops everything after offset in the last segment.
+func (s *FileStore) truncateTail(id uint32, f *os.File, offset int64, last bool) (int64, error) {
+	if !last {
+		return 0, fmt.Errorf("%w: %s at offset %d", ErrCorruptSegment, s.segmentPath(id), offset)
+	}
+	if err := f.Truncate(offset); err != nil {
+		return 0, err
+	}
+
+	return offset, f.Sync()
+}
+
+// maybeRollover starts a new segment once the active one is full.
+func (s *FileStore) maybeRollover() error {
+	if s.activeSize < s.maxSegmentSize {
+		return nil
+	}
+
+	return s.newSegment(s.activeID + 1)
+}
+
+// newSegment creates an empty segment and makes it the active one.
+func (s *FileStore) newSegment(id uint32) error {
+	f, err := os.OpenFile(s.segmentPath(id), os.O_RDWR|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return err
+	}
+
+	s.segments[id] = f
+	s.active = f
+	s.activeID = id
+	s.activeSize = 0
+
+	return nil
+}
+
+// segmentIDs returns the ids of all segment files in ascending order.
+func (s *FileStore) segmentIDs() ([]uint32, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []uint32
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, segmentExt) {
+			continue
+		}
+
+		id, err := strconv.ParseUint(strings.TrimSuffix(name, segmentExt), 10, 32)
+		if err != nil {
+			continue
+		}
+		ids = append(ids, uint32(id))
+	}
+
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	return ids, nil
+}
+
+func (s *FileStore) segmentPath(id uint32) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%06d%s", id, segmentExt))
+}
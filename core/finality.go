@@ -0,0 +1,131 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/blu-fi-tech-inc/blufi-network/types"
+)
+
+var (
+	// ErrFinalizedConflict is returned for blocks and reorganizations that do
+	// not include the last finalized block.
+	ErrFinalizedConflict = errors.New("conflicts with finalized block")
+
+	// ErrNoStakeTable is returned for stake tables of blocks that were never
+	// applied or are below the last finalized block.
+	ErrNoStakeTable = errors.New("stake table not available")
+)
+
+// Finalized returns the header of the last finalized block. Finalized blocks
+// and their ancestors are never unwound by a reorganization. The genesis block
+// is final from the start.
+func (bc *Blockchain) Finalized() *Header {
+	bc.lock.RLock()
+	defer bc.lock.RUnlock()
+
+	return bc.finalized.block.Header
+}
+
+// Finalize marks the block with the given hash and its ancestors final. A
+// known block off the canonical chain becomes canonical, whatever the fork
+// choice prefers, and the branches conflicting with it are dropped from the
+// tree. Finalizing an ancestor of the last finalized block does nothing.
+func (bc *Blockchain) Finalize(hash types.Hash) error {
+	bc.stateLock.Lock()
+
+	if bc.failed != nil {
+		bc.stateLock.Unlock()
+		return bc.failed
+	}
+
+	bc.lock.RLock()
+	node, ok := bc.tree[hash]
+	finalized := bc.finalized
+	canonical := ok && bc.isCanonical(node)
+	bc.lock.RUnlock()
+
+	if !ok {
+		bc.stateLock.Unlock()
+		return fmt.Errorf("block with hash (%s) not found", hash)
+	}
+
+	if node.block.Height <= finalized.block.Height {
+		bc.stateLock.Unlock()
+		if !descends(finalized, node) {
+			return fmt.Errorf("%w: block (%s) at height (%d)", ErrFinalizedConflict, hash, node.block.Height)
+		}
+		return nil
+	}
+
+	if !descends(node, finalized) {
+		bc.stateLock.Unlock()
+		return fmt.Errorf("%w: block (%s) at height (%d)", ErrFinalizedConflict, hash, node.block.Height)
+	}
+
+	var ev *ChainEvent
+	if !canonical {
+		var err error
+		if ev, err = bc.reorg(node); err != nil {
+			bc.stateLock.Unlock()
+			return err
+		}
+	}
+
+	if err := bc.store.Put(storeFinalizedKey, hash.ToSlice()); err != nil {
+		bc.stateLock.Unlock()
+		return err
+	}
+
+	bc.lock.Lock()
+	bc.pruneForks(node, finalized)
+	bc.finalized = node
+	pruneStakes(node)
+	bc.lock.Unlock()
+	bc.stateLock.Unlock()
+
+	bc.logger.Log("msg", "finalized block", "hash", hash, "height", node.block.Height)
+
+	if ev != nil {
+		bc.notify(ev)
+	}
+
+	return nil
+}
+
+// pruneForks drops the branches that leave the chain between the previously
+// finalized block and the newly finalized one. They can never become
+// canonical. It must be called with the lock held.
+func (bc *Blockchain) pruneForks(finalized, previous *blockNode) {
+	for n := finalized; n != previous; n = n.parent {
+		for _, child := range n.parent.children {
+			if child != n {
+				bc.deleteSubtree(child)
+			}
+		}
+		n.parent.children = []*blockNode{n}
+	}
+}
+
+// pruneStakes drops the stake tables of the ancestors of the finalized block.
+// No height below the finalized one is voted on anymore. It must be called
+// with the lock held.
+func pruneStakes(finalized *blockNode) {
+	for n := finalized.parent; n != nil && n.stakes != nil; n = n.parent {
+		n.stakes = nil
+	}
+}
+
+// descends reports whether ancestor is node or one of its ancestors.
+func descends(node, ancestor *blockNode) bool {
+	for n := node; n != nil; n = n.parent {
+		if n == ancestor {
+			return true
+		}
+		if n.block.Height < ancestor.block.Height {
+			return false
+		}
+	}
+
+	return false
+}
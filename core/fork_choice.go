@@ -0,0 +1,338 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/blu-fi-tech-inc/blufi-network/types"
+)
+
+// MaxReorgDepth is the number of most recent canonical blocks that can be
+// unwound by a chain reorganization.
+const MaxReorgDepth = 128
+
+var (
+	// ErrUnknownParent is returned when the parent of a block is not known.
+	ErrUnknownParent = errors.New("unknown parent block")
+
+	// ErrReorgTooDeep is returned when switching to a fork would unwind more
+	// blocks than MaxReorgDepth.
+	ErrReorgTooDeep = errors.New("reorganization too deep")
+
+	// ErrChainCorrupted is returned once the previous chain could not be
+	// restored after a failed reorganization. The state no longer matches
+	// any chain, so the Blockchain rejects every further block.
+	ErrChainCorrupted = errors.New("chain state corrupted")
+)
+
+// ForkChoice assigns a weight to every block. The canonical chain is the
+// branch with the highest cumulative weight; on a tie the current chain is
+// kept.
+type ForkChoice interface {
+	Weight(*Block) uint64
+}
+
+// LongestChain is the default fork choice: every block weighs the same, so the
+// longest chain wins.
+type LongestChain struct{}
+
+// Weight implements ForkChoice.
+func (LongestChain) Weight(*Block) uint64 {
+	return 1
+}
+
+// ReorgHandler is notified with the transactions of blocks that left the
+// canonical chain and are not included in the new one.
+type ReorgHandler func(orphaned []*Transaction)
+
+// ChainEvent describes a change of the canonical chain.
+type ChainEvent struct {
+	// Connected are the blocks added to the canonical chain, in order.
+	Connected []*Block
+	// Orphaned are the transactions of blocks that left the canonical chain
+	// and are not included in the new one.
+	Orphaned []*Transaction
+}
+
+// ChainSubscriber is notified of changes of the canonical chain.
+type ChainSubscriber func(ChainEvent)
+
+// blockNode is a block in the block tree.
+type blockNode struct {
+	block    *Block
+	parent   *blockNode
+	children []*blockNode // known blocks on top of this one
+	weight   uint64       // cumulative fork choice weight from the genesis block
+
+	// stakes is the validator stake table in the state after the block, see
+	// Blockchain.StakesAt. It is nil for blocks never applied and for blocks
+	// below the last finalized one.
+	stakes map[types.Address]uint64
+}
+
+// newNode creates the tree node of a block with the given parent.
+func (bc *Blockchain) newNode(b *Block, parent *blockNode) *blockNode {
+	node := &blockNode{
+		block:  b,
+		parent: parent,
+		weight: bc.forkChoice.Weight(b),
+	}
+	if parent != nil {
+		node.weight += parent.weight
+	}
+
+	return node
+}
+
+// insertNode adds a node to the block tree. Nodes already in the tree are left
+// as they are. It must be called with the lock held.
+func (bc *Blockchain) insertNode(node *blockNode) {
+	hash := node.block.Hash(BlockHasher{})
+	if _, ok := bc.tree[hash]; ok {
+		return
+	}
+
+	bc.tree[hash] = node
+	if node.parent != nil {
+		node.parent.children = append(node.parent.children, node)
+	}
+}
+
+// tipNode returns the node of the last canonical block. It must be called with
+// the lock held.
+func (bc *Blockchain) tipNode() *blockNode {
+	if len(bc.blocks) == 0 {
+		return nil
+	}
+
+	return bc.tree[bc.blocks[len(bc.blocks)-1].Hash(BlockHasher{})]
+}
+
+// tipHash returns the hash of the last canonical block.
+func (bc *Blockchain) tipHash() types.Hash {
+	bc.lock.RLock()
+	defer bc.lock.RUnlock()
+
+	return bc.blocks[len(bc.blocks)-1].Hash(BlockHasher{})
+}
+
+// isCanonical reports whether a node is part of the canonical chain. It must
+// be called with the lock held.
+func (bc *Blockchain) isCanonical(node *blockNode) bool {
+	height := node.block.Height
+	return int(height) < len(bc.blocks) && bc.blocks[height] == node.block
+}
+
+// addForkBlock stores a block that does not extend the canonical tip and
+// switches to its branch if the fork choice prefers it. It returns the change
+// of the canonical chain, nil if there is none. It must be called with the
+// state lock held.
+func (bc *Blockchain) addForkBlock(node *blockNode, tip *blockNode) (*ChainEvent, error) {
+	b := node.block
+	hash := b.Hash(BlockHasher{})
+
+	bc.lock.RLock()
+	finalized := bc.finalized
+	bc.lock.RUnlock()
+	if !descends(node.parent, finalized) {
+		return nil, fmt.Errorf("%w: block (%s) at height (%d)", ErrFinalizedConflict, hash, b.Height)
+	}
+
+	// The proposer is drawn from the stake table after the parent. If the
+	// parent was never applied the table is not known yet, and the proposer
+	// is checked when the branch is connected.
+	if err := bc.verifyProposer(node.parent.block.Header, b); err != nil && !errors.Is(err, ErrNoStakeTable) {
+		return nil, err
+	}
+
+	if err := bc.persistBlockBody(b); err != nil {
+		return nil, err
+	}
+
+	bc.lock.Lock()
+	bc.insertNode(node)
+	bc.lock.Unlock()
+
+	bc.logger.Log(
+		"msg", "new fork block",
+		"hash", hash,
+		"height", b.Height,
+		"weight", node.weight,
+		"tipWeight", tip.weight,
+	)
+
+	if node.weight <= tip.weight {
+		return nil, nil
+	}
+
+	return bc.reorg(node)
+}
+
+// reorg switches the canonical chain to the branch ending in newTip. The
+// blocks of the new branch are fully executed; if one of them fails it is
+// dropped from the tree together with its descendants and the old chain is
+// restored. It must be called with the state lock held.
+func (bc *Blockchain) reorg(newTip *blockNode) (*ChainEvent, error) {
+	bc.lock.RLock()
+	var branch []*blockNode
+	ancestor := newTip
+	for ancestor != nil && !bc.isCanonical(ancestor) {
+		branch = append(branch, ancestor)
+		ancestor = ancestor.parent
+	}
+	var unwind []*Block
+	if ancestor != nil {
+		unwind = append(unwind, bc.blocks[ancestor.block.Height+1:]...)
+	}
+	finalized := bc.finalized
+	bc.lock.RUnlock()
+
+	if ancestor == nil {
+		return nil, fmt.Errorf("%w: fork (%s) does not connect to the chain", ErrUnknownParent, newTip.block.Hash(BlockHasher{}))
+	}
+	if finalized != nil && ancestor.block.Height < finalized.block.Height {
+		return nil, fmt.Errorf("%w: fork (%s) leaves the chain at height (%d)", ErrFinalizedConflict, newTip.block.Hash(BlockHasher{}), ancestor.block.Height)
+	}
+	if len(unwind) > MaxReorgDepth {
+		return nil, fmt.Errorf("%w: %d blocks", ErrReorgTooDeep, len(unwind))
+	}
+	for _, b := range unwind {
+		if _, ok := bc.undoLogs[b.Hash(BlockHasher{})]; !ok {
+			return nil, fmt.Errorf("%w: no undo log for block (%s)", ErrReorgTooDeep, b.Hash(BlockHasher{}))
+		}
+	}
+
+	for range unwind {
+		bc.disconnectTip()
+	}
+
+	for i := len(branch) - 1; i >= 0; i-- {
+		err := bc.verifyProposer(branch[i].parent.block.Header, branch[i].block)
+		if err == nil {
+			err = bc.connectBlock(branch[i], true)
+		}
+		if err != nil {
+			bc.dropBranch(branch[i])
+			if rerr := bc.restoreChain(ancestor, unwind); rerr != nil {
+				bc.failed = fmt.Errorf("%w: restoring the chain after reorg to block (%s): %w", ErrChainCorrupted, newTip.block.Hash(BlockHasher{}), rerr)
+				bc.logger.Log("msg", "failed to restore chain after reorg", "err", bc.failed)
+
+				return nil, bc.failed
+			}
+
+			return nil, fmt.Errorf("reorg to block (%s) failed: %w", newTip.block.Hash(BlockHasher{}), err)
+		}
+	}
+
+	ev := &ChainEvent{}
+	included := make(map[types.Hash]bool)
+	for i := len(branch) - 1; i >= 0; i-- {
+		ev.Connected = append(ev.Connected, branch[i].block)
+		for _, tx := range branch[i].block.Transactions {
+			included[tx.Hash(TxHasher{})] = true
+		}
+	}
+
+	ev.Orphaned = []*Transaction{}
+	for _, b := range unwind {
+		for _, tx := range b.Transactions {
+			if !included[tx.Hash(TxHasher{})] {
+				ev.Orphaned = append(ev.Orphaned, tx)
+			}
+		}
+	}
+
+	bc.logger.Log(
+		"msg", "chain reorganized",
+		"ancestor", ancestor.block.Hash(BlockHasher{}),
+		"unwound", len(unwind),
+		"applied", len(branch),
+		"tip", newTip.block.Hash(BlockHasher{}),
+		"orphanedTxs", len(ev.Orphaned),
+	)
+
+	return ev, nil
+}
+
+// disconnectTip removes the last block from the canonical chain and reverts
+// its state changes. The block stays in the tree. It must be called with the
+// state lock held.
+func (bc *Blockchain) disconnectTip() {
+	bc.lock.Lock()
+	defer bc.lock.Unlock()
+
+	b := bc.blocks[len(bc.blocks)-1]
+	hash := b.Hash(BlockHasher{})
+
+	bc.undoLogs[hash].Revert()
+	delete(bc.undoLogs, hash)
+
+	bc.headers = bc.headers[:len(bc.headers)-1]
+	bc.blocks = bc.blocks[:len(bc.blocks)-1]
+
+	for _, tx := range b.Transactions {
+		txHash := tx.Hash(TxHasher{})
+		if bc.txBlocks[txHash] == b {
+			delete(bc.txStore, txHash)
+			delete(bc.txBlocks, txHash)
+			delete(bc.receipts, txHash)
+		}
+	}
+}
+
+// restoreChain unwinds the canonical chain back to ancestor and reapplies the
+// given blocks, which were canonical before. It must be called with the state
+// lock held.
+func (bc *Blockchain) restoreChain(ancestor *blockNode, blocks []*Block) error {
+	for {
+		bc.lock.RLock()
+		tip := bc.tipNode()
+		bc.lock.RUnlock()
+
+		if tip == ancestor {
+			break
+		}
+		bc.disconnectTip()
+	}
+
+	for _, b := range blocks {
+		bc.lock.RLock()
+		node := bc.tree[b.Hash(BlockHasher{})]
+		bc.lock.RUnlock()
+
+		if err := bc.connectBlock(node, true); err != nil {
+			return fmt.Errorf("block (%s): %w", b.Hash(BlockHasher{}), err)
+		}
+	}
+
+	return nil
+}
+
+// dropBranch removes an invalid block and all of its descendants from the
+// tree.
+func (bc *Blockchain) dropBranch(invalid *blockNode) {
+	bc.lock.Lock()
+	defer bc.lock.Unlock()
+
+	if parent := invalid.parent; parent != nil {
+		for i, child := range parent.children {
+			if child == invalid {
+				parent.children = append(parent.children[:i:i], parent.children[i+1:]...)
+				break
+			}
+		}
+	}
+
+	bc.deleteSubtree(invalid)
+}
+
+// deleteSubtree removes a node and all of its descendants from the tree. It
+// must be called with the lock held.
+func (bc *Blockchain) deleteSubtree(node *blockNode) {
+	nodes := []*blockNode{node}
+	for len(nodes) > 0 {
+		n := nodes[len(nodes)-1]
+		nodes = append(nodes[:len(nodes)-1], n.children...)
+		delete(bc.tree, n.block.Hash(BlockHasher{}))
+	}
+}
@@ -0,0 +1,88 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"math/bits"
+)
+
+// Gas costs of executing a transaction.
+const (
+	GasTxDataByte uint64 = 4    // per byte of code in Transaction.Data
+	GasZero       uint64 = 0    // halting
+	GasBase       uint64 = 2    // cheap stack operations and call context
+	GasArithmetic uint64 = 3    // arithmetic, comparison and boolean operations
+	GasMul        uint64 = 5    // multiplication, division and modulo
+	GasJump       uint64 = 8    // jumps
+	GasJumpDest   uint64 = 1    // jump destinations
+	GasPackByte   uint64 = 1    // per byte packed by InstrPack
+	GasSha256     uint64 = 30   // hashing
+	GasSha256Byte uint64 = 1    // per byte hashed
+	GasGet        uint64 = 200  // reading a contract state entry
+	GasStore      uint64 = 2000 // writing a contract state entry
+	GasStoreByte  uint64 = 10   // per byte of key and value written
+
+	// MaxTxGas is the highest gas limit a transaction may set.
+	MaxTxGas uint64 = 10_000_000
+)
+
+var (
+	// ErrOutOfGas is returned when code runs out of the gas of its transaction.
+	ErrOutOfGas = errors.New("out of gas")
+
+	// ErrIntrinsicGas is returned when the gas limit of a transaction does not
+	// cover the cost of its data.
+	ErrIntrinsicGas = errors.New("gas limit below intrinsic gas")
+
+	// ErrGasLimitTooHigh is returned when a transaction sets a gas limit above
+	// MaxTxGas.
+	ErrGasLimitTooHigh = errors.New("gas limit too high")
+)
+
+// gasTable holds the static gas cost of every instruction. Instructions with
+// a dynamic part charge it while executing.
+var gasTable = map[Instruction]uint64{
+	InstrStop:      GasZero,
+	InstrPushInt:   GasBase,
+	InstrPushByte:  GasBase,
+	InstrPack:      GasBase,
+	InstrAdd:       GasArithmetic,
+	InstrSub:       GasArithmetic,
+	InstrMul:       GasMul,
+	InstrDiv:       GasMul,
+	InstrMod:       GasMul,
+	InstrLt:        GasArithmetic,
+	InstrGt:        GasArithmetic,
+	InstrEq:        GasArithmetic,
+	InstrNot:       GasArithmetic,
+	InstrAnd:       GasArithmetic,
+	InstrOr:        GasArithmetic,
+	InstrJump:      GasJump,
+	InstrJumpIf:    GasJump,
+	InstrJumpDest:  GasJumpDest,
+	InstrDup:       GasBase,
+	InstrSwap:      GasBase,
+	InstrPop:       GasBase,
+	InstrSha256:    GasSha256,
+	InstrCaller:    GasBase,
+	InstrCallValue: GasBase,
+	InstrHeight:    GasBase,
+	InstrCallData:  GasBase,
+	InstrGet:       GasGet,
+	InstrStore:     GasStore,
+}
+
+// IntrinsicGas returns the gas a transaction costs before any code runs.
+func IntrinsicGas(tx *Transaction) uint64 {
+	return uint64(len(tx.Data)) * GasTxDataByte
+}
+
+// gasFee returns gas * price, failing if it overflows.
+func gasFee(gas, price uint64) (uint64, error) {
+	hi, fee := bits.Mul64(gas, price)
+	if hi != 0 {
+		return 0, fmt.Errorf("gas fee overflows: gas (%d) price (%d)", gas, price)
+	}
+
+	return fee, nil
+}
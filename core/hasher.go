@@ -39,21 +39,77 @@ func (TxHasher) Hash(tx interface{}) types.Hash {
 	}
 
 	buf := new(bytes.Buffer)
-	if err := binary.Write(buf, binary.LittleEndian, t.Data); err != nil {
-		log.Fatalf("failed to write tx.Data: %v", err)
-	}
-	if err := binary.Write(buf, binary.LittleEndian, t.To); err != nil {
+	writeSized(buf, t.Data)
+	if err := binary.Write(buf, binary.LittleEndian, t.To.ToSlice()); err != nil {
 		log.Fatalf("failed to write tx.To: %v", err)
 	}
 	if err := binary.Write(buf, binary.LittleEndian, t.Value); err != nil {
 		log.Fatalf("failed to write tx.Value: %v", err)
 	}
-	if err := binary.Write(buf, binary.LittleEndian, t.From); err != nil {
+	if err := binary.Write(buf, binary.LittleEndian, t.From.Bytes()); err != nil {
 		log.Fatalf("failed to write tx.From: %v", err)
 	}
 	if err := binary.Write(buf, binary.LittleEndian, t.Nonce); err != nil {
 		log.Fatalf("failed to write tx.Nonce: %v", err)
 	}
+	if err := binary.Write(buf, binary.LittleEndian, t.GasLimit); err != nil {
+		log.Fatalf("failed to write tx.GasLimit: %v", err)
+	}
+	if err := binary.Write(buf, binary.LittleEndian, t.GasPrice); err != nil {
+		log.Fatalf("failed to write tx.GasPrice: %v", err)
+	}
+	if err := binary.Write(buf, binary.LittleEndian, t.InclusionFee()); err != nil {
+		log.Fatalf("failed to write tx fee: %v", err)
+	}
+	// The inner transaction is signed with its type and every one of its fields.
+	switch inner := t.TxInner.(type) {
+	case nil:
+	case CollectionTx:
+		buf.WriteByte(byte(TxTypeCollection))
+		if err := binary.Write(buf, binary.LittleEndian, inner.Fee); err != nil {
+			log.Fatalf("failed to write collection fee: %v", err)
+		}
+		writeSized(buf, inner.MetaData)
+	case MintTx:
+		buf.WriteByte(byte(TxTypeMint))
+		if err := binary.Write(buf, binary.LittleEndian, inner.Fee); err != nil {
+			log.Fatalf("failed to write mint fee: %v", err)
+		}
+		buf.Write(inner.NFT[:])
+		buf.Write(inner.Collection[:])
+		writeSized(buf, inner.MetaData)
+		writeSized(buf, inner.CollectionOwner.Bytes())
+		writeSized(buf, inner.Signature)
+	case StakeTx:
+		buf.WriteByte(byte(TxTypeStake))
+		if err := binary.Write(buf, binary.LittleEndian, inner.Amount); err != nil {
+			log.Fatalf("failed to write stake amount: %v", err)
+		}
+	case UnstakeTx:
+		buf.WriteByte(byte(TxTypeUnstake))
+		if err := binary.Write(buf, binary.LittleEndian, inner.Amount); err != nil {
+			log.Fatalf("failed to write unstake amount: %v", err)
+		}
+	case EvidenceTx:
+		buf.WriteByte(byte(TxTypeEvidence))
+		for _, h := range []*SignedHeader{inner.First, inner.Second} {
+			if h == nil || h.Header == nil {
+				buf.WriteByte(0)
+				continue
+			}
+			hash := BlockHasher{}.Hash(h.Header)
+			buf.Write(hash[:])
+			buf.Write(h.Validator.Bytes())
+		}
+	default:
+		log.Fatalf("TxHasher: unsupported inner transaction %T", inner)
+	}
 
 	return types.Hash(sha256.Sum256(buf.Bytes()))
 }
+
+// writeSized writes b to buf, prefixed with its length.
+func writeSized(buf *bytes.Buffer, b []byte) {
+	binary.Write(buf, binary.LittleEndian, uint32(len(b)))
+	buf.Write(b)
+}
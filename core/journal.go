@@ -0,0 +1,95 @@
+package core
+
+import "sync"
+
+// Journal records how to undo state changes so that the state can be reverted
+// to an earlier snapshot. AccountState and State append to the journal they
+// are attached to on every write.
+type Journal struct {
+	mu   sync.Mutex
+	undo []func()
+}
+
+// NewJournal creates an empty journal.
+func NewJournal() *Journal {
+	return &Journal{}
+}
+
+// Snapshot returns an id identifying the current state, to be passed to
+// RevertToSnapshot.
+func (j *Journal) Snapshot() int {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	return len(j.undo)
+}
+
+// RevertToSnapshot undoes every change recorded after the given snapshot.
+func (j *Journal) RevertToSnapshot(id int) {
+	j.mu.Lock()
+	if id < 0 || id > len(j.undo) {
+		j.mu.Unlock()
+		return
+	}
+	undo := j.undo[id:]
+	j.undo = j.undo[:id:id]
+	j.mu.Unlock()
+
+	// The undo functions take the locks of the state they restore, so they
+	// must run without holding the journal lock.
+	UndoLog(undo).Revert()
+}
+
+// Commit returns the changes recorded since the given snapshot as an undo log
+// and clears the journal, making the changes permanent.
+func (j *Journal) Commit(id int) UndoLog {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	var undo UndoLog
+	if id >= 0 && id <= len(j.undo) {
+		undo = UndoLog(j.undo[id:])
+	}
+	j.undo = nil
+
+	return undo
+}
+
+// Reset discards all recorded changes, making them permanent.
+func (j *Journal) Reset() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.undo = nil
+}
+
+// UndoLog reverts a set of committed changes.
+type UndoLog []func()
+
+// Revert undoes the changes of the log, newest first.
+func (u UndoLog) Revert() {
+	for i := len(u) - 1; i >= 0; i-- {
+		u[i]()
+	}
+}
+
+func (j *Journal) append(fn func()) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.undo = append(j.undo, fn)
+}
+
+// journalMapPut sets m[k] = v and records how to restore the previous value.
+func journalMapPut[K comparable, V any](j *Journal, m map[K]V, k K, v V) {
+	prev, existed := m[k]
+	m[k] = v
+
+	j.append(func() {
+		if existed {
+			m[k] = prev
+		} else {
+			delete(m, k)
+		}
+	})
+}
@@ -0,0 +1,132 @@
+package core
+
+import (
+	"crypto/sha256"
+	"fmt"
+
+	"github.com/blu-fi-tech-inc/blufi-network/types"
+)
+
+// Prefixes separating leaf and inner node hashes, so an inner node can never
+// be passed off as a leaf.
+const (
+	merkleLeafPrefix byte = 0x00
+	merkleNodePrefix byte = 0x01
+)
+
+// MerkleProof proves that a transaction is included in a block whose header
+// commits to a Merkle root of its transaction hashes.
+type MerkleProof struct {
+	TxHash   types.Hash   // Hash of the proven transaction
+	Index    uint32       // Position of the transaction in the block
+	Total    uint32       // Number of transactions in the block
+	Siblings []types.Hash // Sibling hashes from the leaf up to the root
+}
+
+// CalculateTxRoot computes the Merkle root over the TxHasher hashes of the
+// given transactions. An odd node at the end of a level is promoted to the next
+// level unchanged. The root of an empty list is the zero hash.
+func CalculateTxRoot(txx []*Transaction) types.Hash {
+	return merkleRoot(merkleLeaves(txx))
+}
+
+// NewMerkleProof builds an inclusion proof for the transaction at index.
+func NewMerkleProof(txx []*Transaction, index int) (*MerkleProof, error) {
+	if index < 0 || index >= len(txx) {
+		return nil, fmt.Errorf("tx index (%d) out of range (%d)", index, len(txx))
+	}
+
+	proof := &MerkleProof{
+		TxHash: txx[index].Hash(TxHasher{}),
+		Index:  uint32(index),
+		Total:  uint32(len(txx)),
+	}
+
+	level := merkleLeaves(txx)
+	for idx := index; len(level) > 1; idx /= 2 {
+		if idx%2 == 1 {
+			proof.Siblings = append(proof.Siblings, level[idx-1])
+		} else if idx+1 < len(level) {
+			proof.Siblings = append(proof.Siblings, level[idx+1])
+		}
+		level = merkleNextLevel(level)
+	}
+
+	return proof, nil
+}
+
+// VerifyMerkleProof reports whether the proof shows its transaction is
+// included under the given root.
+func VerifyMerkleProof(root types.Hash, proof *MerkleProof) bool {
+	if proof == nil || proof.Index >= proof.Total {
+		return false
+	}
+
+	var (
+		hash     = merkleLeaf(proof.TxHash)
+		siblings = proof.Siblings
+	)
+
+	for idx, n := proof.Index, proof.Total; n > 1; idx, n = idx/2, (n+1)/2 {
+		if idx%2 == 0 && idx+1 >= n {
+			continue
+		}
+		if len(siblings) == 0 {
+			return false
+		}
+
+		if idx%2 == 1 {
+			hash = merkleNode(siblings[0], hash)
+		} else {
+			hash = merkleNode(hash, siblings[0])
+		}
+		siblings = siblings[1:]
+	}
+
+	return len(siblings) == 0 && hash == root
+}
+
+func merkleLeaves(txx []*Transaction) []types.Hash {
+	leaves := make([]types.Hash, len(txx))
+	for i, tx := range txx {
+		leaves[i] = merkleLeaf(tx.Hash(TxHasher{}))
+	}
+	return leaves
+}
+
+func merkleRoot(level []types.Hash) types.Hash {
+	if len(level) == 0 {
+		return types.Hash{}
+	}
+	for len(level) > 1 {
+		level = merkleNextLevel(level)
+	}
+	return level[0]
+}
+
+func merkleNextLevel(level []types.Hash) []types.Hash {
+	next := make([]types.Hash, 0, (len(level)+1)/2)
+	for i := 0; i < len(level); i += 2 {
+		if i+1 == len(level) {
+			next = append(next, level[i])
+			continue
+		}
+		next = append(next, merkleNode(level[i], level[i+1]))
+	}
+	return next
+}
+
+func merkleLeaf(txHash types.Hash) types.Hash {
+	buf := make([]byte, 0, 1+len(txHash))
+	buf = append(buf, merkleLeafPrefix)
+	buf = append(buf, txHash[:]...)
+	return types.Hash(sha256.Sum256(buf))
+}
+
+func merkleNode(left, right types.Hash) types.Hash {
+	buf := make([]byte, 0, 1+len(left)+len(right))
+	buf = append(buf, merkleNodePrefix)
+	buf = append(buf, left[:]...)
+	buf = append(buf, right[:]...)
+	return types.Hash(sha256.Sum256(buf))
+}
@@ -0,0 +1,28 @@
+package core
+
+import "github.com/blu-fi-tech-inc/blufi-network/types"
+
+// Receipt is the outcome of executing a transaction included in a block.
+type Receipt struct {
+	TxHash          types.Hash
+	GasUsed         uint64        // Gas paid for by the sender
+	ContractAddress types.Address // Address of the deployed contract, if any
+	Err             error         // Why the code of the transaction failed, nil on success
+}
+
+// execContext describes the block a transaction is executed in.
+type execContext struct {
+	height   uint32
+	coinbase *types.Address // Receives the gas fees, nil to burn them
+}
+
+// newExecContext returns the context of the transactions of a block. The gas
+// fees go to the validator that signed it.
+func newExecContext(b *Block) *execContext {
+	ctx := &execContext{height: b.Height}
+	if address, err := b.Validator.Address(); err == nil {
+		ctx.coinbase = &address
+	}
+
+	return ctx
+}
@@ -7,8 +7,9 @@ import (
 )
 
 type State struct {
-	mu   sync.RWMutex
-	data map[string][]byte
+	mu      sync.RWMutex
+	data    map[string][]byte
+	journal *Journal // Records changes for reverting, nil if not journaled
 }
 
 func NewState() *State {
@@ -17,6 +18,47 @@ func NewState() *State {
 	}
 }
 
+// Copy returns a deep copy of the contract state.
+func (s *State) Copy() *State {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	cp := NewState()
+	for k, v := range s.data {
+		cp.data[k] = append([]byte(nil), v...)
+	}
+
+	return cp
+}
+
+// SetJournal attaches a journal that records every change to the state.
+func (s *State) SetJournal(j *Journal) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.journal = j
+}
+
+// journalKey records the current value of a key so that it can be restored on
+// revert. It must be called with the lock held, before the change.
+func (s *State) journalKey(key string) {
+	if s.journal == nil {
+		return
+	}
+
+	prev, existed := s.data[key]
+	s.journal.append(func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		if existed {
+			s.data[key] = prev
+		} else {
+			delete(s.data, key)
+		}
+	})
+}
+
 func (s *State) Put(k []byte, v []byte) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -25,6 +67,7 @@ func (s *State) Put(k []byte, v []byte) error {
 		return errors.New("key cannot be empty")
 	}
 
+	s.journalKey(string(k))
 	s.data[string(k)] = v
 	return nil
 }
@@ -37,6 +80,7 @@ func (s *State) Delete(k []byte) error {
 		return errors.New("key cannot be empty")
 	}
 
+	s.journalKey(string(k))
 	delete(s.data, string(k))
 	return nil
 }
@@ -48,7 +92,7 @@ func (s *State) Get(k []byte) ([]byte, error) {
 	key := string(k)
 	value, ok := s.data[key]
 	if !ok {
-		return nil, fmt.Errorf("given key %s not found", key)
+		return nil, fmt.Errorf("%w: %s", ErrKeyNotFound, key)
 	}
 
 	return value, nil
@@ -0,0 +1,151 @@
+package core
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"sort"
+
+	"github.com/blu-fi-tech-inc/blufi-network/types"
+)
+
+// CalculateStateRoot computes a commitment to every account, contract key, NFT
+// collection and NFT mint.
+//
+// Each of them is sorted by key and hashed into a Merkle tree with the same
+// construction as the transaction root. The state root is the node hash of
+// two nodes: one over the account and contract roots, one over the collection
+// and mint roots.
+func CalculateStateRoot(accounts *AccountState, contracts *State, collections map[types.Hash]*CollectionTx, mints map[types.Hash]*MintTx) types.Hash {
+	return merkleNode(
+		merkleNode(merkleRoot(accounts.stateLeaves()), merkleRoot(contracts.stateLeaves())),
+		merkleNode(merkleRoot(collectionLeaves(collections)), merkleRoot(mintLeaves(mints))),
+	)
+}
+
+// stateLeaves returns one leaf per account, ordered by address.
+func (s *AccountState) stateLeaves() []types.Hash {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	addresses := make([]types.Address, 0, len(s.accounts))
+	for address := range s.accounts {
+		addresses = append(addresses, address)
+	}
+	sort.Slice(addresses, func(i, j int) bool {
+		return bytes.Compare(addresses[i][:], addresses[j][:]) < 0
+	})
+
+	leaves := make([]types.Hash, len(addresses))
+	for i, address := range addresses {
+		account := s.accounts[address]
+
+		buf := make([]byte, 0, len(address)+29+12*len(account.Unbonding))
+		buf = append(buf, address[:]...)
+		buf = binary.BigEndian.AppendUint64(buf, account.Balance)
+		buf = binary.BigEndian.AppendUint64(buf, account.Nonce)
+		buf = binary.BigEndian.AppendUint64(buf, account.Stake)
+		if account.Jailed {
+			buf = append(buf, 1)
+		} else {
+			buf = append(buf, 0)
+		}
+		buf = binary.BigEndian.AppendUint32(buf, uint32(len(account.Unbonding)))
+		for _, entry := range account.Unbonding {
+			buf = binary.BigEndian.AppendUint64(buf, entry.Amount)
+			buf = binary.BigEndian.AppendUint32(buf, entry.Height)
+		}
+
+		leaves[i] = merkleLeaf(sha256.Sum256(buf))
+	}
+
+	return leaves
+}
+
+// stateLeaves returns one leaf per key, ordered by key.
+func (s *State) stateLeaves() []types.Hash {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	keys := make([]string, 0, len(s.data))
+	for k := range s.data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	leaves := make([]types.Hash, len(keys))
+	for i, k := range keys {
+		v := s.data[k]
+
+		buf := make([]byte, 0, 4+len(k)+len(v))
+		buf = binary.BigEndian.AppendUint32(buf, uint32(len(k)))
+		buf = append(buf, k...)
+		buf = append(buf, v...)
+
+		leaves[i] = merkleLeaf(sha256.Sum256(buf))
+	}
+
+	return leaves
+}
+
+// collectionLeaves returns one leaf per NFT collection, ordered by hash.
+func collectionLeaves(collections map[types.Hash]*CollectionTx) []types.Hash {
+	hashes := sortedHashes(collections)
+
+	leaves := make([]types.Hash, len(hashes))
+	for i, hash := range hashes {
+		c := collections[hash]
+
+		buf := make([]byte, 0, len(hash)+12+len(c.MetaData))
+		buf = append(buf, hash[:]...)
+		buf = binary.BigEndian.AppendUint64(buf, uint64(c.Fee))
+		buf = appendSized(buf, c.MetaData)
+
+		leaves[i] = merkleLeaf(sha256.Sum256(buf))
+	}
+
+	return leaves
+}
+
+// mintLeaves returns one leaf per NFT mint, ordered by hash.
+func mintLeaves(mints map[types.Hash]*MintTx) []types.Hash {
+	hashes := sortedHashes(mints)
+
+	leaves := make([]types.Hash, len(hashes))
+	for i, hash := range hashes {
+		m := mints[hash]
+		owner := m.CollectionOwner.Bytes()
+
+		buf := make([]byte, 0, 3*len(hash)+20+len(m.MetaData)+len(owner)+len(m.Signature))
+		buf = append(buf, hash[:]...)
+		buf = binary.BigEndian.AppendUint64(buf, uint64(m.Fee))
+		buf = append(buf, m.NFT[:]...)
+		buf = append(buf, m.Collection[:]...)
+		buf = appendSized(buf, m.MetaData)
+		buf = appendSized(buf, owner)
+		buf = appendSized(buf, m.Signature)
+
+		leaves[i] = merkleLeaf(sha256.Sum256(buf))
+	}
+
+	return leaves
+}
+
+// sortedHashes returns the keys of m in increasing order.
+func sortedHashes[V any](m map[types.Hash]V) []types.Hash {
+	hashes := make([]types.Hash, 0, len(m))
+	for hash := range m {
+		hashes = append(hashes, hash)
+	}
+	sort.Slice(hashes, func(i, j int) bool {
+		return bytes.Compare(hashes[i][:], hashes[j][:]) < 0
+	})
+
+	return hashes
+}
+
+// appendSized appends b to buf, prefixed with its length.
+func appendSized(buf, b []byte) []byte {
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(b)))
+	return append(buf, b...)
+}
@@ -2,8 +2,10 @@ package core
 
 import (
 	"encoding/gob"
+	"errors"
 	"fmt"
-	"math/rand"
+	"math"
+	"math/bits"
 
 	"github.com/blu-fi-tech-inc/blufi-network/crypto"
 	"github.com/blu-fi-tech-inc/blufi-network/types"
@@ -14,8 +16,18 @@ type TxType byte
 const (
 	TxTypeCollection TxType = iota // 0x0
 	TxTypeMint                     // 0x01
+	TxTypeStake                    // 0x02
+	TxTypeUnstake                  // 0x03
+	TxTypeEvidence                 // 0x04
 )
 
+// ErrZeroStake is returned for staking transactions moving no funds.
+var ErrZeroStake = errors.New("staking amount is zero")
+
+// UnbondingPeriod is the number of blocks unstaked funds stay locked before
+// they return to the balance of their owner.
+const UnbondingPeriod uint32 = 100
+
 type CollectionTx struct {
 	Fee      int64
 	MetaData []byte
@@ -30,14 +42,28 @@ type MintTx struct {
 	Signature       []byte
 }
 
+// StakeTx bonds Amount of the balance of the sender as validator stake.
+type StakeTx struct {
+	Amount uint64
+}
+
+// UnstakeTx starts unbonding Amount of the stake of the sender. The funds
+// return to its balance UnbondingPeriod blocks later.
+type UnstakeTx struct {
+	Amount uint64
+}
+
 type Transaction struct {
-	TxInner   interface{}       // Generic type for handling inner transactions
+	TxInner   interface{} // Generic type for handling inner transactions
 	Data      []byte
-	To        crypto.PublicKey
+	To        types.Address // Recipient, zero to deploy Data as a contract
 	Value     uint64
 	From      crypto.PublicKey
 	Signature []byte
-	Nonce     int64
+	Nonce     uint64 // Sequence number of the sender's account
+	GasLimit  uint64 // Maximum gas the transaction may use
+	GasPrice  uint64 // Amount paid per unit of gas
+	Fee       uint64 // Paid to the validator for including the transaction, see InclusionFee
 
 	// Cached version of the tx data hash
 	hash types.Hash
@@ -45,9 +71,63 @@ type Transaction struct {
 
 func NewTransaction(data []byte) *Transaction {
 	return &Transaction{
-		Data:  data,
-		Nonce: rand.Int63n(1000000000000000),
+		Data: data,
+	}
+}
+
+// IsContractCreation reports whether the transaction deploys its data as a
+// contract.
+func (tx *Transaction) IsContractCreation() bool {
+	return tx.To.IsZero() && len(tx.Data) > 0
+}
+
+// InclusionFee returns the fee the transaction pays to the validator of the
+// block including it, on top of its gas. NFT transactions pay the fee of their
+// inner transaction, negative fees pay nothing.
+func (tx *Transaction) InclusionFee() uint64 {
+	switch inner := tx.TxInner.(type) {
+	case CollectionTx:
+		return uint64(max(inner.Fee, 0))
+	case MintTx:
+		return uint64(max(inner.Fee, 0))
+	}
+
+	return tx.Fee
+}
+
+// MaxFee returns the most the transaction pays to the validator: its inclusion
+// fee and the price of its full gas limit. It saturates instead of
+// overflowing.
+func (tx *Transaction) MaxFee() uint64 {
+	gas, err := gasFee(tx.GasLimit, tx.GasPrice)
+	if err != nil {
+		return math.MaxUint64
+	}
+
+	fee, carry := bits.Add64(gas, tx.InclusionFee(), 0)
+	if carry != 0 {
+		return math.MaxUint64
 	}
+
+	return fee
+}
+
+// MinFee returns the least the transaction pays to the validator: its
+// inclusion fee and the price of its intrinsic gas, which it uses whatever its
+// code does. Unlike MaxFee it does not grow with an unused gas limit. It
+// saturates instead of overflowing.
+func (tx *Transaction) MinFee() uint64 {
+	gas, err := gasFee(min(IntrinsicGas(tx), tx.GasLimit), tx.GasPrice)
+	if err != nil {
+		return math.MaxUint64
+	}
+
+	fee, carry := bits.Add64(gas, tx.InclusionFee(), 0)
+	if carry != 0 {
+		return math.MaxUint64
+	}
+
+	return fee
 }
 
 func (tx *Transaction) Hash(hasher Hasher) types.Hash {
@@ -58,13 +138,16 @@ func (tx *Transaction) Hash(hasher Hasher) types.Hash {
 }
 
 func (tx *Transaction) Sign(privKey *crypto.PrivateKey) error {
+	// The sender is part of the signed hash, so set it before hashing.
+	tx.From = crypto.PublicKey{PublicKey: &privKey.PublicKey}
+	tx.hash = types.Hash{}
+
 	hash := tx.Hash(TxHasher{})
 	sig, err := privKey.Sign(hash[:]) // Corrected to use hash as byte slice
 	if err != nil {
 		return err
 	}
 
-	tx.From = crypto.PublicKey{PublicKey: &privKey.PublicKey}
 	tx.Signature = sig
 
 	return nil
@@ -72,13 +155,13 @@ func (tx *Transaction) Sign(privKey *crypto.PrivateKey) error {
 
 func (tx *Transaction) Verify() error {
 	if tx.Signature == nil {
-		return fmt.Errorf("transaction has no signature")
+		return fmt.Errorf("%w: transaction has no signature", ErrInvalidSignature)
 	}
 
 	hash := tx.Hash(TxHasher{})
 	pubKey := &tx.From
 	if !crypto.VerifySignature(pubKey, hash[:], tx.Signature) {
-		return fmt.Errorf("invalid transaction signature")
+		return fmt.Errorf("%w: transaction (%s)", ErrInvalidSignature, hash)
 	}
 
 	// Verify the inner transaction if exists
@@ -87,7 +170,19 @@ func (tx *Transaction) Verify() error {
 		// Add specific verification for CollectionTx if needed
 	case MintTx:
 		if !crypto.VerifySignature(&innerTx.CollectionOwner, innerTx.Collection[:], innerTx.Signature) {
-			return fmt.Errorf("invalid mint transaction signature")
+			return fmt.Errorf("%w: mint transaction (%s)", ErrInvalidSignature, hash)
+		}
+	case StakeTx:
+		if innerTx.Amount == 0 {
+			return fmt.Errorf("%w: stake transaction (%s)", ErrZeroStake, hash)
+		}
+	case UnstakeTx:
+		if innerTx.Amount == 0 {
+			return fmt.Errorf("%w: unstake transaction (%s)", ErrZeroStake, hash)
+		}
+	case EvidenceTx:
+		if err := innerTx.Verify(); err != nil {
+			return fmt.Errorf("evidence transaction (%s): %w", hash, err)
 		}
 	}
 
@@ -105,4 +200,7 @@ func (tx *Transaction) Encode(enc *gob.Encoder) error {
 func init() {
 	gob.Register(CollectionTx{})
 	gob.Register(MintTx{})
+	gob.Register(StakeTx{})
+	gob.Register(UnstakeTx{})
+	gob.Register(EvidenceTx{})
 }
@@ -3,16 +3,49 @@ package core
 import (
 	"errors"
 	"fmt"
+
+	"github.com/blu-fi-tech-inc/blufi-network/types"
 )
 
-// ErrBlockKnown is returned when a block is already known to the blockchain.
-var ErrBlockKnown = errors.New("block already known")
+var (
+	// ErrBlockKnown is returned when a block is already known to the blockchain.
+	ErrBlockKnown = errors.New("block already known")
+
+	// ErrInvalidStateRoot is returned when the state root claimed by a block
+	// does not match the state computed by applying it.
+	ErrInvalidStateRoot = errors.New("invalid state root")
+
+	// ErrInvalidSignature is returned when a block or transaction is not
+	// signed, or not signed by the key it claims.
+	ErrInvalidSignature = errors.New("invalid signature")
+
+	// ErrInvalidSeed is returned when a block does not carry the seed derived
+	// from its parent.
+	ErrInvalidSeed = errors.New("invalid seed")
+
+	// ErrInvalidVersion is returned when a block other than the genesis block
+	// is older than MinBlockVersion.
+	ErrInvalidVersion = errors.New("invalid block version")
+)
 
 // Validator is an interface that defines the ValidateBlock method.
 type Validator interface {
 	ValidateBlock(*Block) error
 }
 
+// Engine is the consensus engine deciding which validator may propose each
+// block. The proposer of a block is decided by the state of the chain at its
+// parent.
+type Engine interface {
+	// Proposer returns the validator allowed to propose a block with the
+	// given timestamp on top of parent.
+	Proposer(parent *Header, timestamp int64) (types.Address, error)
+
+	// VerifyProposer checks that b was signed by the validator allowed to
+	// propose it on top of parent.
+	VerifyProposer(parent *Header, b *Block) error
+}
+
 // BlockValidator is responsible for validating blocks against the blockchain.
 type BlockValidator struct {
 	bc *Blockchain
@@ -27,37 +60,120 @@ func NewBlockValidator(bc *Blockchain) *BlockValidator {
 
 // ValidateBlock validates a block to ensure it can be added to the blockchain.
 func (v *BlockValidator) ValidateBlock(b *Block) error {
+	hash := b.Hash(BlockHasher{})
+
 	// Check if the block is already known to the blockchain.
-	if v.bc.HasBlock(b.Height) {
+	if v.bc.HasBlockHash(hash) {
 		return ErrBlockKnown
 	}
 
-	// Ensure the block height is the next expected height in the blockchain.
-	if b.Height != v.bc.Height()+1 {
+	// The parent may be any known block, on the canonical chain or on a fork.
+	parent, err := v.bc.GetBlockByHash(b.PrevBlockHash)
+	if err != nil {
+		return fmt.Errorf("%w: block (%s) parent (%s)", ErrUnknownParent, hash, b.PrevBlockHash)
+	}
+
+	// Blocks at finalized heights can only conflict with the finalized chain.
+	if finalized := v.bc.Finalized(); b.Height <= finalized.Height {
+		return fmt.Errorf("%w: block (%s) at height (%d)", ErrFinalizedConflict, hash, b.Height)
+	}
+
+	// Ensure the block directly follows its parent.
+	if b.Height != parent.Height+1 {
 		return fmt.Errorf(
-			"block (%s) with height (%d) is too high => current height (%d)",
-			b.Hash(BlockHasher{}),
+			"block (%s) with height (%d) does not follow its parent height (%d)",
+			hash,
 			b.Height,
-			v.bc.Height(),
+			parent.Height,
 		)
 	}
 
-	// Retrieve the previous block header and verify it.
-	prevHeader, err := v.bc.GetHeader(b.Height - 1)
-	if err != nil {
+	// Verify the integrity of the current block.
+	if err := b.Verify(); err != nil {
 		return err
 	}
 
-	// Verify the previous block hash matches the current block's PrevBlockHash.
-	hash := BlockHasher{}.Hash(prevHeader)
-	if hash != b.PrevBlockHash {
-		return fmt.Errorf("the hash of the previous block (%s) is invalid", b.PrevBlockHash)
+	if err := checkVersion(b); err != nil {
+		return err
 	}
 
-	// Verify the integrity of the current block.
-	if err := b.Verify(); err != nil {
+	if b.Seed != NextSeed(parent.Header) {
+		return fmt.Errorf("%w: block (%s) has seed (%s)", ErrInvalidSeed, hash, b.Seed)
+	}
+
+	// The proposer, nonces and the state root depend on the state the block is
+	// applied to. Blocks on a fork are checked when their branch is connected during a
+	// reorganization.
+	if b.PrevBlockHash != v.bc.tipHash() {
+		return nil
+	}
+
+	// Ensure the block was proposed by the validator scheduled for it.
+	if err := v.bc.verifyProposer(parent.Header, b); err != nil {
+		return err
+	}
+
+	// Ensure every transaction uses the next expected nonce of its sender.
+	if err := v.validateNonces(b); err != nil {
+		return err
+	}
+
+	// Ensure the claimed state root matches the state after applying the block.
+	root, err := v.bc.ComputeStateRoot(b)
+	if err != nil {
 		return err
 	}
+	if root != b.StateRoot {
+		return fmt.Errorf(
+			"%w: block (%s) claims (%s), computed (%s)",
+			ErrInvalidStateRoot,
+			hash,
+			b.StateRoot,
+			root,
+		)
+	}
+
+	return nil
+}
+
+// checkVersion rejects blocks other than the genesis block that are older than
+// MinBlockVersion, so no block can skip the checks added by later versions.
+func checkVersion(b *Block) error {
+	if b.Height > 0 && b.Version < MinBlockVersion {
+		return fmt.Errorf("%w: block (%s) has version (%d)", ErrInvalidVersion, b.Hash(BlockHasher{}), b.Version)
+	}
+
+	return nil
+}
+
+// validateNonces checks that the transactions of each sender in the block
+// carry consecutive nonces starting at the sender's next expected nonce.
+func (v *BlockValidator) validateNonces(b *Block) error {
+	expected := make(map[types.Address]uint64)
+
+	for _, tx := range b.Transactions {
+		from, err := tx.From.Address()
+		if err != nil {
+			return err
+		}
+
+		next, ok := expected[from]
+		if !ok {
+			next = v.bc.NextNonce(from)
+		}
+
+		if tx.Nonce != next {
+			return fmt.Errorf(
+				"%w: tx (%s) from (%s) has nonce (%d), expected (%d)",
+				ErrInvalidNonce,
+				tx.Hash(TxHasher{}),
+				from,
+				tx.Nonce,
+				next,
+			)
+		}
+		expected[from] = next + 1
+	}
 
 	return nil
 }
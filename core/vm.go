@@ -1,20 +1,73 @@
 package core
 
 import (
+	"crypto/sha256"
 	"encoding/binary"
 	"errors"
 	"fmt"
+
+	"github.com/blu-fi-tech-inc/blufi-network/types"
 )
 
 type Instruction byte
 
+// Ints on the stack are 64 bit on every platform and wrap around on overflow.
+// Binary instructions pop a, then b, and push the result of a <op> b. Boolean
+// results are pushed as 1 or 0, and any non-zero int counts as true.
 const (
-	InstrPushInt  Instruction = 0x0a // 10
-	InstrAdd      Instruction = 0x0b // 11
-	InstrPushByte Instruction = 0x0c // 12
-	InstrPack     Instruction = 0x0d // 13
-	InstrSub      Instruction = 0x0e // 14
-	InstrStore    Instruction = 0x0f // 15
+	InstrStop      Instruction = 0x00 // halt execution
+	InstrPushInt   Instruction = 0x0a // 10, followed by an 8 byte big endian int
+	InstrAdd       Instruction = 0x0b // 11
+	InstrPushByte  Instruction = 0x0c // 12, followed by the byte
+	InstrPack      Instruction = 0x0d // 13, pop n and pack the next n bytes
+	InstrSub       Instruction = 0x0e // 14
+	InstrStore     Instruction = 0x0f // 15, pop key and int value and store them in the contract storage
+	InstrGet       Instruction = 0x10 // 16, pop key and push the int in the contract storage, 0 if unset
+	InstrMul       Instruction = 0x11 // 17
+	InstrDiv       Instruction = 0x12 // 18
+	InstrMod       Instruction = 0x13 // 19
+	InstrLt        Instruction = 0x14 // 20
+	InstrGt        Instruction = 0x15 // 21
+	InstrEq        Instruction = 0x16 // 22, ints or bytes
+	InstrNot       Instruction = 0x17 // 23
+	InstrAnd       Instruction = 0x18 // 24
+	InstrOr        Instruction = 0x19 // 25
+	InstrJump      Instruction = 0x1a // 26, pop the destination and jump to it
+	InstrJumpIf    Instruction = 0x1b // 27, pop the destination and a condition, jump if true
+	InstrJumpDest  Instruction = 0x1c // 28, marks a valid jump destination
+	InstrDup       Instruction = 0x1d // 29, followed by n, push a copy of the nth item
+	InstrSwap      Instruction = 0x1e // 30, followed by n, swap the top and the (n+1)th item
+	InstrPop       Instruction = 0x1f // 31
+	InstrSha256    Instruction = 0x20 // 32, pop bytes and push their SHA-256 hash
+	InstrCaller    Instruction = 0x21 // 33, push the address of the sender
+	InstrCallValue Instruction = 0x22 // 34, push the value sent with the transaction, reinterpreted as an int
+	InstrHeight    Instruction = 0x23 // 35, push the height of the block
+	InstrCallData  Instruction = 0x24 // 36, push the input of the call as bytes
+)
+
+// ImmediateSize returns the number of operand bytes following the instruction
+// in the code.
+func (instr Instruction) ImmediateSize() int {
+	switch instr {
+	case InstrPushInt:
+		return 8
+	case InstrPushByte, InstrDup, InstrSwap:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// MaxStackSize is the maximum number of items on the VM stack.
+const MaxStackSize = 1024
+
+var (
+	ErrStackOverflow      = errors.New("stack overflow")
+	ErrStackUnderflow     = errors.New("stack underflow")
+	ErrInvalidOperand     = errors.New("invalid operand type")
+	ErrInvalidJump        = errors.New("invalid jump destination")
+	ErrDivisionByZero     = errors.New("division by zero")
+	ErrTruncatedImmediate = errors.New("truncated immediate operand")
 )
 
 type Stack struct {
@@ -29,93 +82,357 @@ func NewStack(size int) *Stack {
 	}
 }
 
-func (s *Stack) Push(v interface{}) {
+func (s *Stack) Push(v interface{}) error {
+	if s.sp >= MaxStackSize {
+		return ErrStackOverflow
+	}
 	s.data = append(s.data, v)
 	s.sp++
+	return nil
 }
 
-func (s *Stack) Pop() interface{} {
+func (s *Stack) Pop() (interface{}, error) {
 	if s.sp <= 0 {
-		panic("stack underflow")
+		return nil, ErrStackUnderflow
 	}
 	value := s.data[s.sp-1]
 	s.data = s.data[:s.sp-1]
 	s.sp--
-	return value
+	return value, nil
+}
+
+// Peek returns the nth item from the top of the stack, starting at 1.
+func (s *Stack) Peek(n int) (interface{}, error) {
+	if n < 1 || n > s.sp {
+		return nil, ErrStackUnderflow
+	}
+	return s.data[s.sp-n], nil
+}
+
+// Swap exchanges the top item with the (n+1)th item from the top.
+func (s *Stack) Swap(n int) error {
+	if n < 1 || n >= s.sp {
+		return ErrStackUnderflow
+	}
+	s.data[s.sp-1], s.data[s.sp-1-n] = s.data[s.sp-1-n], s.data[s.sp-1]
+	return nil
+}
+
+func (s *Stack) popInt() (int64, error) {
+	value, err := s.Pop()
+	if err != nil {
+		return 0, err
+	}
+	v, ok := value.(int64)
+	if !ok {
+		return 0, fmt.Errorf("%w: expected int, got %T", ErrInvalidOperand, value)
+	}
+	return v, nil
+}
+
+func (s *Stack) popByte() (byte, error) {
+	value, err := s.Pop()
+	if err != nil {
+		return 0, err
+	}
+	v, ok := value.(byte)
+	if !ok {
+		return 0, fmt.Errorf("%w: expected byte, got %T", ErrInvalidOperand, value)
+	}
+	return v, nil
+}
+
+func (s *Stack) popBytes() ([]byte, error) {
+	value, err := s.Pop()
+	if err != nil {
+		return nil, err
+	}
+	v, ok := value.([]byte)
+	if !ok {
+		return nil, fmt.Errorf("%w: expected bytes, got %T", ErrInvalidOperand, value)
+	}
+	return v, nil
+}
+
+// CallContext describes the contract call code is executed in.
+type CallContext struct {
+	Address types.Address // Contract being executed, owner of the storage
+	Caller  types.Address // Sender of the transaction
+	Value   uint64        // Value sent with the transaction
+	Height  uint32        // Height of the block including the transaction
+	Input   []byte        // Input of the call
 }
 
 type VM struct {
-	data          []byte   // VM bytecode
-	ip            int      // instruction pointer
-	stack         *Stack   // VM stack
-	contractState *State   // contract state
+	data          []byte       // VM bytecode
+	ip            int          // instruction pointer
+	next          int          // instruction executed after the current one
+	jumpDests     map[int]bool // valid jump destinations
+	stack         *Stack       // VM stack
+	contractState *State       // contract state
+	ctx           CallContext  // call context
+	gas           uint64       // gas left
 }
 
-func NewVM(data []byte, contractState *State) *VM {
+// NewVM creates a VM that runs data against the contract state with the given
+// amount of gas.
+func NewVM(data []byte, contractState *State, ctx CallContext, gas uint64) *VM {
 	return &VM{
 		data:          data,
 		ip:            0,
+		jumpDests:     jumpDests(data),
 		stack:         NewStack(128),
 		contractState: contractState,
+		ctx:           ctx,
+		gas:           gas,
 	}
 }
 
+// jumpDests returns the offsets of the InstrJumpDest instructions of the code,
+// skipping immediate operands.
+func jumpDests(data []byte) map[int]bool {
+	dests := make(map[int]bool)
+	for i := 0; i < len(data); i++ {
+		instr := Instruction(data[i])
+		if instr == InstrJumpDest {
+			dests[i] = true
+		}
+		i += instr.ImmediateSize()
+	}
+	return dests
+}
+
+// GasLeft returns the gas not yet used by the VM.
+func (vm *VM) GasLeft() uint64 {
+	return vm.gas
+}
+
+// Run executes the code until it ends or fails.
 func (vm *VM) Run() error {
 	for vm.ip < len(vm.data) {
 		instr := Instruction(vm.data[vm.ip])
 
+		cost, ok := gasTable[instr]
+		if !ok {
+			return fmt.Errorf("unknown instruction: %v", instr)
+		}
+		if err := vm.useGas(cost); err != nil {
+			return err
+		}
+
+		vm.next = vm.ip + 1 + instr.ImmediateSize()
+		if vm.next > len(vm.data) {
+			return fmt.Errorf("%w: instruction (%d) at (%d)", ErrTruncatedImmediate, instr, vm.ip)
+		}
+
 		if err := vm.Exec(instr); err != nil {
 			return err
 		}
 
-		vm.ip++
+		vm.ip = vm.next
+	}
+
+	return nil
+}
+
+// useGas charges gas, failing with ErrOutOfGas if not enough is left.
+func (vm *VM) useGas(amount uint64) error {
+	if amount > vm.gas {
+		vm.gas = 0
+		return ErrOutOfGas
 	}
+	vm.gas -= amount
+	return nil
+}
 
+// immediate returns the operand bytes of the current instruction.
+func (vm *VM) immediate() []byte {
+	return vm.data[vm.ip+1 : vm.next]
+}
+
+// jump continues execution at dest, which must be an InstrJumpDest.
+func (vm *VM) jump(dest int64) error {
+	if dest < 0 || dest >= int64(len(vm.data)) || !vm.jumpDests[int(dest)] {
+		return fmt.Errorf("%w: (%d)", ErrInvalidJump, dest)
+	}
+	vm.next = int(dest)
 	return nil
 }
 
 func (vm *VM) Exec(instr Instruction) error {
 	switch instr {
+	case InstrStop:
+		vm.next = len(vm.data)
+
 	case InstrStore:
-		key := vm.stack.Pop().([]byte)
-		value := vm.stack.Pop()
+		key, err := vm.stack.popBytes()
+		if err != nil {
+			return err
+		}
+		value, err := vm.stack.Pop()
+		if err != nil {
+			return err
+		}
 
 		switch v := value.(type) {
-		case int:
-			serializedValue := serializeInt64(int64(v))
-			vm.contractState.Put(key, serializedValue)
+		case int64:
+			serializedValue := serializeInt64(v)
+			if err := vm.useGas(uint64(len(key)+len(serializedValue)) * GasStoreByte); err != nil {
+				return err
+			}
+			return vm.contractState.PutStorage(vm.ctx.Address, key, serializedValue)
 		default:
 			return errors.New("unsupported type for storage")
 		}
 
+	case InstrGet:
+		key, err := vm.stack.popBytes()
+		if err != nil {
+			return err
+		}
+
+		value, err := vm.contractState.GetStorage(vm.ctx.Address, key)
+		if errors.Is(err, ErrKeyNotFound) {
+			return vm.stack.Push(int64(0))
+		}
+		if err != nil {
+			return err
+		}
+		if len(value) != 8 {
+			return fmt.Errorf("%w: stored value of (%d) bytes", ErrInvalidOperand, len(value))
+		}
+
+		return vm.stack.Push(deserializeInt64(value))
+
 	case InstrPushInt:
-		vm.stack.Push(int(vm.data[vm.ip-1]))
+		return vm.stack.Push(int64(binary.BigEndian.Uint64(vm.immediate())))
 
 	case InstrPushByte:
-		vm.stack.Push(byte(vm.data[vm.ip-1]))
+		return vm.stack.Push(vm.immediate()[0])
 
 	case InstrPack:
-		n := vm.stack.Pop().(int)
+		n, err := vm.stack.popInt()
+		if err != nil {
+			return err
+		}
 		if n < 0 {
 			return errors.New("negative length in InstrPack")
 		}
+		// The bytes come off the stack, so n is bounded by its size.
+		if n > int64(vm.stack.sp) {
+			return ErrStackUnderflow
+		}
+		if err := vm.useGas(uint64(n) * GasPackByte); err != nil {
+			return err
+		}
 
 		b := make([]byte, n)
-		for i := 0; i < n; i++ {
-			b[i] = vm.stack.Pop().(byte)
+		for i := range b {
+			if b[i], err = vm.stack.popByte(); err != nil {
+				return err
+			}
 		}
 
-		vm.stack.Push(b)
+		return vm.stack.Push(b)
 
-	case InstrSub:
-		a := vm.stack.Pop().(int)
-		b := vm.stack.Pop().(int)
-		vm.stack.Push(a - b)
+	case InstrAdd, InstrSub, InstrMul, InstrDiv, InstrMod, InstrLt, InstrGt, InstrAnd, InstrOr:
+		a, err := vm.stack.popInt()
+		if err != nil {
+			return err
+		}
+		b, err := vm.stack.popInt()
+		if err != nil {
+			return err
+		}
 
-	case InstrAdd:
-		a := vm.stack.Pop().(int)
-		b := vm.stack.Pop().(int)
-		vm.stack.Push(a + b)
+		result, err := arithmetic(instr, a, b)
+		if err != nil {
+			return err
+		}
+		return vm.stack.Push(result)
+
+	case InstrEq:
+		a, err := vm.stack.Pop()
+		if err != nil {
+			return err
+		}
+		b, err := vm.stack.Pop()
+		if err != nil {
+			return err
+		}
+
+		equal, err := equalValues(a, b)
+		if err != nil {
+			return err
+		}
+		return vm.stack.Push(boolToInt(equal))
+
+	case InstrNot:
+		a, err := vm.stack.popInt()
+		if err != nil {
+			return err
+		}
+		return vm.stack.Push(boolToInt(a == 0))
+
+	case InstrJump:
+		dest, err := vm.stack.popInt()
+		if err != nil {
+			return err
+		}
+		return vm.jump(dest)
+
+	case InstrJumpIf:
+		dest, err := vm.stack.popInt()
+		if err != nil {
+			return err
+		}
+		cond, err := vm.stack.popInt()
+		if err != nil {
+			return err
+		}
+		if cond != 0 {
+			return vm.jump(dest)
+		}
+
+	case InstrJumpDest:
+
+	case InstrDup:
+		value, err := vm.stack.Peek(int(vm.immediate()[0]))
+		if err != nil {
+			return err
+		}
+		return vm.stack.Push(value)
+
+	case InstrSwap:
+		return vm.stack.Swap(int(vm.immediate()[0]))
+
+	case InstrPop:
+		_, err := vm.stack.Pop()
+		return err
+
+	case InstrSha256:
+		data, err := vm.stack.popBytes()
+		if err != nil {
+			return err
+		}
+		if err := vm.useGas(uint64(len(data)) * GasSha256Byte); err != nil {
+			return err
+		}
+
+		hash := sha256.Sum256(data)
+		return vm.stack.Push(hash[:])
+
+	case InstrCaller:
+		return vm.stack.Push(vm.ctx.Caller.ToSlice())
+
+	case InstrCallValue:
+		return vm.stack.Push(int64(vm.ctx.Value))
+
+	case InstrHeight:
+		return vm.stack.Push(int64(vm.ctx.Height))
+
+	case InstrCallData:
+		return vm.stack.Push(vm.ctx.Input)
 
 	default:
 		return fmt.Errorf("unknown instruction: %v", instr)
@@ -124,8 +441,71 @@ func (vm *VM) Exec(instr Instruction) error {
 	return nil
 }
 
+// arithmetic applies a binary int instruction to a and b.
+func arithmetic(instr Instruction, a, b int64) (int64, error) {
+	switch instr {
+	case InstrAdd:
+		return a + b, nil
+	case InstrSub:
+		return a - b, nil
+	case InstrMul:
+		return a * b, nil
+	case InstrDiv:
+		if b == 0 {
+			return 0, ErrDivisionByZero
+		}
+		return a / b, nil
+	case InstrMod:
+		if b == 0 {
+			return 0, ErrDivisionByZero
+		}
+		return a % b, nil
+	case InstrLt:
+		return boolToInt(a < b), nil
+	case InstrGt:
+		return boolToInt(a > b), nil
+	case InstrAnd:
+		return boolToInt(a != 0 && b != 0), nil
+	case InstrOr:
+		return boolToInt(a != 0 || b != 0), nil
+	default:
+		return 0, fmt.Errorf("unknown instruction: %v", instr)
+	}
+}
+
+// equalValues compares two ints or two byte slices.
+func equalValues(a, b interface{}) (bool, error) {
+	switch av := a.(type) {
+	case int64:
+		bv, ok := b.(int64)
+		if !ok {
+			return false, fmt.Errorf("%w: cannot compare int with %T", ErrInvalidOperand, b)
+		}
+		return av == bv, nil
+	case []byte:
+		bv, ok := b.([]byte)
+		if !ok {
+			return false, fmt.Errorf("%w: cannot compare bytes with %T", ErrInvalidOperand, b)
+		}
+		return string(av) == string(bv), nil
+	default:
+		return false, fmt.Errorf("%w: cannot compare %T", ErrInvalidOperand, a)
+	}
+}
+
+func boolToInt(v bool) int64 {
+	if v {
+		return 1
+	}
+	return 0
+}
+
 func serializeInt64(value int64) []byte {
 	buf := make([]byte, 8)
 	binary.LittleEndian.PutUint64(buf, uint64(value))
 	return buf
 }
+
+func deserializeInt64(b []byte) int64 {
+	return int64(binary.LittleEndian.Uint64(b))
+}
@@ -1,57 +1,112 @@
 package crypto
 
 import (
-    "crypto/ecdsa"
-    "crypto/rand"
-    "crypto/x509"
-    "crypto/elliptic"
-    "crypto/sha256"
-    "math/big"
-
-    "github.com/blu-fi-tech-inc/blufi-network/types"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"errors"
+	"math/big"
+
+	"github.com/blu-fi-tech-inc/blufi-network/types"
 )
 
 type PrivateKey struct {
-    *ecdsa.PrivateKey
+	*ecdsa.PrivateKey
 }
 
 type PublicKey struct {
-    *ecdsa.PublicKey
+	*ecdsa.PublicKey
 }
 
 func GenerateKeyPair() (*PrivateKey, *PublicKey, error) {
-    privKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
-    if err != nil {
-        return nil, nil, err
-    }
-    return &PrivateKey{privKey}, &PublicKey{&privKey.PublicKey}, nil
+	privKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &PrivateKey{privKey}, &PublicKey{&privKey.PublicKey}, nil
 }
 
 func (pub *PublicKey) Address() (types.Address, error) {
-    pubBytes, err := x509.MarshalPKIXPublicKey(pub.PublicKey)
-    if err != nil {
-        return types.Address{}, err
-    }
+	if pub.PublicKey == nil {
+		return types.Address{}, errors.New("public key is empty")
+	}
+
+	pubBytes, err := x509.MarshalPKIXPublicKey(pub.PublicKey)
+	if err != nil {
+		return types.Address{}, err
+	}
 
-    hash := sha256.Sum256(pubBytes)
-    return types.AddressFromBytes(hash[:20])
+	hash := sha256.Sum256(pubBytes)
+	return types.AddressFromBytes(hash[:20])
 }
 
 func (priv *PrivateKey) Sign(data []byte) ([]byte, error) {
-    r, s, err := ecdsa.Sign(rand.Reader, priv.PrivateKey, data)
-    if err != nil {
-        return nil, err
-    }
-    // Concatenate r and s into a single byte slice
-    signature := append(r.Bytes(), s.Bytes()...)
-    return signature, nil
+	r, s, err := ecdsa.Sign(rand.Reader, priv.PrivateKey, data)
+	if err != nil {
+		return nil, err
+	}
+	// Concatenate r and s into a single fixed-size byte slice
+	signature := make([]byte, 64)
+	r.FillBytes(signature[:32])
+	s.FillBytes(signature[32:])
+	return signature, nil
+}
+
+// SharedSecret performs an ECDH key agreement between the private key and the
+// public key of the other party and returns the shared secret.
+func (priv *PrivateKey) SharedSecret(pub *PublicKey) ([]byte, error) {
+	if priv.PrivateKey == nil || pub.PublicKey == nil {
+		return nil, errors.New("key is empty")
+	}
+
+	local, err := priv.PrivateKey.ECDH()
+	if err != nil {
+		return nil, err
+	}
+	remote, err := pub.PublicKey.ECDH()
+	if err != nil {
+		return nil, err
+	}
+
+	return local.ECDH(remote)
 }
 
 func VerifySignature(pub *PublicKey, data, signature []byte) bool {
-    if len(signature) != 64 {
-        return false
-    }
-    r := new(big.Int).SetBytes(signature[:32])
-    s := new(big.Int).SetBytes(signature[32:])
-    return ecdsa.Verify(pub.PublicKey, data, r, s)
+	if len(signature) != 64 {
+		return false
+	}
+	r := new(big.Int).SetBytes(signature[:32])
+	s := new(big.Int).SetBytes(signature[32:])
+	return ecdsa.Verify(pub.PublicKey, data, r, s)
+}
+
+// Bytes returns the public key as an uncompressed P-256 point, or an empty
+// slice for a nil key.
+func (pub PublicKey) Bytes() []byte {
+	if pub.PublicKey == nil {
+		return []byte{}
+	}
+	return elliptic.Marshal(elliptic.P256(), pub.X, pub.Y)
+}
+
+// GobEncode encodes the public key with Bytes so that blocks and transactions
+// carrying keys can be gob encoded.
+func (pub PublicKey) GobEncode() ([]byte, error) {
+	return pub.Bytes(), nil
+}
+
+// GobDecode decodes a public key previously encoded with GobEncode.
+func (pub *PublicKey) GobDecode(data []byte) error {
+	if len(data) == 0 {
+		pub.PublicKey = nil
+		return nil
+	}
+	x, y := elliptic.Unmarshal(elliptic.P256(), data)
+	if x == nil {
+		return errors.New("invalid public key encoding")
+	}
+	pub.PublicKey = &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}
+	return nil
 }
@@ -0,0 +1,297 @@
+package network
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// MaxAddressBookSize is the number of addresses kept in the address book.
+	MaxAddressBookSize = 1000
+
+	// maxAddrFailures is the number of failed connection attempts in a row
+	// after which an address is forgotten.
+	maxAddrFailures = 10
+
+	// dialBackoff is the time to wait before dialing an address again after a
+	// failed attempt. It doubles with every further failure.
+	dialBackoff = 5 * time.Second
+)
+
+// AddrInfo is what the address book knows about a peer address.
+type AddrInfo struct {
+	Addr        string
+	Seed        bool      // Seed nodes are never forgotten.
+	LastSeen    time.Time // Last time a connection to the address succeeded.
+	LastAttempt time.Time // Last time the address was dialed.
+	Failures    int       // Failed connection attempts since the last success.
+	Score       int       // Successful connections minus failed attempts.
+}
+
+// AddressBook keeps track of the peer addresses a node knows about. It is
+// persisted as JSON so a node can reconnect to the network after a restart
+// without depending on the seed nodes.
+type AddressBook struct {
+	lock  sync.RWMutex
+	path  string // file the book is persisted to, in-memory if empty
+	addrs map[string]*AddrInfo
+}
+
+// NewAddressBook creates an address book persisted to path and loads the
+// addresses stored there. An empty path keeps the book in memory only.
+func NewAddressBook(path string) (*AddressBook, error) {
+	b := &AddressBook{
+		path:  path,
+		addrs: make(map[string]*AddrInfo),
+	}
+	if len(path) == 0 {
+		return b, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return b, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var infos []*AddrInfo
+	if err := json.Unmarshal(data, &infos); err != nil {
+		return nil, err
+	}
+	for _, info := range infos {
+		b.addrs[info.Addr] = info
+	}
+
+	return b, nil
+}
+
+// Add adds an address to the book. It returns false if the address is invalid,
+// already known or the book is full.
+func (b *AddressBook) Add(addr string) bool {
+	if !validPeerAddr(addr) {
+		return false
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if _, ok := b.addrs[addr]; ok || len(b.addrs) >= MaxAddressBookSize {
+		return false
+	}
+	b.addrs[addr] = &AddrInfo{Addr: addr}
+
+	return true
+}
+
+// AddSeed adds the address of a seed node to the book.
+func (b *AddressBook) AddSeed(addr string) {
+	b.Add(addr)
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if info, ok := b.addrs[addr]; ok {
+		info.Seed = true
+	}
+}
+
+// Attempt records that an address is being dialed.
+func (b *AddressBook) Attempt(addr string) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if info, ok := b.addrs[addr]; ok {
+		info.LastAttempt = time.Now()
+	}
+}
+
+// Good records a successful connection to an address, adding it to the book if
+// it is not known yet.
+func (b *AddressBook) Good(addr string) {
+	b.Add(addr)
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if info, ok := b.addrs[addr]; ok {
+		info.LastSeen = time.Now()
+		info.Failures = 0
+		info.Score++
+	}
+}
+
+// Failed records a failed connection attempt to an address. Addresses that
+// keep failing are forgotten, unless they belong to a seed node.
+func (b *AddressBook) Failed(addr string) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	info, ok := b.addrs[addr]
+	if !ok {
+		return
+	}
+
+	info.Failures++
+	info.Score--
+	if info.Failures >= maxAddrFailures && !info.Seed {
+		delete(b.addrs, addr)
+	}
+}
+
+// Get returns what the book knows about an address.
+func (b *AddressBook) Get(addr string) (AddrInfo, bool) {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+
+	info, ok := b.addrs[addr]
+	if !ok {
+		return AddrInfo{}, false
+	}
+
+	return *info, true
+}
+
+// Len returns the number of addresses in the book.
+func (b *AddressBook) Len() int {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+
+	return len(b.addrs)
+}
+
+// DialCandidates returns up to n addresses to dial, best score first. It skips
+// addresses for which skip returns true and addresses that failed recently.
+func (b *AddressBook) DialCandidates(n int, skip func(addr string) bool) []string {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+
+	now := time.Now()
+	infos := []*AddrInfo{}
+	for addr, info := range b.addrs {
+		if skip(addr) || now.Before(info.LastAttempt.Add(info.backoff())) {
+			continue
+		}
+		infos = append(infos, info)
+	}
+
+	return topAddrs(infos, n)
+}
+
+// Known returns up to n of the addresses that were connected to successfully,
+// most recently seen first. These are the addresses shared with other peers.
+func (b *AddressBook) Known(n int) []string {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+
+	infos := []*AddrInfo{}
+	for _, info := range b.addrs {
+		if !info.LastSeen.IsZero() {
+			infos = append(infos, info)
+		}
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].LastSeen.After(infos[j].LastSeen)
+	})
+
+	addrs := []string{}
+	for i := 0; i < len(infos) && i < n; i++ {
+		addrs = append(addrs, infos[i].Addr)
+	}
+
+	return addrs
+}
+
+// Save persists the address book. It is a no-op for in-memory books.
+func (b *AddressBook) Save() error {
+	if len(b.path) == 0 {
+		return nil
+	}
+
+	b.lock.RLock()
+	infos := make([]*AddrInfo, 0, len(b.addrs))
+	for _, info := range b.addrs {
+		infos = append(infos, info)
+	}
+	data, err := json.MarshalIndent(infos, "", "  ")
+	b.lock.RUnlock()
+
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(b.path), 0o755); err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so a crash never leaves a truncated
+	// address book behind.
+	tmp := b.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, b.path)
+}
+
+// backoff returns how long to wait after the last attempt before dialing the
+// address again.
+func (info *AddrInfo) backoff() time.Duration {
+	if info.Failures == 0 {
+		return 0
+	}
+
+	return dialBackoff << min(info.Failures-1, 6)
+}
+
+// topAddrs returns the addresses of the n best scored infos.
+func topAddrs(infos []*AddrInfo, n int) []string {
+	sort.Slice(infos, func(i, j int) bool {
+		if infos[i].Score != infos[j].Score {
+			return infos[i].Score > infos[j].Score
+		}
+		return infos[i].Addr < infos[j].Addr
+	})
+
+	addrs := []string{}
+	for i := 0; i < len(infos) && i < n; i++ {
+		addrs = append(addrs, infos[i].Addr)
+	}
+
+	return addrs
+}
+
+// validPeerAddr reports whether addr is a host:port address that can be
+// dialed.
+func validPeerAddr(addr string) bool {
+	host, port, err := net.SplitHostPort(addr)
+	return err == nil && len(host) > 0 && len(port) > 0
+}
+
+// advertisedAddr returns the address a peer can be dialed at, given the
+// listen address it announced and the address its connection came from. A
+// listen address without a host, or with an unspecified one, is reachable at
+// the host of the connection.
+func advertisedAddr(remote net.Addr, listenAddr string) string {
+	host, port, err := net.SplitHostPort(listenAddr)
+	if err != nil {
+		return ""
+	}
+
+	if ip := net.ParseIP(host); len(host) == 0 || (ip != nil && ip.IsUnspecified()) {
+		remoteHost, _, err := net.SplitHostPort(remote.String())
+		if err != nil {
+			return ""
+		}
+		host = remoteHost
+	}
+
+	return net.JoinHostPort(host, port)
+}
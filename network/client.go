@@ -3,47 +3,38 @@ package network
 import (
 	"bytes"
 	"encoding/gob"
-	"fmt"
 	"net"
 )
 
 // Client represents a network client.
 type Client struct {
-	conn net.Conn
+	conn  net.Conn
+	magic uint32
 }
 
-// NewClient creates a new network client.
-func NewClient(address string) (*Client, error) {
+// NewClient creates a new network client for the network identified by magic.
+func NewClient(address string, magic uint32) (*Client, error) {
 	conn, err := net.Dial("tcp", address)
 	if err != nil {
 		return nil, err
 	}
-	return &Client{conn: conn}, nil
+	return &Client{conn: conn, magic: magic}, nil
 }
 
-// SendData sends data to the connected server.
-func (c *Client) SendData(data interface{}) error {
+// SendData sends data of the given message type to the connected server.
+func (c *Client) SendData(t MessageType, data interface{}) error {
 	var buf bytes.Buffer
 	enc := gob.NewEncoder(&buf)
 	if err := enc.Encode(data); err != nil {
 		return err
 	}
 
-	if _, err := c.conn.Write(buf.Bytes()); err != nil {
-		return err
-	}
-
-	return nil
+	return WriteFrame(c.conn, c.magic, NewMessage(t, buf.Bytes()))
 }
 
-// ReceiveData receives data from the connected server.
-func (c *Client) ReceiveData() (interface{}, error) {
-	var data interface{}
-	dec := gob.NewDecoder(c.conn)
-	if err := dec.Decode(&data); err != nil {
-		return nil, err
-	}
-	return data, nil
+// ReceiveData receives the next message from the connected server.
+func (c *Client) ReceiveData() (*Message, error) {
+	return ReadFrame(c.conn, c.magic)
 }
 
 // Close closes the client connection.
@@ -0,0 +1,85 @@
+package network
+
+import (
+	"sync"
+
+	"github.com/blu-fi-tech-inc/blufi-network/core"
+	"github.com/blu-fi-tech-inc/blufi-network/types"
+)
+
+// maxEvidenceAge is how many heights below the highest block seen the signed
+// headers of blocks are kept to detect double-signing.
+const maxEvidenceAge = 256
+
+// EquivocationDetector remembers the headers validators signed at recent
+// heights and detects validators that sign two different blocks at the same
+// height. Each validator is reported once.
+type EquivocationDetector struct {
+	lock     sync.Mutex
+	headers  map[signedHeight]*core.SignedHeader
+	reported map[types.Address]bool
+	top      uint32 // highest height seen
+}
+
+type signedHeight struct {
+	height uint32
+	signer types.Address
+}
+
+// NewEquivocationDetector creates an empty EquivocationDetector.
+func NewEquivocationDetector() *EquivocationDetector {
+	return &EquivocationDetector{
+		headers:  make(map[signedHeight]*core.SignedHeader),
+		reported: make(map[types.Address]bool),
+	}
+}
+
+// Check records the signed header of b and returns evidence if its validator
+// signed a different block at the same height before. Blocks without a valid
+// signature and blocks too far below the highest one seen are ignored.
+func (d *EquivocationDetector) Check(b *core.Block) (core.EvidenceTx, bool) {
+	header := core.NewSignedHeader(b)
+	if err := header.Verify(); err != nil {
+		return core.EvidenceTx{}, false
+	}
+	signer, err := header.Validator.Address()
+	if err != nil {
+		return core.EvidenceTx{}, false
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if b.Height+maxEvidenceAge < d.top {
+		return core.EvidenceTx{}, false
+	}
+	if b.Height > d.top {
+		d.top = b.Height
+		d.prune()
+	}
+
+	key := signedHeight{height: b.Height, signer: signer}
+	seen, ok := d.headers[key]
+	if !ok {
+		d.headers[key] = header
+		return core.EvidenceTx{}, false
+	}
+
+	hasher := core.BlockHasher{}
+	if d.reported[signer] || hasher.Hash(seen.Header) == hasher.Hash(header.Header) {
+		return core.EvidenceTx{}, false
+	}
+	d.reported[signer] = true
+
+	return core.EvidenceTx{First: seen, Second: header}, true
+}
+
+// prune forgets the headers more than maxEvidenceAge below the highest
+// height. It must be called with the lock held.
+func (d *EquivocationDetector) prune() {
+	for key := range d.headers {
+		if key.height+maxEvidenceAge < d.top {
+			delete(d.headers, key)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package network
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+// Every message sent to a peer is wrapped in a frame:
+//
+//	magic    uint32  identifies the network
+//	version  uint8   protocol version
+//	type     uint8   MessageType of the payload
+//	length   uint32  length of the payload
+//	checksum uint32  CRC-32 of the payload
+//	payload  [length]byte
+//
+// All integers are big endian. Frames are read from the connection one at a
+// time, so a message may span any number of TCP reads and several messages may
+// arrive in one.
+const (
+	// ProtocolVersion is the version of the wire protocol spoken by this node.
+	ProtocolVersion uint8 = 1
+
+	// DefaultNetworkMagic identifies the main BluFi network.
+	DefaultNetworkMagic uint32 = 0xb1f1b1f1
+
+	// MaxFrameSize is the largest payload accepted in a single frame.
+	MaxFrameSize = 32 << 20
+
+	frameHeaderSize = 4 + 1 + 1 + 4 + 4
+)
+
+var (
+	ErrInvalidMagic       = errors.New("frame from another network")
+	ErrUnsupportedVersion = errors.New("unsupported protocol version")
+	ErrFrameTooLarge      = errors.New("frame too large")
+	ErrInvalidChecksum    = errors.New("frame checksum mismatch")
+)
+
+// EncodeFrame wraps a message in a frame for the given network.
+func EncodeFrame(magic uint32, msg *Message) ([]byte, error) {
+	if len(msg.Data) > MaxFrameSize {
+		return nil, fmt.Errorf("%w: (%d) bytes", ErrFrameTooLarge, len(msg.Data))
+	}
+
+	frame := make([]byte, frameHeaderSize+len(msg.Data))
+	binary.BigEndian.PutUint32(frame[0:], magic)
+	frame[4] = ProtocolVersion
+	frame[5] = byte(msg.Header)
+	binary.BigEndian.PutUint32(frame[6:], uint32(len(msg.Data)))
+	binary.BigEndian.PutUint32(frame[10:], crc32.ChecksumIEEE(msg.Data))
+	copy(frame[frameHeaderSize:], msg.Data)
+
+	return frame, nil
+}
+
+// WriteFrame writes a message as a single frame.
+func WriteFrame(w io.Writer, magic uint32, msg *Message) error {
+	frame, err := EncodeFrame(magic, msg)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(frame)
+	return err
+}
+
+// ReadFrame reads the next frame of the given network and returns its message.
+// It returns io.EOF if the stream ends cleanly before a frame.
+func ReadFrame(r io.Reader, magic uint32) (*Message, error) {
+	header := make([]byte, frameHeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+
+	if m := binary.BigEndian.Uint32(header[0:]); m != magic {
+		return nil, fmt.Errorf("%w: magic (%08x), expected (%08x)", ErrInvalidMagic, m, magic)
+	}
+	if v := header[4]; v != ProtocolVersion {
+		return nil, fmt.Errorf("%w: (%d)", ErrUnsupportedVersion, v)
+	}
+
+	length := binary.BigEndian.Uint32(header[6:])
+	if length > MaxFrameSize {
+		return nil, fmt.Errorf("%w: (%d) bytes", ErrFrameTooLarge, length)
+	}
+
+	data := make([]byte, length)
+	if _, err := io.ReadFull(r, data); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	if sum := binary.BigEndian.Uint32(header[10:]); sum != crc32.ChecksumIEEE(data) {
+		return nil, ErrInvalidChecksum
+	}
+
+	return NewMessage(MessageType(header[5]), data), nil
+}
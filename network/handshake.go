@@ -0,0 +1,211 @@
+package network
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+
+	"github.com/blu-fi-tech-inc/blufi-network/crypto"
+	"github.com/blu-fi-tech-inc/blufi-network/types"
+)
+
+const handshakeNonceSize = 32
+
+var (
+	ErrChainMismatch      = errors.New("peer is on another chain")
+	ErrGenesisMismatch    = errors.New("peer has another genesis block")
+	ErrVersionMismatch    = errors.New("peer speaks another protocol version")
+	ErrInvalidHandshake   = errors.New("invalid handshake")
+	ErrHandshakeSignature = errors.New("invalid handshake signature")
+	ErrSelfConnection     = errors.New("connected to self")
+)
+
+// HandshakeMessage is the first message sent by both sides of a connection.
+type HandshakeMessage struct {
+	BlockchainName string
+	GenesisHash    types.Hash
+	Version        uint8
+	PublicKey      crypto.PublicKey // Node identity key of the sender.
+	Nonce          []byte           // Challenge the other side has to sign.
+	Encrypt        bool             // Whether the sender encrypts the connection.
+	SessionKey     crypto.PublicKey // Ephemeral ECDH key of the sender.
+	ListenAddr     string           // Address the sender accepts connections on.
+}
+
+// HandshakeAuthMessage proves that the sender of a HandshakeMessage holds the
+// private key of the identity it announced.
+type HandshakeAuthMessage struct {
+	Signature []byte // Signature over both nonces, see handshakeDigest.
+}
+
+// Handshake holds what a node announces about itself when connecting to a
+// peer. Both sides exchange a HandshakeMessage and check that they are on the
+// same chain, then each signs the nonce of the other and its own session key
+// with its node key.
+type Handshake struct {
+	BlockchainName string
+	GenesisHash    types.Hash
+	NodeKey        *crypto.PrivateKey
+	Encrypt        bool   // Encrypt the connection after the handshake, see Secure.
+	ListenAddr     string // Address the node accepts connections on.
+}
+
+// HandshakeResult is the outcome of a successful handshake.
+type HandshakeResult struct {
+	PublicKey  *crypto.PublicKey // Verified identity key of the peer.
+	ListenAddr string            // Address the peer announced to accept connections on.
+
+	encrypt bool
+	sendKey []byte
+	recvKey []byte
+}
+
+// Run performs the handshake over rw. The connection must not be used for
+// anything else if it fails.
+func (h *Handshake) Run(rw io.ReadWriter, magic uint32) (*HandshakeResult, error) {
+	nonce := make([]byte, handshakeNonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	// A fresh key per connection keeps past sessions secret if the node key
+	// leaks later.
+	sessionKey, sessionPubKey, err := crypto.GenerateKeyPair()
+	if err != nil {
+		return nil, err
+	}
+
+	local := &HandshakeMessage{
+		BlockchainName: h.BlockchainName,
+		GenesisHash:    h.GenesisHash,
+		Version:        ProtocolVersion,
+		PublicKey:      crypto.PublicKey{PublicKey: &h.NodeKey.PublicKey},
+		Nonce:          nonce,
+		Encrypt:        h.Encrypt,
+		SessionKey:     *sessionPubKey,
+		ListenAddr:     h.ListenAddr,
+	}
+	remote := new(HandshakeMessage)
+	if err := exchange(rw, magic, MessageTypeHandshake, local, remote); err != nil {
+		return nil, err
+	}
+
+	if err := h.check(local, remote); err != nil {
+		return nil, err
+	}
+
+	sig, err := h.NodeKey.Sign(handshakeDigest(remote.Nonce, local))
+	if err != nil {
+		return nil, err
+	}
+	auth := new(HandshakeAuthMessage)
+	if err := exchange(rw, magic, MessageTypeHandshakeAuth, &HandshakeAuthMessage{Signature: sig}, auth); err != nil {
+		return nil, err
+	}
+
+	if !crypto.VerifySignature(&remote.PublicKey, handshakeDigest(local.Nonce, remote), auth.Signature) {
+		return nil, ErrHandshakeSignature
+	}
+
+	result := &HandshakeResult{
+		PublicKey:  &remote.PublicKey,
+		ListenAddr: remote.ListenAddr,
+		encrypt:    h.Encrypt,
+	}
+	if h.Encrypt {
+		secret, err := sessionKey.SharedSecret(&remote.SessionKey)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidHandshake, err)
+		}
+		// Each side seals with the key derived from its own nonce.
+		result.sendKey = deriveKey(secret, []byte("blufi session"), local.Nonce, remote.Nonce)
+		result.recvKey = deriveKey(secret, []byte("blufi session"), remote.Nonce, local.Nonce)
+	}
+
+	return result, nil
+}
+
+// Secure wraps the connection the handshake was performed on in an encrypted
+// channel if encryption was agreed on, otherwise conn is returned as is.
+func (r *HandshakeResult) Secure(conn net.Conn) (net.Conn, error) {
+	if !r.encrypt {
+		return conn, nil
+	}
+
+	return NewSecureConn(conn, r.sendKey, r.recvKey)
+}
+
+// check validates the handshake message of the peer against our own.
+func (h *Handshake) check(local, remote *HandshakeMessage) error {
+	if remote.BlockchainName != h.BlockchainName {
+		return fmt.Errorf("%w: (%s), expected (%s)", ErrChainMismatch, remote.BlockchainName, h.BlockchainName)
+	}
+	if remote.GenesisHash != h.GenesisHash {
+		return fmt.Errorf("%w: (%s), expected (%s)", ErrGenesisMismatch, remote.GenesisHash, h.GenesisHash)
+	}
+	if remote.Version != ProtocolVersion {
+		return fmt.Errorf("%w: (%d), expected (%d)", ErrVersionMismatch, remote.Version, ProtocolVersion)
+	}
+	if remote.Encrypt != h.Encrypt {
+		return ErrEncryptMismatch
+	}
+	if remote.PublicKey.PublicKey == nil || remote.SessionKey.PublicKey == nil {
+		return fmt.Errorf("%w: no public key", ErrInvalidHandshake)
+	}
+	if len(remote.Nonce) != handshakeNonceSize {
+		return fmt.Errorf("%w: nonce length (%d)", ErrInvalidHandshake, len(remote.Nonce))
+	}
+	if bytes.Equal(remote.PublicKey.Bytes(), local.PublicKey.Bytes()) {
+		return ErrSelfConnection
+	}
+
+	return nil
+}
+
+// handshakeDigest is the digest signed by the side that received challenge,
+// binding the signature to the nonces of both sides of the connection and to
+// the session key of the signer.
+func handshakeDigest(challenge []byte, signer *HandshakeMessage) []byte {
+	h := sha256.New()
+	h.Write(challenge)
+	h.Write(signer.Nonce)
+	h.Write(signer.SessionKey.Bytes())
+	return h.Sum(nil)
+}
+
+// exchange sends out and receives in as frames of type t. Both sides of a
+// handshake send before they receive, so the write happens concurrently to
+// not depend on the connection buffering it.
+func exchange(rw io.ReadWriter, magic uint32, t MessageType, out, in interface{}) error {
+	buf := &bytes.Buffer{}
+	if err := gob.NewEncoder(buf).Encode(out); err != nil {
+		return err
+	}
+
+	writeErr := make(chan error, 1)
+	go func() {
+		writeErr <- WriteFrame(rw, magic, NewMessage(t, buf.Bytes()))
+	}()
+
+	msg, err := ReadFrame(rw, magic)
+	if err != nil {
+		return err
+	}
+	if err := <-writeErr; err != nil {
+		return err
+	}
+
+	if msg.Header != t {
+		return fmt.Errorf("%w: unexpected message type (%x)", ErrInvalidHandshake, msg.Header)
+	}
+	if err := gob.NewDecoder(bytes.NewReader(msg.Data)).Decode(in); err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidHandshake, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,145 @@
+package network
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/blu-fi-tech-inc/blufi-network/types"
+)
+
+// InvType is the kind of object an inventory item refers to.
+type InvType uint8
+
+const (
+	InvTypeTx    InvType = 0x1
+	InvTypeBlock InvType = 0x2
+)
+
+const (
+	// MaxInvPerMessage is the number of items in a single Inv or GetData
+	// message.
+	MaxInvPerMessage = 1000
+
+	// Number of hashes remembered per peer as known to it.
+	maxKnownTxs    = 20000
+	maxKnownBlocks = 1000
+
+	// getDataTimeout is how long a peer has to deliver an object requested
+	// with GetData before it is requested from another peer announcing it.
+	getDataTimeout = 30 * time.Second
+)
+
+// ErrInvTooLarge is returned for Inv and GetData messages with more than
+// MaxInvPerMessage items.
+var ErrInvTooLarge = errors.New("too many inventory items")
+
+// InvItem refers to a transaction or block by its hash. Transactions are
+// identified by their TxHasher hash and blocks by their BlockHasher hash.
+type InvItem struct {
+	Type InvType
+	Hash types.Hash
+}
+
+// KnownSet is a set of hashes of bounded size. Once it is full, adding a hash
+// forgets the oldest one.
+type KnownSet struct {
+	lock   sync.Mutex
+	max    int
+	hashes map[types.Hash]struct{}
+	order  []types.Hash // ring buffer of the hashes in insertion order
+	next   int          // position of the oldest hash once order is full
+}
+
+// NewKnownSet creates a KnownSet holding up to max hashes.
+func NewKnownSet(max int) *KnownSet {
+	return &KnownSet{
+		max:    max,
+		hashes: make(map[types.Hash]struct{}),
+	}
+}
+
+// Add adds a hash to the set. It returns false if the hash was in the set
+// already.
+func (k *KnownSet) Add(hash types.Hash) bool {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+
+	if _, ok := k.hashes[hash]; ok {
+		return false
+	}
+
+	if len(k.order) < k.max {
+		k.order = append(k.order, hash)
+	} else {
+		delete(k.hashes, k.order[k.next])
+		k.order[k.next] = hash
+		k.next = (k.next + 1) % k.max
+	}
+	k.hashes[hash] = struct{}{}
+
+	return true
+}
+
+// Contains reports whether a hash is in the set.
+func (k *KnownSet) Contains(hash types.Hash) bool {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+
+	_, ok := k.hashes[hash]
+	return ok
+}
+
+// Len returns the number of hashes in the set.
+func (k *KnownSet) Len() int {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+
+	return len(k.hashes)
+}
+
+// dataRequests keeps track of the objects requested with GetData, so an
+// object announced by several peers is only fetched from one of them at a
+// time.
+type dataRequests struct {
+	lock      sync.Mutex
+	requested map[types.Hash]time.Time
+}
+
+func newDataRequests() *dataRequests {
+	return &dataRequests{
+		requested: make(map[types.Hash]time.Time),
+	}
+}
+
+// request records that an object is about to be requested. It returns false
+// if the object was requested already and the request has not timed out.
+func (r *dataRequests) request(hash types.Hash) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	now := time.Now()
+	if sent, ok := r.requested[hash]; ok && now.Sub(sent) < getDataTimeout {
+		return false
+	}
+
+	// Objects that never arrived are forgotten once the map grows large.
+	if len(r.requested) >= maxKnownTxs {
+		for h, sent := range r.requested {
+			if now.Sub(sent) >= getDataTimeout {
+				delete(r.requested, h)
+			}
+		}
+	}
+	r.requested[hash] = now
+
+	return true
+}
+
+// done records that an object arrived.
+func (r *dataRequests) done(hash types.Hash) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	delete(r.requested, hash)
+}
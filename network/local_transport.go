@@ -36,7 +36,7 @@ func (t *LocalTransport) Connect(tr Transport) error {
 	return nil
 }
 
-func (t *LocalTransport) SendMessage(to net.Addr, payload []byte) error {
+func (t *LocalTransport) SendMessage(to net.Addr, msg *Message) error {
 	t.lock.RLock()
 	defer t.lock.RUnlock()
 
@@ -50,7 +50,7 @@ func (t *LocalTransport) SendMessage(to net.Addr, payload []byte) error {
 	}
 
 	select {
-	case peer.consumeCh <- RPC{From: t.addr, Payload: bytes.NewReader(payload)}:
+	case peer.consumeCh <- RPC{From: t.addr, Type: msg.Header, Payload: bytes.NewReader(msg.Data)}:
 	default:
 		return fmt.Errorf("failed to send message to %s", to)
 	}
@@ -58,14 +58,14 @@ func (t *LocalTransport) SendMessage(to net.Addr, payload []byte) error {
 	return nil
 }
 
-func (t *LocalTransport) Broadcast(payload []byte) error {
+func (t *LocalTransport) Broadcast(msg *Message) error {
 	t.lock.RLock()
 	defer t.lock.RUnlock()
 
 	var errs []error
 
 	for _, peer := range t.peers {
-		if err := t.SendMessage(peer.Addr(), payload); err != nil {
+		if err := t.SendMessage(peer.Addr(), msg); err != nil {
 			errs = append(errs, err)
 		}
 	}
@@ -1,6 +1,10 @@
 package network
 
-import "github.com/blu-fi-tech-inc/blufi-network/core"
+import (
+	"github.com/blu-fi-tech-inc/blufi-network/consensus"
+	"github.com/blu-fi-tech-inc/blufi-network/core"
+	"github.com/blu-fi-tech-inc/blufi-network/types"
+)
 
 // GetBlocksMessage represents a request to get blocks from a specific index to a maximum index (if To is 0).
 type GetBlocksMessage struct {
@@ -13,9 +17,53 @@ type BlocksMessage struct {
 	Blocks []*core.Block // List of blocks to send in response.
 }
 
+// GetHeadersMessage represents a request to get the headers from a specific
+// index to a maximum index (if To is 0).
+type GetHeadersMessage struct {
+	From uint32 // Starting index of headers to fetch.
+	To   uint32 // Ending index (inclusive) of headers to fetch. If 0, fetch maximum available.
+}
+
+// HeadersMessage represents a response containing block headers.
+type HeadersMessage struct {
+	Headers []*core.Header // List of headers to send in response.
+}
+
+// InvMessage announces transactions and blocks the sender has.
+type InvMessage struct {
+	Items []InvItem // Announced objects, at most MaxInvPerMessage.
+}
+
+// GetDataMessage represents a request for announced transactions and blocks.
+// They are sent back in Tx and Block messages.
+type GetDataMessage struct {
+	Items []InvItem // Requested objects, at most MaxInvPerMessage.
+}
+
+// RejectMessage tells a peer why a transaction it sent was not accepted.
+type RejectMessage struct {
+	Hash   types.Hash   // Hash of the rejected transaction.
+	Reason RejectReason // Why the transaction was rejected.
+	Error  string       // Description of the problem.
+}
+
+// VoteMessage carries a finality vote of a validator. It is sent as a Prevote
+// or Precommit message, matching the type of the vote.
+type VoteMessage struct {
+	Vote *consensus.Vote // Signed vote.
+}
+
 // GetStatusMessage represents a request to get status information.
 type GetStatusMessage struct{}
 
+// GetPeersMessage represents a request for the addresses of other peers.
+type GetPeersMessage struct{}
+
+// PeersMessage represents a response containing peer addresses.
+type PeersMessage struct {
+	Addrs []string // Addresses other nodes accept connections on.
+}
+
 // StatusMessage represents a response containing status information.
 type StatusMessage struct {
 	ID            string // ID of the server.
@@ -5,33 +5,38 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"sync"
 )
 
 // Peer represents a network peer.
 type Peer struct {
 	conn     net.Conn
 	Outgoing bool
+	magic    uint32     // network magic of the frames exchanged with the peer
+	sendLock sync.Mutex // keeps concurrently sent frames from interleaving
 }
 
-// NewPeer creates a new Peer instance.
-func NewPeer(conn net.Conn, outgoing bool) *Peer {
+// NewPeer creates a new Peer instance for the network identified by magic.
+func NewPeer(conn net.Conn, magic uint32, outgoing bool) *Peer {
 	return &Peer{
 		conn:     conn,
 		Outgoing: outgoing,
+		magic:    magic,
 	}
 }
 
-// Send sends data to the peer.
-func (p *Peer) Send(data []byte) error {
-	_, err := p.conn.Write(data)
-	return err
+// Send sends a message to the peer as a single frame.
+func (p *Peer) Send(msg *Message) error {
+	p.sendLock.Lock()
+	defer p.sendLock.Unlock()
+
+	return WriteFrame(p.conn, p.magic, msg)
 }
 
-// ReadLoop continuously reads data from the peer connection.
+// ReadLoop continuously reads frames from the peer connection.
 func (p *Peer) ReadLoop(rpcCh chan<- RPC) {
-	buf := make([]byte, 4096)
 	for {
-		n, err := p.conn.Read(buf)
+		msg, err := ReadFrame(p.conn, p.magic)
 		if err == io.EOF {
 			fmt.Printf("Connection closed by peer: %s\n", p.conn.RemoteAddr().String())
 			break
@@ -41,11 +46,10 @@ func (p *Peer) ReadLoop(rpcCh chan<- RPC) {
 			break
 		}
 
-		msg := make([]byte, n)
-		copy(msg, buf[:n])
 		rpcCh <- RPC{
-			From:    p.conn.RemoteAddr().String(),
-			Payload: bytes.NewReader(msg),
+			From:    p.conn.RemoteAddr(),
+			Type:    msg.Header,
+			Payload: bytes.NewReader(msg.Data),
 		}
 	}
 	p.conn.Close()
@@ -0,0 +1,218 @@
+package network
+
+import (
+	"errors"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/blu-fi-tech-inc/blufi-network/consensus"
+	"github.com/blu-fi-tech-inc/blufi-network/core"
+)
+
+const (
+	// BanScore is the misbehaviour score at which a peer is banned.
+	BanScore = 100
+
+	// DefaultBanDuration is how long a misbehaving peer stays banned.
+	DefaultBanDuration = 24 * time.Hour
+
+	// Misbehaviour score added for each offence.
+	penaltyMalformed        = 20
+	penaltyInvalidSignature = 50
+	penaltyInvalidBlock     = 50
+	penaltyRateLimit        = 5
+
+	// scoreDecayPerMinute is how many misbehaviour points a peer sheds per
+	// minute, so that the occasional offences of an honest peer never add up
+	// to a ban.
+	scoreDecayPerMinute = 10
+
+	defaultPeerMessageRate = 100
+)
+
+var (
+	// ErrInvalidBlock is returned for blocks received from a peer that the
+	// chain rejects.
+	ErrInvalidBlock = errors.New("invalid block from peer")
+
+	// ErrRateLimited is the reason peers sending too many messages are
+	// penalized for.
+	ErrRateLimited = errors.New("peer exceeded its message rate")
+)
+
+// MisbehaviourPenalty returns the score penalty for a peer whose message could
+// not be processed because of err. Errors that honest peers run into, like
+// announcing a block we already have, are not penalized. Neither are blocks
+// rejected because of the state of this node, which the peer may not share.
+func MisbehaviourPenalty(err error) int {
+	var execErr *core.TxExecutionError
+	switch {
+	case errors.Is(err, core.ErrBlockKnown), errors.Is(err, core.ErrUnknownParent), errors.Is(err, core.ErrReorgTooDeep):
+		return 0
+	case errors.Is(err, core.ErrFinalizedConflict), errors.Is(err, core.ErrInvalidStateRoot), errors.Is(err, core.ErrInvalidNonce),
+		errors.Is(err, core.ErrNoStakeTable), errors.Is(err, core.ErrChainCorrupted), errors.As(err, &execErr):
+		return 0
+	case errors.Is(err, ErrInvTooLarge), errors.Is(err, ErrTxTooLarge):
+		return penaltyMalformed
+	case errors.Is(err, core.ErrInvalidSignature), errors.Is(err, consensus.ErrInvalidVote):
+		return penaltyInvalidSignature
+	case errors.Is(err, ErrInvalidBlock), errors.Is(err, ErrInvalidHeaders):
+		return penaltyInvalidBlock
+	}
+
+	return 0
+}
+
+// PeerScore is the misbehaviour score of a peer. It decays by
+// scoreDecayPerMinute points per minute. The zero value is a clean score.
+type PeerScore struct {
+	score float64
+	last  time.Time
+}
+
+// Add adds a penalty at the given time and returns the new score.
+func (s *PeerScore) Add(penalty int, now time.Time) int {
+	s.decay(now)
+	s.score += float64(penalty)
+
+	return int(s.score)
+}
+
+// Score returns the score at the given time.
+func (s *PeerScore) Score(now time.Time) int {
+	s.decay(now)
+
+	return int(s.score)
+}
+
+// decay lowers the score for the time passed since it last changed.
+func (s *PeerScore) decay(now time.Time) {
+	if now.Before(s.last) {
+		return
+	}
+	if !s.last.IsZero() {
+		s.score = max(0, s.score-now.Sub(s.last).Minutes()*scoreDecayPerMinute)
+	}
+	s.last = now
+}
+
+// BanInfo describes a banned host.
+type BanInfo struct {
+	Host   string
+	Until  time.Time
+	Reason string
+}
+
+// BanList keeps track of hosts that are not allowed to connect. Bans expire
+// on their own.
+type BanList struct {
+	lock sync.RWMutex
+	bans map[string]BanInfo
+}
+
+// NewBanList creates an empty BanList.
+func NewBanList() *BanList {
+	return &BanList{
+		bans: make(map[string]BanInfo),
+	}
+}
+
+// Ban bans a host for the given duration.
+func (l *BanList) Ban(host string, d time.Duration, reason string) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.bans[host] = BanInfo{
+		Host:   host,
+		Until:  time.Now().Add(d),
+		Reason: reason,
+	}
+}
+
+// Unban lifts the ban of a host. It returns false if the host was not banned.
+func (l *BanList) Unban(host string) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	_, ok := l.bans[host]
+	delete(l.bans, host)
+
+	return ok
+}
+
+// IsBanned reports whether a host is banned.
+func (l *BanList) IsBanned(host string) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	ban, ok := l.bans[host]
+	if ok && time.Now().After(ban.Until) {
+		delete(l.bans, host)
+		return false
+	}
+
+	return ok
+}
+
+// List returns the bans in effect, sorted by host.
+func (l *BanList) List() []BanInfo {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+
+	now := time.Now()
+	bans := []BanInfo{}
+	for _, ban := range l.bans {
+		if now.Before(ban.Until) {
+			bans = append(bans, ban)
+		}
+	}
+	sort.Slice(bans, func(i, j int) bool {
+		return bans[i].Host < bans[j].Host
+	})
+
+	return bans
+}
+
+// rateLimiter is a token bucket allowing rate events per second on average
+// and bursts of up to burst events.
+type rateLimiter struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rate float64, burst float64) *rateLimiter {
+	return &rateLimiter{
+		rate:   rate,
+		burst:  burst,
+		tokens: burst,
+		last:   time.Now(),
+	}
+}
+
+// allow reports whether an event may happen now and takes a token if so.
+func (r *rateLimiter) allow() bool {
+	now := time.Now()
+	r.tokens = min(r.burst, r.tokens+now.Sub(r.last).Seconds()*r.rate)
+	r.last = now
+
+	if r.tokens < 1 {
+		return false
+	}
+	r.tokens--
+
+	return true
+}
+
+// peerHost returns the host part of a peer address, which bans apply to.
+func peerHost(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+
+	return host
+}
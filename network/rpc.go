@@ -1,12 +1,12 @@
 package network
 
 import (
-	"bytes"
 	"encoding/gob"
 	"fmt"
 	"io"
 	"net"
 
+	"github.com/blu-fi-tech-inc/blufi-network/consensus"
 	"github.com/blu-fi-tech-inc/blufi-network/core"
 	"github.com/sirupsen/logrus"
 )
@@ -20,12 +20,30 @@ const (
 	MessageTypeStatus    MessageType = 0x4
 	MessageTypeGetStatus MessageType = 0x5
 	MessageTypeBlocks    MessageType = 0x6
+
+	// Handshake messages are only valid as the first messages on a
+	// connection, see Handshake.
+	MessageTypeHandshake     MessageType = 0x7
+	MessageTypeHandshakeAuth MessageType = 0x8
+
+	MessageTypeGetPeers   MessageType = 0x9
+	MessageTypePeers      MessageType = 0xa
+	MessageTypeGetHeaders MessageType = 0xb
+	MessageTypeHeaders    MessageType = 0xc
+	MessageTypeInv        MessageType = 0xd
+	MessageTypeGetData    MessageType = 0xe
+	MessageTypeReject     MessageType = 0xf
+
+	// Finality votes, see consensus.Finalizer.
+	MessageTypePrevote   MessageType = 0x10
+	MessageTypePrecommit MessageType = 0x11
 )
 
 // RPC represents a Remote Procedure Call.
 type RPC struct {
-	From    net.Addr // Address of the sender.
-	Payload io.Reader // Payload of the RPC.
+	From    net.Addr    // Address of the sender.
+	Type    MessageType // Type of message, from the frame header.
+	Payload io.Reader   // Payload of the RPC.
 }
 
 // Message represents a network message.
@@ -34,7 +52,8 @@ type Message struct {
 	Data   []byte      // Data payload.
 }
 
-// NewMessage creates a new Message instance.
+// NewMessage creates a new Message instance. It is sent to peers wrapped in a
+// frame, see WriteFrame.
 func NewMessage(t MessageType, data []byte) *Message {
 	return &Message{
 		Header: t,
@@ -42,16 +61,9 @@ func NewMessage(t MessageType, data []byte) *Message {
 	}
 }
 
-// Bytes serializes the Message into bytes.
-func (msg *Message) Bytes() []byte {
-	buf := &bytes.Buffer{}
-	gob.NewEncoder(buf).Encode(msg)
-	return buf.Bytes()
-}
-
 // DecodedMessage represents a decoded RPC message.
 type DecodedMessage struct {
-	From net.Addr // Address of the sender.
+	From net.Addr    // Address of the sender.
 	Data interface{} // Decoded data payload.
 }
 
@@ -60,20 +72,15 @@ type RPCDecodeFunc func(RPC) (*DecodedMessage, error)
 
 // DefaultRPCDecodeFunc decodes an RPC message based on its type.
 func DefaultRPCDecodeFunc(rpc RPC) (*DecodedMessage, error) {
-	msg := Message{}
-	if err := gob.NewDecoder(rpc.Payload).Decode(&msg); err != nil {
-		return nil, fmt.Errorf("failed to decode message from %s: %s", rpc.From, err)
-	}
-
 	logrus.WithFields(logrus.Fields{
 		"from": rpc.From,
-		"type": msg.Header,
+		"type": rpc.Type,
 	}).Debug("new incoming message")
 
-	switch msg.Header {
+	switch rpc.Type {
 	case MessageTypeTx:
 		tx := new(core.Transaction)
-		if err := tx.Decode(core.NewGobTxDecoder(bytes.NewReader(msg.Data))); err != nil {
+		if err := tx.Decode(gob.NewDecoder(rpc.Payload)); err != nil {
 			return nil, fmt.Errorf("failed to decode transaction message from %s: %s", rpc.From, err)
 		}
 
@@ -84,7 +91,7 @@ func DefaultRPCDecodeFunc(rpc RPC) (*DecodedMessage, error) {
 
 	case MessageTypeBlock:
 		block := new(core.Block)
-		if err := block.Decode(core.NewGobBlockDecoder(bytes.NewReader(msg.Data))); err != nil {
+		if err := block.Decode(gob.NewDecoder(rpc.Payload)); err != nil {
 			return nil, fmt.Errorf("failed to decode block message from %s: %s", rpc.From, err)
 		}
 
@@ -101,7 +108,7 @@ func DefaultRPCDecodeFunc(rpc RPC) (*DecodedMessage, error) {
 
 	case MessageTypeStatus:
 		statusMessage := new(StatusMessage)
-		if err := gob.NewDecoder(bytes.NewReader(msg.Data)).Decode(statusMessage); err != nil {
+		if err := gob.NewDecoder(rpc.Payload).Decode(statusMessage); err != nil {
 			return nil, fmt.Errorf("failed to decode status message from %s: %s", rpc.From, err)
 		}
 
@@ -112,7 +119,7 @@ func DefaultRPCDecodeFunc(rpc RPC) (*DecodedMessage, error) {
 
 	case MessageTypeGetBlocks:
 		getBlocks := new(GetBlocksMessage)
-		if err := gob.NewDecoder(bytes.NewReader(msg.Data)).Decode(getBlocks); err != nil {
+		if err := gob.NewDecoder(rpc.Payload).Decode(getBlocks); err != nil {
 			return nil, fmt.Errorf("failed to decode get blocks message from %s: %s", rpc.From, err)
 		}
 
@@ -123,7 +130,7 @@ func DefaultRPCDecodeFunc(rpc RPC) (*DecodedMessage, error) {
 
 	case MessageTypeBlocks:
 		blocks := new(BlocksMessage)
-		if err := gob.NewDecoder(bytes.NewReader(msg.Data)).Decode(blocks); err != nil {
+		if err := gob.NewDecoder(rpc.Payload).Decode(blocks); err != nil {
 			return nil, fmt.Errorf("failed to decode blocks message from %s: %s", rpc.From, err)
 		}
 
@@ -132,9 +139,103 @@ func DefaultRPCDecodeFunc(rpc RPC) (*DecodedMessage, error) {
 			Data: blocks,
 		}, nil
 
+	case MessageTypeGetHeaders:
+		getHeaders := new(GetHeadersMessage)
+		if err := gob.NewDecoder(rpc.Payload).Decode(getHeaders); err != nil {
+			return nil, fmt.Errorf("failed to decode get headers message from %s: %s", rpc.From, err)
+		}
+
+		return &DecodedMessage{
+			From: rpc.From,
+			Data: getHeaders,
+		}, nil
+
+	case MessageTypeHeaders:
+		headers := new(HeadersMessage)
+		if err := gob.NewDecoder(rpc.Payload).Decode(headers); err != nil {
+			return nil, fmt.Errorf("failed to decode headers message from %s: %s", rpc.From, err)
+		}
+
+		return &DecodedMessage{
+			From: rpc.From,
+			Data: headers,
+		}, nil
+
+	case MessageTypeInv:
+		inv := new(InvMessage)
+		if err := gob.NewDecoder(rpc.Payload).Decode(inv); err != nil {
+			return nil, fmt.Errorf("failed to decode inv message from %s: %s", rpc.From, err)
+		}
+
+		return &DecodedMessage{
+			From: rpc.From,
+			Data: inv,
+		}, nil
+
+	case MessageTypeGetData:
+		getData := new(GetDataMessage)
+		if err := gob.NewDecoder(rpc.Payload).Decode(getData); err != nil {
+			return nil, fmt.Errorf("failed to decode get data message from %s: %s", rpc.From, err)
+		}
+
+		return &DecodedMessage{
+			From: rpc.From,
+			Data: getData,
+		}, nil
+
+	case MessageTypeReject:
+		rejectMsg := new(RejectMessage)
+		if err := gob.NewDecoder(rpc.Payload).Decode(rejectMsg); err != nil {
+			return nil, fmt.Errorf("failed to decode reject message from %s: %s", rpc.From, err)
+		}
+
+		return &DecodedMessage{
+			From: rpc.From,
+			Data: rejectMsg,
+		}, nil
+
+	case MessageTypeGetPeers:
+		return &DecodedMessage{
+			From: rpc.From,
+			Data: &GetPeersMessage{},
+		}, nil
+
+	case MessageTypePeers:
+		peers := new(PeersMessage)
+		if err := gob.NewDecoder(rpc.Payload).Decode(peers); err != nil {
+			return nil, fmt.Errorf("failed to decode peers message from %s: %s", rpc.From, err)
+		}
+
+		return &DecodedMessage{
+			From: rpc.From,
+			Data: peers,
+		}, nil
+
+	case MessageTypePrevote, MessageTypePrecommit:
+		voteMsg := new(VoteMessage)
+		if err := gob.NewDecoder(rpc.Payload).Decode(voteMsg); err != nil {
+			return nil, fmt.Errorf("failed to decode vote message from %s: %s", rpc.From, err)
+		}
+		if voteMsg.Vote == nil || voteMessageType(voteMsg.Vote.Type) != rpc.Type {
+			return nil, fmt.Errorf("vote message from %s does not match its header %x", rpc.From, rpc.Type)
+		}
+
+		return &DecodedMessage{
+			From: rpc.From,
+			Data: voteMsg,
+		}, nil
+
 	default:
-		return nil, fmt.Errorf("invalid message header %x from %s", msg.Header, rpc.From)
+		return nil, fmt.Errorf("invalid message header %x from %s", rpc.Type, rpc.From)
+	}
+}
+
+// voteMessageType returns the type of the message votes of type t are sent in.
+func voteMessageType(t consensus.VoteType) MessageType {
+	if t == consensus.VotePrecommit {
+		return MessageTypePrecommit
 	}
+	return MessageTypePrevote
 }
 
 // RPCProcessor defines an interface for processing decoded RPC messages.
@@ -149,4 +250,12 @@ func init() {
 	gob.Register(&StatusMessage{})
 	gob.Register(&GetBlocksMessage{})
 	gob.Register(&BlocksMessage{})
+	gob.Register(&GetPeersMessage{})
+	gob.Register(&PeersMessage{})
+	gob.Register(&GetHeadersMessage{})
+	gob.Register(&HeadersMessage{})
+	gob.Register(&InvMessage{})
+	gob.Register(&GetDataMessage{})
+	gob.Register(&RejectMessage{})
+	gob.Register(&VoteMessage{})
 }
@@ -0,0 +1,219 @@
+package network
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+)
+
+// Encrypted connections carry records of
+//
+//	length     uint32  length of the sealed data
+//	sealed     [length]byte
+//
+// sealed with AES-256-GCM under a key per direction. The nonce is the number
+// of records sent under the current key and the length prefix is authenticated
+// as additional data. Both sides replace the key of a direction with
+// rekey(key) after RekeyInterval records, so no key seals more than
+// RekeyInterval records.
+const (
+	// RekeyInterval is the number of records sealed with a key before it is
+	// replaced.
+	RekeyInterval = 1 << 14
+
+	secureKeySize = 32
+
+	// maxRecordSize fits the largest frame and its authentication tag.
+	maxRecordSize = frameHeaderSize + MaxFrameSize + 16
+)
+
+var (
+	ErrDecrypt         = errors.New("failed to decrypt record")
+	ErrRecordTooLarge  = errors.New("record too large")
+	ErrEncryptMismatch = errors.New("peer does not agree on transport encryption")
+)
+
+// secureConn encrypts everything written to the wrapped connection and
+// decrypts everything read from it. Every Write is sent as a single record.
+type secureConn struct {
+	net.Conn
+
+	writeLock sync.Mutex
+	send      *recordCipher
+
+	readLock sync.Mutex
+	recv     *recordCipher
+	pending  []byte // decrypted data not returned by Read yet
+}
+
+// NewSecureConn wraps conn in an encrypted channel. sendKey seals the records
+// written to conn and recvKey opens the records read from it; the other side
+// of the connection must use them the other way around.
+func NewSecureConn(conn net.Conn, sendKey, recvKey []byte) (net.Conn, error) {
+	send, err := newRecordCipher(sendKey)
+	if err != nil {
+		return nil, err
+	}
+	recv, err := newRecordCipher(recvKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &secureConn{
+		Conn: conn,
+		send: send,
+		recv: recv,
+	}, nil
+}
+
+// Write seals p into a single record.
+func (c *secureConn) Write(p []byte) (int, error) {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+
+	if len(p)+c.send.aead.Overhead() > maxRecordSize {
+		return 0, fmt.Errorf("%w: (%d) bytes", ErrRecordTooLarge, len(p))
+	}
+
+	record := make([]byte, 4, 4+len(p)+c.send.aead.Overhead())
+	binary.BigEndian.PutUint32(record, uint32(len(p)+c.send.aead.Overhead()))
+	record, err := c.send.seal(record, p)
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err := c.Conn.Write(record); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// Read returns decrypted data, reading the next record when all data of the
+// previous one has been returned.
+func (c *secureConn) Read(p []byte) (int, error) {
+	c.readLock.Lock()
+	defer c.readLock.Unlock()
+
+	for len(c.pending) == 0 {
+		header := make([]byte, 4)
+		if _, err := io.ReadFull(c.Conn, header); err != nil {
+			return 0, err
+		}
+
+		length := binary.BigEndian.Uint32(header)
+		if length > maxRecordSize {
+			return 0, fmt.Errorf("%w: (%d) bytes", ErrRecordTooLarge, length)
+		}
+
+		sealed := make([]byte, length)
+		if _, err := io.ReadFull(c.Conn, sealed); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+
+		data, err := c.recv.open(header, sealed)
+		if err != nil {
+			return 0, err
+		}
+		c.pending = data
+	}
+
+	n := copy(p, c.pending)
+	c.pending = c.pending[n:]
+
+	return n, nil
+}
+
+// recordCipher seals or opens the records of one direction of a connection.
+type recordCipher struct {
+	key   []byte
+	aead  cipher.AEAD
+	count uint64 // records processed with the current key
+}
+
+func newRecordCipher(key []byte) (*recordCipher, error) {
+	if len(key) != secureKeySize {
+		return nil, fmt.Errorf("invalid key length (%d)", len(key))
+	}
+
+	c := &recordCipher{}
+	if err := c.setKey(key); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func (c *recordCipher) setKey(key []byte) error {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+
+	c.key = key
+	c.aead = aead
+	c.count = 0
+
+	return nil
+}
+
+// seal appends the sealed data to header, which is authenticated with it.
+func (c *recordCipher) seal(header, data []byte) ([]byte, error) {
+	record := c.aead.Seal(header, c.nonce(), data, header)
+	if err := c.advance(); err != nil {
+		return nil, err
+	}
+
+	return record, nil
+}
+
+func (c *recordCipher) open(header, sealed []byte) ([]byte, error) {
+	data, err := c.aead.Open(nil, c.nonce(), sealed, header)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	if err := c.advance(); err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+func (c *recordCipher) nonce() []byte {
+	nonce := make([]byte, c.aead.NonceSize())
+	binary.BigEndian.PutUint64(nonce[len(nonce)-8:], c.count)
+	return nonce
+}
+
+// advance counts a processed record and rekeys after RekeyInterval records.
+func (c *recordCipher) advance() error {
+	c.count++
+	if c.count < RekeyInterval {
+		return nil
+	}
+
+	return c.setKey(deriveKey(c.key, []byte("blufi rekey")))
+}
+
+// deriveKey derives a key from secret for the given purpose.
+func deriveKey(secret []byte, info ...[]byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	for _, b := range info {
+		mac.Write(b)
+	}
+	return mac.Sum(nil)
+}
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"net"
 	"os"
+	"path/filepath"
 	"sync"
 	"time"
 
@@ -20,6 +21,31 @@ import (
 
 var defaultBlockTime = 5 * time.Second
 
+const (
+	defaultMaxInboundPeers = 32
+	defaultOutboundPeers   = 8
+
+	// peerMaintenanceInterval is how often the server dials new peers to
+	// reach its outbound target and persists the address book.
+	peerMaintenanceInterval = 10 * time.Second
+
+	// maxPeersPerMessage is the number of addresses shared in a Peers message.
+	maxPeersPerMessage = 100
+
+	// syncTickInterval is how often timed out sync requests are retried and
+	// statusInterval how often peers are asked for their chain height.
+	syncTickInterval = 3 * time.Second
+	statusInterval   = 30 * time.Second
+
+	// finalityTickInterval is how often finality rounds are checked for
+	// timeouts.
+	finalityTickInterval = time.Second
+
+	// maxTxsPerBlock is the number of transactions taken from the mempool for
+	// a new block.
+	maxTxsPerBlock = 1000
+)
+
 // ServerOpts defines options for configuring the Server instance.
 type ServerOpts struct {
 	APIListenAddr  string
@@ -32,9 +58,25 @@ type ServerOpts struct {
 	RPCProcessor   RPCProcessor
 	BlockTime      time.Duration
 	PrivateKey     *crypto.PrivateKey
-	StakeManager   *consensus.StakeManager
-	PoS            *consensus.PoS
 	BlockchainName string // Added field for blockchain name
+	DataDir        string // Directory of the on-disk block store, in-memory if empty
+	NetworkMagic   uint32 // Identifies the network in every frame, DefaultNetworkMagic if zero
+	// NodeKey identifies the node to its peers. It defaults to PrivateKey and
+	// to a random key for nodes that are not validators.
+	NodeKey *crypto.PrivateKey
+	// EncryptTransport encrypts the connections to peers. Peers only connect
+	// if they agree on it.
+	EncryptTransport bool
+	MaxInboundPeers  int           // Inbound connections accepted, 32 if zero
+	OutboundPeers    int           // Outbound connections kept open, 8 if zero
+	BanDuration      time.Duration // How long misbehaving peers are banned, DefaultBanDuration if zero
+	PeerMessageRate  float64       // Messages per second accepted from a peer, 100 if zero
+	// GenesisStakes is the stake bonded by each validator in the genesis
+	// state. It must be the same on every node of the network.
+	GenesisStakes map[types.Address]uint64
+	// APIAdminListenAddr is the loopback address the admin routes of the API
+	// are served on, see api.ServerConfig. They are not served if empty.
+	APIAdminListenAddr string
 }
 
 // Server represents the main server instance.
@@ -49,8 +91,16 @@ type Server struct {
 	isValidator bool
 	rpcCh       chan RPC
 	quitCh      chan struct{}
-	txChan      chan *core.Transaction
-	pos         *consensus.PoS
+	engine      core.Engine
+	finalizer   *consensus.Finalizer
+	doubleSigns *EquivocationDetector // validators signing two blocks at a height
+	signGuard   *SignGuard            // last block this validator signed
+	handshake   *Handshake
+	addrBook    *AddressBook
+	dialing     map[string]bool // addresses with an outbound connection in progress
+	bans        *BanList
+	syncer      *Syncer
+	requests    *dataRequests // transactions and blocks requested from peers
 }
 
 // NewServer creates a new Server instance with the provided options.
@@ -61,35 +111,89 @@ func NewServer(opts ServerOpts) (*Server, error) {
 	if opts.RPCDecodeFunc == nil {
 		opts.RPCDecodeFunc = DefaultRPCDecodeFunc
 	}
+	if opts.NetworkMagic == 0 {
+		opts.NetworkMagic = DefaultNetworkMagic
+	}
+	if opts.MaxInboundPeers == 0 {
+		opts.MaxInboundPeers = defaultMaxInboundPeers
+	}
+	if opts.OutboundPeers == 0 {
+		opts.OutboundPeers = defaultOutboundPeers
+	}
+	if opts.BanDuration == 0 {
+		opts.BanDuration = DefaultBanDuration
+	}
+	if opts.PeerMessageRate == 0 {
+		opts.PeerMessageRate = defaultPeerMessageRate
+	}
+	if opts.NodeKey == nil {
+		opts.NodeKey = opts.PrivateKey
+	}
+	if opts.NodeKey == nil {
+		nodeKey, _, err := crypto.GenerateKeyPair()
+		if err != nil {
+			return nil, err
+		}
+		opts.NodeKey = nodeKey
+	}
 	if opts.Logger == nil {
 		opts.Logger = log.NewLogfmtLogger(os.Stderr)
 		opts.Logger = log.With(opts.Logger, "addr", opts.ID)
 	}
 
-	chain, err := core.NewBlockchain(opts.Logger, genesisBlock(), opts.BlockchainName)
+	store, err := newChainStore(opts.DataDir)
+	if err != nil {
+		return nil, err
+	}
+
+	accounts := core.NewAccountState()
+	for address, stake := range opts.GenesisStakes {
+		if err := accounts.AddGenesisStake(address, stake); err != nil {
+			return nil, err
+		}
+	}
+
+	chain, err := core.NewBlockchain(store, opts.Logger, accounts, genesisBlock())
 	if err != nil {
 		return nil, err
 	}
 
+	// Blocks are proposed in turn by the validators bonded on chain, one slot
+	// of BlockTime each.
+	stakeManager := consensus.NewStakeManager(chain)
+	engine := consensus.NewPoS(stakeManager, opts.BlockTime)
+	chain.SetEngine(engine)
+
+	// The same validators finalize the blocks in prevote and precommit rounds.
+	// Nodes that are not validators follow their votes.
+	finalizer := consensus.NewFinalizer(chain, stakeManager, opts.PrivateKey, 0)
+
 	opts.Logger.Log("msg", "Initializing blockchain", "name", opts.BlockchainName)
 
-	// Channel used to communicate between the JSON RPC server and the node.
-	txChan := make(chan *core.Transaction)
+	genesis, err := chain.GetHeader(0)
+	if err != nil {
+		return nil, err
+	}
 
-	// Start the JSON RPC API server if a valid address is provided.
-	if len(opts.APIListenAddr) > 0 {
-		apiServerCfg := api.ServerConfig{
-			Logger:     opts.Logger,
-			ListenAddr: opts.APIListenAddr,
-		}
-		apiServer := api.NewServer(apiServerCfg, chain, txChan)
-		go apiServer.Start()
+	addrBookPath := ""
+	if len(opts.DataDir) > 0 {
+		addrBookPath = filepath.Join(opts.DataDir, "peers.json")
+	}
+	addrBook, err := NewAddressBook(addrBookPath)
+	if err != nil {
+		return nil, err
+	}
 
-		opts.Logger.Log("msg", "JSON API server running", "port", opts.APIListenAddr)
+	signGuard, err := NewSignGuard(store)
+	if err != nil {
+		return nil, err
 	}
 
+	mempool := NewTxPool(1000)
+	mempool.SetStateSource(chain)
+
 	peerCh := make(chan *TCPPeer)
-	tr := NewTCPTransport(opts.ListenAddr, peerCh)
+	tr := NewTCPTransport(opts.ListenAddr, opts.NetworkMagic, peerCh)
 
 	s := &Server{
 		TCPTransport: tr,
@@ -97,21 +201,69 @@ func NewServer(opts ServerOpts) (*Server, error) {
 		peerMap:      make(map[net.Addr]*TCPPeer),
 		ServerOpts:   opts,
 		chain:        chain,
-		mempool:      NewTxPool(1000),
+		mempool:      mempool,
 		isValidator:  opts.PrivateKey != nil,
 		rpcCh:        make(chan RPC),
 		quitCh:       make(chan struct{}, 1),
-		txChan:       txChan,
-		pos:          opts.PoS,
+		engine:       engine,
+		finalizer:    finalizer,
+		doubleSigns:  NewEquivocationDetector(),
+		signGuard:    signGuard,
+		handshake: &Handshake{
+			BlockchainName: opts.BlockchainName,
+			GenesisHash:    core.BlockHasher{}.Hash(genesis),
+			NodeKey:        opts.NodeKey,
+			Encrypt:        opts.EncryptTransport,
+			ListenAddr:     opts.ListenAddr,
+		},
+		addrBook: addrBook,
+		dialing:  make(map[string]bool),
+		bans:     NewBanList(),
+		syncer:   NewSyncer(chain, opts.Logger),
+		requests: newDataRequests(),
 	}
 
 	s.TCPTransport.peerCh = peerCh
 
+	// The mempool follows the canonical chain: mined transactions leave it and
+	// transactions dropped from the chain by a reorg go back to it.
+	chain.Subscribe(func(ev core.ChainEvent) {
+		for _, tx := range s.mempool.Reset(ev) {
+			s.Logger.Log("msg", "dropping tx from mempool", "hash", tx.Hash(core.TxHasher{}))
+		}
+	})
+
+	// New blocks can be voted on. The finalizer runs apart from the event,
+	// since finalizing a block can reorganize the chain itself.
+	chain.Subscribe(func(ev core.ChainEvent) {
+		go s.tickFinalizer()
+	})
+
 	// Use the server instance as the default RPC processor if not provided.
 	if s.RPCProcessor == nil {
 		s.RPCProcessor = s
 	}
 
+	// Start the JSON RPC API server if a valid address is provided.
+	if len(opts.APIListenAddr) > 0 {
+		apiServerCfg := api.ServerConfig{
+			Logger:          opts.Logger,
+			ListenAddr:      opts.APIListenAddr,
+			AdminListenAddr: opts.APIAdminListenAddr,
+		}
+		apiServer := api.NewServer(apiServerCfg, chain, stakeManager, mempool)
+		apiServer.SetPeerAdmin(s)
+		apiServer.SetSyncProgress(s)
+		apiServer.SetTxSubmitter(s)
+		go func() {
+			if err := apiServer.Start(); err != nil {
+				opts.Logger.Log("msg", "JSON API server stopped", "err", err)
+			}
+		}()
+
+		opts.Logger.Log("msg", "JSON API server running", "port", opts.APIListenAddr)
+	}
+
 	// Start validator loop if the server has a private key.
 	if s.isValidator {
 		go s.validatorLoop()
@@ -128,34 +280,27 @@ func (s *Server) Start() {
 
 	s.bootstrapNetwork()
 
+	go s.peerLoop()
+	go s.syncLoop()
+	go s.finalityLoop()
+
 	s.Logger.Log("msg", "accepting TCP connection on", "addr", s.ListenAddr, "id", s.ID)
 
 free:
 	for {
 		select {
 		case peer := <-s.peerCh:
-			s.mu.Lock()
-			s.peerMap[peer.conn.RemoteAddr()] = peer
-			s.mu.Unlock()
-
-			go peer.readLoop(s.rpcCh)
+			go s.addPeer(peer)
 
-			if err := s.sendGetStatusMessage(peer); err != nil {
-				s.Logger.Log("err", err)
+		case rpc := <-s.rpcCh:
+			if !s.allowMessage(rpc.From) {
 				continue
 			}
 
-			s.Logger.Log("msg", "peer added to the server", "outgoing", peer.Outgoing, "addr", peer.conn.RemoteAddr())
-
-		case tx := <-s.txChan:
-			if err := s.processTransaction(tx); err != nil {
-				s.Logger.Log("process TX error", err)
-			}
-
-		case rpc := <-s.rpcCh:
 			msg, err := s.RPCDecodeFunc(rpc)
 			if err != nil {
 				s.Logger.Log("RPC error", err)
+				s.misbehave(rpc.From, penaltyMalformed, err)
 				continue
 			}
 
@@ -163,6 +308,10 @@ free:
 				if err != core.ErrBlockKnown {
 					s.Logger.Log("error", err)
 				}
+				s.exitOnCorruption(err)
+				if penalty := MisbehaviourPenalty(err); penalty > 0 {
+					s.misbehave(msg.From, penalty, err)
+				}
 			}
 
 		case <-s.quitCh:
@@ -173,6 +322,254 @@ free:
 	s.Logger.Log("msg", "Server is shutting down")
 }
 
+// bootstrapNetwork adds the seed nodes to the address book and connects to
+// the network.
+func (s *Server) bootstrapNetwork() {
+	for _, addr := range s.SeedNodes {
+		s.addrBook.AddSeed(addr)
+	}
+
+	s.dialPeers()
+}
+
+// peerLoop keeps the number of outbound peers at the target, reconnecting
+// when peers drop out, and persists the address book.
+func (s *Server) peerLoop() {
+	ticker := time.NewTicker(peerMaintenanceInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.dialPeers()
+
+			if err := s.addrBook.Save(); err != nil {
+				s.Logger.Log("msg", "failed to save address book", "err", err)
+			}
+
+		case <-s.quitCh:
+			return
+		}
+	}
+}
+
+// dialPeers dials addresses from the address book until the outbound target
+// is reached.
+func (s *Server) dialPeers() {
+	s.mu.Lock()
+	outbound := len(s.dialing)
+	for _, peer := range s.peerMap {
+		if peer.Outgoing {
+			outbound++
+		}
+	}
+
+	candidates := s.addrBook.DialCandidates(s.OutboundPeers-outbound, func(addr string) bool {
+		return addr == s.ListenAddr || s.dialing[addr] || s.connectedTo(addr) || s.bans.IsBanned(peerHost(addr))
+	})
+	for _, addr := range candidates {
+		s.dialing[addr] = true
+	}
+	s.mu.Unlock()
+
+	for _, addr := range candidates {
+		s.addrBook.Attempt(addr)
+
+		go func(addr string) {
+			if err := s.TCPTransport.Dial(addr); err != nil {
+				s.Logger.Log("msg", "failed to dial peer", "addr", addr, "err", err)
+				s.finishDial(addr, false)
+			}
+		}(addr)
+	}
+}
+
+// finishDial records the outcome of dialing an address.
+func (s *Server) finishDial(addr string, ok bool) {
+	s.mu.Lock()
+	delete(s.dialing, addr)
+	s.mu.Unlock()
+
+	if ok {
+		s.addrBook.Good(addr)
+	} else {
+		s.addrBook.Failed(addr)
+	}
+}
+
+// connectedTo reports whether a peer with the given listen address is
+// connected. It must be called with the lock held.
+func (s *Server) connectedTo(listenAddr string) bool {
+	for _, peer := range s.peerMap {
+		if peer.ListenAddr == listenAddr {
+			return true
+		}
+	}
+
+	return false
+}
+
+// addPeer performs the handshake with a new peer and starts exchanging
+// messages with it. Peers that fail the handshake are disconnected before
+// they are known to the server.
+func (s *Server) addPeer(peer *TCPPeer) {
+	addr := peer.conn.RemoteAddr()
+
+	if s.bans.IsBanned(peerHost(addr.String())) {
+		s.Logger.Log("msg", "rejecting banned peer", "addr", addr)
+		peer.conn.Close()
+		return
+	}
+	if !peer.Outgoing && s.inboundCount() >= s.MaxInboundPeers {
+		s.Logger.Log("msg", "rejecting inbound peer, too many connections", "addr", addr)
+		peer.conn.Close()
+		return
+	}
+
+	err := peer.handshake(s.handshake)
+	if peer.Outgoing {
+		s.finishDial(peer.ListenAddr, err == nil)
+	}
+	if err != nil {
+		s.Logger.Log("msg", "peer handshake failed", "addr", addr, "err", err)
+		peer.conn.Close()
+		return
+	}
+
+	peer.limiter = newRateLimiter(s.PeerMessageRate, s.PeerMessageRate)
+
+	s.mu.Lock()
+	for _, other := range s.peerMap {
+		if bytes.Equal(other.PublicKey.Bytes(), peer.PublicKey.Bytes()) {
+			s.mu.Unlock()
+			s.Logger.Log("msg", "already connected to peer", "addr", addr)
+			peer.conn.Close()
+			return
+		}
+	}
+	s.peerMap[addr] = peer
+	s.mu.Unlock()
+
+	if !peer.Outgoing {
+		s.addrBook.Add(peer.ListenAddr)
+	}
+
+	go func() {
+		peer.readLoop(s.rpcCh)
+		s.removePeer(peer)
+	}()
+
+	if err := s.sendGetStatusMessage(peer); err != nil {
+		s.Logger.Log("err", err)
+		return
+	}
+	if err := s.sendGetPeersMessage(peer); err != nil {
+		s.Logger.Log("err", err)
+		return
+	}
+
+	s.Logger.Log("msg", "peer added to the server", "outgoing", peer.Outgoing, "addr", addr)
+}
+
+// removePeer forgets a disconnected peer. Lost outbound connections are
+// replaced right away.
+func (s *Server) removePeer(peer *TCPPeer) {
+	s.mu.Lock()
+	delete(s.peerMap, peer.conn.RemoteAddr())
+	s.mu.Unlock()
+
+	s.Logger.Log("msg", "peer removed from the server", "outgoing", peer.Outgoing, "addr", peer.conn.RemoteAddr())
+
+	s.sendSyncRequests(s.syncer.RemovePeer(peer.conn.RemoteAddr()))
+
+	if peer.Outgoing {
+		s.dialPeers()
+	}
+}
+
+// allowMessage reports whether a message from a peer is within the peer's
+// message rate. Messages above the rate are dropped and count as misbehaviour.
+func (s *Server) allowMessage(from net.Addr) bool {
+	s.mu.RLock()
+	peer, ok := s.peerMap[from]
+	s.mu.RUnlock()
+
+	if !ok || peer.limiter.allow() {
+		return true
+	}
+
+	s.misbehave(from, penaltyRateLimit, ErrRateLimited)
+
+	return false
+}
+
+// misbehave adds a penalty to the misbehaviour score of a peer. Peers reaching
+// BanScore are banned and all connections from their host are closed.
+func (s *Server) misbehave(from net.Addr, penalty int, reason error) {
+	s.mu.RLock()
+	peer, ok := s.peerMap[from]
+	s.mu.RUnlock()
+
+	if !ok {
+		return
+	}
+
+	score := peer.score.Add(penalty, time.Now())
+	s.Logger.Log("msg", "peer misbehaved", "addr", from, "penalty", penalty, "score", score, "reason", reason)
+
+	if score < BanScore {
+		return
+	}
+
+	host := peerHost(from.String())
+	s.bans.Ban(host, s.BanDuration, reason.Error())
+	s.Logger.Log("msg", "banned peer", "host", host, "duration", s.BanDuration, "reason", reason)
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for addr, peer := range s.peerMap {
+		if peerHost(addr.String()) == host {
+			peer.conn.Close()
+		}
+	}
+}
+
+// BannedPeers returns the hosts banned for misbehaving. It implements
+// api.PeerAdmin.
+func (s *Server) BannedPeers() []api.BannedPeer {
+	peers := []api.BannedPeer{}
+	for _, ban := range s.bans.List() {
+		peers = append(peers, api.BannedPeer{
+			Host:   ban.Host,
+			Until:  ban.Until,
+			Reason: ban.Reason,
+		})
+	}
+
+	return peers
+}
+
+// UnbanPeer lifts the ban of a host. It implements api.PeerAdmin.
+func (s *Server) UnbanPeer(host string) bool {
+	return s.bans.Unban(host)
+}
+
+// inboundCount returns the number of connected inbound peers.
+func (s *Server) inboundCount() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	n := 0
+	for _, peer := range s.peerMap {
+		if !peer.Outgoing {
+			n++
+		}
+	}
+
+	return n
+}
+
 // validatorLoop runs the validator's block creation at regular intervals.
 func (s *Server) validatorLoop() {
 	ticker := time.NewTicker(s.BlockTime)
@@ -182,6 +579,7 @@ func (s *Server) validatorLoop() {
 	for {
 		if err := s.createNewBlock(); err != nil {
 			s.Logger.Log("create block error", err)
+			s.exitOnCorruption(err)
 		}
 
 		<-ticker.C
@@ -192,9 +590,15 @@ func (s *Server) validatorLoop() {
 func (s *Server) ProcessMessage(msg *DecodedMessage) error {
 	switch t := msg.Data.(type) {
 	case *core.Transaction:
-		return s.processTransaction(t)
+		return s.processTransaction(msg.From, t)
 	case *core.Block:
-		return s.processBlock(t)
+		return s.processBlock(msg.From, t)
+	case *InvMessage:
+		return s.processInvMessage(msg.From, t)
+	case *GetDataMessage:
+		return s.processGetDataMessage(msg.From, t)
+	case *RejectMessage:
+		return s.processRejectMessage(msg.From, t)
 	case *GetStatusMessage:
 		return s.processGetStatusMessage(msg.From, t)
 	case *StatusMessage:
@@ -203,50 +607,114 @@ func (s *Server) ProcessMessage(msg *DecodedMessage) error {
 		return s.processGetBlocksMessage(msg.From, t)
 	case *BlocksMessage:
 		return s.processBlocksMessage(msg.From, t)
+	case *GetHeadersMessage:
+		return s.processGetHeadersMessage(msg.From, t)
+	case *HeadersMessage:
+		return s.processHeadersMessage(msg.From, t)
+	case *GetPeersMessage:
+		return s.processGetPeersMessage(msg.From, t)
+	case *PeersMessage:
+		return s.processPeersMessage(msg.From, t)
+	case *VoteMessage:
+		return s.processVoteMessage(msg.From, t)
 	}
 
 	return nil
 }
 
-// processGetBlocksMessage handles the reception of GetBlocks messages from peers.
-func (s *Server) processGetBlocksMessage(from net.Addr, data *GetBlocksMessage) error {
-	s.Logger.Log("msg", "received getBlocks message", "from", from)
+// processTransaction verifies a transaction, adds it to the mempool and
+// announces it to peers. from is nil for transactions submitted locally.
+func (s *Server) processTransaction(from net.Addr, tx *core.Transaction) error {
+	hash := tx.Hash(core.TxHasher{})
 
-	var (
-		blocks    = []*core.Block{}
-		ourHeight = s.chain.Height()
-	)
+	if peer, ok := s.getPeer(from); ok {
+		peer.markKnown(InvItem{Type: InvTypeTx, Hash: hash})
+	}
+	s.requests.done(hash)
 
-	if data.To == 0 {
-		for i := int(data.From); i <= int(ourHeight); i++ {
-			block, err := s.chain.GetBlock(uint32(i))
-			if err != nil {
-				return err
-			}
+	if s.mempool.Contains(hash) {
+		return nil
+	}
 
-			blocks = append(blocks, block)
+	if err := s.mempool.Add(tx); err != nil {
+		var rejected *TxRejectedError
+		if from != nil && errors.As(err, &rejected) {
+			s.sendReject(from, rejected)
 		}
+		return err
 	}
 
-	blocksMsg := &BlocksMessage{
-		Blocks: blocks,
+	s.Logger.Log(
+		"msg", "adding new tx to mempool",
+		"hash", hash,
+		"nonce", tx.Nonce,
+		"mempoolLength", s.mempool.Len(),
+	)
+
+	go s.broadcastTx(tx)
+
+	return nil
+}
+
+// SubmitTx adds a transaction submitted to the node to the mempool and
+// announces it to peers. It implements api.TxSubmitter.
+func (s *Server) SubmitTx(tx *core.Transaction) error {
+	return s.processTransaction(nil, tx)
+}
+
+// sendReject tells a peer why the transaction it sent was not accepted.
+func (s *Server) sendReject(to net.Addr, rejected *TxRejectedError) {
+	msg := &RejectMessage{
+		Hash:   rejected.Hash,
+		Reason: rejected.Reason,
+		Error:  rejected.Err.Error(),
 	}
+	if err := s.sendMessage(to, MessageTypeReject, msg); err != nil {
+		s.Logger.Log("msg", "failed to send reject", "addr", to, "err", err)
+	}
+}
 
-	buf := new(bytes.Buffer)
-	if err := gob.NewEncoder(buf).Encode(blocksMsg); err != nil {
+// processRejectMessage handles a peer rejecting a transaction the node sent.
+func (s *Server) processRejectMessage(from net.Addr, data *RejectMessage) error {
+	s.Logger.Log("msg", "peer rejected tx", "from", from, "hash", data.Hash, "reason", data.Reason, "err", data.Error)
+
+	return nil
+}
+
+// processGetBlocksMessage handles the reception of GetBlocks messages from
+// peers. At most MaxBlocksPerMessage blocks are sent back.
+func (s *Server) processGetBlocksMessage(from net.Addr, data *GetBlocksMessage) error {
+	s.Logger.Log("msg", "received getBlocks message", "from", from, "blocks", fmt.Sprintf("%d..%d", data.From, data.To))
+
+	blocks, err := BlocksInRange(s.chain, data.From, data.To)
+	if err != nil {
 		return err
 	}
 
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+	return s.sendMessage(from, MessageTypeBlocks, &BlocksMessage{Blocks: blocks})
+}
 
-	msg := NewMessage(MessageTypeBlocks, buf.Bytes())
-	peer, ok := s.peerMap[from]
-	if !ok {
-		return fmt.Errorf("peer %s not known", from)
+// processGetHeadersMessage handles the reception of GetHeaders messages from
+// peers. At most MaxHeadersPerMessage headers are sent back.
+func (s *Server) processGetHeadersMessage(from net.Addr, data *GetHeadersMessage) error {
+	s.Logger.Log("msg", "received getHeaders message", "from", from, "headers", fmt.Sprintf("%d..%d", data.From, data.To))
+
+	headers, err := HeadersInRange(s.chain, data.From, data.To)
+	if err != nil {
+		return err
 	}
 
-	return peer.Send(msg.Bytes())
+	return s.sendMessage(from, MessageTypeHeaders, &HeadersMessage{Headers: headers})
+}
+
+// processHeadersMessage hands the headers received from a peer to the syncer.
+func (s *Server) processHeadersMessage(from net.Addr, data *HeadersMessage) error {
+	s.Logger.Log("msg", "received HEADERS message", "from", from, "headers", len(data.Headers))
+
+	reqs, err := s.syncer.HandleHeaders(from, data)
+	s.sendSyncRequests(reqs)
+
+	return err
 }
 
 // sendGetStatusMessage sends a GetStatus message to a peer.
@@ -259,47 +727,135 @@ func (s *Server) sendGetStatusMessage(peer *TCPPeer) error {
 	}
 
 	msg := NewMessage(MessageTypeGetStatus, buf.Bytes())
-	return peer.Send(msg.Bytes())
+	return peer.Send(msg)
 }
 
-// broadcast broadcasts a message to all connected peers.
-func (s *Server) broadcast(payload []byte) error {
+// sendGetPeersMessage asks a peer for the addresses it knows.
+func (s *Server) sendGetPeersMessage(peer *TCPPeer) error {
+	buf := new(bytes.Buffer)
+	if err := gob.NewEncoder(buf).Encode(new(GetPeersMessage)); err != nil {
+		return err
+	}
+
+	msg := NewMessage(MessageTypeGetPeers, buf.Bytes())
+	return peer.Send(msg)
+}
+
+// processGetPeersMessage answers a GetPeers message with the addresses of the
+// peers this node could connect to.
+func (s *Server) processGetPeersMessage(from net.Addr, data *GetPeersMessage) error {
+	peersMsg := &PeersMessage{
+		Addrs: s.addrBook.Known(maxPeersPerMessage),
+	}
+
+	buf := new(bytes.Buffer)
+	if err := gob.NewEncoder(buf).Encode(peersMsg); err != nil {
+		return err
+	}
+
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	for netAddr, peer := range s.peerMap {
-		if err := peer.Send(payload); err != nil {
-			s.Logger.Log("peer send error", "addr", netAddr, "err", err)
+	msg := NewMessage(MessageTypePeers, buf.Bytes())
+	peer, ok := s.peerMap[from]
+	if !ok {
+		return fmt.Errorf("peer %s not known", from)
+	}
+
+	return peer.Send(msg)
+}
+
+// processPeersMessage adds the addresses received from a peer to the address
+// book.
+func (s *Server) processPeersMessage(from net.Addr, data *PeersMessage) error {
+	if len(data.Addrs) > maxPeersPerMessage {
+		return fmt.Errorf("peer %s sent too many addresses (%d)", from, len(data.Addrs))
+	}
+
+	added := 0
+	for _, addr := range data.Addrs {
+		if addr != s.ListenAddr && s.addrBook.Add(addr) {
+			added++
 		}
 	}
 
+	s.Logger.Log("msg", "received PEERS message", "from", from, "addrs", len(data.Addrs), "new", added)
+
 	return nil
 }
 
-// processBlocksMessage handles the reception of Blocks messages from peers.
-func (s *Server) processBlocksMessage(from net.Addr, data *BlocksMessage) error {
-	s.Logger.Log("msg", "received BLOCKS message", "from", from)
+// processBlock adds a block received from a peer to the chain and announces
+// it to the other peers.
+func (s *Server) processBlock(from net.Addr, b *core.Block) error {
+	hash := b.Hash(core.BlockHasher{})
+
+	if peer, ok := s.getPeer(from); ok {
+		peer.markKnown(InvItem{Type: InvTypeBlock, Hash: hash})
+	}
+	s.requests.done(hash)
 
-	for _, block := range data.Blocks {
-		if err := s.chain.AddBlock(block); err != nil {
-			s.Logger.Log("error", err.Error())
+	// Conflicting blocks are evidence even if the chain rejects them.
+	if evidence, ok := s.doubleSigns.Check(b); ok {
+		s.reportEquivocation(evidence)
+	}
+
+	if err := s.chain.AddBlock(b); err != nil {
+		if err == core.ErrBlockKnown {
 			return err
 		}
+		return fmt.Errorf("%w: %w", ErrInvalidBlock, err)
 	}
 
+	go s.broadcastBlock(b)
+
 	return nil
 }
 
-// processStatusMessage handles the reception of Status messages from peers.
-func (s *Server) processStatusMessage(from net.Addr, data *StatusMessage) error {
-	s.Logger.Log("msg", "received STATUS message", "from", from)
+// reportEquivocation submits evidence that a validator signed two blocks at
+// the same height, so that a following block slashes it. Validators send the
+// evidence from their own account, other nodes only log it.
+func (s *Server) reportEquivocation(evidence core.EvidenceTx) {
+	offender, _ := evidence.Offender()
+	s.Logger.Log("msg", "validator signed conflicting blocks", "validator", offender, "height", evidence.First.Header.Height)
 
-	if data.CurrentHeight <= s.chain.Height() {
-		s.Logger.Log("msg", "cannot sync block height too low", "ourHeight", s.chain.Height(), "theirHeight", data.CurrentHeight, "addr", from)
-		return nil
+	if !s.isValidator {
+		return
+	}
+
+	pubKey := crypto.PublicKey{PublicKey: &s.PrivateKey.PublicKey}
+	self, err := pubKey.Address()
+	if err != nil {
+		s.Logger.Log("msg", "failed to submit evidence", "err", err)
+		return
 	}
 
-	go s.requestBlocksLoop(from)
+	tx := core.NewTransaction(nil)
+	tx.TxInner = evidence
+	tx.Nonce = s.mempool.NextNonce(self)
+	if err := tx.Sign(s.PrivateKey); err != nil {
+		s.Logger.Log("msg", "failed to submit evidence", "err", err)
+		return
+	}
+	if err := s.processTransaction(nil, tx); err != nil {
+		s.Logger.Log("msg", "failed to submit evidence", "err", err)
+	}
+}
+
+// processBlocksMessage handles the reception of Blocks messages from peers.
+func (s *Server) processBlocksMessage(from net.Addr, data *BlocksMessage) error {
+	s.Logger.Log("msg", "received BLOCKS message", "from", from)
+
+	reqs, err := s.syncer.HandleBlocks(from, data)
+	s.sendSyncRequests(reqs)
+
+	return err
+}
+
+// processStatusMessage handles the reception of Status messages from peers.
+func (s *Server) processStatusMessage(from net.Addr, data *StatusMessage) error {
+	s.Logger.Log("msg", "received STATUS message", "from", from, "id", data.ID, "version", data.Version)
+
+	s.sendSyncRequests(s.syncer.UpdatePeer(from, data.CurrentHeight))
 
 	return nil
 }
@@ -307,8 +863,10 @@ func (s *Server) processStatusMessage(from net.Addr, data *StatusMessage) error
 // processGetStatusMessage handles the reception of GetStatus messages from peers.
 func (s *Server) processGetStatusMessage(from net.Addr, data *GetStatusMessage) error {
 	s.Logger.Log("msg", "received getStatus message", "from", from)
-	
+
 	statusMsg := &StatusMessage{
+		ID:            s.ID,
+		Version:       uint32(ProtocolVersion),
 		CurrentHeight: s.chain.Height(),
 	}
 
@@ -318,69 +876,290 @@ func (s *Server) processGetStatusMessage(from net.Addr, data *GetStatusMessage)
 	}
 
 	msg := NewMessage(MessageTypeStatus, buf.Bytes())
-	peer, ok := s.peerMap[from]
+	peer, ok := s.getPeer(from)
 	if !ok {
 		return fmt.Errorf("peer %s not known", from)
 	}
 
-	return peer.Send(msg.Bytes())
+	return peer.Send(msg)
 }
 
-// requestBlocksLoop continuously requests blocks from a peer.
-func (s *Server) requestBlocksLoop(peer net.Addr) error {
-	ticker := time.NewTicker(3 * time.Second)
+// syncLoop retries sync requests that timed out and regularly asks the peers
+// for their status, so the node follows peers that move ahead.
+func (s *Server) syncLoop() {
+	syncTicker := time.NewTicker(syncTickInterval)
+	defer syncTicker.Stop()
+	statusTicker := time.NewTicker(statusInterval)
+	defer statusTicker.Stop()
+
 	for {
-		ourHeight := s.chain.Height()
+		select {
+		case <-syncTicker.C:
+			s.sendSyncRequests(s.syncer.Tick())
+
+		case <-statusTicker.C:
+			s.mu.RLock()
+			peers := make([]*TCPPeer, 0, len(s.peerMap))
+			for _, peer := range s.peerMap {
+				peers = append(peers, peer)
+			}
+			s.mu.RUnlock()
 
-		s.Logger.Log("msg", "requesting new blocks", "requesting height", ourHeight+1)
+			for _, peer := range peers {
+				if err := s.sendGetStatusMessage(peer); err != nil {
+					s.Logger.Log("msg", "failed to request peer status", "addr", peer.conn.RemoteAddr(), "err", err)
+				}
+			}
 
-		getBlocksMessage := &GetBlocksMessage{
-			From: ourHeight + 1,
-			To:   0,
+		case <-s.quitCh:
+			return
 		}
+	}
+}
 
-		buf := new(bytes.Buffer)
-		if err := gob.NewEncoder(buf).Encode(getBlocksMessage); err != nil {
-			return err
+// finalityLoop moves the finality rounds forward when they time out.
+func (s *Server) finalityLoop() {
+	ticker := time.NewTicker(finalityTickInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.tickFinalizer()
+
+		case <-s.quitCh:
+			return
 		}
+	}
+}
 
-		s.mu.RLock()
-		defer s.mu.RUnlock()
+// tickFinalizer moves the finalizer forward and sends the votes it casts.
+func (s *Server) tickFinalizer() {
+	s.broadcastVotes(nil, s.finalizer.Tick(time.Now()))
+}
 
-		msg := NewMessage(MessageTypeGetBlocks, buf.Bytes())
-		peer, ok := s.peerMap[peer]
-		if !ok {
-			return fmt.Errorf("peer %s not known", peer)
+// processVoteMessage adds a finality vote received from a peer and relays it
+// to the other peers if it is new, along with the votes it led this validator
+// to cast. Votes already known or for heights already finalized are dropped.
+func (s *Server) processVoteMessage(from net.Addr, data *VoteMessage) error {
+	votes, err := s.finalizer.AddVote(data.Vote)
+	if err != nil {
+		if errors.Is(err, consensus.ErrKnownVote) || errors.Is(err, consensus.ErrVoteOutOfRange) {
+			return nil
 		}
+		return err
+	}
+
+	go s.broadcastVotes(from, append([]*consensus.Vote{data.Vote}, votes...))
+
+	return nil
+}
 
-		if err := peer.Send(msg.Bytes()); err != nil {
-			s.Logger.Log("error", "failed to send to peer", "err", err, "peer", peer)
+// broadcastVotes sends finality votes to all connected peers but the one they
+// came from. from is nil for votes of this validator.
+func (s *Server) broadcastVotes(from net.Addr, votes []*consensus.Vote) {
+	for _, vote := range votes {
+		buf := new(bytes.Buffer)
+		if err := gob.NewEncoder(buf).Encode(&VoteMessage{Vote: vote}); err != nil {
+			s.Logger.Log("msg", "failed to encode vote", "err", err)
+			continue
 		}
+		msg := NewMessage(voteMessageType(vote.Type), buf.Bytes())
 
-		<-ticker.C
+		s.mu.RLock()
+		for netAddr, peer := range s.peerMap {
+			if from != nil && netAddr.String() == from.String() {
+				continue
+			}
+			if err := peer.Send(msg); err != nil {
+				s.Logger.Log("peer send error", "addr", netAddr, "err", err)
+			}
+		}
+		s.mu.RUnlock()
 	}
 }
 
-// broadcastBlock broadcasts a new block to all connected peers.
-func (s *Server) broadcastBlock(b *core.Block) error {
-	buf := &bytes.Buffer{}
-	if err := b.Encode(core.NewGobBlockEncoder(buf)); err != nil {
+// sendSyncRequests sends the requests of the syncer to the peers.
+func (s *Server) sendSyncRequests(reqs []SyncRequest) {
+	for _, req := range reqs {
+		if err := s.sendMessage(req.Peer, req.Type, req.Data); err != nil {
+			s.Logger.Log("msg", "failed to send sync request", "addr", req.Peer, "err", err)
+		}
+	}
+}
+
+// sendMessage encodes data and sends it to a peer as a message of type t.
+func (s *Server) sendMessage(to net.Addr, t MessageType, data interface{}) error {
+	buf := new(bytes.Buffer)
+	if err := gob.NewEncoder(buf).Encode(data); err != nil {
 		return err
 	}
-	msg := NewMessage(MessageTypeBlock, buf.Bytes())
 
-	return s.broadcast(msg.Bytes())
+	s.mu.RLock()
+	peer, ok := s.peerMap[to]
+	s.mu.RUnlock()
+
+	if !ok {
+		return fmt.Errorf("peer %s not known", to)
+	}
+
+	return peer.Send(NewMessage(t, buf.Bytes()))
+}
+
+// SyncStatus returns the progress of block sync. It implements
+// api.SyncProgress.
+func (s *Server) SyncStatus() api.SyncStatus {
+	status := s.syncer.Status()
+	finalized := s.chain.Finalized()
+
+	return api.SyncStatus{
+		Syncing:         status.Syncing,
+		Height:          status.Height,
+		TargetHeight:    status.TargetHeight,
+		HeadersHeight:   status.HeadersHeight,
+		Downloaded:      status.Downloaded,
+		InFlight:        status.InFlight,
+		Peers:           status.Peers,
+		FinalizedHeight: finalized.Height,
+		FinalizedHash:   core.BlockHasher{}.Hash(finalized).String(),
+	}
+}
+
+// broadcastBlock announces a new block to all connected peers that do not
+// have it yet.
+func (s *Server) broadcastBlock(b *core.Block) error {
+	return s.announce(InvItem{Type: InvTypeBlock, Hash: b.Hash(core.BlockHasher{})})
 }
 
-// broadcastTx broadcasts a new transaction to all connected peers.
+// broadcastTx announces a new transaction to all connected peers that do not
+// have it yet.
 func (s *Server) broadcastTx(tx *core.Transaction) error {
+	return s.announce(InvItem{Type: InvTypeTx, Hash: tx.Hash(core.TxHasher{})})
+}
+
+// announce sends an Inv message for item to the peers not known to have it.
+// Peers fetch the object with a GetData message if they need it.
+func (s *Server) announce(item InvItem) error {
 	buf := &bytes.Buffer{}
-	if err := tx.Encode(core.NewGobTxEncoder(buf)); err != nil {
+	if err := gob.NewEncoder(buf).Encode(&InvMessage{Items: []InvItem{item}}); err != nil {
 		return err
 	}
-	msg := NewMessage(MessageTypeTx, buf.Bytes())
+	msg := NewMessage(MessageTypeInv, buf.Bytes())
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for netAddr, peer := range s.peerMap {
+		if !peer.markKnown(item) {
+			continue
+		}
+		if err := peer.Send(msg); err != nil {
+			s.Logger.Log("peer send error", "addr", netAddr, "err", err)
+		}
+	}
+
+	return nil
+}
+
+// processInvMessage requests the announced transactions and blocks that the
+// node does not have and has not requested from another peer yet.
+func (s *Server) processInvMessage(from net.Addr, data *InvMessage) error {
+	if len(data.Items) > MaxInvPerMessage {
+		return fmt.Errorf("%w: (%d) items", ErrInvTooLarge, len(data.Items))
+	}
+
+	peer, ok := s.getPeer(from)
+	if !ok {
+		return fmt.Errorf("peer %s not known", from)
+	}
+
+	items := []InvItem{}
+	for _, item := range data.Items {
+		peer.markKnown(item)
+		if s.hasItem(item) || !s.requests.request(item.Hash) {
+			continue
+		}
+		items = append(items, item)
+	}
+
+	if len(items) == 0 {
+		return nil
+	}
+
+	return s.sendMessage(from, MessageTypeGetData, &GetDataMessage{Items: items})
+}
+
+// processGetDataMessage sends the requested transactions and blocks the node
+// has to the peer.
+func (s *Server) processGetDataMessage(from net.Addr, data *GetDataMessage) error {
+	if len(data.Items) > MaxInvPerMessage {
+		return fmt.Errorf("%w: (%d) items", ErrInvTooLarge, len(data.Items))
+	}
+
+	for _, item := range data.Items {
+		var err error
+		switch item.Type {
+		case InvTypeTx:
+			tx := s.mempool.Get(item.Hash)
+			if tx == nil {
+				continue
+			}
+			err = s.sendMessage(from, MessageTypeTx, tx)
+
+		case InvTypeBlock:
+			b, berr := s.chain.GetBlockByHash(item.Hash)
+			if berr != nil {
+				continue
+			}
+			err = s.sendMessage(from, MessageTypeBlock, b)
+
+		default:
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// hasItem reports whether the node has the object of an inventory item.
+func (s *Server) hasItem(item InvItem) bool {
+	switch item.Type {
+	case InvTypeTx:
+		if s.mempool.Contains(item.Hash) {
+			return true
+		}
+		_, err := s.chain.GetTxByHash(item.Hash)
+		return err == nil
+	case InvTypeBlock:
+		return s.chain.HasBlockHash(item.Hash)
+	}
+
+	// Objects of unknown type cannot be processed, so they are never
+	// requested.
+	return true
+}
+
+// getPeer returns the connected peer with the given address.
+func (s *Server) getPeer(addr net.Addr) (*TCPPeer, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	peer, ok := s.peerMap[addr]
+	return peer, ok
+}
+
+// exitOnCorruption stops the node if err reports that the state of the chain
+// is corrupted. Such a node can neither validate blocks nor vote safely.
+func (s *Server) exitOnCorruption(err error) {
+	if !errors.Is(err, core.ErrChainCorrupted) {
+		return
+	}
 
-	return s.broadcast(msg.Bytes())
+	s.Logger.Log("msg", "chain state corrupted, exiting", "err", err)
+	os.Exit(1)
 }
 
 // createNewBlock creates a new block and adds it to the blockchain.
@@ -389,18 +1168,50 @@ func (s *Server) createNewBlock() error {
 	if err != nil {
 		return err
 	}
-	txx := s.mempool.Pending()
+
+	// Only the validator scheduled for the current slot proposes.
+	timestamp := time.Now().UnixNano()
+	proposer, err := s.engine.Proposer(currentHeader, timestamp)
+	if err != nil {
+		return err
+	}
+	pubKey := crypto.PublicKey{PublicKey: &s.PrivateKey.PublicKey}
+	self, err := pubKey.Address()
+	if err != nil {
+		return err
+	}
+	if proposer != self {
+		return nil
+	}
+	// After a reorg to a shorter branch or a restart the tip can be below the
+	// last block this validator signed. Signing another block at the same
+	// height would get it slashed.
+	if !s.signGuard.CanSign(currentHeader.Height + 1) {
+		return nil
+	}
+
+	txx := s.chain.FilterExecutable(s.mempool.Pending(maxTxsPerBlock))
 
 	block, err := core.NewBlockFromPrevHeader(currentHeader, txx)
 	if err != nil {
 		return err
 	}
+	block.Timestamp = timestamp
+
+	// The validator receives the gas fees of the block, so it must be known
+	// before computing the state root.
+	block.Validator = pubKey
 
-	if err := s.pos.SelectValidator(block, s.chain); err != nil {
+	stateRoot, err := s.chain.ComputeStateRoot(block)
+	if err != nil {
 		return err
 	}
+	block.StateRoot = stateRoot
 
-	if err := block.Sign(*s.PrivateKey); err != nil {
+	if err := block.Sign(s.PrivateKey); err != nil {
+		return err
+	}
+	if err := s.signGuard.Record(block); err != nil {
 		return err
 	}
 
@@ -408,13 +1219,21 @@ func (s *Server) createNewBlock() error {
 		return err
 	}
 
-	s.mempool.ClearPending()
-
 	go s.broadcastBlock(block)
 
 	return nil
 }
 
+// newChainStore opens the block store for the given data directory, falling
+// back to an in-memory store when no directory is configured.
+func newChainStore(dataDir string) (core.Store, error) {
+	if len(dataDir) == 0 {
+		return core.NewMemStore(), nil
+	}
+
+	return core.NewFileStore(filepath.Join(dataDir, "chain"))
+}
+
 // genesisBlock creates and returns the genesis block of the blockchain.
 func genesisBlock() *core.Block {
 	header := &core.Header{
@@ -425,14 +1244,10 @@ func genesisBlock() *core.Block {
 	}
 	b, _ := core.NewBlock(header, nil)
 
-	coinbase := crypto.PublicKey{}
-	tx := core.NewTransaction(nil)
-	tx.From = coinbase
-	tx.To = coinbase
-	tx.Value = 10_000_000
-	b.Transactions = append(b.Transactions, tx)
-
-	privKey := crypto.GeneratePrivateKey()
+	privKey, _, err := crypto.GenerateKeyPair()
+	if err != nil {
+		panic(err)
+	}
 	if err := b.Sign(privKey); err != nil {
 		panic(err)
 	}
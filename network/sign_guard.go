@@ -0,0 +1,98 @@
+package network
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/blu-fi-tech-inc/blufi-network/core"
+	"github.com/blu-fi-tech-inc/blufi-network/types"
+)
+
+// signGuardKey is the key of the last block signed by the validator in the
+// chain store: its height followed by its hash.
+const signGuardKey = "validator/lastsigned"
+
+// ErrAlreadySigned is returned when the validator would sign a block at or
+// below the height of a block it signed before.
+var ErrAlreadySigned = errors.New("already signed a block at this height")
+
+// SignGuard keeps a validator from signing two blocks at the same height,
+// which would get it slashed. The last signed block is written to the store
+// before the block leaves the node, so the guard holds across restarts.
+type SignGuard struct {
+	lock   sync.Mutex
+	store  core.Store
+	height uint32
+	hash   types.Hash
+	signed bool // whether the validator signed any block
+}
+
+// NewSignGuard loads the last signed block from the store.
+func NewSignGuard(store core.Store) (*SignGuard, error) {
+	g := &SignGuard{store: store}
+
+	data, err := store.Get(signGuardKey)
+	if errors.Is(err, core.ErrKeyNotFound) {
+		return g, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(data) != 4+len(types.Hash{}) {
+		return nil, fmt.Errorf("invalid last signed block record of (%d) bytes", len(data))
+	}
+
+	g.height = binary.BigEndian.Uint32(data)
+	g.hash, err = types.HashFromBytes(data[4:])
+	if err != nil {
+		return nil, err
+	}
+	g.signed = true
+
+	return g, nil
+}
+
+// Last returns the height and hash of the last block the validator signed.
+// ok is false if it never signed one.
+func (g *SignGuard) Last() (height uint32, hash types.Hash, ok bool) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	return g.height, g.hash, g.signed
+}
+
+// CanSign reports whether the validator may sign a block at the height.
+func (g *SignGuard) CanSign(height uint32) bool {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	return !g.signed || height > g.height
+}
+
+// Record persists b as the last block signed by the validator. It must be
+// called after signing and before the block is added or broadcast; the block
+// must not be used if it fails.
+func (g *SignGuard) Record(b *core.Block) error {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	if g.signed && b.Height <= g.height {
+		return fmt.Errorf("%w: height (%d), last signed (%d)", ErrAlreadySigned, b.Height, g.height)
+	}
+
+	hash := b.Hash(core.BlockHasher{})
+	data := make([]byte, 4, 4+len(hash))
+	binary.BigEndian.PutUint32(data, b.Height)
+	data = append(data, hash.ToSlice()...)
+	if err := g.store.Put(signGuardKey, data); err != nil {
+		return err
+	}
+
+	g.height = b.Height
+	g.hash = hash
+	g.signed = true
+
+	return nil
+}
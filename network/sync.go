@@ -0,0 +1,487 @@
+package network
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/blu-fi-tech-inc/blufi-network/core"
+	"github.com/go-kit/log"
+)
+
+const (
+	// MaxBlocksPerMessage is the number of blocks served or requested in a
+	// single Blocks message.
+	MaxBlocksPerMessage = 100
+
+	// MaxHeadersPerMessage is the number of headers served or requested in a
+	// single Headers message.
+	MaxHeadersPerMessage = 1000
+
+	// syncRequestTimeout is how long a peer has to answer a sync request
+	// before it is sent to another peer.
+	syncRequestTimeout = 10 * time.Second
+
+	// maxSyncRequestsPerPeer is the number of block requests in flight to a
+	// single peer.
+	maxSyncRequestsPerPeer = 2
+)
+
+// ErrInvalidHeaders is returned for headers received from a peer that do not
+// form a chain.
+var ErrInvalidHeaders = errors.New("invalid headers from peer")
+
+// SyncRequest is a message the Syncer wants sent to a peer.
+type SyncRequest struct {
+	Peer net.Addr
+	Type MessageType
+	Data interface{}
+}
+
+// SyncStatus describes the progress of a Syncer.
+type SyncStatus struct {
+	Syncing       bool
+	Height        uint32 // Height of the local chain.
+	TargetHeight  uint32 // Height of the best peer.
+	HeadersHeight uint32 // Height of the last downloaded header.
+	Downloaded    int    // Blocks downloaded and waiting to be added.
+	InFlight      int    // Block requests waiting for an answer.
+	Peers         int    // Peers that reported their height.
+}
+
+// syncRequest is a request sent to a peer for the headers or blocks in
+// from..to.
+type syncRequest struct {
+	peer     net.Addr
+	from, to uint32
+	sent     time.Time
+}
+
+// Syncer downloads the blocks of peers that are ahead of the local chain.
+// It first downloads the headers of the best peer, then fetches the blocks
+// they commit to in batches from all peers that have them, and adds them to
+// the chain in order.
+//
+// The Syncer does not send messages itself. Every method that makes progress
+// returns the requests to send to peers.
+type Syncer struct {
+	lock   sync.Mutex
+	chain  *core.Blockchain
+	logger log.Logger
+
+	peers   map[net.Addr]uint32 // heights reported by peers
+	syncing bool
+	target  uint32
+
+	// The headers to download blocks for start at base. Blocks below base
+	// are added to the chain already.
+	base      uint32
+	headers   []*core.Header
+	headerReq *syncRequest
+	lookback  uint32 // how far below the tip headers are requested to find a common ancestor
+
+	pending map[uint32]*syncRequest // block requests in flight by height
+	blocks  map[uint32]*core.Block  // downloaded blocks by height
+}
+
+// NewSyncer creates a Syncer adding blocks to chain.
+func NewSyncer(chain *core.Blockchain, logger log.Logger) *Syncer {
+	return &Syncer{
+		chain:   chain,
+		logger:  logger,
+		base:    chain.Height() + 1,
+		peers:   make(map[net.Addr]uint32),
+		pending: make(map[uint32]*syncRequest),
+		blocks:  make(map[uint32]*core.Block),
+	}
+}
+
+// UpdatePeer records the chain height reported by a peer.
+func (s *Syncer) UpdatePeer(peer net.Addr, height uint32) []SyncRequest {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.peers[peer] = height
+
+	return s.next()
+}
+
+// RemovePeer forgets a peer and sends its requests to other peers.
+func (s *Syncer) RemovePeer(peer net.Addr) []SyncRequest {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.dropPeer(peer)
+
+	return s.next()
+}
+
+// Tick sends requests that timed out to other peers.
+func (s *Syncer) Tick() []SyncRequest {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	deadline := time.Now().Add(-syncRequestTimeout)
+	if s.headerReq != nil && s.headerReq.sent.Before(deadline) {
+		s.logger.Log("msg", "sync request timed out", "peer", s.headerReq.peer)
+		s.dropPeer(s.headerReq.peer)
+	}
+	for _, req := range s.pending {
+		if req.sent.Before(deadline) {
+			s.logger.Log("msg", "sync request timed out", "peer", req.peer)
+			s.dropPeer(req.peer)
+		}
+	}
+
+	return s.next()
+}
+
+// HandleHeaders processes the headers sent by a peer in answer to a
+// GetHeaders request.
+func (s *Syncer) HandleHeaders(peer net.Addr, msg *HeadersMessage) ([]SyncRequest, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	req := s.headerReq
+	if req == nil || req.peer != peer {
+		return nil, nil
+	}
+	s.headerReq = nil
+
+	headers := msg.Headers
+	if len(headers) == 0 {
+		// The peer does not have the headers after all.
+		delete(s.peers, peer)
+		return s.next(), nil
+	}
+	if len(headers) > int(req.to-req.from)+1 {
+		return nil, fmt.Errorf("%w: (%d) headers for (%d..%d)", ErrInvalidHeaders, len(headers), req.from, req.to)
+	}
+
+	hasher := core.BlockHasher{}
+	for i, h := range headers {
+		if h.Height != req.from+uint32(i) {
+			return nil, fmt.Errorf("%w: height (%d), expected (%d)", ErrInvalidHeaders, h.Height, req.from+uint32(i))
+		}
+		if i > 0 && h.PrevBlockHash != hasher.Hash(headers[i-1]) {
+			return nil, fmt.Errorf("%w: header (%d) does not follow its parent", ErrInvalidHeaders, h.Height)
+		}
+	}
+
+	first := headers[0]
+	if len(s.headers) > 0 {
+		if first.PrevBlockHash != hasher.Hash(s.headers[len(s.headers)-1]) {
+			return nil, fmt.Errorf("%w: header (%d) does not follow its parent", ErrInvalidHeaders, first.Height)
+		}
+	} else if !s.chain.HasBlockHash(first.PrevBlockHash) {
+		// The peer is on a fork that branched off further back. Look for the
+		// common ancestor below the tip.
+		height := s.chain.Height()
+		limit := min(height, core.MaxReorgDepth)
+		if s.lookback >= limit {
+			s.logger.Log("msg", "no common ancestor with peer", "peer", peer)
+			delete(s.peers, peer)
+			s.lookback = 0
+			s.base = height + 1
+			return s.next(), nil
+		}
+		s.lookback = min(max(1, 2*s.lookback), limit)
+		s.base = height + 1 - s.lookback
+		return s.next(), nil
+	}
+
+	s.headers = append(s.headers, headers...)
+
+	return s.next(), nil
+}
+
+// HandleBlocks processes the blocks sent by a peer in answer to a GetBlocks
+// request and adds the blocks that are next in line to the chain.
+func (s *Syncer) HandleBlocks(peer net.Addr, msg *BlocksMessage) ([]SyncRequest, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if len(msg.Blocks) == 0 {
+		s.dropPeer(peer)
+		return s.next(), nil
+	}
+
+	req := s.pending[msg.Blocks[0].Height]
+	if req == nil || req.peer != peer || req.from != msg.Blocks[0].Height {
+		return nil, nil
+	}
+	s.cancel(req)
+
+	hasher := core.BlockHasher{}
+	for i, b := range msg.Blocks {
+		height := req.from + uint32(i)
+		if height > req.to || b.Height != height {
+			return s.next(), fmt.Errorf("%w: unexpected block at height (%d)", ErrInvalidBlock, b.Height)
+		}
+		if b.Hash(hasher) != hasher.Hash(s.headers[height-s.base]) {
+			// The peer follows another chain than the one being synced.
+			s.dropPeer(peer)
+			return s.next(), nil
+		}
+		s.blocks[height] = b
+	}
+
+	if err := s.apply(); err != nil {
+		return s.next(), err
+	}
+
+	return s.next(), nil
+}
+
+// Status returns the progress of the Syncer.
+func (s *Syncer) Status() SyncStatus {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	inFlight := 0
+	for _, n := range s.inFlight() {
+		inFlight += n
+	}
+
+	return SyncStatus{
+		Syncing:       s.syncing,
+		Height:        s.chain.Height(),
+		TargetHeight:  s.target,
+		HeadersHeight: s.headersHeight(),
+		Downloaded:    len(s.blocks),
+		InFlight:      inFlight,
+		Peers:         len(s.peers),
+	}
+}
+
+// apply adds the downloaded blocks that are next in line to the chain. If one
+// is rejected the headers that led to it are dropped. It must be called with
+// the lock held.
+func (s *Syncer) apply() error {
+	for {
+		b, ok := s.blocks[s.base]
+		if !ok {
+			return nil
+		}
+
+		if err := s.chain.AddBlock(b); err != nil && err != core.ErrBlockKnown {
+			s.restart()
+			return fmt.Errorf("%w: %w", ErrInvalidBlock, err)
+		}
+
+		delete(s.blocks, s.base)
+		s.headers = s.headers[1:]
+		s.base++
+	}
+}
+
+// next starts or stops syncing depending on the heights of the peers and
+// returns the requests to send. It must be called with the lock held.
+func (s *Syncer) next() []SyncRequest {
+	height := s.chain.Height()
+
+	var best net.Addr
+	for peer, peerHeight := range s.peers {
+		if best == nil || peerHeight > s.peers[best] || (peerHeight == s.peers[best] && peer.String() < best.String()) {
+			best = peer
+		}
+	}
+
+	if best == nil || s.peers[best] <= height {
+		if s.syncing {
+			s.syncing = false
+			s.restart()
+			s.logger.Log("msg", "sync complete", "height", height)
+		}
+		return nil
+	}
+
+	if !s.syncing {
+		s.syncing = true
+		s.restart()
+		s.logger.Log("msg", "starting sync", "height", height, "target", s.peers[best], "peer", best)
+	}
+	s.target = s.peers[best]
+
+	reqs := []SyncRequest{}
+
+	headersHeight := s.headersHeight()
+	if s.headerReq == nil && headersHeight < s.target {
+		s.headerReq = &syncRequest{
+			peer: best,
+			from: headersHeight + 1,
+			to:   min(s.target, headersHeight+MaxHeadersPerMessage),
+			sent: time.Now(),
+		}
+		reqs = append(reqs, SyncRequest{
+			Peer: best,
+			Type: MessageTypeGetHeaders,
+			Data: &GetHeadersMessage{From: s.headerReq.from, To: s.headerReq.to},
+		})
+	}
+
+	// Request the blocks of the headers in batches of consecutive heights
+	// that are neither downloaded nor requested yet.
+	inFlight := s.inFlight()
+	for from := s.base; from <= headersHeight; {
+		if s.blocks[from] != nil || s.pending[from] != nil {
+			from++
+			continue
+		}
+
+		to := from
+		for to < headersHeight && to-from+1 < MaxBlocksPerMessage && s.blocks[to+1] == nil && s.pending[to+1] == nil {
+			to++
+		}
+
+		peer := s.pickPeer(to, inFlight)
+		if peer == nil {
+			break
+		}
+
+		req := &syncRequest{peer: peer, from: from, to: to, sent: time.Now()}
+		for height := from; height <= to; height++ {
+			s.pending[height] = req
+		}
+		inFlight[peer]++
+
+		reqs = append(reqs, SyncRequest{
+			Peer: peer,
+			Type: MessageTypeGetBlocks,
+			Data: &GetBlocksMessage{From: from, To: to},
+		})
+
+		from = to + 1
+	}
+
+	return reqs
+}
+
+// pickPeer returns the peer with the fewest requests in flight that has the
+// block at the given height, or nil if all of them are busy.
+func (s *Syncer) pickPeer(height uint32, inFlight map[net.Addr]int) net.Addr {
+	candidates := []net.Addr{}
+	for peer, peerHeight := range s.peers {
+		if peerHeight >= height && inFlight[peer] < maxSyncRequestsPerPeer {
+			candidates = append(candidates, peer)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		if inFlight[candidates[i]] != inFlight[candidates[j]] {
+			return inFlight[candidates[i]] < inFlight[candidates[j]]
+		}
+		return candidates[i].String() < candidates[j].String()
+	})
+
+	return candidates[0]
+}
+
+// inFlight returns the number of block requests in flight per peer.
+func (s *Syncer) inFlight() map[net.Addr]int {
+	seen := make(map[*syncRequest]bool)
+	inFlight := make(map[net.Addr]int)
+	for _, req := range s.pending {
+		if !seen[req] {
+			seen[req] = true
+			inFlight[req.peer]++
+		}
+	}
+
+	return inFlight
+}
+
+// headersHeight returns the height of the last downloaded header.
+func (s *Syncer) headersHeight() uint32 {
+	return s.base + uint32(len(s.headers)) - 1
+}
+
+// cancel forgets a block request.
+func (s *Syncer) cancel(req *syncRequest) {
+	for height := req.from; height <= req.to; height++ {
+		if s.pending[height] == req {
+			delete(s.pending, height)
+		}
+	}
+}
+
+// dropPeer stops syncing from a peer and forgets its requests.
+func (s *Syncer) dropPeer(peer net.Addr) {
+	delete(s.peers, peer)
+
+	if s.headerReq != nil && s.headerReq.peer == peer {
+		s.headerReq = nil
+	}
+	for _, req := range s.pending {
+		if req.peer == peer {
+			s.cancel(req)
+		}
+	}
+}
+
+// restart forgets all downloaded headers and blocks and continues from the
+// tip of the chain.
+func (s *Syncer) restart() {
+	s.base = s.chain.Height() + 1
+	s.headers = nil
+	s.headerReq = nil
+	s.lookback = 0
+	s.pending = make(map[uint32]*syncRequest)
+	s.blocks = make(map[uint32]*core.Block)
+}
+
+// BlocksInRange returns the canonical blocks of chain from..to, at most
+// MaxBlocksPerMessage of them. To 0 means up to the tip of the chain.
+func BlocksInRange(chain *core.Blockchain, from, to uint32) ([]*core.Block, error) {
+	blocks := []*core.Block{}
+	end, ok := rangeEnd(chain, from, to, MaxBlocksPerMessage)
+	for height := from; ok && height <= end; height++ {
+		b, err := chain.GetBlock(height)
+		if err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, b)
+	}
+
+	return blocks, nil
+}
+
+// HeadersInRange returns the canonical headers of chain from..to, at most
+// MaxHeadersPerMessage of them. To 0 means up to the tip of the chain.
+func HeadersInRange(chain *core.Blockchain, from, to uint32) ([]*core.Header, error) {
+	headers := []*core.Header{}
+	end, ok := rangeEnd(chain, from, to, MaxHeadersPerMessage)
+	for height := from; ok && height <= end; height++ {
+		h, err := chain.GetHeader(height)
+		if err != nil {
+			return nil, err
+		}
+		headers = append(headers, h)
+	}
+
+	return headers, nil
+}
+
+// rangeEnd returns the last height of the range starting at from that is
+// served, bounded by to, the tip of the chain and the batch size limit. ok is
+// false if the range is empty.
+func rangeEnd(chain *core.Blockchain, from, to uint32, limit uint32) (end uint32, ok bool) {
+	end = chain.Height()
+	if to != 0 && to < end {
+		end = to
+	}
+	if end < from {
+		return 0, false
+	}
+	if end-from >= limit {
+		end = from + limit - 1
+	}
+
+	return end, true
+}
@@ -2,46 +2,109 @@ package network
 
 import (
 	"bytes"
-	"fmt"
 	"io"
 	"net"
+	"sync"
+	"time"
 
+	"github.com/blu-fi-tech-inc/blufi-network/crypto"
 	"github.com/sirupsen/logrus"
 )
 
+// handshakeTimeout bounds the time a new connection has to complete the
+// handshake.
+const handshakeTimeout = 10 * time.Second
+
 // TCPPeer represents a TCP peer.
 type TCPPeer struct {
-	conn     net.Conn
-	Outgoing bool
+	conn       net.Conn
+	Outgoing   bool
+	PublicKey  *crypto.PublicKey // node identity key, set by the handshake
+	ListenAddr string            // address the peer can be dialed at
+	magic      uint32            // network magic of the frames exchanged with the peer
+	sendLock   sync.Mutex        // keeps concurrently sent frames from interleaving
+
+	// Misbehaviour score and message rate limit, only used by the server loop.
+	score   PeerScore
+	limiter *rateLimiter
+
+	// Transactions and blocks the peer is known to have, so they are not
+	// announced to it again.
+	knownTxs    *KnownSet
+	knownBlocks *KnownSet
 }
 
-// Send sends data to the peer.
-func (p *TCPPeer) Send(b []byte) error {
-	_, err := p.conn.Write(b)
+// handshake performs the handshake with the peer and records its identity.
+// If encryption was agreed on, all later traffic goes through an encrypted
+// channel.
+func (p *TCPPeer) handshake(h *Handshake) error {
+	if err := p.conn.SetDeadline(time.Now().Add(handshakeTimeout)); err != nil {
+		return err
+	}
+
+	result, err := h.Run(p.conn, p.magic)
+	if err != nil {
+		return err
+	}
+	p.PublicKey = result.PublicKey
+	if !p.Outgoing {
+		p.ListenAddr = advertisedAddr(p.conn.RemoteAddr(), result.ListenAddr)
+	}
+
+	if err := p.conn.SetDeadline(time.Time{}); err != nil {
+		return err
+	}
+
+	p.conn, err = result.Secure(p.conn)
+	return err
+}
+
+// markKnown records that the peer has the object of an inventory item. It
+// returns false if the peer was known to have it already.
+func (p *TCPPeer) markKnown(item InvItem) bool {
+	switch item.Type {
+	case InvTypeTx:
+		return p.knownTxs.Add(item.Hash)
+	case InvTypeBlock:
+		return p.knownBlocks.Add(item.Hash)
+	}
+
+	return false
+}
+
+// Send sends a message to the peer as a single frame.
+func (p *TCPPeer) Send(msg *Message) error {
+	p.sendLock.Lock()
+	defer p.sendLock.Unlock()
+
+	err := WriteFrame(p.conn, p.magic, msg)
 	if err != nil {
 		logrus.WithError(err).Error("error sending data to peer")
 	}
 	return err
 }
 
-// readLoop continuously reads from the peer connection.
+// readLoop reads frames from the peer connection until it fails. A stream
+// that cannot be framed cannot be resynchronized, so the connection is closed
+// on any error.
 func (p *TCPPeer) readLoop(rpcCh chan RPC) {
-	buf := make([]byte, 4096)
+	defer p.conn.Close()
+
 	for {
-		n, err := p.conn.Read(buf)
+		msg, err := ReadFrame(p.conn, p.magic)
 		if err == io.EOF {
-			continue // EOF is expected when connection closes
+			logrus.WithField("peer", p.conn.RemoteAddr()).Info("peer disconnected")
+			return
 		}
 		if err != nil {
-			logrus.WithError(err).Error("read error from peer")
-			continue
+			logrus.WithError(err).WithField("peer", p.conn.RemoteAddr()).Error("read error from peer")
+			return
 		}
 
-		msg := make([]byte, n)
-		copy(msg, buf[:n]) // Create a copy of the buffer to avoid concurrent access issues
 		rpcCh <- RPC{
 			From:    p.conn.RemoteAddr(),
-			Payload: bytes.NewReader(msg),
+			Type:    msg.Header,
+			Payload: bytes.NewReader(msg.Data),
 		}
 	}
 }
@@ -51,13 +114,16 @@ type TCPTransport struct {
 	peerCh     chan *TCPPeer
 	listenAddr string
 	listener   net.Listener
+	magic      uint32
 }
 
-// NewTCPTransport creates a new TCPTransport instance.
-func NewTCPTransport(addr string, peerCh chan *TCPPeer) *TCPTransport {
+// NewTCPTransport creates a new TCPTransport instance for the network
+// identified by magic.
+func NewTCPTransport(addr string, magic uint32, peerCh chan *TCPPeer) *TCPTransport {
 	return &TCPTransport{
 		peerCh:     peerCh,
 		listenAddr: addr,
+		magic:      magic,
 	}
 }
 
@@ -78,6 +144,26 @@ func (t *TCPTransport) Start() error {
 	return nil
 }
 
+// Dial connects to the node at addr and hands the connection to the server as
+// an outgoing peer.
+func (t *TCPTransport) Dial(addr string) error {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	t.peerCh <- &TCPPeer{
+		conn:        conn,
+		Outgoing:    true,
+		ListenAddr:  addr,
+		magic:       t.magic,
+		knownTxs:    NewKnownSet(maxKnownTxs),
+		knownBlocks: NewKnownSet(maxKnownBlocks),
+	}
+
+	return nil
+}
+
 // acceptLoop continuously accepts incoming connections.
 func (t *TCPTransport) acceptLoop() {
 	for {
@@ -88,7 +174,10 @@ func (t *TCPTransport) acceptLoop() {
 		}
 
 		peer := &TCPPeer{
-			conn: conn,
+			conn:        conn,
+			magic:       t.magic,
+			knownTxs:    NewKnownSet(maxKnownTxs),
+			knownBlocks: NewKnownSet(maxKnownBlocks),
 		}
 
 		t.peerCh <- peer
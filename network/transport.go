@@ -14,10 +14,10 @@ type Transport interface {
 	Connect(Transport) error
 
 	// SendMessage sends a message to a specific network address.
-	SendMessage(net.Addr, []byte) error
+	SendMessage(net.Addr, *Message) error
 
 	// Broadcast sends a message to all connected peers.
-	Broadcast([]byte) error
+	Broadcast(*Message) error
 
 	// Addr returns the network address of this transport instance.
 	Addr() net.Addr
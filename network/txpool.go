@@ -1,159 +1,595 @@
 package network
 
 import (
+	"bytes"
+	"container/heap"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"math"
+	"math/bits"
+	"sort"
 	"sync"
 
 	"github.com/blu-fi-tech-inc/blufi-network/core"
 	"github.com/blu-fi-tech-inc/blufi-network/types"
 )
 
-// TxPool manages a pool of transactions.
+const (
+	// ReplaceFeeBump is the percentage by which a transaction must raise the
+	// fee of the queued transaction with the same sender and nonce to replace
+	// it.
+	ReplaceFeeBump = 10
+
+	// MaxTxSize is the size of the largest transaction admitted to the pool,
+	// in gob encoded bytes.
+	MaxTxSize = 128 << 10
+
+	// MaxSenderTxs is the most transactions queued for one sender. With a
+	// state source, transactions may also be at most this many nonces ahead
+	// of the next nonce of their sender.
+	MaxSenderTxs = 64
+)
+
+var (
+	// ErrNonceTooLow is returned when a transaction uses a nonce its sender
+	// has already consumed on chain.
+	ErrNonceTooLow = errors.New("nonce too low")
+
+	// ErrNonceTooHigh is returned when a transaction uses a nonce too far
+	// ahead of the next nonce of its sender, see MaxSenderTxs.
+	ErrNonceTooHigh = errors.New("nonce too high")
+
+	// ErrSenderQueueFull is returned for a transaction whose sender already
+	// has MaxSenderTxs transactions queued.
+	ErrSenderQueueFull = errors.New("sender queue is full")
+
+	// ErrReplacementUnderpriced is returned for a transaction with the sender
+	// and nonce of a queued one that does not pay enough more to replace it.
+	ErrReplacementUnderpriced = errors.New("replacement transaction underpriced")
+
+	// ErrTxPoolFull is returned for a transaction that does not pay more than
+	// the transactions that would have to be evicted for it.
+	ErrTxPoolFull = errors.New("transaction pool is full")
+
+	// ErrTxTooLarge is returned for transactions larger than MaxTxSize.
+	ErrTxTooLarge = errors.New("transaction too large")
+
+	// ErrInsufficientFunds is returned for a transaction whose sender cannot
+	// pay for it and the transactions queued before it.
+	ErrInsufficientFunds = errors.New("insufficient funds")
+)
+
+// RejectReason tells why a transaction was not admitted to the pool.
+type RejectReason uint8
+
+const (
+	RejectInvalid RejectReason = iota + 1
+	RejectInvalidSignature
+	RejectTooLarge
+	RejectNonceTooLow
+	RejectInsufficientFunds
+	RejectUnderpriced
+	RejectPoolFull
+	RejectNonceTooHigh
+	RejectSenderQueueFull
+)
+
+func (r RejectReason) String() string {
+	switch r {
+	case RejectInvalidSignature:
+		return "invalid_signature"
+	case RejectTooLarge:
+		return "too_large"
+	case RejectNonceTooLow:
+		return "nonce_too_low"
+	case RejectInsufficientFunds:
+		return "insufficient_funds"
+	case RejectUnderpriced:
+		return "underpriced"
+	case RejectPoolFull:
+		return "pool_full"
+	case RejectNonceTooHigh:
+		return "nonce_too_high"
+	case RejectSenderQueueFull:
+		return "sender_queue_full"
+	}
+
+	return "invalid"
+}
+
+// TxRejectedError is returned by TxPool.Add for transactions that are not
+// admitted. It wraps the error describing the problem.
+type TxRejectedError struct {
+	Hash   types.Hash
+	Reason RejectReason
+	Err    error
+}
+
+func (e *TxRejectedError) Error() string {
+	return fmt.Sprintf("tx (%s) rejected: %s", e.Hash, e.Err)
+}
+
+func (e *TxRejectedError) Unwrap() error {
+	return e.Err
+}
+
+// RejectReason returns the reason of the rejection as a string. It lets the
+// API report the reason without depending on this package.
+func (e *TxRejectedError) RejectReason() string {
+	return e.Reason.String()
+}
+
+// StateSource gives the pool access to the account state transactions are
+// checked against.
+type StateSource interface {
+	NextNonce(types.Address) uint64
+	Balance(types.Address) uint64
+}
+
+// TxPool holds the transactions waiting to be included in a block. The
+// transactions of every sender are queued by nonce and transactions are
+// prioritized by the fee they are sure to pay to the validator, see
+// core.Transaction.MinFee, so raising the gas limit does not raise the
+// priority of a transaction. When the pool is full, the lowest paying
+// transactions are evicted.
 type TxPool struct {
-	all       *TxSortedMap // All transactions in the pool
-	pending   *TxSortedMap // Transactions pending inclusion
-	maxLength int          // Maximum length of the total pool
+	lock      sync.RWMutex
+	all       map[types.Hash]*core.Transaction
+	senders   map[types.Address]*txQueue
+	maxLength int         // Maximum number of transactions in the pool
+	state     StateSource // Source of nonces and balances, nil disables the checks against them
 }
 
 // NewTxPool creates a new transaction pool with a maximum length.
 func NewTxPool(maxLength int) *TxPool {
 	return &TxPool{
-		all:       NewTxSortedMap(),
-		pending:   NewTxSortedMap(),
+		all:       make(map[types.Hash]*core.Transaction),
+		senders:   make(map[types.Address]*txQueue),
 		maxLength: maxLength,
 	}
 }
 
-// Add adds a transaction to the pool.
-func (p *TxPool) Add(tx *core.Transaction) {
-	// Prune the oldest transaction in the 'all' pool if it reaches max length.
-	if p.all.Count() == p.maxLength {
-		oldest := p.all.First()
-		p.all.Remove(oldest.Hash(core.TxHasher{}))
+// SetStateSource sets the source used to check transaction nonces and the
+// balances of their senders.
+func (p *TxPool) SetStateSource(state StateSource) {
+	p.state = state
+}
+
+// Add validates a transaction and adds it to the pool. Transactions that are
+// not admitted are reported with a *TxRejectedError.
+//
+// A transaction with the sender and nonce of a queued one replaces it if it
+// raises the fee by at least ReplaceFeeBump percent. A sender has at most
+// MaxSenderTxs transactions queued. If the pool is full, the lowest paying
+// transaction is evicted to make room, unless the new one does not pay more.
+func (p *TxPool) Add(tx *core.Transaction) error {
+	hash := tx.Hash(core.TxHasher{})
+
+	if size, err := txSize(tx); err != nil {
+		return reject(hash, RejectInvalid, err)
+	} else if size > MaxTxSize {
+		return reject(hash, RejectTooLarge, fmt.Errorf("%w: (%d) bytes, max (%d)", ErrTxTooLarge, size, MaxTxSize))
+	}
+	if err := tx.Verify(); err != nil {
+		return reject(hash, RejectInvalidSignature, err)
+	}
+	from, err := tx.From.Address()
+	if err != nil {
+		return reject(hash, RejectInvalid, err)
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if _, ok := p.all[hash]; ok {
+		return nil
+	}
+
+	if p.state != nil {
+		next := p.state.NextNonce(from)
+		if tx.Nonce < next {
+			return reject(hash, RejectNonceTooLow, fmt.Errorf(
+				"%w: nonce (%d), account (%s) expects (%d)",
+				ErrNonceTooLow,
+				tx.Nonce,
+				from,
+				next,
+			))
+		}
+		if tx.Nonce-next >= MaxSenderTxs {
+			return reject(hash, RejectNonceTooHigh, fmt.Errorf(
+				"%w: nonce (%d), account (%s) expects (%d)",
+				ErrNonceTooHigh,
+				tx.Nonce,
+				from,
+				next,
+			))
+		}
+
+		// The sender must be able to pay for the transaction after paying
+		// for all of its transactions queued before it.
+		balance := p.state.Balance(from)
+		if cost, ok := p.costBefore(from, tx.Nonce, txCost(tx)); !ok || cost > balance {
+			return reject(hash, RejectInsufficientFunds, fmt.Errorf(
+				"%w: account (%s) has (%d), needs (%d)",
+				ErrInsufficientFunds,
+				from,
+				balance,
+				cost,
+			))
+		}
 	}
 
-	// Add transaction to 'all' and 'pending' pools if it's not already present.
-	if !p.all.Contains(tx.Hash(core.TxHasher{})) {
-		p.all.Add(tx)
-		p.pending.Add(tx)
+	queue := p.senders[from]
+	if old := queue.get(tx.Nonce); old != nil {
+		if !replaces(tx, old) {
+			return reject(hash, RejectUnderpriced, fmt.Errorf(
+				"%w: pays (%d), replacing (%s) requires (%d)",
+				ErrReplacementUnderpriced,
+				tx.MinFee(),
+				old.Hash(core.TxHasher{}),
+				minReplacementFee(old),
+			))
+		}
+		p.remove(from, old)
+	} else if queue != nil && len(queue.txx) >= MaxSenderTxs {
+		return reject(hash, RejectSenderQueueFull, fmt.Errorf(
+			"%w: account (%s) has (%d) queued",
+			ErrSenderQueueFull,
+			from,
+			len(queue.txx),
+		))
+	} else if len(p.all) >= p.maxLength {
+		victim, victimFrom := p.evictionCandidate()
+		if victim == nil || !outbids(tx, victim) || (victimFrom == from && tx.Nonce > victim.Nonce) {
+			return reject(hash, RejectPoolFull, fmt.Errorf("%w: pays (%d)", ErrTxPoolFull, tx.MinFee()))
+		}
+		p.remove(victimFrom, victim)
 	}
+
+	if p.senders[from] == nil {
+		p.senders[from] = &txQueue{txx: make(map[uint64]*core.Transaction)}
+	}
+	p.senders[from].txx[tx.Nonce] = tx
+	p.all[hash] = tx
+
+	return nil
+}
+
+// Remove removes a transaction from the pool.
+func (p *TxPool) Remove(hash types.Hash) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	tx, ok := p.all[hash]
+	if !ok {
+		return
+	}
+	from, err := tx.From.Address()
+	if err != nil {
+		return
+	}
+
+	p.remove(from, tx)
+}
+
+// Reset updates the pool after a change of the canonical chain. Transactions
+// included in the connected blocks are removed, the remaining ones are checked
+// against the new state and transactions orphaned by a reorg are added back.
+// It returns the transactions dropped because they are no longer valid or
+// could not be added back.
+func (p *TxPool) Reset(ev core.ChainEvent) []*core.Transaction {
+	p.lock.Lock()
+	for _, b := range ev.Connected {
+		for _, tx := range b.Transactions {
+			if queued, ok := p.all[tx.Hash(core.TxHasher{})]; ok {
+				if from, err := queued.From.Address(); err == nil {
+					p.remove(from, queued)
+				}
+			}
+		}
+	}
+	dropped := p.revalidate()
+	p.lock.Unlock()
+
+	for _, tx := range ev.Orphaned {
+		if err := p.Add(tx); err != nil {
+			dropped = append(dropped, tx)
+		}
+	}
+
+	return dropped
+}
+
+// revalidate drops the transactions whose nonce was used on chain and those
+// their sender can no longer pay for. It returns the dropped transactions. It
+// must be called with the lock held.
+func (p *TxPool) revalidate() []*core.Transaction {
+	dropped := []*core.Transaction{}
+	if p.state == nil {
+		return dropped
+	}
+
+	for from, queue := range p.senders {
+		var (
+			next    = p.state.NextNonce(from)
+			balance = p.state.Balance(from)
+			cost    uint64
+		)
+		for _, nonce := range queue.nonces() {
+			tx := queue.txx[nonce]
+			if nonce >= next {
+				sum, carry := bits.Add64(cost, txCost(tx), 0)
+				if carry == 0 && sum <= balance {
+					cost = sum
+					continue
+				}
+			}
+			dropped = append(dropped, tx)
+			p.remove(from, tx)
+		}
+	}
+
+	return dropped
 }
 
 // Contains checks if a transaction hash exists in the pool.
 func (p *TxPool) Contains(hash types.Hash) bool {
-	return p.all.Contains(hash)
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	_, ok := p.all[hash]
+	return ok
 }
 
-// Pending returns a slice of transactions in the pending pool.
-func (p *TxPool) Pending() []*core.Transaction {
-	return p.pending.All()
+// Get returns the transaction with the given hash, or nil if it is not in the
+// pool.
+func (p *TxPool) Get(hash types.Hash) *core.Transaction {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	return p.all[hash]
 }
 
-// ClearPending clears all transactions from the pending pool.
-func (p *TxPool) ClearPending() {
-	p.pending.Clear()
+// Len returns the number of transactions in the pool.
+func (p *TxPool) Len() int {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	return len(p.all)
 }
 
-// PendingCount returns the count of transactions in the pending pool.
-func (p *TxPool) PendingCount() int {
-	return p.pending.Count()
+// NextNonce returns the nonce the next transaction of the given address must
+// use to follow its queued transactions: the nonce after the gap-free run of
+// them starting at its next expected nonce.
+func (p *TxPool) NextNonce(address types.Address) uint64 {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	queue := p.senders[address]
+
+	var nonce uint64
+	if p.state != nil {
+		nonce = p.state.NextNonce(address)
+	} else if queue != nil && len(queue.txx) > 0 {
+		nonce = queue.lowest()
+	}
+	for queue.get(nonce) != nil {
+		nonce++
+	}
+
+	return nonce
 }
 
-// TxSortedMap is a sorted map implementation for transactions.
-type TxSortedMap struct {
-	lock   sync.RWMutex                // Mutex for concurrent access
-	lookup map[types.Hash]*core.Transaction // Map for fast lookup by hash
-	txx    *types.List                 // Sorted list of transactions
+// Pending returns up to n transactions that can be included in the next
+// block, best paying first. Every sender contributes a gap-free run of nonces
+// starting at its next expected nonce (its lowest queued nonce without a
+// nonce source), so a transaction is only returned after all transactions of
+// its sender with lower nonces. For the same pool contents the result is
+// always the same.
+func (p *TxPool) Pending(n int) []*core.Transaction {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	h := &txHeap{}
+	for from, queue := range p.senders {
+		nonce := queue.lowest()
+		if p.state != nil {
+			nonce = p.state.NextNonce(from)
+		}
+		if tx := queue.get(nonce); tx != nil {
+			*h = append(*h, txHeapItem{tx: tx, from: from})
+		}
+	}
+	heap.Init(h)
+
+	txx := []*core.Transaction{}
+	for h.Len() > 0 && len(txx) < n {
+		item := heap.Pop(h).(txHeapItem)
+		txx = append(txx, item.tx)
+
+		if next := p.senders[item.from].get(item.tx.Nonce + 1); next != nil {
+			heap.Push(h, txHeapItem{tx: next, from: item.from})
+		}
+	}
+
+	return txx
 }
 
-// NewTxSortedMap creates a new instance of TxSortedMap.
-func NewTxSortedMap() *TxSortedMap {
-	return &TxSortedMap{
-		lookup: make(map[types.Hash]*core.Transaction),
-		txx:    types.NewList(),
+// costBefore returns cost plus the cost of the transactions of a sender queued
+// with a lower nonce. ok is false if the sum overflows. It must be called with
+// the lock held.
+func (p *TxPool) costBefore(from types.Address, nonce uint64, cost uint64) (total uint64, ok bool) {
+	total = cost
+	if queue := p.senders[from]; queue != nil {
+		for n, tx := range queue.txx {
+			if n >= nonce {
+				continue
+			}
+			sum, carry := bits.Add64(total, txCost(tx), 0)
+			if carry != 0 {
+				return 0, false
+			}
+			total = sum
+		}
 	}
+
+	return total, true
 }
 
-// First returns the first transaction in the sorted map.
-func (t *TxSortedMap) First() *core.Transaction {
-	t.lock.RLock()
-	defer t.lock.RUnlock()
+// remove removes a transaction of the given sender. It must be called with
+// the lock held.
+func (p *TxPool) remove(from types.Address, tx *core.Transaction) {
+	delete(p.all, tx.Hash(core.TxHasher{}))
 
-	first := t.txx.First()
-	if first == nil {
-		return nil
+	queue := p.senders[from]
+	if queue == nil || queue.txx[tx.Nonce] != tx {
+		return
+	}
+	delete(queue.txx, tx.Nonce)
+	if len(queue.txx) == 0 {
+		delete(p.senders, from)
 	}
-	return t.lookup[first.Hash(core.TxHasher{})]
 }
 
-// Get retrieves a transaction by its hash.
-func (t *TxSortedMap) Get(h types.Hash) *core.Transaction {
-	t.lock.RLock()
-	defer t.lock.RUnlock()
+// evictionCandidate returns the transaction to evict when the pool is full:
+// the lowest paying of the transactions with the highest nonce of each sender,
+// so eviction never leaves a gap in the queue of a sender. It must be called
+// with the lock held.
+func (p *TxPool) evictionCandidate() (*core.Transaction, types.Address) {
+	var (
+		victim     *core.Transaction
+		victimFrom types.Address
+	)
+	for from, queue := range p.senders {
+		tx := queue.get(queue.highest())
+		if victim == nil || outbids(victim, tx) || (!outbids(tx, victim) && bytes.Compare(from[:], victimFrom[:]) > 0) {
+			victim, victimFrom = tx, from
+		}
+	}
 
-	return t.lookup[h]
+	return victim, victimFrom
 }
 
-// Add adds a transaction to the sorted map.
-func (t *TxSortedMap) Add(tx *core.Transaction) {
-	hash := tx.Hash(core.TxHasher{})
+// txCost returns the most a transaction takes from the balance of its sender:
+// its value, its fees and the stake it bonds. It saturates instead of
+// overflowing.
+func txCost(tx *core.Transaction) uint64 {
+	cost, carry := bits.Add64(tx.Value, tx.MaxFee(), 0)
+	if stake, ok := tx.TxInner.(core.StakeTx); ok && carry == 0 {
+		cost, carry = bits.Add64(cost, stake.Amount, 0)
+	}
+	if carry != 0 {
+		return math.MaxUint64
+	}
+
+	return cost
+}
+
+// txSize returns the size of the gob encoding of a transaction.
+func txSize(tx *core.Transaction) (int, error) {
+	buf := &bytes.Buffer{}
+	if err := tx.Encode(gob.NewEncoder(buf)); err != nil {
+		return 0, err
+	}
 
-	t.lock.Lock()
-	defer t.lock.Unlock()
+	return buf.Len(), nil
+}
 
-	if _, ok := t.lookup[hash]; !ok {
-		t.lookup[hash] = tx
-		t.txx.Insert(tx)
+// reject returns the error reporting that a transaction was not admitted.
+func reject(hash types.Hash, reason RejectReason, err error) error {
+	return &TxRejectedError{
+		Hash:   hash,
+		Reason: reason,
+		Err:    err,
 	}
 }
 
-// Remove removes a transaction from the sorted map by its hash.
-func (t *TxSortedMap) Remove(h types.Hash) {
-	t.lock.Lock()
-	defer t.lock.Unlock()
+// replaces reports whether tx pays enough to replace old.
+func replaces(tx, old *core.Transaction) bool {
+	return tx.MinFee() >= minReplacementFee(old) && outbids(tx, old)
+}
 
-	tx := t.lookup[h]
-	if tx == nil {
-		return
+// minReplacementFee returns the fee a transaction must pay to replace tx.
+func minReplacementFee(tx *core.Transaction) uint64 {
+	fee := tx.MinFee()
+	bump := fee / 100 * ReplaceFeeBump
+	if fee+bump < fee {
+		return fee
 	}
 
-	t.txx.Remove(tx)
-	delete(t.lookup, h)
+	return fee + bump
 }
 
-// Count returns the number of transactions in the sorted map.
-func (t *TxSortedMap) Count() int {
-	t.lock.RLock()
-	defer t.lock.RUnlock()
+// outbids reports whether a pays a higher fee than b.
+func outbids(a, b *core.Transaction) bool {
+	return a.MinFee() > b.MinFee()
+}
 
-	return len(t.lookup)
+// txQueue holds the transactions of a sender by nonce.
+type txQueue struct {
+	txx map[uint64]*core.Transaction
 }
 
-// Contains checks if a transaction hash exists in the sorted map.
-func (t *TxSortedMap) Contains(h types.Hash) bool {
-	t.lock.RLock()
-	defer t.lock.RUnlock()
+func (q *txQueue) get(nonce uint64) *core.Transaction {
+	if q == nil {
+		return nil
+	}
 
-	_, ok := t.lookup[h]
-	return ok
+	return q.txx[nonce]
+}
+
+// lowest returns the lowest queued nonce.
+func (q *txQueue) lowest() uint64 {
+	nonces := q.nonces()
+	return nonces[0]
 }
 
-// All returns all transactions in the sorted map as a slice.
-func (t *TxSortedMap) All() []*core.Transaction {
-	t.lock.RLock()
-	defer t.lock.RUnlock()
+// highest returns the highest queued nonce.
+func (q *txQueue) highest() uint64 {
+	nonces := q.nonces()
+	return nonces[len(nonces)-1]
+}
 
-	var all []*core.Transaction
-	for _, tx := range t.lookup {
-		all = append(all, tx)
+// nonces returns the queued nonces in ascending order.
+func (q *txQueue) nonces() []uint64 {
+	nonces := make([]uint64, 0, len(q.txx))
+	for nonce := range q.txx {
+		nonces = append(nonces, nonce)
 	}
-	return all
+	sort.Slice(nonces, func(i, j int) bool {
+		return nonces[i] < nonces[j]
+	})
+
+	return nonces
+}
+
+type txHeapItem struct {
+	tx   *core.Transaction
+	from types.Address
 }
 
-// Clear removes all transactions from the sorted map.
-func (t *TxSortedMap) Clear() {
-	t.lock.Lock()
-	defer t.lock.Unlock()
+// txHeap orders transactions by fee, highest first. Ties are broken by sender
+// address so the order does not depend on map iteration.
+type txHeap []txHeapItem
+
+func (h txHeap) Len() int { return len(h) }
+
+func (h txHeap) Less(i, j int) bool {
+	if fi, fj := h[i].tx.MinFee(), h[j].tx.MinFee(); fi != fj {
+		return fi > fj
+	}
+	return bytes.Compare(h[i].from[:], h[j].from[:]) < 0
+}
+
+func (h txHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *txHeap) Push(x any) { *h = append(*h, x.(txHeapItem)) }
 
-	t.lookup = make(map[types.Hash]*core.Transaction)
-	t.txx.Clear()
+func (h *txHeap) Pop() any {
+	old := *h
+	item := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return item
 }
@@ -3,14 +3,16 @@ package tests
 import (
 	"testing"
 
+	"github.com/blu-fi-tech-inc/blufi-network/core"
 	"github.com/blu-fi-tech-inc/blufi-network/crypto"
+	"github.com/blu-fi-tech-inc/blufi-network/types"
 	"github.com/stretchr/testify/assert"
 )
 
 func TestAccounState(t *testing.T) {
-	state := NewAccountState()
+	state := core.NewAccountState()
 
-	address := crypto.GeneratePrivateKey().PublicKey().Address()
+	address := newAddress(t)
 	account := state.CreateAccount(address)
 
 	assert.Equal(t, account.Address, address)
@@ -22,10 +24,10 @@ func TestAccounState(t *testing.T) {
 }
 
 func TestTransferFailInsufficientBalance(t *testing.T) {
-	state := NewAccountState()
+	state := core.NewAccountState()
 
-	addressBob := crypto.GeneratePrivateKey().PublicKey().Address()
-	addressAlice := crypto.GeneratePrivateKey().PublicKey().Address()
+	addressBob := newAddress(t)
+	addressAlice := newAddress(t)
 
 	accountBob := state.CreateAccount(addressBob)
 	accountBob.Balance = 99
@@ -38,10 +40,10 @@ func TestTransferFailInsufficientBalance(t *testing.T) {
 }
 
 func TestTransferSuccessEmpyToAccount(t *testing.T) {
-	state := NewAccountState()
+	state := core.NewAccountState()
 
-	addressBob := crypto.GeneratePrivateKey().PublicKey().Address()
-	addressAlice := crypto.GeneratePrivateKey().PublicKey().Address()
+	addressBob := newAddress(t)
+	addressAlice := newAddress(t)
 
 	accountBob := state.CreateAccount(addressBob)
 	accountBob.Balance = 100
@@ -51,4 +53,14 @@ func TestTransferSuccessEmpyToAccount(t *testing.T) {
 	amount := uint64(100)
 	assert.Nil(t, state.Transfer(addressBob, addressAlice, amount))
 	assert.Equal(t, accountAlice.Balance, amount)
-}
\ No newline at end of file
+}
+
+// newAddress returns the address of a new key.
+func newAddress(t *testing.T) types.Address {
+	_, pubKey, err := crypto.GenerateKeyPair()
+	assert.Nil(t, err)
+	address, err := pubKey.Address()
+	assert.Nil(t, err)
+
+	return address
+}
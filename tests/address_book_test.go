@@ -0,0 +1,84 @@
+package tests
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/blu-fi-tech-inc/blufi-network/network"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAddressBookAdd(t *testing.T) {
+	b, err := network.NewAddressBook("")
+	assert.Nil(t, err)
+
+	assert.True(t, b.Add("10.0.0.1:3000"))
+	assert.False(t, b.Add("10.0.0.1:3000"))
+	assert.False(t, b.Add("10.0.0.1"))
+	assert.False(t, b.Add(":3000"))
+	assert.Equal(t, 1, b.Len())
+}
+
+func TestAddressBookDialCandidates(t *testing.T) {
+	b, err := network.NewAddressBook("")
+	assert.Nil(t, err)
+
+	b.Add("10.0.0.1:3000")
+	b.Add("10.0.0.2:3000")
+	b.Add("10.0.0.3:3000")
+	b.Good("10.0.0.2:3000")
+
+	none := func(string) bool { return false }
+	assert.Equal(t, []string{"10.0.0.2:3000", "10.0.0.1:3000"}, b.DialCandidates(2, none))
+
+	// Addresses that just failed wait before they are dialed again.
+	b.Attempt("10.0.0.1:3000")
+	b.Failed("10.0.0.1:3000")
+	assert.Equal(t, []string{"10.0.0.2:3000", "10.0.0.3:3000"}, b.DialCandidates(5, none))
+
+	connected := func(addr string) bool { return addr == "10.0.0.2:3000" }
+	assert.Equal(t, []string{"10.0.0.3:3000"}, b.DialCandidates(5, connected))
+}
+
+func TestAddressBookForgetsFailingAddrs(t *testing.T) {
+	b, err := network.NewAddressBook("")
+	assert.Nil(t, err)
+
+	b.Add("10.0.0.1:3000")
+	b.AddSeed("10.0.0.2:3000")
+	for i := 0; i < 10; i++ {
+		b.Failed("10.0.0.1:3000")
+		b.Failed("10.0.0.2:3000")
+	}
+
+	_, ok := b.Get("10.0.0.1:3000")
+	assert.False(t, ok)
+
+	info, ok := b.Get("10.0.0.2:3000")
+	assert.True(t, ok)
+	assert.Equal(t, 10, info.Failures)
+
+	b.Good("10.0.0.2:3000")
+	info, _ = b.Get("10.0.0.2:3000")
+	assert.Equal(t, 0, info.Failures)
+	assert.False(t, info.LastSeen.IsZero())
+}
+
+func TestAddressBookPersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "peers.json")
+
+	b, err := network.NewAddressBook(path)
+	assert.Nil(t, err)
+	b.Add("10.0.0.1:3000")
+	b.Good("10.0.0.2:3000")
+	assert.Nil(t, b.Save())
+
+	b, err = network.NewAddressBook(path)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, b.Len())
+	assert.Equal(t, []string{"10.0.0.2:3000"}, b.Known(10))
+
+	info, ok := b.Get("10.0.0.2:3000")
+	assert.True(t, ok)
+	assert.Equal(t, 1, info.Score)
+}
@@ -0,0 +1,319 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/blu-fi-tech-inc/blufi-network/api"
+	"github.com/blu-fi-tech-inc/blufi-network/consensus"
+	"github.com/blu-fi-tech-inc/blufi-network/core"
+	"github.com/blu-fi-tech-inc/blufi-network/crypto"
+	"github.com/blu-fi-tech-inc/blufi-network/network"
+	"github.com/blu-fi-tech-inc/blufi-network/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAPIAccountRoutes(t *testing.T) {
+	privKey, pubKey, err := crypto.GenerateKeyPair()
+	assert.Nil(t, err)
+	sender, err := pubKey.Address()
+	assert.Nil(t, err)
+
+	accounts := core.NewAccountState()
+	accounts.AddBalance(sender, 1000)
+	bc := newBlockchainWithGenesis(t, accounts)
+	srv, _ := newAPITestServer(t, bc)
+
+	counter := concat(pushInt(7), pushBytes("count"), instr(core.InstrStore))
+	deploy := newContractTx(t, privKey, 0, nil, counter)
+	contract := core.ContractAddress(sender, 0)
+	call := newContractTx(t, privKey, 1, contract[:], nil)
+	stake := newStakingTx(t, privKey, 2, core.StakeTx{Amount: 400})
+	unstake := newStakingTx(t, privKey, 3, core.UnstakeTx{Amount: 100})
+	block := newTestBlock(t, bc, nil, blockOpts{stateRoot: true}, deploy, call, stake, unstake)
+	assert.Nil(t, bc.AddBlock(block))
+
+	var nonce struct {
+		Address string
+		Nonce   uint64
+	}
+	getJSON(t, srv, "/accounts/"+sender.String()+"/nonce", http.StatusOK, &nonce)
+	assert.Equal(t, sender.String(), nonce.Address)
+	assert.Equal(t, uint64(4), nonce.Nonce)
+
+	var proof struct {
+		TxHash    string
+		BlockHash string
+		Height    uint32
+		TxRoot    string
+		Index     uint32
+		Total     uint32
+		Siblings  []string
+	}
+	callHash := call.Hash(core.TxHasher{})
+	getJSON(t, srv, "/transactions/"+callHash.String()+"/proof", http.StatusOK, &proof)
+	assert.Equal(t, block.Hash(core.BlockHasher{}).String(), proof.BlockHash)
+	assert.Equal(t, uint32(1), proof.Height)
+	assert.Equal(t, uint32(1), proof.Index)
+	assert.Equal(t, uint32(4), proof.Total)
+
+	merkleProof := &core.MerkleProof{TxHash: decodeTestHash(t, proof.TxHash), Index: proof.Index, Total: proof.Total}
+	for _, sibling := range proof.Siblings {
+		merkleProof.Siblings = append(merkleProof.Siblings, decodeTestHash(t, sibling))
+	}
+	assert.Equal(t, callHash, merkleProof.TxHash)
+	assert.True(t, core.VerifyMerkleProof(decodeTestHash(t, proof.TxRoot), merkleProof))
+
+	var code struct {
+		Address string
+		Code    string
+	}
+	getJSON(t, srv, "/contracts/"+contract.String()+"/code", http.StatusOK, &code)
+	assert.Equal(t, hex.EncodeToString(counter), code.Code)
+
+	var storage struct {
+		Address string
+		Key     string
+		Value   string
+	}
+	getJSON(t, srv, "/contracts/"+contract.String()+"/storage/"+hex.EncodeToString([]byte("count")), http.StatusOK, &storage)
+	value, err := bc.GetStorage(contract, []byte("count"))
+	assert.Nil(t, err)
+	assert.Equal(t, hex.EncodeToString(value), storage.Value)
+
+	var stakeInfo struct {
+		Address   string
+		Stake     uint64
+		Unbonding uint64
+		Releases  []api.UnbondingRelease
+		Jailed    bool
+	}
+	getJSON(t, srv, "/stake/"+sender.String(), http.StatusOK, &stakeInfo)
+	assert.Equal(t, uint64(300), stakeInfo.Stake)
+	assert.Equal(t, uint64(100), stakeInfo.Unbonding)
+	assert.Equal(t, []api.UnbondingRelease{{Amount: 100, Height: 1 + core.UnbondingPeriod}}, stakeInfo.Releases)
+	assert.False(t, stakeInfo.Jailed)
+
+	unknown := newAddress(t)
+	getJSON(t, srv, "/contracts/"+unknown.String()+"/code", http.StatusNotFound, nil)
+	getJSON(t, srv, "/contracts/"+contract.String()+"/storage/"+hex.EncodeToString([]byte("other")), http.StatusNotFound, nil)
+	getJSON(t, srv, "/transactions/"+types.Hash{}.String()+"/proof", http.StatusNotFound, nil)
+	getJSON(t, srv, "/accounts/xyz/nonce", http.StatusBadRequest, nil)
+	getJSON(t, srv, "/stake/00", http.StatusBadRequest, nil)
+}
+
+func TestAPISubmitTransaction(t *testing.T) {
+	privKey, pubKey, err := crypto.GenerateKeyPair()
+	assert.Nil(t, err)
+	sender, err := pubKey.Address()
+	assert.Nil(t, err)
+
+	accounts := core.NewAccountState()
+	accounts.AddBalance(sender, 1000)
+	bc := newBlockchainWithGenesis(t, accounts)
+	srv, node := newAPITestServer(t, bc)
+
+	tx := newPoolTx(t, privKey, 0, 10)
+	tx.To = newAddress(t)
+	tx.Value = 100
+	assert.Nil(t, tx.Sign(privKey))
+
+	var hash string
+	postGob(t, srv, "/transactions", tx, http.StatusCreated, &hash)
+	assert.Equal(t, tx.Hash(core.TxHasher{}).String(), hash)
+	assert.True(t, node.pool.Contains(tx.Hash(core.TxHasher{})))
+
+	var pending []api.PendingTx
+	getJSON(t, srv, "/transactions/pending", http.StatusOK, &pending)
+	assert.Equal(t, []api.PendingTx{{
+		Hash:  hash,
+		From:  sender.String(),
+		To:    tx.To.String(),
+		Value: 100,
+		Nonce: 0,
+		Fee:   10,
+	}}, pending)
+
+	// Rejected transactions are reported with the reason of the mempool.
+	assert.Nil(t, bc.AddBlock(newTestBlock(t, bc, nil, blockOpts{stateRoot: true}, tx)))
+	replay := newPoolTx(t, privKey, 0, 20)
+	var rejection api.TxRejection
+	postGob(t, srv, "/transactions", replay, http.StatusBadRequest, &rejection)
+	assert.Equal(t, replay.Hash(core.TxHasher{}).String(), rejection.Hash)
+	assert.Equal(t, network.RejectNonceTooLow.String(), rejection.Reason)
+
+	resp, err := http.Post(srv.URL+"/transactions", "application/octet-stream", bytes.NewReader([]byte("not a tx")))
+	assert.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestAPISubmitBlock(t *testing.T) {
+	bc := newBlockchainWithGenesis(t, core.NewAccountState())
+	srv, _ := newAPITestServer(t, bc)
+
+	block := newTestBlock(t, bc, nil, blockOpts{stateRoot: true})
+	var hash string
+	postGob(t, srv, "/blocks", block, http.StatusCreated, &hash)
+	assert.Equal(t, block.Hash(core.BlockHasher{}).String(), hash)
+	assert.Equal(t, uint32(1), bc.Height())
+
+	// The same block does not extend the chain again.
+	postGob(t, srv, "/blocks", block, http.StatusBadRequest, nil)
+}
+
+func TestAPINodeRoutes(t *testing.T) {
+	bc := newBlockchainWithGenesis(t, core.NewAccountState())
+
+	// The node routes are unavailable until the node is set.
+	bare := httptest.NewServer(api.NewServer(api.ServerConfig{}, bc, consensus.NewStakeManager(bc), network.NewTxPool(10)).Handler())
+	defer bare.Close()
+	getJSON(t, bare, "/sync", http.StatusServiceUnavailable, nil)
+	getJSON(t, bare, "/peers/banned", http.StatusServiceUnavailable, nil)
+	postGob(t, bare, "/transactions", randomTxWithSignature(t), http.StatusServiceUnavailable, nil)
+
+	s, node := newAPIServer(t, bc)
+	srv := httptest.NewServer(s.Handler())
+	defer srv.Close()
+	admin := httptest.NewServer(s.AdminHandler())
+	defer admin.Close()
+	node.banned = []api.BannedPeer{{Host: "10.0.0.1", Until: time.Unix(100, 0).UTC(), Reason: "malformed"}}
+	node.status = api.SyncStatus{Syncing: true, Height: 1, TargetHeight: 10, FinalizedHash: "ab"}
+
+	var banned []api.BannedPeer
+	getJSON(t, srv, "/peers/banned", http.StatusOK, &banned)
+	assert.Equal(t, node.banned, banned)
+
+	// Bans are only lifted through the admin routes.
+	req, err := http.NewRequest(http.MethodDelete, srv.URL+"/peers/banned/10.0.0.1", nil)
+	assert.Nil(t, err)
+	resp, err := http.DefaultClient.Do(req)
+	assert.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	assert.Equal(t, 1, len(node.banned))
+
+	req, err = http.NewRequest(http.MethodDelete, admin.URL+"/peers/banned/10.0.0.1", nil)
+	assert.Nil(t, err)
+	resp, err = http.DefaultClient.Do(req)
+	assert.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	assert.Empty(t, node.banned)
+
+	resp, err = http.DefaultClient.Do(req)
+	assert.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	var status api.SyncStatus
+	getJSON(t, srv, "/sync", http.StatusOK, &status)
+	assert.Equal(t, node.status, status)
+}
+
+func TestAPIAdminOnlyOnLoopback(t *testing.T) {
+	bc := newBlockchainWithGenesis(t, core.NewAccountState())
+
+	// The server refuses to start before listening on any address.
+	for _, addr := range []string{":9001", "0.0.0.0:9001", "10.0.0.1:9001", "localhost:9001"} {
+		cfg := api.ServerConfig{ListenAddr: "127.0.0.1:0", AdminListenAddr: addr}
+		s := api.NewServer(cfg, bc, consensus.NewStakeManager(bc), network.NewTxPool(10))
+		assert.ErrorIs(t, s.Start(), api.ErrAdminNotLocal, addr)
+	}
+}
+
+// apiTestNode is the node behind the API in tests. Transactions are submitted
+// to its mempool.
+type apiTestNode struct {
+	pool   *network.TxPool
+	banned []api.BannedPeer
+	status api.SyncStatus
+}
+
+func (n *apiTestNode) SubmitTx(tx *core.Transaction) error {
+	return n.pool.Add(tx)
+}
+
+func (n *apiTestNode) BannedPeers() []api.BannedPeer {
+	return n.banned
+}
+
+func (n *apiTestNode) UnbanPeer(host string) bool {
+	for i, ban := range n.banned {
+		if ban.Host == host {
+			n.banned = append(n.banned[:i], n.banned[i+1:]...)
+			return true
+		}
+	}
+
+	return false
+}
+
+func (n *apiTestNode) SyncStatus() api.SyncStatus {
+	return n.status
+}
+
+func newAPITestServer(t *testing.T, bc *core.Blockchain) (*httptest.Server, *apiTestNode) {
+	s, node := newAPIServer(t, bc)
+	srv := httptest.NewServer(s.Handler())
+	t.Cleanup(srv.Close)
+
+	return srv, node
+}
+
+// newAPIServer makes an API server in front of a test node.
+func newAPIServer(t *testing.T, bc *core.Blockchain) (*api.Server, *apiTestNode) {
+	node := &apiTestNode{pool: network.NewTxPool(100)}
+	node.pool.SetStateSource(bc)
+
+	s := api.NewServer(api.ServerConfig{}, bc, consensus.NewStakeManager(bc), node.pool)
+	s.SetTxSubmitter(node)
+	s.SetPeerAdmin(node)
+	s.SetSyncProgress(node)
+
+	return s, node
+}
+
+// getJSON gets the path from the API, checks the status code and decodes the
+// JSON response into out unless it is nil.
+func getJSON(t *testing.T, srv *httptest.Server, path string, status int, out interface{}) {
+	resp, err := http.Get(srv.URL + path)
+	assert.Nil(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, status, resp.StatusCode, path)
+	if out != nil {
+		assert.Nil(t, json.NewDecoder(resp.Body).Decode(out))
+	}
+}
+
+// postGob posts the gob encoding of v to the API, checks the status code and
+// decodes the JSON response into out unless it is nil.
+func postGob(t *testing.T, srv *httptest.Server, path string, v interface{}, status int, out interface{}) {
+	buf := &bytes.Buffer{}
+	assert.Nil(t, gob.NewEncoder(buf).Encode(v))
+
+	resp, err := http.Post(srv.URL+path, "application/octet-stream", buf)
+	assert.Nil(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, status, resp.StatusCode, path)
+	if out != nil {
+		assert.Nil(t, json.NewDecoder(resp.Body).Decode(out))
+	}
+}
+
+func decodeTestHash(t *testing.T, s string) types.Hash {
+	b, err := hex.DecodeString(s)
+	assert.Nil(t, err)
+	hash, err := types.HashFromBytes(b)
+	assert.Nil(t, err)
+
+	return hash
+}
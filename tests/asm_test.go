@@ -0,0 +1,101 @@
+package tests
+
+import (
+	"encoding/binary"
+	"errors"
+	"testing"
+
+	"github.com/blu-fi-tech-inc/blufi-network/core"
+	"github.com/blu-fi-tech-inc/blufi-network/core/asm"
+	"github.com/blu-fi-tech-inc/blufi-network/types"
+	"github.com/stretchr/testify/assert"
+)
+
+const sumProgram = `
+; Sums 1..10 into "sum".
+    push 10             ; counter
+loop:
+    dup 1
+    push "sum"
+    get
+    add
+    push "sum"
+    store
+    push 1
+    swap 1
+    sub                 ; counter -= 1
+    dup 1
+    push 0
+    swap 1
+    gt
+    push loop
+    jumpi
+`
+
+func TestAssemble(t *testing.T) {
+	code, err := asm.Assemble(sumProgram)
+	assert.Nil(t, err)
+
+	contractState := core.NewState()
+	assert.Nil(t, core.NewVM(code, contractState, core.CallContext{}, 1_000_000).Run())
+
+	value, err := contractState.GetStorage(types.Address{}, []byte("sum"))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(55), int64(binary.LittleEndian.Uint64(value)))
+}
+
+func TestAssembleEncoding(t *testing.T) {
+	code, err := asm.Assemble("push 0x0102\npushb\t7\nstart:\tdup \t 2\npush start\njump")
+	assert.Nil(t, err)
+	assert.Equal(t, concat(
+		pushInt(0x0102),
+		[]byte{byte(core.InstrPushByte), 7},
+		instr(core.InstrJumpDest),
+		[]byte{byte(core.InstrDup), 2},
+		pushInt(11),
+		instr(core.InstrJump),
+	), code)
+}
+
+func TestDisassembleRoundTrip(t *testing.T) {
+	code, err := asm.Assemble(sumProgram)
+	assert.Nil(t, err)
+
+	src, err := asm.Disassemble(code)
+	assert.Nil(t, err)
+
+	again, err := asm.Assemble(src)
+	assert.Nil(t, err)
+	assert.Equal(t, code, again)
+
+	_, err = asm.Disassemble([]byte{0xff})
+	assert.NotNil(t, err)
+	_, err = asm.Disassemble([]byte{byte(core.InstrPushInt), 1})
+	assert.NotNil(t, err)
+}
+
+func TestAssembleErrors(t *testing.T) {
+	cases := []struct {
+		src  string
+		line int
+	}{
+		{"push 1\nfrob", 2},
+		{"push 1\n\npush nowhere", 3},
+		{"a:\na:", 2},
+		{"add 1", 1},
+		{"push", 1},
+		{"; comment\npushb 256", 2},
+		{"dup 0", 1},
+		{"push \"unterminated", 1},
+		{"1abel: add", 1},
+	}
+
+	for _, c := range cases {
+		_, err := asm.Assemble(c.src)
+
+		var asmErr *asm.Error
+		if assert.True(t, errors.As(err, &asmErr), c.src) {
+			assert.Equal(t, c.line, asmErr.Line, c.src)
+		}
+	}
+}
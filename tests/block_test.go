@@ -2,16 +2,20 @@ package tests
 
 import (
 	"bytes"
+	"encoding/gob"
 	"testing"
 	"time"
 
+	"github.com/blu-fi-tech-inc/blufi-network/core"
 	"github.com/blu-fi-tech-inc/blufi-network/crypto"
 	"github.com/blu-fi-tech-inc/blufi-network/types"
+	"github.com/go-kit/log"
 	"github.com/stretchr/testify/assert"
 )
 
 func TestSignBlock(t *testing.T) {
-	privKey := crypto.GeneratePrivateKey()
+	privKey, _, err := crypto.GenerateKeyPair()
+	assert.Nil(t, err)
 	b := randomBlock(t, 0, types.Hash{})
 
 	assert.Nil(t, b.Sign(privKey))
@@ -19,14 +23,16 @@ func TestSignBlock(t *testing.T) {
 }
 
 func TestVerifyBlock(t *testing.T) {
-	privKey := crypto.GeneratePrivateKey()
+	privKey, _, err := crypto.GenerateKeyPair()
+	assert.Nil(t, err)
 	b := randomBlock(t, 0, types.Hash{})
 
 	assert.Nil(t, b.Sign(privKey))
 	assert.Nil(t, b.Verify())
 
-	otherPrivKey := crypto.GeneratePrivateKey()
-	b.Validator = otherPrivKey.PublicKey()
+	_, otherPubKey, err := crypto.GenerateKeyPair()
+	assert.Nil(t, err)
+	b.Validator = *otherPubKey
 	assert.NotNil(t, b.Verify())
 
 	b.Height = 100
@@ -36,38 +42,107 @@ func TestVerifyBlock(t *testing.T) {
 func TestDecodeEncodeBlock(t *testing.T) {
 	b := randomBlock(t, 1, types.Hash{})
 	buf := &bytes.Buffer{}
-	assert.Nil(t, b.Encode(NewGobBlockEncoder(buf)))
+	assert.Nil(t, b.Encode(gob.NewEncoder(buf)))
 
-	bDecode := new(Block)
-	assert.Nil(t, bDecode.Decode(NewGobBlockDecoder(buf)))
+	bDecode := new(core.Block)
+	assert.Nil(t, bDecode.Decode(gob.NewDecoder(buf)))
 
 	assert.Equal(t, b.Header, bDecode.Header)
 
+	assert.Equal(t, len(b.Transactions), len(bDecode.Transactions))
 	for i := 0; i < len(b.Transactions); i++ {
-		b.Transactions[i].hash = types.Hash{}
-		assert.Equal(t, b.Transactions[i], bDecode.Transactions[i])
+		assert.Equal(t, b.Transactions[i].Hash(core.TxHasher{}), bDecode.Transactions[i].Hash(core.TxHasher{}))
+		assert.Equal(t, b.Transactions[i].Signature, bDecode.Transactions[i].Signature)
 	}
 
 	assert.Equal(t, b.Validator, bDecode.Validator)
 	assert.Equal(t, b.Signature, bDecode.Signature)
+	assert.Nil(t, bDecode.Verify())
 }
 
-func randomBlock(t *testing.T, height uint32, prevBlockHash types.Hash) *Block {
-	privKey := crypto.GeneratePrivateKey()
+func randomBlock(t *testing.T, height uint32, prevBlockHash types.Hash) *core.Block {
+	privKey, _, err := crypto.GenerateKeyPair()
+	assert.Nil(t, err)
 	tx := randomTxWithSignature(t)
-	header := &Header{
+	header := &core.Header{
 		Version:       1,
 		PrevBlockHash: prevBlockHash,
 		Height:        height,
 		Timestamp:     time.Now().UnixNano(),
 	}
 
-	b, err := NewBlock(header, []*Transaction{tx})
+	b, err := core.NewBlock(header, []*core.Transaction{tx})
 	assert.Nil(t, err)
-	dataHash, err := CalculateDataHash(b.Transactions)
-	assert.Nil(t, err)
-	b.Header.DataHash = dataHash
+	b.Header.DataHash = core.CalculateTxRoot(b.Transactions)
 	assert.Nil(t, b.Sign(privKey))
 
 	return b
-}
\ No newline at end of file
+}
+
+// blockOpts configures the blocks made by newTestBlock.
+type blockOpts struct {
+	key       *crypto.PrivateKey // Signs the block, a new key if nil
+	timestamp int64              // Timestamp of the block, the current time if zero
+	stateRoot bool               // Whether the block commits to the state root computed by the chain
+}
+
+// newTestBlock makes a signed block with the transactions on top of prev, or
+// of the tip of bc if prev is nil. Without either it makes a genesis block,
+// which keeps a zero timestamp unless one is given so every chain of a test
+// can share it.
+//
+// Blocks other than the genesis block are BlockVersionSeed blocks. With
+// stateRoot they commit to the state root computed by bc, otherwise they keep
+// the state root of prev, which is only right for blocks without transactions.
+func newTestBlock(t *testing.T, bc *core.Blockchain, prev *core.Header, opts blockOpts, txx ...*core.Transaction) *core.Block {
+	var err error
+	if prev == nil && bc != nil {
+		prev, err = bc.GetHeader(bc.Height())
+		assert.Nil(t, err)
+	}
+
+	var b *core.Block
+	if prev == nil {
+		b, err = core.NewBlock(&core.Header{Version: core.BlockVersionLegacy}, txx)
+		assert.Nil(t, err)
+		b.DataHash = core.CalculateTxRoot(b.Transactions)
+	} else {
+		b, err = core.NewBlockFromPrevHeader(prev, txx)
+		assert.Nil(t, err)
+		b.StateRoot = prev.StateRoot
+	}
+	if opts.timestamp != 0 {
+		b.Timestamp = opts.timestamp
+	}
+
+	key := opts.key
+	if key == nil {
+		key, _, err = crypto.GenerateKeyPair()
+		assert.Nil(t, err)
+	}
+	// The validator receives the gas fees, which are part of the state root.
+	b.Validator = crypto.PublicKey{PublicKey: &key.PublicKey}
+	if opts.stateRoot {
+		b.StateRoot, err = bc.ComputeStateRoot(b)
+		assert.Nil(t, err)
+	}
+	assert.Nil(t, b.Sign(key))
+
+	return b
+}
+
+// newTestBlocks makes a chain of n blocks on top of genesis, each committing
+// to the state root of a chain that starts with empty accounts.
+func newTestBlocks(t *testing.T, genesis *core.Block, n int) []*core.Block {
+	bc, err := core.NewBlockchain(core.NewMemStore(), log.NewNopLogger(), core.NewAccountState(), genesis)
+	assert.Nil(t, err)
+
+	blocks := []*core.Block{}
+	for i := 0; i < n; i++ {
+		b := newTestBlock(t, bc, nil, blockOpts{stateRoot: true})
+		assert.Nil(t, bc.AddBlock(b))
+		blocks = append(blocks, b)
+	}
+
+	return blocks
+}
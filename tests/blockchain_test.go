@@ -1,9 +1,9 @@
 package tests
 
 import (
-	"fmt"
 	"testing"
 
+	"github.com/blu-fi-tech-inc/blufi-network/core"
 	"github.com/blu-fi-tech-inc/blufi-network/crypto"
 	"github.com/blu-fi-tech-inc/blufi-network/types"
 	"github.com/go-kit/log"
@@ -11,133 +11,118 @@ import (
 )
 
 func TestSendNativeTransferTamper(t *testing.T) {
-	bc := newBlockchainWithGenesis(t)
-	signer := crypto.GeneratePrivateKey()
-
-	block := randomBlock(t, uint32(1), getPrevBlockHash(t, bc, uint32(1)))
-	assert.Nil(t, block.Sign(signer))
-
-	privKeyBob := crypto.GeneratePrivateKey()
-	privKeyAlice := crypto.GeneratePrivateKey()
+	privKeyBob, pubKeyBob, err := crypto.GenerateKeyPair()
+	assert.Nil(t, err)
+	addressBob, err := pubKeyBob.Address()
+	assert.Nil(t, err)
 	amount := uint64(100)
 
-	accountBob := bc.accountState.CreateAccount(privKeyBob.PublicKey().Address())
-	accountBob.Balance = amount
+	accounts := core.NewAccountState()
+	accounts.AddBalance(addressBob, amount)
+	bc := newBlockchainWithGenesis(t, accounts)
 
-	tx := NewTransaction([]byte{})
-	tx.From = privKeyBob.PublicKey()
-	tx.To = privKeyAlice.PublicKey()
+	tx := core.NewTransaction([]byte{})
+	tx.To = newAddress(t)
 	tx.Value = amount
-	tx.Sign(privKeyBob)
-	tx.hash = types.Hash{}
+	assert.Nil(t, tx.Sign(privKeyBob))
 
-	hackerPrivKey := crypto.GeneratePrivateKey()
-	tx.To = hackerPrivKey.PublicKey()
+	// The transaction reaches the chain without the hash cached by Sign.
+	tx = decodeTx(t, tx)
+	hacker := newAddress(t)
+	tx.To = hacker
 
-	block.AddTransaction(tx)
-	assert.NotNil(t, bc.AddBlock(block)) // this should fail
+	assert.NotNil(t, bc.AddBlock(newTestBlock(t, bc, nil, blockOpts{}, tx))) // this should fail
 
-	_, err := bc.accountState.GetAccount(hackerPrivKey.PublicKey().Address())
-	assert.Equal(t, err, ErrAccountNotFound)
+	assert.Equal(t, uint64(0), bc.Balance(hacker))
+	assert.Equal(t, amount, bc.Balance(addressBob))
 }
 
 func TestSendNativeTransferInsuffientBalance(t *testing.T) {
-	bc := newBlockchainWithGenesis(t)
-	signer := crypto.GeneratePrivateKey()
-
-	block := randomBlock(t, uint32(1), getPrevBlockHash(t, bc, uint32(1)))
-	assert.Nil(t, block.Sign(signer))
-
-	privKeyBob := crypto.GeneratePrivateKey()
-	privKeyAlice := crypto.GeneratePrivateKey()
+	privKeyBob, pubKeyBob, err := crypto.GenerateKeyPair()
+	assert.Nil(t, err)
+	addressBob, err := pubKeyBob.Address()
+	assert.Nil(t, err)
+	addressAlice := newAddress(t)
 	amount := uint64(100)
 
-	accountBob := bc.accountState.CreateAccount(privKeyBob.PublicKey().Address())
-	accountBob.Balance = uint64(99)
+	accounts := core.NewAccountState()
+	accounts.AddBalance(addressBob, 99)
+	bc := newBlockchainWithGenesis(t, accounts)
 
-	tx := NewTransaction([]byte{})
-	tx.From = privKeyBob.PublicKey()
-	tx.To = privKeyAlice.PublicKey()
+	tx := core.NewTransaction([]byte{})
+	tx.To = addressAlice
 	tx.Value = amount
-	tx.Sign(privKeyBob)
-	tx.hash = types.Hash{}
+	assert.Nil(t, tx.Sign(privKeyBob))
 
-	fmt.Printf("alice => %s\n", privKeyAlice.PublicKey().Address())
-	fmt.Printf("bob => %s\n", privKeyBob.PublicKey().Address())
+	assert.NotNil(t, bc.AddBlock(newTestBlock(t, bc, nil, blockOpts{}, tx))) // the overdrawn transfer rejects the block
 
-	block.AddTransaction(tx)
-	assert.Nil(t, bc.AddBlock(block))
+	assert.Equal(t, uint64(0), bc.Balance(addressAlice))
 
-	_, err := bc.accountState.GetAccount(privKeyAlice.PublicKey().Address())
-	assert.NotNil(t, err)
-
-	hash := tx.Hash(TxHasher{})
+	hash := tx.Hash(core.TxHasher{})
 	_, err = bc.GetTxByHash(hash)
 	assert.NotNil(t, err)
 }
 
 func TestSendNativeTransferSuccess(t *testing.T) {
-	bc := newBlockchainWithGenesis(t)
-
-	signer := crypto.GeneratePrivateKey()
-
-	block := randomBlock(t, uint32(1), getPrevBlockHash(t, bc, uint32(1)))
-	assert.Nil(t, block.Sign(signer))
-
-	privKeyBob := crypto.GeneratePrivateKey()
-	privKeyAlice := crypto.GeneratePrivateKey()
+	privKeyBob, pubKeyBob, err := crypto.GenerateKeyPair()
+	assert.Nil(t, err)
+	addressBob, err := pubKeyBob.Address()
+	assert.Nil(t, err)
+	addressAlice := newAddress(t)
 	amount := uint64(100)
 
-	accountBob := bc.accountState.CreateAccount(privKeyBob.PublicKey().Address())
-	accountBob.Balance = amount
+	accounts := core.NewAccountState()
+	accounts.AddBalance(addressBob, amount)
+	bc := newBlockchainWithGenesis(t, accounts)
 
-	tx := NewTransaction([]byte{})
-	tx.From = privKeyBob.PublicKey()
-	tx.To = privKeyAlice.PublicKey()
+	tx := core.NewTransaction([]byte{})
+	tx.To = addressAlice
 	tx.Value = amount
-	tx.Sign(privKeyBob)
-	block.AddTransaction(tx)
+	assert.Nil(t, tx.Sign(privKeyBob))
 
-	assert.Nil(t, bc.AddBlock(block))
+	assert.Nil(t, bc.AddBlock(newTestBlock(t, bc, nil, blockOpts{stateRoot: true}, tx)))
 
-	accountAlice, err := bc.accountState.GetAccount(privKeyAlice.PublicKey().Address())
-	assert.Nil(t, err)
-	assert.Equal(t, amount, accountAlice.Balance)
+	assert.Equal(t, amount, bc.Balance(addressAlice))
+	assert.Equal(t, uint64(0), bc.Balance(addressBob))
 }
 
 func TestAddBlock(t *testing.T) {
-	bc := newBlockchainWithGenesis(t)
+	bc := newBlockchainWithGenesis(t, core.NewAccountState())
 
 	lenBlocks := 1000
 	for i := 0; i < lenBlocks; i++ {
-		block := randomBlock(t, uint32(i+1), getPrevBlockHash(t, bc, uint32(i+1)))
-		assert.Nil(t, bc.AddBlock(block))
+		assert.Nil(t, bc.AddBlock(newTestBlock(t, bc, nil, blockOpts{stateRoot: true})))
 	}
 
 	assert.Equal(t, bc.Height(), uint32(lenBlocks))
-	assert.Equal(t, len(bc.headers), lenBlocks+1)
+	for i := 0; i <= lenBlocks; i++ {
+		assert.True(t, bc.HasBlock(uint32(i)))
+	}
 	assert.NotNil(t, bc.AddBlock(randomBlock(t, 89, types.Hash{})))
 }
 
 func TestNewBlockchain(t *testing.T) {
-	bc := newBlockchainWithGenesis(t)
-	assert.NotNil(t, bc.validator)
+	bc := newBlockchainWithGenesis(t, core.NewAccountState())
 	assert.Equal(t, bc.Height(), uint32(0))
+
+	// Blocks are validated: one that does not extend the genesis block is
+	// rejected.
+	assert.NotNil(t, bc.AddBlock(randomBlock(t, 1, types.Hash{})))
 }
 
 func TestHasBlock(t *testing.T) {
-	bc := newBlockchainWithGenesis(t)
+	bc := newBlockchainWithGenesis(t, core.NewAccountState())
 	assert.True(t, bc.HasBlock(0))
 	assert.False(t, bc.HasBlock(1))
 	assert.False(t, bc.HasBlock(100))
 }
 
 func TestGetBlock(t *testing.T) {
-	bc := newBlockchainWithGenesis(t)
+	bc := newBlockchainWithGenesis(t, core.NewAccountState())
 	lenBlocks := 100
 
 	for i := 0; i < lenBlocks; i++ {
-		block := randomBlock(t, uint32(i+1), getPrevBlockHash(t, bc, uint32(i+1)))
+		block := newTestBlock(t, bc, nil, blockOpts{stateRoot: true})
 		assert.Nil(t, bc.AddBlock(block))
 
 		fetchedBlock, err := bc.GetBlock(block.Height)
@@ -147,11 +132,11 @@ func TestGetBlock(t *testing.T) {
 }
 
 func TestGetHeader(t *testing.T) {
-	bc := newBlockchainWithGenesis(t)
+	bc := newBlockchainWithGenesis(t, core.NewAccountState())
 	lenBlocks := 1000
 
 	for i := 0; i < lenBlocks; i++ {
-		block := randomBlock(t, uint32(i+1), getPrevBlockHash(t, bc, uint32(i+1)))
+		block := newTestBlock(t, bc, nil, blockOpts{stateRoot: true})
 		assert.Nil(t, bc.AddBlock(block))
 		header, err := bc.GetHeader(block.Height)
 		assert.Nil(t, err)
@@ -160,21 +145,15 @@ func TestGetHeader(t *testing.T) {
 }
 
 func TestAddBlockToHigh(t *testing.T) {
-	bc := newBlockchainWithGenesis(t)
+	bc := newBlockchainWithGenesis(t, core.NewAccountState())
 
-	assert.Nil(t, bc.AddBlock(randomBlock(t, 1, getPrevBlockHash(t, bc, uint32(1)))))
+	assert.Nil(t, bc.AddBlock(newTestBlock(t, bc, nil, blockOpts{stateRoot: true})))
 	assert.NotNil(t, bc.AddBlock(randomBlock(t, 3, types.Hash{})))
 }
 
-func newBlockchainWithGenesis(t *testing.T) *Blockchain {
-	bc, err := NewBlockchain(log.NewNopLogger(), randomBlock(t, 0, types.Hash{}))
+func newBlockchainWithGenesis(t *testing.T, accounts *core.AccountState) *core.Blockchain {
+	bc, err := core.NewBlockchain(core.NewMemStore(), log.NewNopLogger(), accounts, newTestBlock(t, nil, nil, blockOpts{}))
 	assert.Nil(t, err)
 
 	return bc
 }
-
-func getPrevBlockHash(t *testing.T, bc *Blockchain, height uint32) types.Hash {
-	prevHeader, err := bc.GetHeader(height - 1)
-	assert.Nil(t, err)
-	return BlockHasher{}.Hash(prevHeader)
-}
\ No newline at end of file
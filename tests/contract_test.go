@@ -0,0 +1,107 @@
+package tests
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/blu-fi-tech-inc/blufi-network/core"
+	"github.com/blu-fi-tech-inc/blufi-network/crypto"
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestContractDeployAndCall(t *testing.T) {
+	bc, err := core.NewBlockchain(core.NewMemStore(), log.NewNopLogger(), core.NewAccountState(), newTestBlock(t, nil, nil, blockOpts{}))
+	assert.Nil(t, err)
+
+	privKey, pubKey, err := crypto.GenerateKeyPair()
+	assert.Nil(t, err)
+	sender, err := pubKey.Address()
+	assert.Nil(t, err)
+
+	// count += 1
+	counter := concat(
+		pushBytes("count"),
+		instr(core.InstrGet),
+		pushInt(1),
+		instr(core.InstrAdd),
+		pushBytes("count"),
+		instr(core.InstrStore),
+	)
+
+	deployA := newContractTx(t, privKey, 0, nil, counter)
+	deployB := newContractTx(t, privKey, 1, nil, counter)
+	assert.Nil(t, bc.AddBlock(newTestBlock(t, bc, nil, blockOpts{stateRoot: true}, deployA, deployB)))
+
+	contractA := core.ContractAddress(sender, 0)
+	contractB := core.ContractAddress(sender, 1)
+	assert.NotEqual(t, contractA, contractB)
+
+	receipt, err := bc.GetReceipt(deployA.Hash(core.TxHasher{}))
+	assert.Nil(t, err)
+	assert.Nil(t, receipt.Err)
+	assert.Equal(t, contractA, receipt.ContractAddress)
+
+	code, err := bc.GetCode(contractA)
+	assert.Nil(t, err)
+	assert.Equal(t, counter, code)
+
+	callA := newContractTx(t, privKey, 2, contractA[:], nil)
+	callA2 := newContractTx(t, privKey, 3, contractA[:], nil)
+	callB := newContractTx(t, privKey, 4, contractB[:], nil)
+	assert.Nil(t, bc.AddBlock(newTestBlock(t, bc, nil, blockOpts{stateRoot: true}, callA, callA2, callB)))
+
+	// Both contracts use the same key, but each has its own storage.
+	value, err := bc.GetStorage(contractA, []byte("count"))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), int64(binary.LittleEndian.Uint64(value)))
+
+	value, err = bc.GetStorage(contractB, []byte("count"))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), int64(binary.LittleEndian.Uint64(value)))
+}
+
+func TestContractOutOfGasRevertsStorage(t *testing.T) {
+	bc, err := core.NewBlockchain(core.NewMemStore(), log.NewNopLogger(), core.NewAccountState(), newTestBlock(t, nil, nil, blockOpts{}))
+	assert.Nil(t, err)
+
+	privKey, pubKey, err := crypto.GenerateKeyPair()
+	assert.Nil(t, err)
+	sender, err := pubKey.Address()
+	assert.Nil(t, err)
+
+	// Store "k" = 1, then loop forever.
+	store := concat(pushInt(1), pushBytes("k"), instr(core.InstrStore))
+	loop := concat(
+		store,
+		instr(core.InstrJumpDest),
+		pushInt(int64(len(store))),
+		instr(core.InstrJump),
+	)
+
+	deploy := newContractTx(t, privKey, 0, nil, loop)
+	contract := core.ContractAddress(sender, 0)
+	call := newContractTx(t, privKey, 1, contract[:], nil)
+	assert.Nil(t, bc.AddBlock(newTestBlock(t, bc, nil, blockOpts{stateRoot: true}, deploy, call)))
+
+	receipt, err := bc.GetReceipt(call.Hash(core.TxHasher{}))
+	assert.Nil(t, err)
+	assert.ErrorIs(t, receipt.Err, core.ErrOutOfGas)
+	assert.Equal(t, call.GasLimit, receipt.GasUsed)
+
+	_, err = bc.GetStorage(contract, []byte("k"))
+	assert.ErrorIs(t, err, core.ErrKeyNotFound)
+	assert.Equal(t, uint64(2), bc.NextNonce(sender))
+}
+
+// newContractTx signs a transaction to the given contract address, or a
+// deployment of data if to is nil.
+func newContractTx(t *testing.T, privKey *crypto.PrivateKey, nonce uint64, to []byte, data []byte) *core.Transaction {
+	tx := core.NewTransaction(data)
+	copy(tx.To[:], to)
+	tx.Nonce = nonce
+	tx.GasLimit = 100_000
+	assert.Nil(t, tx.Sign(privKey))
+
+	return tx
+}
@@ -0,0 +1,92 @@
+package tests
+
+import (
+	"testing"
+	"time"
+
+	"github.com/blu-fi-tech-inc/blufi-network/core"
+	"github.com/blu-fi-tech-inc/blufi-network/network"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEquivocationDetector(t *testing.T) {
+	keys, validators := newValidatorKeys(t, 2)
+	offender, other := keys[validators[0].Address], keys[validators[1].Address]
+	d := network.NewEquivocationDetector()
+
+	prev := &core.Header{Version: core.BlockVersionSeed, Height: 300, Timestamp: time.Now().UnixNano()}
+	a := newTestBlock(t, nil, prev, blockOpts{key: offender, timestamp: prev.Timestamp + 1})
+	b := newTestBlock(t, nil, prev, blockOpts{key: offender, timestamp: prev.Timestamp + 2})
+	c := newTestBlock(t, nil, prev, blockOpts{key: offender, timestamp: prev.Timestamp + 3})
+
+	_, ok := d.Check(a)
+	assert.False(t, ok)
+	_, ok = d.Check(a)
+	assert.False(t, ok)
+	_, ok = d.Check(newTestBlock(t, nil, prev, blockOpts{key: other, timestamp: prev.Timestamp + 2}))
+	assert.False(t, ok)
+
+	// Blocks without a valid signature prove nothing.
+	unsigned := newTestBlock(t, nil, prev, blockOpts{key: offender, timestamp: prev.Timestamp + 4})
+	unsigned.Signature = b.Signature
+	_, ok = d.Check(unsigned)
+	assert.False(t, ok)
+
+	evidence, ok := d.Check(b)
+	assert.True(t, ok)
+	assert.Nil(t, evidence.Verify())
+	assert.Equal(t, core.NewEvidenceTx(a, b), evidence)
+
+	// The offender is reported once.
+	_, ok = d.Check(c)
+	assert.False(t, ok)
+
+	// Old heights are forgotten.
+	old := &core.Header{Version: core.BlockVersionSeed, Height: 10, Timestamp: prev.Timestamp}
+	_, ok = d.Check(newTestBlock(t, nil, old, blockOpts{key: other, timestamp: old.Timestamp + 1}))
+	assert.False(t, ok)
+	_, ok = d.Check(newTestBlock(t, nil, old, blockOpts{key: other, timestamp: old.Timestamp + 2}))
+	assert.False(t, ok)
+}
+
+func TestSignGuardSurvivesRestart(t *testing.T) {
+	keys, validators := newValidatorKeys(t, 1)
+	key := keys[validators[0].Address]
+	dir := t.TempDir()
+
+	store, err := core.NewFileStore(dir)
+	assert.Nil(t, err)
+	g, err := network.NewSignGuard(store)
+	assert.Nil(t, err)
+	_, _, ok := g.Last()
+	assert.False(t, ok)
+	assert.True(t, g.CanSign(1))
+
+	prev := &core.Header{Version: core.BlockVersionSeed, Height: 4, Timestamp: time.Now().UnixNano()}
+	b := newTestBlock(t, nil, prev, blockOpts{key: key, timestamp: prev.Timestamp + 1})
+	assert.Nil(t, g.Record(b))
+	assert.Nil(t, store.Close())
+
+	// After a restart the validator refuses to sign at or below the height,
+	// even a block on another branch.
+	store, err = core.NewFileStore(dir)
+	assert.Nil(t, err)
+	defer store.Close()
+	g, err = network.NewSignGuard(store)
+	assert.Nil(t, err)
+
+	height, hash, ok := g.Last()
+	assert.True(t, ok)
+	assert.Equal(t, uint32(5), height)
+	assert.Equal(t, b.Hash(core.BlockHasher{}), hash)
+	assert.False(t, g.CanSign(4))
+	assert.False(t, g.CanSign(5))
+	assert.True(t, g.CanSign(6))
+
+	conflict := newTestBlock(t, nil, prev, blockOpts{key: key, timestamp: prev.Timestamp + 2})
+	assert.ErrorIs(t, g.Record(conflict), network.ErrAlreadySigned)
+
+	next := newTestBlock(t, nil, b.Header, blockOpts{key: key, timestamp: b.Timestamp + 1})
+	assert.Nil(t, g.Record(next))
+	assert.False(t, g.CanSign(6))
+}
@@ -0,0 +1,144 @@
+package tests
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/blu-fi-tech-inc/blufi-network/core"
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileStorePutGetDelete(t *testing.T) {
+	s, err := core.NewFileStore(t.TempDir())
+	assert.Nil(t, err)
+	defer s.Close()
+
+	assert.Nil(t, s.Put("foo", []byte("bar")))
+	value, err := s.Get("foo")
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("bar"), value)
+
+	assert.Nil(t, s.Put("foo", []byte("baz")))
+	value, err = s.Get("foo")
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("baz"), value)
+
+	assert.Nil(t, s.Delete("foo"))
+	_, err = s.Get("foo")
+	assert.ErrorIs(t, err, core.ErrKeyNotFound)
+}
+
+func TestFileStoreReopen(t *testing.T) {
+	dir := t.TempDir()
+
+	s, err := core.NewFileStoreWithSegmentSize(dir, 64)
+	assert.Nil(t, err)
+	assert.Nil(t, s.Put("a", []byte("1")))
+	assert.Nil(t, s.Put("b", make([]byte, 100)))
+	assert.Nil(t, s.Put("c", []byte("3")))
+	assert.Nil(t, s.Delete("a"))
+	assert.Nil(t, s.Close())
+
+	s, err = core.NewFileStoreWithSegmentSize(dir, 64)
+	assert.Nil(t, err)
+	defer s.Close()
+
+	_, err = s.Get("a")
+	assert.ErrorIs(t, err, core.ErrKeyNotFound)
+	value, err := s.Get("b")
+	assert.Nil(t, err)
+	assert.Len(t, value, 100)
+	value, err = s.Get("c")
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("3"), value)
+}
+
+func TestFileStoreTruncatesTornTail(t *testing.T) {
+	dir := t.TempDir()
+
+	s, err := core.NewFileStore(dir)
+	assert.Nil(t, err)
+	assert.Nil(t, s.Put("a", []byte("1")))
+	assert.Nil(t, s.Close())
+
+	f, err := os.OpenFile(filepath.Join(dir, "000000.seg"), os.O_APPEND|os.O_WRONLY, 0o644)
+	assert.Nil(t, err)
+	_, err = f.Write([]byte{0x01, 0x02, 0x03})
+	assert.Nil(t, err)
+	assert.Nil(t, f.Close())
+
+	s, err = core.NewFileStore(dir)
+	assert.Nil(t, err)
+	defer s.Close()
+
+	value, err := s.Get("a")
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("1"), value)
+	assert.Nil(t, s.Put("b", []byte("2")))
+}
+
+func TestFileStoreTruncatesCorruptLength(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "000000.seg")
+
+	s, err := core.NewFileStore(dir)
+	assert.Nil(t, err)
+	assert.Nil(t, s.Put("a", []byte("1")))
+	assert.Nil(t, s.Close())
+	info, err := os.Stat(path)
+	assert.Nil(t, err)
+
+	// A header claiming gigabytes of key and value is dropped without
+	// reading them.
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o644)
+	assert.Nil(t, err)
+	_, err = f.Write([]byte{0, 0, 0, 0, 0xff, 0xff, 0xff, 0x7f, 0xfe, 0xff, 0xff, 0xff, 'x'})
+	assert.Nil(t, err)
+	assert.Nil(t, f.Close())
+
+	s, err = core.NewFileStore(dir)
+	assert.Nil(t, err)
+	defer s.Close()
+
+	truncated, err := os.Stat(path)
+	assert.Nil(t, err)
+	assert.Equal(t, info.Size(), truncated.Size())
+	value, err := s.Get("a")
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("1"), value)
+}
+
+func TestBlockchainRestoreFromFileStore(t *testing.T) {
+	dir := t.TempDir()
+	genesis := newTestBlock(t, nil, nil, blockOpts{})
+
+	s, err := core.NewFileStore(dir)
+	assert.Nil(t, err)
+	bc, err := core.NewBlockchain(s, log.NewNopLogger(), core.NewAccountState(), genesis)
+	assert.Nil(t, err)
+
+	for _, b := range newTestBlocks(t, genesis, 5) {
+		assert.Nil(t, bc.AddBlock(b))
+	}
+	head, err := bc.GetBlock(5)
+	assert.Nil(t, err)
+	assert.Nil(t, s.Close())
+
+	s, err = core.NewFileStore(dir)
+	assert.Nil(t, err)
+	defer s.Close()
+
+	restored, err := core.NewBlockchain(s, log.NewNopLogger(), core.NewAccountState(), genesis)
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(5), restored.Height())
+
+	restoredHead, err := restored.GetBlock(5)
+	assert.Nil(t, err)
+	assert.Equal(t, head.Hash(core.BlockHasher{}), restoredHead.Hash(core.BlockHasher{}))
+
+	other := newTestBlock(t, nil, nil, blockOpts{timestamp: 1})
+	_, err = core.NewBlockchain(s, log.NewNopLogger(), core.NewAccountState(), other)
+	assert.NotNil(t, err)
+}
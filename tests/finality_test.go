@@ -0,0 +1,274 @@
+package tests
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/blu-fi-tech-inc/blufi-network/consensus"
+	"github.com/blu-fi-tech-inc/blufi-network/core"
+	"github.com/blu-fi-tech-inc/blufi-network/crypto"
+	"github.com/blu-fi-tech-inc/blufi-network/types"
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBlockchainFinalize(t *testing.T) {
+	genesis := newTestBlock(t, nil, nil, blockOpts{})
+	store := core.NewMemStore()
+	bc, err := core.NewBlockchain(store, log.NewNopLogger(), core.NewAccountState(), genesis)
+	assert.Nil(t, err)
+	assert.Equal(t, genesis.Header, bc.Finalized())
+
+	b1 := newTestBlock(t, bc, nil, blockOpts{stateRoot: true})
+	assert.Nil(t, bc.AddBlock(b1))
+	b2 := newTestBlock(t, bc, nil, blockOpts{stateRoot: true})
+	assert.Nil(t, bc.AddBlock(b2))
+	fork2 := newTestBlock(t, nil, b1.Header, blockOpts{})
+	assert.Nil(t, bc.AddBlock(fork2))
+
+	assert.NotNil(t, bc.Finalize(types.Hash{1}))
+
+	// Finalizing a block off the canonical chain makes it canonical.
+	assert.Nil(t, bc.Finalize(fork2.Hash(core.BlockHasher{})))
+	assert.Equal(t, fork2.Header, bc.Finalized())
+	head, err := bc.GetBlock(2)
+	assert.Nil(t, err)
+	assert.Equal(t, fork2, head)
+
+	// Ancestors are already final, the other branch can no longer be and is
+	// pruned.
+	assert.Nil(t, bc.Finalize(b1.Hash(core.BlockHasher{})))
+	assert.False(t, bc.HasBlockHash(b2.Hash(core.BlockHasher{})))
+	assert.NotNil(t, bc.Finalize(b2.Hash(core.BlockHasher{})))
+
+	// Neither new blocks at finalized heights nor forks unwinding the
+	// finalized block are accepted.
+	assert.ErrorIs(t, bc.AddBlock(newTestBlock(t, nil, b1.Header, blockOpts{})), core.ErrFinalizedConflict)
+	assert.ErrorIs(t, bc.AddBlock(newTestBlock(t, nil, b2.Header, blockOpts{})), core.ErrUnknownParent)
+	assert.Equal(t, uint32(2), bc.Height())
+	head, err = bc.GetBlock(2)
+	assert.Nil(t, err)
+	assert.Equal(t, fork2, head)
+
+	b3 := newTestBlock(t, bc, nil, blockOpts{stateRoot: true})
+	assert.Nil(t, bc.AddBlock(b3))
+	assert.Equal(t, fork2.Header, bc.Finalized())
+
+	// The finalized block survives a restart.
+	restored, err := core.NewBlockchain(store, log.NewNopLogger(), core.NewAccountState(), genesis)
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(3), restored.Height())
+	assert.Equal(t, fork2.Hash(core.BlockHasher{}), core.BlockHasher{}.Hash(restored.Finalized()))
+}
+
+func TestFinalizer(t *testing.T) {
+	keys, validators := newValidatorKeys(t, 4)
+	genesis := newTestBlock(t, nil, nil, blockOpts{timestamp: time.Now().Add(-time.Minute).UnixNano()})
+
+	chains := make([]*core.Blockchain, len(validators))
+	finalizers := make([]*consensus.Finalizer, len(validators))
+	for i, v := range validators {
+		accounts := core.NewAccountState()
+		for _, v := range validators {
+			assert.Nil(t, accounts.AddGenesisStake(v.Address, v.Stake))
+		}
+		bc, err := core.NewBlockchain(core.NewMemStore(), log.NewNopLogger(), accounts, genesis)
+		assert.Nil(t, err)
+
+		chains[i] = bc
+		finalizers[i] = consensus.NewFinalizer(bc, consensus.NewStakeManager(bc), keys[v.Address], 0)
+	}
+
+	addBlock := func() *core.Block {
+		prev, err := chains[0].GetHeader(chains[0].Height())
+		assert.Nil(t, err)
+		b := newTestBlock(t, chains[0], prev, blockOpts{key: keys[validators[0].Address], timestamp: prev.Timestamp + 1, stateRoot: true})
+		for _, bc := range chains {
+			assert.Nil(t, bc.AddBlock(b))
+		}
+		return b
+	}
+
+	// All validators online: the block is finalized in the first round.
+	b1 := addBlock()
+	now := time.Now()
+	var votes []*consensus.Vote
+	for _, f := range finalizers {
+		cast := f.Tick(now)
+		assert.Equal(t, 1, len(cast))
+		assert.Equal(t, consensus.VotePrevote, cast[0].Type)
+		votes = append(votes, cast...)
+	}
+	deliverVotes(t, finalizers, votes)
+
+	for i, bc := range chains {
+		assert.Equal(t, b1.Header, bc.Finalized())
+
+		height, round := finalizers[i].Round()
+		assert.Equal(t, uint32(2), height)
+		assert.Equal(t, uint32(0), round)
+
+		qc := finalizers[i].Certificate()
+		assert.Equal(t, b1.Hash(core.BlockHasher{}), qc.BlockHash)
+		assert.Nil(t, qc.Verify(validators))
+	}
+
+	qc := *finalizers[0].Certificate()
+	qc.Votes = qc.Votes[:2]
+	assert.ErrorIs(t, qc.Verify(validators), consensus.ErrInvalidQuorum)
+
+	// Two of four validators cannot finalize the next block. The round times
+	// out and the block is finalized in the next round once all are back.
+	b2 := addBlock()
+	for _, f := range finalizers[:2] {
+		deliverVotes(t, finalizers[:2], f.Tick(now))
+	}
+	assert.Equal(t, b1.Header, chains[0].Finalized())
+
+	later := now.Add(time.Minute)
+	votes = finalizers[0].Tick(later)
+	assert.Equal(t, 1, len(votes))
+	assert.Equal(t, uint32(1), votes[0].Round)
+
+	deliverVotes(t, finalizers, votes)
+	for _, f := range finalizers[2:] {
+		deliverVotes(t, finalizers, f.Tick(later))
+	}
+
+	for i, bc := range chains {
+		assert.Equal(t, b2.Header, bc.Finalized())
+		assert.Equal(t, uint32(1), finalizers[i].Certificate().Round)
+	}
+
+	// A node that missed the votes of a height finalizes it along with the
+	// next height it sees a certificate for.
+	accounts := core.NewAccountState()
+	for _, v := range validators {
+		assert.Nil(t, accounts.AddGenesisStake(v.Address, v.Stake))
+	}
+	observed, err := core.NewBlockchain(core.NewMemStore(), log.NewNopLogger(), accounts, genesis)
+	assert.Nil(t, err)
+	assert.Nil(t, observed.AddBlock(b1))
+	assert.Nil(t, observed.AddBlock(b2))
+
+	observer := consensus.NewFinalizer(observed, consensus.NewStakeManager(observed), nil, 0)
+	assert.Empty(t, observer.Tick(now))
+	for _, vote := range finalizers[0].Certificate().Votes {
+		cast, err := observer.AddVote(vote)
+		assert.Nil(t, err)
+		assert.Empty(t, cast)
+	}
+	assert.Equal(t, b2.Header, observed.Finalized())
+
+	// Votes are checked before they count.
+	f := finalizers[0]
+	key := keys[validators[1].Address]
+
+	vote := consensus.NewVote(consensus.VotePrevote, 3, 0, types.Hash{1})
+	assert.Nil(t, vote.Sign(key))
+	_, err = f.AddVote(vote)
+	assert.Nil(t, err)
+	_, err = f.AddVote(vote)
+	assert.ErrorIs(t, err, consensus.ErrKnownVote)
+
+	conflict := consensus.NewVote(consensus.VotePrevote, 3, 0, types.Hash{2})
+	assert.Nil(t, conflict.Sign(key))
+	_, err = f.AddVote(conflict)
+	assert.ErrorIs(t, err, consensus.ErrConflictingVote)
+
+	stale := consensus.NewVote(consensus.VotePrecommit, 2, 0, types.Hash{1})
+	assert.Nil(t, stale.Sign(key))
+	_, err = f.AddVote(stale)
+	assert.ErrorIs(t, err, consensus.ErrVoteOutOfRange)
+
+	unknownKey, _, err := crypto.GenerateKeyPair()
+	assert.Nil(t, err)
+	unknown := consensus.NewVote(consensus.VotePrevote, 3, 0, types.Hash{1})
+	assert.Nil(t, unknown.Sign(unknownKey))
+	_, err = f.AddVote(unknown)
+	assert.ErrorIs(t, err, consensus.ErrUnknownValidator)
+
+	tampered := consensus.NewVote(consensus.VotePrevote, 3, 0, types.Hash{1})
+	assert.Nil(t, tampered.Sign(keys[validators[2].Address]))
+	tampered.Round = 1
+	_, err = f.AddVote(tampered)
+	assert.ErrorIs(t, err, consensus.ErrInvalidVote)
+}
+
+// deliverVotes passes votes to all finalizers, and the votes they cast in
+// response, until no new votes are cast.
+func deliverVotes(t *testing.T, finalizers []*consensus.Finalizer, votes []*consensus.Vote) {
+	for len(votes) > 0 {
+		var next []*consensus.Vote
+		for _, vote := range votes {
+			for _, f := range finalizers {
+				cast, err := f.AddVote(vote)
+				if err != nil {
+					assert.Condition(t, func() bool {
+						return errors.Is(err, consensus.ErrKnownVote) || errors.Is(err, consensus.ErrVoteOutOfRange)
+					}, err.Error())
+					continue
+				}
+				next = append(next, cast...)
+			}
+		}
+		votes = next
+	}
+}
+
+func TestFinalizerWeighsHeightsByParentStake(t *testing.T) {
+	var (
+		keys      []*crypto.PrivateKey
+		addresses []types.Address
+	)
+	accounts := core.NewAccountState()
+	for i := 0; i < 4; i++ {
+		privKey, pubKey, err := crypto.GenerateKeyPair()
+		assert.Nil(t, err)
+		address, err := pubKey.Address()
+		assert.Nil(t, err)
+		assert.Nil(t, accounts.AddGenesisStake(address, 100))
+		keys = append(keys, privKey)
+		addresses = append(addresses, address)
+	}
+	accounts.AddBalance(addresses[0], 2000)
+
+	// The first validator bonds most of the stake in block 1, so it decides
+	// the votes for block 2 but not for block 1.
+	bc := newBlockchainWithGenesis(t, accounts.Copy())
+	b1 := newTestBlock(t, bc, nil, blockOpts{stateRoot: true}, newStakingTx(t, keys[0], 0, core.StakeTx{Amount: 1000}))
+	assert.Nil(t, bc.AddBlock(b1))
+	b2 := newTestBlock(t, bc, nil, blockOpts{stateRoot: true})
+	assert.Nil(t, bc.AddBlock(b2))
+	assert.Equal(t, uint64(1100), bc.Stake(addresses[0]))
+
+	precommit := func(f *consensus.Finalizer, i int, b *core.Block) {
+		vote := consensus.NewVote(consensus.VotePrecommit, b.Height, 0, b.Hash(core.BlockHasher{}))
+		assert.Nil(t, vote.Sign(keys[i]))
+		_, err := f.AddVote(vote)
+		assert.Nil(t, err)
+	}
+
+	f := consensus.NewFinalizer(bc, consensus.NewStakeManager(bc), nil, 0)
+	for i := 1; i < 4; i++ {
+		precommit(f, i, b1)
+	}
+	assert.Equal(t, b1.Header, bc.Finalized())
+
+	for i := 1; i < 4; i++ {
+		precommit(f, i, b2)
+	}
+	assert.Equal(t, b1.Header, bc.Finalized())
+	precommit(f, 0, b2)
+	assert.Equal(t, b2.Header, bc.Finalized())
+
+	// A node that missed height 1 weighs the votes for block 2 the same, so
+	// the precommit of the first validator alone finalizes both blocks.
+	late := newBlockchainWithGenesis(t, accounts.Copy())
+	assert.Nil(t, late.AddBlock(b1))
+	assert.Nil(t, late.AddBlock(b2))
+	f = consensus.NewFinalizer(late, consensus.NewStakeManager(late), nil, 0)
+	precommit(f, 0, b2)
+	assert.Equal(t, b2.Header, late.Finalized())
+}
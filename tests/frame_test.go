@@ -0,0 +1,90 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+
+	"github.com/blu-fi-tech-inc/blufi-network/network"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFrameRoundTrip(t *testing.T) {
+	buf := &bytes.Buffer{}
+	msg := network.NewMessage(network.MessageTypeTx, []byte("payload"))
+	assert.Nil(t, network.WriteFrame(buf, network.DefaultNetworkMagic, msg))
+
+	decoded, err := network.ReadFrame(buf, network.DefaultNetworkMagic)
+	assert.Nil(t, err)
+	assert.Equal(t, msg, decoded)
+
+	_, err = network.ReadFrame(buf, network.DefaultNetworkMagic)
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestFrameStream(t *testing.T) {
+	// Several frames in one read and a frame far larger than a single TCP read.
+	large := bytes.Repeat([]byte{0xab}, 1<<20)
+	msgs := []*network.Message{
+		network.NewMessage(network.MessageTypeGetStatus, nil),
+		network.NewMessage(network.MessageTypeBlocks, large),
+		network.NewMessage(network.MessageTypeTx, []byte{1, 2, 3}),
+	}
+
+	buf := &bytes.Buffer{}
+	for _, msg := range msgs {
+		assert.Nil(t, network.WriteFrame(buf, network.DefaultNetworkMagic, msg))
+	}
+
+	r := &chunkReader{data: buf.Bytes(), chunk: 1000}
+	for _, msg := range msgs {
+		decoded, err := network.ReadFrame(r, network.DefaultNetworkMagic)
+		assert.Nil(t, err)
+		assert.Equal(t, msg.Header, decoded.Header)
+		assert.Equal(t, len(msg.Data), len(decoded.Data))
+		assert.True(t, bytes.Equal(msg.Data, decoded.Data))
+	}
+}
+
+func TestFrameInvalid(t *testing.T) {
+	frame, err := network.EncodeFrame(network.DefaultNetworkMagic, network.NewMessage(network.MessageTypeTx, []byte("payload")))
+	assert.Nil(t, err)
+
+	_, err = network.ReadFrame(bytes.NewReader(frame), network.DefaultNetworkMagic+1)
+	assert.ErrorIs(t, err, network.ErrInvalidMagic)
+
+	badVersion := bytes.Clone(frame)
+	badVersion[4] = network.ProtocolVersion + 1
+	_, err = network.ReadFrame(bytes.NewReader(badVersion), network.DefaultNetworkMagic)
+	assert.ErrorIs(t, err, network.ErrUnsupportedVersion)
+
+	corrupted := bytes.Clone(frame)
+	corrupted[len(corrupted)-1] ^= 0xff
+	_, err = network.ReadFrame(bytes.NewReader(corrupted), network.DefaultNetworkMagic)
+	assert.ErrorIs(t, err, network.ErrInvalidChecksum)
+
+	oversized := bytes.Clone(frame)
+	binary.BigEndian.PutUint32(oversized[6:], network.MaxFrameSize+1)
+	_, err = network.ReadFrame(bytes.NewReader(oversized), network.DefaultNetworkMagic)
+	assert.ErrorIs(t, err, network.ErrFrameTooLarge)
+
+	_, err = network.ReadFrame(bytes.NewReader(frame[:len(frame)-1]), network.DefaultNetworkMagic)
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+}
+
+// chunkReader returns its data at most chunk bytes at a time, like a socket.
+type chunkReader struct {
+	data  []byte
+	chunk int
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, io.EOF
+	}
+	n := min(len(p), r.chunk, len(r.data))
+	copy(p, r.data[:n])
+	r.data = r.data[n:]
+	return n, nil
+}
@@ -0,0 +1,131 @@
+package tests
+
+import (
+	"testing"
+
+	"github.com/blu-fi-tech-inc/blufi-network/core"
+	"github.com/blu-fi-tech-inc/blufi-network/crypto"
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVMOutOfGas(t *testing.T) {
+	vm := core.NewVM([]byte{byte(core.InstrPushInt)}, core.NewState(), core.CallContext{}, core.GasBase-1)
+	assert.ErrorIs(t, vm.Run(), core.ErrOutOfGas)
+	assert.Equal(t, uint64(0), vm.GasLeft())
+
+	vm = core.NewVM([]byte{byte(core.InstrAdd)}, core.NewState(), core.CallContext{}, core.GasArithmetic)
+	assert.ErrorIs(t, vm.Run(), core.ErrStackUnderflow)
+}
+
+func TestStackLimit(t *testing.T) {
+	s := core.NewStack(128)
+	for i := 0; i < core.MaxStackSize; i++ {
+		assert.Nil(t, s.Push(i))
+	}
+	assert.ErrorIs(t, s.Push(0), core.ErrStackOverflow)
+}
+
+func TestBlockchainChargesGas(t *testing.T) {
+	privKey, pubKey, err := crypto.GenerateKeyPair()
+	assert.Nil(t, err)
+	address, err := pubKey.Address()
+	assert.Nil(t, err)
+
+	accounts := core.NewAccountState()
+	accounts.CreateAccount(address).Balance = 1000
+	bc, err := core.NewBlockchain(core.NewMemStore(), log.NewNopLogger(), accounts, newTestBlock(t, nil, nil, blockOpts{}))
+	assert.Nil(t, err)
+
+	// The deployment runs out of gas: the sender pays for all of it and the
+	// block is still valid.
+	code := []byte{byte(core.InstrPushInt)}
+	outOfGas := core.NewTransaction(code)
+	outOfGas.GasLimit = core.IntrinsicGas(outOfGas) + 1
+	outOfGas.GasPrice = 2
+	assert.Nil(t, outOfGas.Sign(privKey))
+
+	// Gas that is not used is refunded.
+	unused := core.NewTransaction(nil)
+	unused.Nonce = 1
+	unused.GasLimit = 100
+	unused.GasPrice = 2
+	assert.Nil(t, unused.Sign(privKey))
+
+	assert.Nil(t, bc.AddBlock(newTestBlock(t, bc, nil, blockOpts{stateRoot: true}, outOfGas, unused)))
+
+	receipt, err := bc.GetReceipt(outOfGas.Hash(core.TxHasher{}))
+	assert.Nil(t, err)
+	assert.ErrorIs(t, receipt.Err, core.ErrOutOfGas)
+	assert.Equal(t, outOfGas.GasLimit, receipt.GasUsed)
+
+	receipt, err = bc.GetReceipt(unused.Hash(core.TxHasher{}))
+	assert.Nil(t, err)
+	assert.Nil(t, receipt.Err)
+	assert.Equal(t, uint64(0), receipt.GasUsed)
+
+	balance, err := accounts.GetBalance(address)
+	assert.Nil(t, err)
+	assert.Equal(t, 1000-outOfGas.GasLimit*outOfGas.GasPrice, balance)
+	assert.Equal(t, uint64(2), bc.NextNonce(address))
+}
+
+func TestBlockchainRejectsUnpaidGas(t *testing.T) {
+	privKey, _, err := crypto.GenerateKeyPair()
+	assert.Nil(t, err)
+	bc, err := core.NewBlockchain(core.NewMemStore(), log.NewNopLogger(), core.NewAccountState(), newTestBlock(t, nil, nil, blockOpts{}))
+	assert.Nil(t, err)
+
+	noGas := core.NewTransaction([]byte{byte(core.InstrPushInt)})
+	assert.Nil(t, noGas.Sign(privKey))
+	_, err = bc.ComputeStateRoot(newTestBlock(t, bc, nil, blockOpts{}, noGas))
+	assert.ErrorIs(t, err, core.ErrIntrinsicGas)
+
+	unfunded := core.NewTransaction(nil)
+	unfunded.GasLimit = 10
+	unfunded.GasPrice = 1
+	assert.Nil(t, unfunded.Sign(privKey))
+	_, err = bc.ComputeStateRoot(newTestBlock(t, bc, nil, blockOpts{}, unfunded))
+	assert.ErrorIs(t, err, core.ErrInsufficientBalance)
+
+	assert.Empty(t, bc.FilterExecutable([]*core.Transaction{noGas, unfunded}))
+}
+
+func TestBlockchainPaysInclusionFee(t *testing.T) {
+	privKey, pubKey, err := crypto.GenerateKeyPair()
+	assert.Nil(t, err)
+	address, err := pubKey.Address()
+	assert.Nil(t, err)
+
+	accounts := core.NewAccountState()
+	accounts.CreateAccount(address).Balance = 1000
+	bc, err := core.NewBlockchain(core.NewMemStore(), log.NewNopLogger(), accounts, newTestBlock(t, nil, nil, blockOpts{}))
+	assert.Nil(t, err)
+
+	tx := core.NewTransaction(nil)
+	tx.GasLimit = 10
+	tx.GasPrice = 2
+	tx.Fee = 30
+	assert.Nil(t, tx.Sign(privKey))
+	assert.Equal(t, uint64(50), tx.MaxFee())
+
+	b := newTestBlock(t, bc, nil, blockOpts{stateRoot: true}, tx)
+	assert.Nil(t, bc.AddBlock(b))
+
+	balance, err := accounts.GetBalance(address)
+	assert.Nil(t, err)
+	// Unused gas is refunded, the fee is not.
+	assert.Equal(t, uint64(1000-30), balance)
+
+	validator, err := b.Validator.Address()
+	assert.Nil(t, err)
+	balance, err = accounts.GetBalance(validator)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(30), balance)
+
+	// NFT transactions pay the fee of their inner transaction.
+	nft := &core.Transaction{TxInner: core.CollectionTx{Fee: 200}, Fee: 1}
+	assert.Equal(t, uint64(200), nft.InclusionFee())
+	nft.TxInner = core.CollectionTx{Fee: -1}
+	assert.Equal(t, uint64(0), nft.InclusionFee())
+}
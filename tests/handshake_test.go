@@ -0,0 +1,95 @@
+package tests
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/blu-fi-tech-inc/blufi-network/crypto"
+	"github.com/blu-fi-tech-inc/blufi-network/network"
+	"github.com/blu-fi-tech-inc/blufi-network/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandshake(t *testing.T) {
+	a, b := newHandshake(t, "blufi", types.Hash{1}), newHandshake(t, "blufi", types.Hash{1})
+
+	resA, resB, errA, errB := runHandshake(a, b)
+	assert.Nil(t, errA)
+	assert.Nil(t, errB)
+	assert.Equal(t, a.NodeKey.PublicKey.X, resB.PublicKey.X)
+	assert.Equal(t, b.NodeKey.PublicKey.X, resA.PublicKey.X)
+}
+
+func TestHandshakeMismatch(t *testing.T) {
+	a := newHandshake(t, "blufi", types.Hash{1})
+
+	_, _, errA, errB := runHandshake(a, newHandshake(t, "other", types.Hash{1}))
+	assert.ErrorIs(t, errA, network.ErrChainMismatch)
+	assert.ErrorIs(t, errB, network.ErrChainMismatch)
+
+	_, _, errA, errB = runHandshake(a, newHandshake(t, "blufi", types.Hash{2}))
+	assert.ErrorIs(t, errA, network.ErrGenesisMismatch)
+	assert.ErrorIs(t, errB, network.ErrGenesisMismatch)
+
+	_, _, errA, errB = runHandshake(a, a)
+	assert.ErrorIs(t, errA, network.ErrSelfConnection)
+	assert.ErrorIs(t, errB, network.ErrSelfConnection)
+
+	encrypted := newHandshake(t, "blufi", types.Hash{1})
+	encrypted.Encrypt = true
+	_, _, errA, errB = runHandshake(a, encrypted)
+	assert.ErrorIs(t, errA, network.ErrEncryptMismatch)
+	assert.ErrorIs(t, errB, network.ErrEncryptMismatch)
+}
+
+func TestHandshakeWrongMagic(t *testing.T) {
+	connA, connB := net.Pipe()
+	defer connA.Close()
+
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := newHandshake(t, "blufi", types.Hash{1}).Run(connB, network.DefaultNetworkMagic+1)
+		connB.Close()
+		errCh <- err
+	}()
+
+	_, errA := newHandshake(t, "blufi", types.Hash{1}).Run(connA, network.DefaultNetworkMagic)
+	connA.Close()
+	errB := <-errCh
+
+	// The side that reads the first frame rejects it and hangs up, so the
+	// other side may only see the connection closing.
+	assert.NotNil(t, errA)
+	assert.NotNil(t, errB)
+	assert.True(t, errors.Is(errA, network.ErrInvalidMagic) || errors.Is(errB, network.ErrInvalidMagic))
+}
+
+func newHandshake(t *testing.T, name string, genesis types.Hash) *network.Handshake {
+	privKey, _, err := crypto.GenerateKeyPair()
+	assert.Nil(t, err)
+
+	return &network.Handshake{
+		BlockchainName: name,
+		GenesisHash:    genesis,
+		NodeKey:        privKey,
+	}
+}
+
+// runHandshake runs both sides of a handshake over an in-memory connection.
+func runHandshake(a, b *network.Handshake) (resA, resB *network.HandshakeResult, errA, errB error) {
+	connA, connB := net.Pipe()
+
+	done := make(chan struct{})
+	go func() {
+		resB, errB = b.Run(connB, network.DefaultNetworkMagic)
+		connB.Close()
+		close(done)
+	}()
+
+	resA, errA = a.Run(connA, network.DefaultNetworkMagic)
+	connA.Close()
+	<-done
+
+	return resA, resB, errA, errB
+}
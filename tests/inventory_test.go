@@ -0,0 +1,62 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/gob"
+	"testing"
+
+	"github.com/blu-fi-tech-inc/blufi-network/network"
+	"github.com/blu-fi-tech-inc/blufi-network/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKnownSet(t *testing.T) {
+	k := network.NewKnownSet(3)
+	hashes := []types.Hash{{1}, {2}, {3}, {4}, {5}}
+
+	assert.True(t, k.Add(hashes[0]))
+	assert.False(t, k.Add(hashes[0]))
+	assert.True(t, k.Contains(hashes[0]))
+	assert.False(t, k.Contains(hashes[1]))
+
+	for _, h := range hashes[1:] {
+		assert.True(t, k.Add(h))
+	}
+
+	// The oldest hashes are forgotten once the set is full.
+	assert.Equal(t, 3, k.Len())
+	assert.False(t, k.Contains(hashes[0]))
+	assert.False(t, k.Contains(hashes[1]))
+	for _, h := range hashes[2:] {
+		assert.True(t, k.Contains(h))
+	}
+
+	assert.True(t, k.Add(hashes[0]))
+	assert.False(t, k.Contains(hashes[2]))
+	assert.Equal(t, 3, k.Len())
+}
+
+func TestInvMessageDecode(t *testing.T) {
+	items := []network.InvItem{
+		{Type: network.InvTypeTx, Hash: types.Hash{1}},
+		{Type: network.InvTypeBlock, Hash: types.Hash{2}},
+	}
+
+	for _, tc := range []struct {
+		typ  network.MessageType
+		data interface{}
+	}{
+		{network.MessageTypeInv, &network.InvMessage{Items: items}},
+		{network.MessageTypeGetData, &network.GetDataMessage{Items: items}},
+	} {
+		buf := &bytes.Buffer{}
+		assert.Nil(t, gob.NewEncoder(buf).Encode(tc.data))
+
+		msg, err := network.DefaultRPCDecodeFunc(network.RPC{
+			Type:    tc.typ,
+			Payload: buf,
+		})
+		assert.Nil(t, err)
+		assert.Equal(t, tc.data, msg.Data)
+	}
+}
@@ -0,0 +1,85 @@
+package tests
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/blu-fi-tech-inc/blufi-network/core"
+	"github.com/blu-fi-tech-inc/blufi-network/crypto"
+	"github.com/blu-fi-tech-inc/blufi-network/types"
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJournalRevertToSnapshot(t *testing.T) {
+	j := core.NewJournal()
+	accounts := core.NewAccountState()
+	accounts.SetJournal(j)
+	contracts := core.NewState()
+	contracts.SetJournal(j)
+
+	alice, bob := types.Address{0x01}, types.Address{0x02}
+	accounts.CreateAccount(alice).Balance = 100
+	assert.Nil(t, contracts.Put([]byte("foo"), []byte("bar")))
+	root := core.CalculateStateRoot(accounts, contracts, nil, nil)
+
+	snapshot := j.Snapshot()
+	assert.Nil(t, accounts.Transfer(alice, bob, 40))
+	assert.Nil(t, accounts.UseNonce(alice, 0))
+	assert.Nil(t, contracts.Put([]byte("foo"), []byte("baz")))
+	assert.Nil(t, contracts.Put([]byte("new"), []byte("key")))
+	assert.NotEqual(t, root, core.CalculateStateRoot(accounts, contracts, nil, nil))
+
+	j.RevertToSnapshot(snapshot)
+	assert.Equal(t, root, core.CalculateStateRoot(accounts, contracts, nil, nil))
+
+	balance, err := accounts.GetBalance(alice)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(100), balance)
+	_, err = accounts.GetAccount(bob)
+	assert.Equal(t, core.ErrAccountNotFound, err)
+	_, err = contracts.Get([]byte("new"))
+	assert.NotNil(t, err)
+}
+
+func TestBlockWithFailingTxIsRejected(t *testing.T) {
+	bc, err := core.NewBlockchain(core.NewMemStore(), log.NewNopLogger(), core.NewAccountState(), newTestBlock(t, nil, nil, blockOpts{}))
+	assert.Nil(t, err)
+	root := bc.StateRoot()
+
+	privKeyAlice, pubKeyAlice, err := crypto.GenerateKeyPair()
+	assert.Nil(t, err)
+	alice, err := pubKeyAlice.Address()
+	assert.Nil(t, err)
+	privKeyBob, _, err := crypto.GenerateKeyPair()
+	assert.Nil(t, err)
+
+	ok := core.NewTransaction(nil)
+	assert.Nil(t, ok.Sign(privKeyAlice))
+
+	overdrawn := core.NewTransaction(nil)
+	overdrawn.To = alice
+	overdrawn.Value = 100
+	assert.Nil(t, overdrawn.Sign(privKeyBob))
+
+	prev, err := bc.GetHeader(bc.Height())
+	assert.Nil(t, err)
+	b, err := core.NewBlockFromPrevHeader(prev, []*core.Transaction{ok, overdrawn})
+	assert.Nil(t, err)
+	assert.Nil(t, b.Sign(privKeyAlice))
+
+	err = bc.AddBlock(b)
+	var execErr *core.TxExecutionError
+	assert.True(t, errors.As(err, &execErr))
+	assert.Equal(t, 1, execErr.Index)
+	assert.Equal(t, overdrawn.Hash(core.TxHasher{}), execErr.Tx)
+
+	assert.Equal(t, uint32(0), bc.Height())
+	assert.Equal(t, root, bc.StateRoot())
+	assert.Equal(t, uint64(0), bc.NextNonce(alice))
+
+	executable := bc.FilterExecutable([]*core.Transaction{ok, overdrawn})
+	assert.Equal(t, []*core.Transaction{ok}, executable)
+	assert.Equal(t, root, bc.StateRoot())
+
+}
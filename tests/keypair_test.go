@@ -3,31 +3,32 @@ package tests
 import (
 	"testing"
 
+	"github.com/blu-fi-tech-inc/blufi-network/crypto"
 	"github.com/stretchr/testify/assert"
 )
 
 func TestKeypairSignVerifySuccess(t *testing.T) {
-	privKey := GeneratePrivateKey()
-	publicKey := privKey.PublicKey()
+	privKey, publicKey, err := crypto.GenerateKeyPair()
+	assert.Nil(t, err)
 	msg := []byte("hello world")
 
 	sig, err := privKey.Sign(msg)
 	assert.Nil(t, err)
 
-	assert.True(t, sig.Verify(publicKey, msg))
+	assert.True(t, crypto.VerifySignature(publicKey, msg, sig))
 }
 
 func TestKeypairSignVerifyFail(t *testing.T) {
-	privKey := GeneratePrivateKey()
-	publicKey := privKey.PublicKey()
+	privKey, publicKey, err := crypto.GenerateKeyPair()
+	assert.Nil(t, err)
 	msg := []byte("hello world")
 
 	sig, err := privKey.Sign(msg)
 	assert.Nil(t, err)
 
-	otherPrivKey := GeneratePrivateKey()
-	otherPublicKey := otherPrivKey.PublicKey()
+	_, otherPublicKey, err := crypto.GenerateKeyPair()
+	assert.Nil(t, err)
 
-	assert.False(t, sig.Verify(otherPublicKey, msg))
-	assert.False(t, sig.Verify(publicKey, []byte("xxxxxx")))
-}
\ No newline at end of file
+	assert.False(t, crypto.VerifySignature(otherPublicKey, msg, sig))
+	assert.False(t, crypto.VerifySignature(publicKey, []byte("xxxxxx"), sig))
+}
@@ -4,16 +4,17 @@ import (
 	"fmt"
 	"testing"
 
+	"github.com/blu-fi-tech-inc/blufi-network/types"
 	"github.com/stretchr/testify/assert"
 )
 
 func TestNewList(t *testing.T) {
-	l := NewList[int]()
+	l := types.NewList[int]()
 	assert.Equal(t, l.Data, []int{})
 }
 
 func TestListClear(t *testing.T) {
-	l := NewList[int]()
+	l := types.NewList[int]()
 	n := 100
 
 	for i := 0; i < n; i++ {
@@ -26,7 +27,7 @@ func TestListClear(t *testing.T) {
 }
 
 func TestListContains(t *testing.T) {
-	l := NewList[int]()
+	l := types.NewList[int]()
 	n := 100
 
 	for i := 0; i < n; i++ {
@@ -36,7 +37,7 @@ func TestListContains(t *testing.T) {
 }
 
 func TestListGetIndex(t *testing.T) {
-	l := NewList[string]()
+	l := types.NewList[string]()
 	n := 100
 
 	for i := 0; i < n; i++ {
@@ -49,7 +50,7 @@ func TestListGetIndex(t *testing.T) {
 }
 
 func TestListRemove(t *testing.T) {
-	l := NewList[string]()
+	l := types.NewList[string]()
 	n := 100
 
 	for i := 0; i < n; i++ {
@@ -63,7 +64,7 @@ func TestListRemove(t *testing.T) {
 }
 
 func TestListGet(t *testing.T) {
-	l := NewList[int]()
+	l := types.NewList[int]()
 	n := 100
 
 	for i := 0; i < n; i++ {
@@ -74,7 +75,7 @@ func TestListGet(t *testing.T) {
 }
 
 func TestRemoveAt(t *testing.T) {
-	l := NewList[int]()
+	l := types.NewList[int]()
 	l.Insert(1)
 	l.Insert(2)
 	l.Insert(3)
@@ -85,7 +86,7 @@ func TestRemoveAt(t *testing.T) {
 }
 
 func TestListAdd(t *testing.T) {
-	l := NewList[int]()
+	l := types.NewList[int]()
 	n := 100
 
 	for i := 0; i < n; i++ {
@@ -96,10 +97,10 @@ func TestListAdd(t *testing.T) {
 }
 
 func TestListLast(t *testing.T) {
-	l := NewList[int]()
+	l := types.NewList[int]()
 	l.Insert(1)
 	l.Insert(2)
 	l.Insert(3)
 
 	assert.Equal(t, 3, l.Last())
-}
\ No newline at end of file
+}
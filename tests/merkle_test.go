@@ -0,0 +1,59 @@
+package tests
+
+import (
+	"testing"
+
+	"github.com/blu-fi-tech-inc/blufi-network/core"
+	"github.com/blu-fi-tech-inc/blufi-network/crypto"
+	"github.com/blu-fi-tech-inc/blufi-network/types"
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMerkleProofs(t *testing.T) {
+	for n := 1; n <= 9; n++ {
+		txx := make([]*core.Transaction, n)
+		for i := range txx {
+			txx[i] = core.NewTransaction([]byte{byte(i)})
+		}
+		root := core.CalculateTxRoot(txx)
+
+		for i := range txx {
+			proof, err := core.NewMerkleProof(txx, i)
+			assert.Nil(t, err)
+			assert.True(t, core.VerifyMerkleProof(root, proof), "n=%d i=%d", n, i)
+
+			proof.TxHash = types.Hash{}
+			assert.False(t, core.VerifyMerkleProof(root, proof))
+		}
+	}
+
+	assert.Equal(t, types.Hash{}, core.CalculateTxRoot(nil))
+}
+
+func TestBlockchainGetTxProof(t *testing.T) {
+	bc, err := core.NewBlockchain(core.NewMemStore(), log.NewNopLogger(), core.NewAccountState(), newTestBlock(t, nil, nil, blockOpts{}))
+	assert.Nil(t, err)
+
+	txx := []*core.Transaction{}
+	for i := 0; i < 3; i++ {
+		privKey, _, err := crypto.GenerateKeyPair()
+		assert.Nil(t, err)
+		tx := core.NewTransaction([]byte{})
+		assert.Nil(t, tx.Sign(privKey))
+		txx = append(txx, tx)
+	}
+
+	b := newTestBlock(t, bc, nil, blockOpts{stateRoot: true}, txx...)
+	assert.Equal(t, core.BlockVersionSeed, b.Version)
+	assert.Nil(t, bc.AddBlock(b))
+
+	block, proof, err := bc.GetTxProof(txx[2].Hash(core.TxHasher{}))
+	assert.Nil(t, err)
+	assert.Equal(t, b, block)
+	assert.Equal(t, uint32(2), proof.Index)
+	assert.True(t, core.VerifyMerkleProof(block.DataHash, proof))
+
+	_, _, err = bc.GetTxProof(types.Hash{})
+	assert.NotNil(t, err)
+}
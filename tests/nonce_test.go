@@ -0,0 +1,55 @@
+package tests
+
+import (
+	"testing"
+
+	"github.com/blu-fi-tech-inc/blufi-network/core"
+	"github.com/blu-fi-tech-inc/blufi-network/crypto"
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAccountStateUseNonce(t *testing.T) {
+	state := core.NewAccountState()
+	_, pubKey, err := crypto.GenerateKeyPair()
+	assert.Nil(t, err)
+	address, err := pubKey.Address()
+	assert.Nil(t, err)
+
+	assert.Equal(t, uint64(0), state.GetNonce(address))
+	assert.Nil(t, state.UseNonce(address, 0))
+	assert.Equal(t, uint64(1), state.GetNonce(address))
+
+	assert.ErrorIs(t, state.UseNonce(address, 0), core.ErrInvalidNonce)
+	assert.ErrorIs(t, state.UseNonce(address, 2), core.ErrInvalidNonce)
+	assert.Nil(t, state.UseNonce(address, 1))
+}
+
+func TestBlockchainRejectsReplayedTransaction(t *testing.T) {
+	bc, err := core.NewBlockchain(core.NewMemStore(), log.NewNopLogger(), core.NewAccountState(), newTestBlock(t, nil, nil, blockOpts{}))
+	assert.Nil(t, err)
+
+	privKey, pubKey, err := crypto.GenerateKeyPair()
+	assert.Nil(t, err)
+	address, err := pubKey.Address()
+	assert.Nil(t, err)
+
+	tx := core.NewTransaction(nil)
+	assert.Nil(t, tx.Sign(privKey))
+
+	assert.Nil(t, bc.AddBlock(newTestBlock(t, bc, nil, blockOpts{stateRoot: true}, tx)))
+	assert.Equal(t, uint64(1), bc.NextNonce(address))
+
+	prev, err := bc.GetHeader(bc.Height())
+	assert.Nil(t, err)
+	replay, err := core.NewBlockFromPrevHeader(prev, []*core.Transaction{tx})
+	assert.Nil(t, err)
+	assert.Nil(t, replay.Sign(privKey))
+	assert.ErrorIs(t, bc.AddBlock(replay), core.ErrInvalidNonce)
+
+	next := core.NewTransaction(nil)
+	next.Nonce = 1
+	assert.Nil(t, next.Sign(privKey))
+	assert.Nil(t, bc.AddBlock(newTestBlock(t, bc, nil, blockOpts{stateRoot: true}, next)))
+	assert.Equal(t, uint64(2), bc.NextNonce(address))
+}
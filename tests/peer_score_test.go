@@ -0,0 +1,85 @@
+package tests
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/blu-fi-tech-inc/blufi-network/core"
+	"github.com/blu-fi-tech-inc/blufi-network/crypto"
+	"github.com/blu-fi-tech-inc/blufi-network/network"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBanList(t *testing.T) {
+	l := network.NewBanList()
+	assert.False(t, l.IsBanned("10.0.0.1"))
+
+	l.Ban("10.0.0.2", time.Hour, "invalid block")
+	l.Ban("10.0.0.1", time.Hour, "rate limited")
+	assert.True(t, l.IsBanned("10.0.0.1"))
+
+	bans := l.List()
+	assert.Equal(t, 2, len(bans))
+	assert.Equal(t, "10.0.0.1", bans[0].Host)
+	assert.Equal(t, "rate limited", bans[0].Reason)
+	assert.Equal(t, "10.0.0.2", bans[1].Host)
+
+	assert.True(t, l.Unban("10.0.0.1"))
+	assert.False(t, l.Unban("10.0.0.1"))
+	assert.False(t, l.IsBanned("10.0.0.1"))
+}
+
+func TestBanListExpiry(t *testing.T) {
+	l := network.NewBanList()
+	l.Ban("10.0.0.1", 10*time.Millisecond, "invalid block")
+	assert.True(t, l.IsBanned("10.0.0.1"))
+
+	time.Sleep(20 * time.Millisecond)
+	assert.False(t, l.IsBanned("10.0.0.1"))
+	assert.Equal(t, 0, len(l.List()))
+}
+
+func TestPeerScoreDecay(t *testing.T) {
+	var s network.PeerScore
+	now := time.Now()
+	assert.Equal(t, 0, s.Score(now))
+	assert.Equal(t, 50, s.Add(50, now))
+
+	// Offences far apart never add up to a ban.
+	now = now.Add(5 * time.Minute)
+	assert.Equal(t, 0, s.Score(now))
+	assert.Equal(t, 50, s.Add(50, now))
+	assert.Equal(t, 90, s.Add(50, now.Add(time.Minute)))
+	assert.Equal(t, network.BanScore, s.Add(10, now.Add(time.Minute)))
+}
+
+func TestMisbehaviourPenalty(t *testing.T) {
+	wrap := func(err error) error {
+		return fmt.Errorf("%w: %w", network.ErrInvalidBlock, err)
+	}
+
+	// Blocks rejected because of the state of this node cost nothing.
+	assert.Equal(t, 0, network.MisbehaviourPenalty(wrap(core.ErrUnknownParent)))
+	assert.Equal(t, 0, network.MisbehaviourPenalty(wrap(core.ErrFinalizedConflict)))
+	assert.Equal(t, 0, network.MisbehaviourPenalty(wrap(core.ErrInvalidStateRoot)))
+	assert.Equal(t, 0, network.MisbehaviourPenalty(wrap(&core.TxExecutionError{Err: core.ErrInsufficientBalance})))
+
+	assert.Less(t, 0, network.MisbehaviourPenalty(wrap(core.ErrInvalidSignature)))
+	assert.Less(t, 0, network.MisbehaviourPenalty(wrap(core.ErrInvalidSeed)))
+}
+
+func TestInvalidSignatureError(t *testing.T) {
+	privKey, _, err := crypto.GenerateKeyPair()
+	assert.Nil(t, err)
+
+	tx := core.NewTransaction([]byte("foo"))
+	assert.Nil(t, tx.Sign(privKey))
+	assert.Nil(t, tx.Verify())
+
+	tx.Signature[0] ^= 0xff
+	assert.ErrorIs(t, tx.Verify(), core.ErrInvalidSignature)
+
+	tx.Signature = nil
+	assert.ErrorIs(t, tx.Verify(), core.ErrInvalidSignature)
+}
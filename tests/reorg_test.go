@@ -0,0 +1,190 @@
+package tests
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/blu-fi-tech-inc/blufi-network/core"
+	"github.com/blu-fi-tech-inc/blufi-network/crypto"
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBlockchainReorgToLongerFork(t *testing.T) {
+	genesis := newTestBlock(t, nil, nil, blockOpts{})
+	bc, err := core.NewBlockchain(core.NewMemStore(), log.NewNopLogger(), core.NewAccountState(), genesis)
+	assert.Nil(t, err)
+
+	var orphaned []*core.Transaction
+	bc.SetReorgHandler(func(txx []*core.Transaction) {
+		orphaned = append(orphaned, txx...)
+	})
+
+	privKey, pubKey, err := crypto.GenerateKeyPair()
+	assert.Nil(t, err)
+	address, err := pubKey.Address()
+	assert.Nil(t, err)
+
+	forkPrivKey, forkPubKey, err := crypto.GenerateKeyPair()
+	assert.Nil(t, err)
+	forkAddress, err := forkPubKey.Address()
+	assert.Nil(t, err)
+
+	mainTx := core.NewTransaction(nil)
+	assert.Nil(t, mainTx.Sign(privKey))
+	mainBlock := newTestBlock(t, bc, nil, blockOpts{stateRoot: true}, mainTx)
+	assert.Nil(t, bc.AddBlock(mainBlock))
+
+	// The fork is built on a second chain from the same genesis, which
+	// computes its state roots.
+	forkChain, err := core.NewBlockchain(core.NewMemStore(), log.NewNopLogger(), core.NewAccountState(), genesis)
+	assert.Nil(t, err)
+	forkTx := core.NewTransaction(nil)
+	assert.Nil(t, forkTx.Sign(forkPrivKey))
+	fork1 := newTestBlock(t, forkChain, nil, blockOpts{stateRoot: true}, forkTx)
+	assert.Nil(t, forkChain.AddBlock(fork1))
+	fork2 := newTestBlock(t, forkChain, nil, blockOpts{stateRoot: true})
+
+	// A fork of equal length does not replace the canonical chain.
+	assert.Nil(t, bc.AddBlock(fork1))
+	assert.True(t, bc.HasBlockHash(fork1.Hash(core.BlockHasher{})))
+	assert.Equal(t, uint32(1), bc.Height())
+	head, err := bc.GetBlock(1)
+	assert.Nil(t, err)
+	assert.Equal(t, mainBlock, head)
+	assert.Empty(t, orphaned)
+
+	// Once the fork is longer the chain switches over to it.
+	assert.Nil(t, bc.AddBlock(fork2))
+	assert.Equal(t, uint32(2), bc.Height())
+	head, err = bc.GetBlock(1)
+	assert.Nil(t, err)
+	assert.Equal(t, fork1, head)
+	head, err = bc.GetBlock(2)
+	assert.Nil(t, err)
+	assert.Equal(t, fork2, head)
+
+	assert.Equal(t, uint64(0), bc.NextNonce(address))
+	assert.Equal(t, uint64(1), bc.NextNonce(forkAddress))
+	_, err = bc.GetTxByHash(mainTx.Hash(core.TxHasher{}))
+	assert.NotNil(t, err)
+	_, err = bc.GetTxByHash(forkTx.Hash(core.TxHasher{}))
+	assert.Nil(t, err)
+
+	assert.Equal(t, []*core.Transaction{mainTx}, orphaned)
+	assert.ErrorIs(t, bc.AddBlock(mainBlock), core.ErrBlockKnown)
+}
+
+func TestBlockchainRejectsInvalidFork(t *testing.T) {
+	genesis := newTestBlock(t, nil, nil, blockOpts{})
+	bc, err := core.NewBlockchain(core.NewMemStore(), log.NewNopLogger(), core.NewAccountState(), genesis)
+	assert.Nil(t, err)
+
+	privKey, pubKey, err := crypto.GenerateKeyPair()
+	assert.Nil(t, err)
+	address, err := pubKey.Address()
+	assert.Nil(t, err)
+
+	mainTx := core.NewTransaction(nil)
+	assert.Nil(t, mainTx.Sign(privKey))
+	mainBlock := newTestBlock(t, bc, nil, blockOpts{stateRoot: true}, mainTx)
+	assert.Nil(t, bc.AddBlock(mainBlock))
+	root := bc.StateRoot()
+
+	badTx := core.NewTransaction(nil)
+	badTx.Nonce = 5
+	assert.Nil(t, badTx.Sign(privKey))
+	fork1 := newTestBlock(t, nil, genesis.Header, blockOpts{}, badTx)
+	fork2 := newTestBlock(t, nil, fork1.Header, blockOpts{})
+
+	assert.Nil(t, bc.AddBlock(fork1))
+	assert.ErrorIs(t, bc.AddBlock(fork2), core.ErrInvalidNonce)
+
+	assert.Equal(t, uint32(1), bc.Height())
+	head, err := bc.GetBlock(1)
+	assert.Nil(t, err)
+	assert.Equal(t, mainBlock, head)
+	assert.Equal(t, root, bc.StateRoot())
+	assert.Equal(t, uint64(1), bc.NextNonce(address))
+	assert.False(t, bc.HasBlockHash(fork1.Hash(core.BlockHasher{})))
+	assert.False(t, bc.HasBlockHash(fork2.Hash(core.BlockHasher{})))
+}
+
+func TestBlockchainFailsWhenRestoreFails(t *testing.T) {
+	genesis := newTestBlock(t, nil, nil, blockOpts{})
+	store := &failingStore{MemStore: core.NewMemStore(), puts: -1}
+	bc, err := core.NewBlockchain(store, log.NewNopLogger(), core.NewAccountState(), genesis)
+	assert.Nil(t, err)
+
+	privKey, _, err := crypto.GenerateKeyPair()
+	assert.Nil(t, err)
+
+	mainTx := core.NewTransaction(nil)
+	assert.Nil(t, mainTx.Sign(privKey))
+	assert.Nil(t, bc.AddBlock(newTestBlock(t, bc, nil, blockOpts{stateRoot: true}, mainTx)))
+
+	badTx := core.NewTransaction(nil)
+	badTx.Nonce = 5
+	assert.Nil(t, badTx.Sign(privKey))
+	fork1 := newTestBlock(t, nil, genesis.Header, blockOpts{}, badTx)
+	fork2 := newTestBlock(t, nil, fork1.Header, blockOpts{})
+	assert.Nil(t, bc.AddBlock(fork1))
+
+	// The reorg to the fork fails, and so does writing the old chain back.
+	store.puts = 1
+	assert.ErrorIs(t, bc.AddBlock(fork2), core.ErrChainCorrupted)
+
+	// The chain is unusable from then on.
+	store.puts = -1
+	assert.ErrorIs(t, bc.AddBlock(newTestBlock(t, bc, nil, blockOpts{stateRoot: true})), core.ErrChainCorrupted)
+	assert.ErrorIs(t, bc.Finalize(genesis.Hash(core.BlockHasher{})), core.ErrChainCorrupted)
+}
+
+func TestBlockchainChainEvents(t *testing.T) {
+	genesis := newTestBlock(t, nil, nil, blockOpts{})
+	bc, err := core.NewBlockchain(core.NewMemStore(), log.NewNopLogger(), core.NewAccountState(), genesis)
+	assert.Nil(t, err)
+
+	var events []core.ChainEvent
+	bc.Subscribe(func(ev core.ChainEvent) {
+		events = append(events, ev)
+	})
+
+	privKey, _, err := crypto.GenerateKeyPair()
+	assert.Nil(t, err)
+
+	mainTx := core.NewTransaction(nil)
+	assert.Nil(t, mainTx.Sign(privKey))
+	mainBlock := newTestBlock(t, bc, nil, blockOpts{stateRoot: true}, mainTx)
+	assert.Nil(t, bc.AddBlock(mainBlock))
+	assert.Equal(t, []core.ChainEvent{{Connected: []*core.Block{mainBlock}}}, events)
+
+	// Fork blocks only cause an event once the chain switches to them.
+	fork := newTestBlocks(t, genesis, 2)
+	fork1, fork2 := fork[0], fork[1]
+	assert.Nil(t, bc.AddBlock(fork1))
+	assert.Equal(t, 1, len(events))
+
+	assert.Nil(t, bc.AddBlock(fork2))
+	assert.Equal(t, 2, len(events))
+	assert.Equal(t, []*core.Block{fork1, fork2}, events[1].Connected)
+	assert.Equal(t, []*core.Transaction{mainTx}, events[1].Orphaned)
+}
+
+// failingStore is a MemStore that fails every write once puts writes were
+// made. It never fails if puts is negative.
+type failingStore struct {
+	*core.MemStore
+	puts int
+}
+
+func (s *failingStore) Put(key string, value []byte) error {
+	if s.puts == 0 {
+		return errors.New("disk full")
+	}
+	if s.puts > 0 {
+		s.puts--
+	}
+
+	return s.MemStore.Put(key, value)
+}
@@ -0,0 +1,219 @@
+package tests
+
+import (
+	"testing"
+	"time"
+
+	"github.com/blu-fi-tech-inc/blufi-network/consensus"
+	"github.com/blu-fi-tech-inc/blufi-network/core"
+	"github.com/blu-fi-tech-inc/blufi-network/crypto"
+	"github.com/blu-fi-tech-inc/blufi-network/types"
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScheduleDeterministic(t *testing.T) {
+	aa, bb, cc := types.Address{0xaa}, types.Address{0xbb}, types.Address{0xcc}
+	a := consensus.NewSchedule([]consensus.Validator{{Address: aa, Stake: 100}, {Address: bb, Stake: 300}, {Address: cc}})
+	b := consensus.NewSchedule([]consensus.Validator{{Address: bb, Stake: 300}, {Address: aa, Stake: 100}})
+	assert.Equal(t, 2, a.Len())
+
+	counts := make(map[types.Address]int)
+	seed := types.Hash{}
+	for height := uint32(1); height <= 4000; height++ {
+		seed = core.NextSeed(&core.Header{Height: height - 1, Seed: seed})
+
+		pa, err := a.Proposer(seed, height, 0)
+		assert.Nil(t, err)
+		pb, err := b.Proposer(seed, height, 0)
+		assert.Nil(t, err)
+		assert.Equal(t, pa, pb)
+
+		counts[pa]++
+	}
+
+	// Proposers are drawn by stake, validators without stake are never drawn.
+	assert.Equal(t, 0, counts[cc])
+	assert.InDelta(t, 1000, counts[aa], 150)
+	assert.InDelta(t, 3000, counts[bb], 150)
+
+	_, err := consensus.NewSchedule(nil).Proposer(seed, 1, 0)
+	assert.ErrorIs(t, err, consensus.ErrNoValidators)
+}
+
+func TestScheduleCommittee(t *testing.T) {
+	validators := []consensus.Validator{
+		{Address: types.Address{1}, Stake: 1},
+		{Address: types.Address{2}, Stake: 2},
+		{Address: types.Address{3}, Stake: 3},
+		{Address: types.Address{4}, Stake: 4},
+	}
+	s := consensus.NewSchedule(validators)
+	seed := types.Hash{1}
+
+	committee, err := s.Committee(seed, 7, 4)
+	assert.Nil(t, err)
+	assert.ElementsMatch(t, validators, committee)
+
+	again, err := s.Committee(seed, 7, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, committee[:2], again)
+
+	_, err = s.Committee(seed, 7, 5)
+	assert.ErrorIs(t, err, consensus.ErrNotEnoughValidators)
+}
+
+func TestScheduleVerifyProposer(t *testing.T) {
+	keys, validators := newValidatorKeys(t, 4)
+	s := consensus.NewSchedule(validators)
+
+	prev := &core.Header{Version: core.BlockVersionSeed, Height: 9, Timestamp: time.Now().Add(-time.Minute).UnixNano()}
+	slotDuration := 5 * time.Second
+
+	for slot := uint32(0); slot < 3; slot++ {
+		b, err := core.NewBlockFromPrevHeader(prev, nil)
+		assert.Nil(t, err)
+		assert.Equal(t, core.NextSeed(prev), b.Seed)
+		b.Timestamp = prev.Timestamp + int64(slot)*int64(slotDuration) + 1
+
+		proposer, err := s.Proposer(b.Seed, b.Height, slot)
+		assert.Nil(t, err)
+		assert.Nil(t, b.Sign(keys[proposer]))
+		assert.Nil(t, s.VerifyProposer(prev, b, slotDuration))
+
+		for addr, key := range keys {
+			if addr == proposer {
+				continue
+			}
+			assert.Nil(t, b.Sign(key))
+			assert.ErrorIs(t, s.VerifyProposer(prev, b, slotDuration), consensus.ErrWrongProposer)
+		}
+	}
+
+	b, err := core.NewBlockFromPrevHeader(prev, nil)
+	assert.Nil(t, err)
+	b.Timestamp = prev.Timestamp - 1
+	assert.ErrorIs(t, s.VerifyProposer(prev, b, slotDuration), consensus.ErrInvalidTimestamp)
+
+	b.Timestamp = time.Now().Add(time.Minute).UnixNano()
+	assert.ErrorIs(t, s.VerifyProposer(prev, b, slotDuration), consensus.ErrInvalidTimestamp)
+}
+
+func TestBlockchainRejectsInvalidSeed(t *testing.T) {
+	genesis := newTestBlock(t, nil, nil, blockOpts{})
+	bc, err := core.NewBlockchain(core.NewMemStore(), log.NewNopLogger(), core.NewAccountState(), genesis)
+	assert.Nil(t, err)
+
+	privKey, _, err := crypto.GenerateKeyPair()
+	assert.Nil(t, err)
+
+	b, err := core.NewBlockFromPrevHeader(genesis.Header, nil)
+	assert.Nil(t, err)
+	b.StateRoot, err = bc.ComputeStateRoot(b)
+	assert.Nil(t, err)
+	b.Seed = types.Hash{1}
+	assert.Nil(t, b.Sign(privKey))
+	assert.ErrorIs(t, bc.AddBlock(b), core.ErrInvalidSeed)
+
+	// Downgrading the block does not skip the seed check.
+	b.Version = core.BlockVersionStateRoot
+	assert.Nil(t, b.Sign(privKey))
+	assert.ErrorIs(t, bc.AddBlock(b), core.ErrInvalidVersion)
+
+	b.Version = core.BlockVersionSeed
+	b.Seed = core.NextSeed(genesis.Header)
+	assert.Nil(t, b.Sign(privKey))
+	assert.Nil(t, bc.AddBlock(b))
+}
+
+func TestPoSEngine(t *testing.T) {
+	keys, validators := newValidatorKeys(t, 3)
+	validators[0].Stake = 300
+
+	accounts := core.NewAccountState()
+	for _, v := range validators {
+		assert.Nil(t, accounts.AddGenesisStake(v.Address, v.Stake))
+	}
+	genesis := newTestBlock(t, nil, nil, blockOpts{timestamp: time.Now().Add(-time.Minute).UnixNano()})
+	bc, err := core.NewBlockchain(core.NewMemStore(), log.NewNopLogger(), accounts, genesis)
+	assert.Nil(t, err)
+
+	sm := consensus.NewStakeManager(bc)
+	pos := consensus.NewPoS(sm, time.Second)
+	bc.SetEngine(pos)
+
+	assert.Equal(t, uint64(300), sm.GetStake(validators[0].Address))
+	assert.Equal(t, uint64(0), sm.GetStake(types.Address{1}))
+	assert.ElementsMatch(t, validators, sm.Validators())
+
+	selected, err := pos.SelectValidators(genesis.Header, 3)
+	assert.Nil(t, err)
+	assert.ElementsMatch(t, validators, selected)
+
+	ts := genesis.Timestamp + int64(time.Second)/2
+	proposer, err := pos.Proposer(genesis.Header, ts)
+	assert.Nil(t, err)
+
+	for addr, key := range keys {
+		if addr == proposer {
+			continue
+		}
+		b := newTestBlock(t, bc, genesis.Header, blockOpts{key: key, timestamp: ts, stateRoot: true})
+		assert.ErrorIs(t, bc.AddBlock(b), consensus.ErrWrongProposer)
+	}
+
+	b := newTestBlock(t, bc, genesis.Header, blockOpts{key: keys[proposer], timestamp: ts, stateRoot: true})
+	assert.Nil(t, bc.AddBlock(b))
+	assert.Equal(t, uint32(1), bc.Height())
+
+	// Blocks on a fork are checked against the stake table after their
+	// parent before they are stored.
+	var wrong *crypto.PrivateKey
+	for addr, key := range keys {
+		if addr != proposer {
+			wrong = key
+		}
+	}
+	fork := newTestBlock(t, nil, genesis.Header, blockOpts{key: wrong, timestamp: ts})
+	assert.ErrorIs(t, bc.AddBlock(fork), consensus.ErrWrongProposer)
+	assert.False(t, bc.HasBlockHash(fork.Hash(core.BlockHasher{})))
+
+	fork = newTestBlock(t, bc, genesis.Header, blockOpts{key: keys[proposer], timestamp: ts + 1, stateRoot: true})
+	assert.Nil(t, bc.AddBlock(fork))
+
+	// On top of a fork block that was never applied the stake table is not
+	// known yet, so the proposer is checked when the fork becomes canonical.
+	// The fork does not change the stakes, so its schedule is the current one.
+	ts = fork.Timestamp + int64(time.Second)/2
+	_, err = pos.Proposer(fork.Header, ts)
+	assert.ErrorIs(t, err, core.ErrNoStakeTable)
+	next, err := pos.Schedule().Proposer(core.NextSeed(fork.Header), fork.Height+1, 0)
+	assert.Nil(t, err)
+	for addr, key := range keys {
+		if addr != next {
+			wrong = key
+		}
+	}
+	assert.ErrorIs(t, bc.AddBlock(newTestBlock(t, nil, fork.Header, blockOpts{key: wrong, timestamp: ts})), consensus.ErrWrongProposer)
+
+	head, err := bc.GetBlock(1)
+	assert.Nil(t, err)
+	assert.Equal(t, b.Hash(core.BlockHasher{}), head.Hash(core.BlockHasher{}))
+}
+
+// newValidatorKeys generates n validators with 100 stake each.
+func newValidatorKeys(t *testing.T, n int) (map[types.Address]*crypto.PrivateKey, []consensus.Validator) {
+	keys := make(map[types.Address]*crypto.PrivateKey)
+	validators := []consensus.Validator{}
+	for i := 0; i < n; i++ {
+		privKey, pubKey, err := crypto.GenerateKeyPair()
+		assert.Nil(t, err)
+		addr, err := pubKey.Address()
+		assert.Nil(t, err)
+
+		keys[addr] = privKey
+		validators = append(validators, consensus.Validator{Address: addr, Stake: 100})
+	}
+
+	return keys, validators
+}
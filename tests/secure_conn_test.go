@@ -0,0 +1,104 @@
+package tests
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/blu-fi-tech-inc/blufi-network/network"
+	"github.com/blu-fi-tech-inc/blufi-network/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSecureConnAfterHandshake(t *testing.T) {
+	a, b := newHandshake(t, "blufi", types.Hash{1}), newHandshake(t, "blufi", types.Hash{1})
+	a.Encrypt, b.Encrypt = true, true
+
+	connA, connB := net.Pipe()
+	defer connA.Close()
+	defer connB.Close()
+
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+	resCh := make(chan result, 1)
+	go func() {
+		res, err := b.Run(connB, network.DefaultNetworkMagic)
+		if err != nil {
+			resCh <- result{err: err}
+			return
+		}
+		conn, err := res.Secure(connB)
+		resCh <- result{conn, err}
+	}()
+
+	res, err := a.Run(connA, network.DefaultNetworkMagic)
+	assert.Nil(t, err)
+	secureA, err := res.Secure(connA)
+	assert.Nil(t, err)
+	r := <-resCh
+	assert.Nil(t, r.err)
+	secureB := r.conn
+
+	// Frames go both ways, across more records than a key may seal.
+	go func() {
+		for i := 0; i < network.RekeyInterval+10; i++ {
+			network.WriteFrame(secureA, network.DefaultNetworkMagic, network.NewMessage(network.MessageTypeTx, []byte{byte(i)}))
+		}
+		network.WriteFrame(secureA, network.DefaultNetworkMagic, network.NewMessage(network.MessageTypeBlocks, bytes.Repeat([]byte{7}, 100000)))
+	}()
+	for i := 0; i < network.RekeyInterval+10; i++ {
+		msg, err := network.ReadFrame(secureB, network.DefaultNetworkMagic)
+		assert.Nil(t, err)
+		assert.Equal(t, []byte{byte(i)}, msg.Data)
+	}
+	msg, err := network.ReadFrame(secureB, network.DefaultNetworkMagic)
+	assert.Nil(t, err)
+	assert.Equal(t, 100000, len(msg.Data))
+
+	go network.WriteFrame(secureB, network.DefaultNetworkMagic, network.NewMessage(network.MessageTypeStatus, []byte("back")))
+	msg, err = network.ReadFrame(secureA, network.DefaultNetworkMagic)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("back"), msg.Data)
+}
+
+func TestSecureConnRejectsTampering(t *testing.T) {
+	keyA, keyB := bytes.Repeat([]byte{1}, 32), bytes.Repeat([]byte{2}, 32)
+
+	// Capture what A puts on the wire.
+	wire := &bytesConn{}
+	secureA, err := network.NewSecureConn(wire, keyA, keyB)
+	assert.Nil(t, err)
+	assert.Nil(t, network.WriteFrame(secureA, network.DefaultNetworkMagic, network.NewMessage(network.MessageTypeTx, []byte("secret payload"))))
+	record := bytes.Clone(wire.Bytes())
+	assert.False(t, bytes.Contains(record, []byte("secret payload")))
+
+	// The receiving side reads it back with the keys the other way around.
+	secureB, err := network.NewSecureConn(&bytesConn{Buffer: *bytes.NewBuffer(bytes.Clone(record))}, keyB, keyA)
+	assert.Nil(t, err)
+	msg, err := network.ReadFrame(secureB, network.DefaultNetworkMagic)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("secret payload"), msg.Data)
+
+	record[len(record)-1] ^= 0xff
+	secureB, err = network.NewSecureConn(&bytesConn{Buffer: *bytes.NewBuffer(record)}, keyB, keyA)
+	assert.Nil(t, err)
+	_, err = network.ReadFrame(secureB, network.DefaultNetworkMagic)
+	assert.ErrorIs(t, err, network.ErrDecrypt)
+
+	// Records sealed with the wrong key do not open.
+	secureB, err = network.NewSecureConn(&bytesConn{Buffer: *bytes.NewBuffer(bytes.Clone(wire.Bytes()))}, keyB, keyB)
+	assert.Nil(t, err)
+	_, err = network.ReadFrame(secureB, network.DefaultNetworkMagic)
+	assert.ErrorIs(t, err, network.ErrDecrypt)
+}
+
+// bytesConn is a net.Conn reading from and writing to a buffer.
+type bytesConn struct {
+	net.Conn
+	bytes.Buffer
+}
+
+func (c *bytesConn) Read(p []byte) (int, error)  { return c.Buffer.Read(p) }
+func (c *bytesConn) Write(p []byte) (int, error) { return c.Buffer.Write(p) }
@@ -0,0 +1,110 @@
+package tests
+
+import (
+	"testing"
+	"time"
+
+	"github.com/blu-fi-tech-inc/blufi-network/consensus"
+	"github.com/blu-fi-tech-inc/blufi-network/core"
+	"github.com/blu-fi-tech-inc/blufi-network/crypto"
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEvidenceTxVerify(t *testing.T) {
+	keys, validators := newValidatorKeys(t, 2)
+	offender, other := keys[validators[0].Address], keys[validators[1].Address]
+
+	prev := &core.Header{Version: core.BlockVersionSeed, Height: 4, Timestamp: time.Now().UnixNano()}
+	a := newTestBlock(t, nil, prev, blockOpts{key: offender, timestamp: prev.Timestamp + 1})
+	b := newTestBlock(t, nil, prev, blockOpts{key: offender, timestamp: prev.Timestamp + 2})
+
+	evidence := core.NewEvidenceTx(a, b)
+	assert.Nil(t, evidence.Verify())
+	address, err := evidence.Offender()
+	assert.Nil(t, err)
+	assert.Equal(t, validators[0].Address, address)
+
+	// Signing the same block twice is not double-signing.
+	assert.ErrorIs(t, core.NewEvidenceTx(a, a).Verify(), core.ErrInvalidEvidence)
+
+	// Nor is signing blocks at different heights.
+	next := newTestBlock(t, nil, a.Header, blockOpts{key: offender, timestamp: a.Timestamp + 1})
+	assert.ErrorIs(t, core.NewEvidenceTx(a, next).Verify(), core.ErrInvalidEvidence)
+
+	// Both blocks must be signed by the validator.
+	c := newTestBlock(t, nil, prev, blockOpts{key: other, timestamp: prev.Timestamp + 3})
+	assert.ErrorIs(t, core.NewEvidenceTx(a, c).Verify(), core.ErrInvalidEvidence)
+
+	forged := core.NewEvidenceTx(a, b)
+	forged.Second.Signature = c.Signature
+	assert.ErrorIs(t, forged.Verify(), core.ErrInvalidSignature)
+
+	// Transactions carry only valid evidence, which is part of their hash.
+	reporter, _, err := crypto.GenerateKeyPair()
+	assert.Nil(t, err)
+	tx := newStakingTx(t, reporter, 0, evidence)
+	assert.Nil(t, tx.Verify())
+	assert.ErrorIs(t, newStakingTx(t, reporter, 0, core.NewEvidenceTx(a, c)).Verify(), core.ErrInvalidEvidence)
+
+	swapped := newStakingTx(t, reporter, 0, core.NewEvidenceTx(b, a))
+	assert.NotEqual(t, tx.Hash(core.TxHasher{}), swapped.Hash(core.TxHasher{}))
+}
+
+func TestBlockchainSlashing(t *testing.T) {
+	keys, validators := newValidatorKeys(t, 2)
+	offender := validators[0].Address
+
+	reporterKey, reporterPubKey, err := crypto.GenerateKeyPair()
+	assert.Nil(t, err)
+	reporter, err := reporterPubKey.Address()
+	assert.Nil(t, err)
+
+	accounts := core.NewAccountState()
+	assert.Nil(t, accounts.AddGenesisStake(offender, 1000))
+	assert.Nil(t, accounts.AddGenesisStake(validators[1].Address, 100))
+	accounts.AddBalance(offender, 100)
+	genesis := newTestBlock(t, nil, nil, blockOpts{timestamp: time.Now().Add(-time.Minute).UnixNano()})
+	bc, err := core.NewBlockchain(core.NewMemStore(), log.NewNopLogger(), accounts, genesis)
+	assert.Nil(t, err)
+	sm := consensus.NewStakeManager(bc)
+	pos := consensus.NewPoS(sm, time.Second)
+
+	// The offender starts unbonding part of its stake, which is slashed too.
+	unstake := newStakingTx(t, keys[offender], 0, core.UnstakeTx{Amount: 200})
+	assert.Nil(t, bc.AddBlock(newTestBlock(t, bc, nil, blockOpts{stateRoot: true}, unstake)))
+
+	a := newTestBlock(t, nil, genesis.Header, blockOpts{key: keys[offender], timestamp: genesis.Timestamp + 1})
+	b := newTestBlock(t, nil, genesis.Header, blockOpts{key: keys[offender], timestamp: genesis.Timestamp + 2})
+	evidence := newStakingTx(t, reporterKey, 0, core.NewEvidenceTx(a, b))
+	assert.Nil(t, bc.AddBlock(newTestBlock(t, bc, nil, blockOpts{stateRoot: true}, evidence)))
+
+	assert.Equal(t, uint64(400), bc.Stake(offender))
+	assert.Equal(t, []core.UnbondingEntry{{Amount: 100, Height: 1 + core.UnbondingPeriod}}, bc.Unbonding(offender))
+	assert.Equal(t, uint64(50), bc.Balance(reporter))
+	assert.True(t, sm.IsJailed(offender))
+
+	// Jailed validators are never scheduled again.
+	assert.Equal(t, validators[1:], sm.Validators())
+	selected, err := pos.SelectValidators(genesis.Header, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, validators[1:], selected)
+	_, err = pos.SelectValidators(genesis.Header, 2)
+	assert.ErrorIs(t, err, consensus.ErrNotEnoughValidators)
+
+	// A validator is slashed once, and cannot bond again.
+	again := newStakingTx(t, reporterKey, 1, core.NewEvidenceTx(b, a))
+	assert.Empty(t, bc.FilterExecutable([]*core.Transaction{again}))
+	assert.ErrorIs(t, bc.AddBlock(newTestBlock(t, bc, nil, blockOpts{}, again)), core.ErrJailed)
+
+	bond := newStakingTx(t, keys[offender], 1, core.StakeTx{Amount: 100})
+	assert.Empty(t, bc.FilterExecutable([]*core.Transaction{bond}))
+
+	// Validators without stake have nothing to slash.
+	innocentKey, _, err := crypto.GenerateKeyPair()
+	assert.Nil(t, err)
+	c := newTestBlock(t, nil, genesis.Header, blockOpts{key: innocentKey, timestamp: genesis.Timestamp + 1})
+	d := newTestBlock(t, nil, genesis.Header, blockOpts{key: innocentKey, timestamp: genesis.Timestamp + 2})
+	empty := newStakingTx(t, reporterKey, 1, core.NewEvidenceTx(c, d))
+	assert.ErrorIs(t, bc.AddBlock(newTestBlock(t, bc, nil, blockOpts{}, empty)), core.ErrInvalidEvidence)
+}
@@ -0,0 +1,118 @@
+package tests
+
+import (
+	"testing"
+
+	"github.com/blu-fi-tech-inc/blufi-network/consensus"
+	"github.com/blu-fi-tech-inc/blufi-network/core"
+	"github.com/blu-fi-tech-inc/blufi-network/crypto"
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBlockchainStaking(t *testing.T) {
+	privKey, pubKey, err := crypto.GenerateKeyPair()
+	assert.Nil(t, err)
+	address, err := pubKey.Address()
+	assert.Nil(t, err)
+
+	accounts := core.NewAccountState()
+	accounts.AddBalance(address, 1000)
+	bc, err := core.NewBlockchain(core.NewMemStore(), log.NewNopLogger(), accounts, newTestBlock(t, nil, nil, blockOpts{}))
+	assert.Nil(t, err)
+	sm := consensus.NewStakeManager(bc)
+
+	// Stake only comes out of the balance once the chain uses the state.
+	assert.ErrorIs(t, accounts.AddGenesisStake(address, 100), core.ErrNotGenesis)
+	assert.Equal(t, uint64(0), sm.GetStake(address))
+
+	assert.Nil(t, bc.AddBlock(newTestBlock(t, bc, nil, blockOpts{stateRoot: true}, newStakingTx(t, privKey, 0, core.StakeTx{Amount: 400}))))
+	assert.Equal(t, uint64(600), bc.Balance(address))
+	assert.Equal(t, uint64(400), sm.GetStake(address))
+	assert.Equal(t, []consensus.Validator{{Address: address, Stake: 400}}, sm.Validators())
+
+	// Staking transactions moving more than the sender has are not executable.
+	overstake := newStakingTx(t, privKey, 1, core.StakeTx{Amount: 601})
+	overunstake := newStakingTx(t, privKey, 1, core.UnstakeTx{Amount: 401})
+	assert.Empty(t, bc.FilterExecutable([]*core.Transaction{overstake}))
+	assert.Empty(t, bc.FilterExecutable([]*core.Transaction{overunstake}))
+
+	assert.Nil(t, bc.AddBlock(newTestBlock(t, bc, nil, blockOpts{stateRoot: true}, newStakingTx(t, privKey, 1, core.UnstakeTx{Amount: 150}))))
+	assert.Equal(t, uint64(250), sm.GetStake(address))
+	release := 2 + core.UnbondingPeriod
+	assert.Equal(t, []core.UnbondingEntry{{Amount: 150, Height: release}}, bc.Unbonding(address))
+
+	// The unstaked funds stay locked until the end of the unbonding period.
+	for bc.Height() < release-1 {
+		assert.Nil(t, bc.AddBlock(newTestBlock(t, bc, nil, blockOpts{stateRoot: true})))
+	}
+	assert.Equal(t, uint64(600), bc.Balance(address))
+
+	assert.Nil(t, bc.AddBlock(newTestBlock(t, bc, nil, blockOpts{stateRoot: true})))
+	assert.Equal(t, uint64(750), bc.Balance(address))
+	assert.Empty(t, bc.Unbonding(address))
+	assert.Equal(t, uint64(250), sm.GetStake(address))
+}
+
+func TestBlockchainUnbondsAtEachReleaseHeight(t *testing.T) {
+	privKey, pubKey, err := crypto.GenerateKeyPair()
+	assert.Nil(t, err)
+	address, err := pubKey.Address()
+	assert.Nil(t, err)
+
+	accounts := core.NewAccountState()
+	accounts.AddBalance(address, 1000)
+	bc := newBlockchainWithGenesis(t, accounts)
+
+	assert.Nil(t, bc.AddBlock(newTestBlock(t, bc, nil, blockOpts{stateRoot: true}, newStakingTx(t, privKey, 0, core.StakeTx{Amount: 400}))))
+	assert.Nil(t, bc.AddBlock(newTestBlock(t, bc, nil, blockOpts{stateRoot: true}, newStakingTx(t, privKey, 1, core.UnstakeTx{Amount: 100}))))
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, bc.AddBlock(newTestBlock(t, bc, nil, blockOpts{stateRoot: true})))
+	}
+	assert.Nil(t, bc.AddBlock(newTestBlock(t, bc, nil, blockOpts{stateRoot: true}, newStakingTx(t, privKey, 2, core.UnstakeTx{Amount: 50}))))
+
+	// The second unbond does not push back the release of the first one.
+	first, second := 2+core.UnbondingPeriod, 13+core.UnbondingPeriod
+	assert.Equal(t, []core.UnbondingEntry{{Amount: 100, Height: first}, {Amount: 50, Height: second}}, bc.Unbonding(address))
+
+	for bc.Height() < first {
+		assert.Nil(t, bc.AddBlock(newTestBlock(t, bc, nil, blockOpts{stateRoot: true})))
+	}
+	assert.Equal(t, uint64(700), bc.Balance(address))
+	assert.Equal(t, []core.UnbondingEntry{{Amount: 50, Height: second}}, bc.Unbonding(address))
+
+	for bc.Height() < second {
+		assert.Nil(t, bc.AddBlock(newTestBlock(t, bc, nil, blockOpts{stateRoot: true})))
+	}
+	assert.Equal(t, uint64(750), bc.Balance(address))
+	assert.Empty(t, bc.Unbonding(address))
+	assert.Equal(t, uint64(250), bc.Stake(address))
+}
+
+func TestStakingTxSigned(t *testing.T) {
+	privKey, _, err := crypto.GenerateKeyPair()
+	assert.Nil(t, err)
+
+	a := newStakingTx(t, privKey, 0, core.StakeTx{Amount: 1})
+	b := newStakingTx(t, privKey, 0, core.StakeTx{Amount: 2})
+	c := newStakingTx(t, privKey, 0, core.UnstakeTx{Amount: 1})
+	assert.NotEqual(t, a.Hash(core.TxHasher{}), b.Hash(core.TxHasher{}))
+	assert.NotEqual(t, a.Hash(core.TxHasher{}), c.Hash(core.TxHasher{}))
+
+	// The amount cannot be changed after signing.
+	a.TxInner = core.StakeTx{Amount: 1000}
+	a.Signature = b.Signature
+	assert.ErrorIs(t, a.Verify(), core.ErrInvalidSignature)
+
+	zero := newStakingTx(t, privKey, 0, core.UnstakeTx{})
+	assert.ErrorIs(t, zero.Verify(), core.ErrZeroStake)
+}
+
+func newStakingTx(t *testing.T, privKey *crypto.PrivateKey, nonce uint64, inner interface{}) *core.Transaction {
+	tx := core.NewTransaction(nil)
+	tx.TxInner = inner
+	tx.Nonce = nonce
+	assert.Nil(t, tx.Sign(privKey))
+
+	return tx
+}
@@ -1 +1,94 @@
-package tests
\ No newline at end of file
+package tests
+
+import (
+	"testing"
+
+	"github.com/blu-fi-tech-inc/blufi-network/core"
+	"github.com/blu-fi-tech-inc/blufi-network/crypto"
+	"github.com/blu-fi-tech-inc/blufi-network/types"
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCalculateStateRoot(t *testing.T) {
+	accounts := core.NewAccountState()
+	contracts := core.NewState()
+	empty := core.CalculateStateRoot(accounts, contracts, nil, nil)
+
+	address := types.Address{0x01}
+	accounts.CreateAccount(address).Balance = 10
+	withAccount := core.CalculateStateRoot(accounts, contracts, nil, nil)
+	assert.NotEqual(t, empty, withAccount)
+
+	assert.Nil(t, contracts.Put([]byte("foo"), []byte("bar")))
+	withContract := core.CalculateStateRoot(accounts, contracts, nil, nil)
+	assert.NotEqual(t, withAccount, withContract)
+
+	copied := core.CalculateStateRoot(accounts.Copy(), contracts.Copy(), nil, nil)
+	assert.Equal(t, withContract, copied)
+
+	assert.Nil(t, accounts.UseNonce(address, 0))
+	withNonce := core.CalculateStateRoot(accounts, contracts, nil, nil)
+	assert.NotEqual(t, withContract, withNonce)
+
+	// NFT collections and mints are part of the state, down to their
+	// metadata.
+	collection := &core.CollectionTx{Fee: 1, MetaData: []byte("collection")}
+	collections := map[types.Hash]*core.CollectionTx{{0x02}: collection}
+	withCollection := core.CalculateStateRoot(accounts, contracts, collections, nil)
+	assert.NotEqual(t, withNonce, withCollection)
+
+	mints := map[types.Hash]*core.MintTx{{0x03}: {Fee: 1, NFT: types.Hash{0x04}, Collection: types.Hash{0x02}, MetaData: []byte("mint")}}
+	withMint := core.CalculateStateRoot(accounts, contracts, collections, mints)
+	assert.NotEqual(t, withCollection, withMint)
+
+	mints[types.Hash{0x03}].MetaData = []byte("other")
+	assert.NotEqual(t, withMint, core.CalculateStateRoot(accounts, contracts, collections, mints))
+	collection.MetaData = []byte("other")
+	assert.NotEqual(t, withCollection, core.CalculateStateRoot(accounts, contracts, collections, nil))
+}
+
+func TestBlockchainRejectsInvalidStateRoot(t *testing.T) {
+	bc, err := core.NewBlockchain(core.NewMemStore(), log.NewNopLogger(), core.NewAccountState(), newTestBlock(t, nil, nil, blockOpts{}))
+	assert.Nil(t, err)
+
+	privKey, _, err := crypto.GenerateKeyPair()
+	assert.Nil(t, err)
+	tx := core.NewTransaction(nil)
+	assert.Nil(t, tx.Sign(privKey))
+
+	before := bc.StateRoot()
+	b := newTestBlock(t, bc, nil, blockOpts{stateRoot: true}, tx)
+	assert.Equal(t, before, bc.StateRoot())
+	assert.NotEqual(t, before, b.StateRoot)
+
+	forged := *b.Header
+	forged.StateRoot = before
+	forgedBlock, err := core.NewBlock(&forged, b.Transactions)
+	assert.Nil(t, err)
+	signer, _, err := crypto.GenerateKeyPair()
+	assert.Nil(t, err)
+	assert.Nil(t, forgedBlock.Sign(signer))
+	assert.ErrorIs(t, bc.AddBlock(forgedBlock), core.ErrInvalidStateRoot)
+
+	assert.Nil(t, bc.AddBlock(b))
+	assert.Equal(t, b.StateRoot, bc.StateRoot())
+}
+
+func TestBlockchainRejectsBlockWithoutStateRoot(t *testing.T) {
+	bc, err := core.NewBlockchain(core.NewMemStore(), log.NewNopLogger(), core.NewAccountState(), newTestBlock(t, nil, nil, blockOpts{}))
+	assert.Nil(t, err)
+
+	privKey, _, err := crypto.GenerateKeyPair()
+	assert.Nil(t, err)
+	tx := core.NewTransaction(nil)
+	assert.Nil(t, tx.Sign(privKey))
+
+	// Only the genesis block may predate state roots.
+	b := newTestBlock(t, bc, nil, blockOpts{}, tx)
+	b.Version = core.BlockVersionMerkle
+	b.StateRoot = types.Hash{}
+	assert.Nil(t, b.Sign(privKey))
+	assert.ErrorIs(t, bc.AddBlock(b), core.ErrInvalidVersion)
+	assert.Equal(t, uint32(0), bc.Height())
+}
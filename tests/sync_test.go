@@ -0,0 +1,160 @@
+package tests
+
+import (
+	"net"
+	"testing"
+
+	"github.com/blu-fi-tech-inc/blufi-network/core"
+	"github.com/blu-fi-tech-inc/blufi-network/network"
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBlocksInRange(t *testing.T) {
+	genesis := newTestBlock(t, nil, nil, blockOpts{})
+	bc := newSyncChain(t, genesis, newTestBlocks(t, genesis, 250))
+
+	blocks, err := network.BlocksInRange(bc, 1, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, network.MaxBlocksPerMessage, len(blocks))
+	assert.Equal(t, uint32(1), blocks[0].Height)
+	assert.Equal(t, uint32(network.MaxBlocksPerMessage), blocks[len(blocks)-1].Height)
+
+	blocks, err = network.BlocksInRange(bc, 5, 10)
+	assert.Nil(t, err)
+	assert.Equal(t, 6, len(blocks))
+	assert.Equal(t, uint32(10), blocks[5].Height)
+
+	blocks, err = network.BlocksInRange(bc, 240, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, 11, len(blocks))
+
+	blocks, err = network.BlocksInRange(bc, 251, 0)
+	assert.Nil(t, err)
+	assert.Empty(t, blocks)
+
+	blocks, err = network.BlocksInRange(bc, 10, 5)
+	assert.Nil(t, err)
+	assert.Empty(t, blocks)
+
+	headers, err := network.HeadersInRange(bc, 0, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, 251, len(headers))
+}
+
+func TestSyncFromMultiplePeers(t *testing.T) {
+	genesis := newTestBlock(t, nil, nil, blockOpts{})
+	blocks := newTestBlocks(t, genesis, 250)
+	peerA := &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 3000}
+	peerB := &net.TCPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 3000}
+	peers := map[net.Addr]*core.Blockchain{
+		peerA: newSyncChain(t, genesis, blocks),
+		peerB: newSyncChain(t, genesis, blocks),
+	}
+
+	bc := newSyncChain(t, genesis, nil)
+	s := network.NewSyncer(bc, log.NewNopLogger())
+
+	reqs := s.UpdatePeer(peerA, 250)
+	reqs = append(reqs, s.UpdatePeer(peerB, 250)...)
+	assert.True(t, s.Status().Syncing)
+
+	served := runSync(t, s, peers, reqs)
+	assert.Equal(t, uint32(250), bc.Height())
+	assert.False(t, s.Status().Syncing)
+	assert.Equal(t, 0, s.Status().InFlight)
+	assert.True(t, served[peerA] > 0)
+	assert.True(t, served[peerB] > 0)
+
+	head, err := bc.GetBlock(250)
+	assert.Nil(t, err)
+	assert.Equal(t, blocks[249].Hash(core.BlockHasher{}), head.Hash(core.BlockHasher{}))
+
+	// Peers that are not ahead do not start a sync.
+	assert.Empty(t, s.UpdatePeer(peerA, 250))
+	assert.False(t, s.Status().Syncing)
+}
+
+func TestSyncFork(t *testing.T) {
+	genesis := newTestBlock(t, nil, nil, blockOpts{})
+	peer := &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 3000}
+	blocks := newTestBlocks(t, genesis, 10)
+	peers := map[net.Addr]*core.Blockchain{
+		peer: newSyncChain(t, genesis, blocks),
+	}
+
+	bc := newSyncChain(t, genesis, newTestBlocks(t, genesis, 3))
+	s := network.NewSyncer(bc, log.NewNopLogger())
+	assert.Equal(t, uint32(3), s.Status().HeadersHeight)
+
+	runSync(t, s, peers, s.UpdatePeer(peer, 10))
+	assert.Equal(t, uint32(10), bc.Height())
+	assert.False(t, s.Status().Syncing)
+
+	head, err := bc.GetBlock(1)
+	assert.Nil(t, err)
+	assert.Equal(t, blocks[0].Hash(core.BlockHasher{}), head.Hash(core.BlockHasher{}))
+}
+
+func TestSyncInvalidHeaders(t *testing.T) {
+	genesis := newTestBlock(t, nil, nil, blockOpts{})
+	peer := &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 3000}
+	blocks := newTestBlocks(t, genesis, 5)
+
+	s := network.NewSyncer(newSyncChain(t, genesis, nil), log.NewNopLogger())
+	reqs := s.UpdatePeer(peer, 5)
+	assert.Equal(t, 1, len(reqs))
+	assert.Equal(t, network.MessageTypeGetHeaders, reqs[0].Type)
+	assert.Equal(t, &network.GetHeadersMessage{From: 1, To: 5}, reqs[0].Data)
+
+	// The headers skip a height.
+	_, err := s.HandleHeaders(peer, &network.HeadersMessage{
+		Headers: []*core.Header{blocks[0].Header, blocks[2].Header},
+	})
+	assert.ErrorIs(t, err, network.ErrInvalidHeaders)
+}
+
+// runSync answers the requests of the syncer from the chains of the peers
+// until it stops sending requests. It returns the number of block requests
+// served per peer.
+func runSync(t *testing.T, s *network.Syncer, peers map[net.Addr]*core.Blockchain, reqs []network.SyncRequest) map[net.Addr]int {
+	served := make(map[net.Addr]int)
+	for len(reqs) > 0 {
+		req := reqs[0]
+		reqs = reqs[1:]
+
+		var (
+			next []network.SyncRequest
+			err  error
+		)
+		switch msg := req.Data.(type) {
+		case *network.GetHeadersMessage:
+			headers, herr := network.HeadersInRange(peers[req.Peer], msg.From, msg.To)
+			assert.Nil(t, herr)
+			next, err = s.HandleHeaders(req.Peer, &network.HeadersMessage{Headers: headers})
+
+		case *network.GetBlocksMessage:
+			assert.LessOrEqual(t, int(msg.To-msg.From)+1, network.MaxBlocksPerMessage)
+			served[req.Peer]++
+			blocks, berr := network.BlocksInRange(peers[req.Peer], msg.From, msg.To)
+			assert.Nil(t, berr)
+			next, err = s.HandleBlocks(req.Peer, &network.BlocksMessage{Blocks: blocks})
+		}
+		assert.Nil(t, err)
+
+		reqs = append(reqs, next...)
+	}
+
+	return served
+}
+
+func newSyncChain(t *testing.T, genesis *core.Block, blocks []*core.Block) *core.Blockchain {
+	bc, err := core.NewBlockchain(core.NewMemStore(), log.NewNopLogger(), core.NewAccountState(), genesis)
+	assert.Nil(t, err)
+
+	for _, b := range blocks {
+		assert.Nil(t, bc.AddBlock(b))
+	}
+
+	return bc
+}
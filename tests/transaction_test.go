@@ -5,56 +5,89 @@ import (
 	"encoding/gob"
 	"testing"
 
+	"github.com/blu-fi-tech-inc/blufi-network/core"
 	"github.com/blu-fi-tech-inc/blufi-network/crypto"
-	"github.com/blu-fi-tech-inc/blufi-network/types"
+	"github.com/blu-fi-tech-inc/blufi-network/utils"
 	"github.com/stretchr/testify/assert"
 )
 
 func TestVerifyTransactionWithTamper(t *testing.T) {
-	tx := NewTransaction(nil)
+	tx := core.NewTransaction(nil)
 
-	fromPrivKey := crypto.GeneratePrivateKey()
-	toPrivKey := crypto.GeneratePrivateKey()
-	hackerPrivKey := crypto.GeneratePrivateKey()
+	fromPrivKey, _, err := crypto.GenerateKeyPair()
+	assert.Nil(t, err)
 
-	tx.From = fromPrivKey.PublicKey()
-	tx.To = toPrivKey.PublicKey()
+	tx.To = newAddress(t)
 	tx.Value = 666
 
 	assert.Nil(t, tx.Sign(fromPrivKey))
-	tx.hash = types.Hash{}
 
-	tx.To = hackerPrivKey.PublicKey()
+	// Peers see the tampered transaction without the hash cached by Sign.
+	tampered := decodeTx(t, tx)
+	tampered.To = newAddress(t)
 
-	assert.NotNil(t, tx.Verify())
+	assert.NotNil(t, tampered.Verify())
+}
+
+func TestVerifyTransactionWithTamperedData(t *testing.T) {
+	privKey, _, err := crypto.GenerateKeyPair()
+	assert.Nil(t, err)
+
+	tx := core.NewTransaction([]byte("data"))
+	assert.Nil(t, tx.Sign(privKey))
+
+	tampered := decodeTx(t, tx)
+	tampered.Data = []byte("forged")
+	assert.ErrorIs(t, tampered.Verify(), core.ErrInvalidSignature)
 }
 
 func TestNFTTransaction(t *testing.T) {
-	collectionTx := CollectionTx{
+	collectionTx := core.CollectionTx{
 		Fee:      200,
 		MetaData: []byte("The beginning of a new collection"),
 	}
 
-	privKey := crypto.GeneratePrivateKey()
-	tx := &Transaction{
+	privKey, _, err := crypto.GenerateKeyPair()
+	assert.Nil(t, err)
+	tx := &core.Transaction{
 		TxInner: collectionTx,
 	}
-	tx.Sign(privKey)
-	tx.hash = types.Hash{}
+	assert.Nil(t, tx.Sign(privKey))
 
-	buf := new(bytes.Buffer)
-	assert.Nil(t, gob.NewEncoder(buf).Encode(tx))
+	txDecoded := decodeTx(t, tx)
+	assert.Equal(t, tx.Hash(core.TxHasher{}), txDecoded.Hash(core.TxHasher{}))
+	assert.Equal(t, tx.TxInner, txDecoded.TxInner)
+	assert.Equal(t, tx.From, txDecoded.From)
+	assert.Equal(t, tx.Signature, txDecoded.Signature)
+}
 
-	txDecoded := &Transaction{}
-	assert.Nil(t, gob.NewDecoder(buf).Decode(txDecoded))
-	assert.Equal(t, tx, txDecoded)
+func TestNFTTransactionWithTamper(t *testing.T) {
+	privKey, _, err := crypto.GenerateKeyPair()
+	assert.Nil(t, err)
+
+	collection := newStakingTx(t, privKey, 0, core.CollectionTx{Fee: 200, MetaData: []byte("collection")})
+	tampered := decodeTx(t, collection)
+	tampered.TxInner = core.CollectionTx{Fee: 200, MetaData: []byte("forged")}
+	assert.ErrorIs(t, tampered.Verify(), core.ErrInvalidSignature)
+
+	mint := newStakingTx(t, privKey, 1, core.MintTx{
+		Fee:        200,
+		NFT:        utils.RandomHash(),
+		Collection: collection.Hash(core.TxHasher{}),
+		MetaData:   []byte("nft"),
+	})
+	tampered = decodeTx(t, mint)
+	inner := tampered.TxInner.(core.MintTx)
+	inner.NFT = utils.RandomHash()
+	tampered.TxInner = inner
+	assert.ErrorIs(t, tampered.Verify(), core.ErrInvalidSignature)
 }
 
 func TestNativeTransferTransaction(t *testing.T) {
-	fromPrivKey := crypto.GeneratePrivateKey()
-	toPrivKey := crypto.GeneratePrivateKey()
-	tx := &Transaction{
-		To:    toPrivKey.PublicKey(),
+	fromPrivKey, _, err := crypto.GenerateKeyPair()
+	assert.Nil(t, err)
+	tx := &core.Transaction{
+		To:    newAddress(t),
 		Value: 666,
 	}
 
@@ -62,8 +95,9 @@ func TestNativeTransferTransaction(t *testing.T) {
 }
 
 func TestSignTransaction(t *testing.T) {
-	privKey := crypto.GeneratePrivateKey()
-	tx := &Transaction{
+	privKey, _, err := crypto.GenerateKeyPair()
+	assert.Nil(t, err)
+	tx := &core.Transaction{
 		Data: []byte("foo"),
 	}
 
@@ -72,37 +106,52 @@ func TestSignTransaction(t *testing.T) {
 }
 
 func TestVerifyTransaction(t *testing.T) {
-	privKey := crypto.GeneratePrivateKey()
-	tx := &Transaction{
+	privKey, _, err := crypto.GenerateKeyPair()
+	assert.Nil(t, err)
+	tx := &core.Transaction{
 		Data: []byte("foo"),
 	}
 
 	assert.Nil(t, tx.Sign(privKey))
 	assert.Nil(t, tx.Verify())
 
-	otherPrivKey := crypto.GeneratePrivateKey()
-	tx.From = otherPrivKey.PublicKey()
+	_, otherPubKey, err := crypto.GenerateKeyPair()
+	assert.Nil(t, err)
+	tx.From = *otherPubKey
 
 	assert.NotNil(t, tx.Verify())
 }
 
 func TestTxEncodeDecode(t *testing.T) {
 	tx := randomTxWithSignature(t)
-	buf := &bytes.Buffer{}
-	assert.Nil(t, tx.Encode(NewGobTxEncoder(buf)))
-	tx.hash = types.Hash{}
 
-	txDecoded := new(Transaction)
-	assert.Nil(t, txDecoded.Decode(NewGobTxDecoder(buf)))
-	assert.Equal(t, tx, txDecoded)
+	txDecoded := decodeTx(t, tx)
+	assert.Equal(t, tx.Hash(core.TxHasher{}), txDecoded.Hash(core.TxHasher{}))
+	assert.Equal(t, tx.Data, txDecoded.Data)
+	assert.Equal(t, tx.From, txDecoded.From)
+	assert.Equal(t, tx.Signature, txDecoded.Signature)
+	assert.Nil(t, txDecoded.Verify())
 }
 
-func randomTxWithSignature(t *testing.T) *Transaction {
-	privKey := crypto.GeneratePrivateKey()
-	tx := Transaction{
+func randomTxWithSignature(t *testing.T) *core.Transaction {
+	privKey, _, err := crypto.GenerateKeyPair()
+	assert.Nil(t, err)
+	tx := core.Transaction{
 		Data: []byte("foo"),
 	}
 	assert.Nil(t, tx.Sign(privKey))
 
 	return &tx
-}
\ No newline at end of file
+}
+
+// decodeTx returns the transaction as received from a peer, gob encoded and
+// decoded.
+func decodeTx(t *testing.T, tx *core.Transaction) *core.Transaction {
+	buf := &bytes.Buffer{}
+	assert.Nil(t, tx.Encode(gob.NewEncoder(buf)))
+
+	decoded := new(core.Transaction)
+	assert.Nil(t, decoded.Decode(gob.NewDecoder(buf)))
+
+	return decoded
+}
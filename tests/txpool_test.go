@@ -4,104 +4,302 @@ import (
 	"testing"
 
 	"github.com/blu-fi-tech-inc/blufi-network/core"
-	"github.com/blu-fi-tech-inc/blufi-network/utils"
+	"github.com/blu-fi-tech-inc/blufi-network/crypto"
+	"github.com/blu-fi-tech-inc/blufi-network/network"
+	"github.com/blu-fi-tech-inc/blufi-network/types"
+	"github.com/go-kit/log"
 	"github.com/stretchr/testify/assert"
 )
 
-func TestTxMaxLength(t *testing.T) {
-	p := NewTxPool(1)
-	p.Add(utils.NewRandomTransaction(10))
-	assert.Equal(t, 1, p.all.Count())
-
-	p.Add(utils.NewRandomTransaction(10))
-	p.Add(utils.NewRandomTransaction(10))
-	p.Add(utils.NewRandomTransaction(10))
-	tx := utils.NewRandomTransaction(100)
-	p.Add(tx)
-	assert.Equal(t, 1, p.all.Count())
-	assert.True(t, p.Contains(tx.Hash(core.TxHasher{})))
+func TestTxPoolPendingOrder(t *testing.T) {
+	alice, bob := newPoolKey(t), newPoolKey(t)
+	p := network.NewTxPool(10)
+
+	alice0 := newPoolTx(t, alice, 0, 1)
+	alice1 := newPoolTx(t, alice, 1, 100)
+	bob0 := newPoolTx(t, bob, 0, 50)
+	for _, tx := range []*core.Transaction{alice1, bob0, alice0} {
+		assert.Nil(t, p.Add(tx))
+	}
+	// Adding a transaction twice is a no-op.
+	assert.Nil(t, p.Add(bob0))
+	assert.Equal(t, 3, p.Len())
+
+	// The fee of alice1 does not let it overtake alice0.
+	assert.Equal(t, []*core.Transaction{bob0, alice0, alice1}, p.Pending(10))
+	assert.Equal(t, []*core.Transaction{bob0, alice0, alice1}, p.Pending(10))
+	assert.Equal(t, []*core.Transaction{bob0}, p.Pending(1))
+
+	p.Remove(bob0.Hash(core.TxHasher{}))
+	assert.False(t, p.Contains(bob0.Hash(core.TxHasher{})))
+	assert.Equal(t, []*core.Transaction{alice0, alice1}, p.Pending(10))
 }
 
-func TestTxPoolAdd(t *testing.T) {
-	p := NewTxPool(11)
-	n := 10
+func TestTxPoolStateSource(t *testing.T) {
+	key := newPoolKey(t)
+	pubKey := crypto.PublicKey{PublicKey: &key.PublicKey}
+	address, err := pubKey.Address()
+	assert.Nil(t, err)
+
+	p := network.NewTxPool(10)
+	p.SetStateSource(&poolState{
+		nonces:   map[types.Address]uint64{address: 1},
+		balances: map[types.Address]uint64{address: 100},
+	})
+
+	assert.ErrorIs(t, p.Add(newPoolTx(t, key, 0, 1)), network.ErrNonceTooLow)
+	assert.Equal(t, uint64(1), p.NextNonce(address))
+
+	tx1 := newPoolTx(t, key, 1, 1)
+	assert.Nil(t, p.Add(tx1))
+	assert.Nil(t, p.Add(newPoolTx(t, key, 3, 1)))
 
-	for i := 1; i <= n; i++ {
-		tx := utils.NewRandomTransaction(100)
-		p.Add(tx)
-		// cannot add twice
-		p.Add(tx)
+	// Nonce 3 waits for nonce 2.
+	assert.Equal(t, []*core.Transaction{tx1}, p.Pending(10))
+	assert.Equal(t, uint64(2), p.NextNonce(address))
+}
+
+func TestTxPoolReplaceByFee(t *testing.T) {
+	key := newPoolKey(t)
+	p := network.NewTxPool(10)
+
+	old := newPoolTx(t, key, 0, 100)
+	assert.Nil(t, p.Add(old))
+
+	assert.ErrorIs(t, p.Add(newPoolTx(t, key, 0, 105)), network.ErrReplacementUnderpriced)
+	assert.True(t, p.Contains(old.Hash(core.TxHasher{})))
+
+	replacement := newPoolTx(t, key, 0, 110)
+	assert.Nil(t, p.Add(replacement))
+	assert.False(t, p.Contains(old.Hash(core.TxHasher{})))
+	assert.Equal(t, []*core.Transaction{replacement}, p.Pending(10))
+}
 
-		assert.Equal(t, i, p.PendingCount())
-		assert.Equal(t, i, p.pending.Count())
-		assert.Equal(t, i, p.all.Count())
+func TestTxPoolEvictsLowestFee(t *testing.T) {
+	alice, bob, carol := newPoolKey(t), newPoolKey(t), newPoolKey(t)
+	p := network.NewTxPool(3)
+
+	alice0 := newPoolTx(t, alice, 0, 10)
+	alice1 := newPoolTx(t, alice, 1, 5)
+	bob0 := newPoolTx(t, bob, 0, 20)
+	for _, tx := range []*core.Transaction{alice0, alice1, bob0} {
+		assert.Nil(t, p.Add(tx))
 	}
+
+	assert.ErrorIs(t, p.Add(newPoolTx(t, carol, 0, 5)), network.ErrTxPoolFull)
+
+	// The last transaction of alice is evicted, not the one before it.
+	carol0 := newPoolTx(t, carol, 0, 6)
+	assert.Nil(t, p.Add(carol0))
+	assert.Equal(t, 3, p.Len())
+	assert.False(t, p.Contains(alice1.Hash(core.TxHasher{})))
+	assert.Equal(t, []*core.Transaction{bob0, alice0, carol0}, p.Pending(10))
 }
 
-func TestTxPoolMaxLength(t *testing.T) {
-	maxLen := 10
-	p := NewTxPool(maxLen)
-	n := 100
-	txx := []*core.Transaction{}
+func TestTxPoolRanksByMinFee(t *testing.T) {
+	alice, bob, carol, dave := newPoolKey(t), newPoolKey(t), newPoolKey(t), newPoolKey(t)
+	p := network.NewTxPool(3)
 
-	for i := 0; i < n; i++ {
-		tx := utils.NewRandomTransaction(100)
-		p.Add(tx)
+	// A gas limit the transaction does not use does not raise its priority.
+	inflated := core.NewTransaction(nil)
+	inflated.Fee = 5
+	inflated.GasLimit = 1000
+	inflated.GasPrice = 1
+	assert.Nil(t, inflated.Sign(alice))
+	assert.Equal(t, uint64(5), inflated.MinFee())
 
-		if i > n-(maxLen+1) {
-			txx = append(txx, tx)
-		}
+	// The intrinsic gas of the data is used whatever the code does.
+	data := core.NewTransaction([]byte("data"))
+	data.Fee = 5
+	data.GasLimit = 1000
+	data.GasPrice = 1
+	assert.Nil(t, data.Sign(bob))
+	assert.Equal(t, uint64(5+4*core.GasTxDataByte), data.MinFee())
+
+	carol0 := newPoolTx(t, carol, 0, 10)
+	for _, tx := range []*core.Transaction{inflated, data, carol0} {
+		assert.Nil(t, p.Add(tx))
 	}
+	assert.Equal(t, []*core.Transaction{data, carol0, inflated}, p.Pending(10))
+
+	dave0 := newPoolTx(t, dave, 0, 6)
+	assert.Nil(t, p.Add(dave0))
+	assert.False(t, p.Contains(inflated.Hash(core.TxHasher{})))
+	assert.Equal(t, []*core.Transaction{data, carol0, dave0}, p.Pending(10))
+}
+
+func TestTxPoolRejectsInvalid(t *testing.T) {
+	key := newPoolKey(t)
+	pubKey := crypto.PublicKey{PublicKey: &key.PublicKey}
+	address, err := pubKey.Address()
+	assert.Nil(t, err)
+
+	p := network.NewTxPool(10)
+	p.SetStateSource(&poolState{
+		balances: map[types.Address]uint64{address: 100},
+	})
+
+	unsigned := core.NewTransaction(nil)
+	assertRejected(t, p.Add(unsigned), network.RejectInvalidSignature)
+	assert.ErrorIs(t, p.Add(unsigned), core.ErrInvalidSignature)
+
+	tampered := newPoolTx(t, key, 0, 1)
+	tampered.Signature[0] ^= 0xff
+	assertRejected(t, p.Add(tampered), network.RejectInvalidSignature)
+
+	large := core.NewTransaction(make([]byte, network.MaxTxSize))
+	assert.Nil(t, large.Sign(key))
+	assertRejected(t, p.Add(large), network.RejectTooLarge)
+	assert.ErrorIs(t, p.Add(large), network.ErrTxTooLarge)
+
+	// The sender pays for its queued transactions first.
+	assert.Nil(t, p.Add(newPoolTx(t, key, 0, 60)))
+	overdrawn := newPoolTx(t, key, 1, 41)
+	assertRejected(t, p.Add(overdrawn), network.RejectInsufficientFunds)
+	assert.ErrorIs(t, p.Add(overdrawn), network.ErrInsufficientFunds)
+	assert.Nil(t, p.Add(newPoolTx(t, key, 1, 40)))
 
-	assert.Equal(t, p.all.Count(), maxLen)
-	assert.Equal(t, len(txx), maxLen)
+	value := newPoolTx(t, key, 2, 0)
+	value.Value = 1
+	assert.Nil(t, value.Sign(key))
+	assertRejected(t, p.Add(value), network.RejectInsufficientFunds)
 
-	for _, tx := range txx {
-		assert.True(t, p.Contains(tx.Hash(core.TxHasher{})))
+	// Bonded stake comes out of the balance too.
+	stake := newPoolTx(t, key, 2, 0)
+	stake.TxInner = core.StakeTx{Amount: 1}
+	assert.Nil(t, stake.Sign(key))
+	assertRejected(t, p.Add(stake), network.RejectInsufficientFunds)
+
+	assert.Equal(t, 2, p.Len())
+}
+
+func TestTxPoolLimitsSender(t *testing.T) {
+	alice, bob := newPoolKey(t), newPoolKey(t)
+	pubKey := crypto.PublicKey{PublicKey: &bob.PublicKey}
+	address, err := pubKey.Address()
+	assert.Nil(t, err)
+
+	// Without a state source only the number of queued transactions is
+	// limited.
+	p := network.NewTxPool(2 * network.MaxSenderTxs)
+	for nonce := uint64(0); nonce < network.MaxSenderTxs; nonce++ {
+		assert.Nil(t, p.Add(newPoolTx(t, alice, 1000+nonce, 1)))
 	}
+	full := newPoolTx(t, alice, 2000, 100)
+	assertRejected(t, p.Add(full), network.RejectSenderQueueFull)
+	assert.ErrorIs(t, p.Add(full), network.ErrSenderQueueFull)
+
+	// A queued transaction can still be replaced.
+	assert.Nil(t, p.Add(newPoolTx(t, alice, 1000, 2)))
+	assert.Nil(t, p.Add(newPoolTx(t, bob, 0, 1)))
+
+	p = network.NewTxPool(10)
+	p.SetStateSource(&poolState{
+		nonces:   map[types.Address]uint64{address: 5},
+		balances: map[types.Address]uint64{address: 100},
+	})
+	tooHigh := newPoolTx(t, bob, 5+network.MaxSenderTxs, 1)
+	assertRejected(t, p.Add(tooHigh), network.RejectNonceTooHigh)
+	assert.ErrorIs(t, p.Add(tooHigh), network.ErrNonceTooHigh)
+	assert.Nil(t, p.Add(newPoolTx(t, bob, 4+network.MaxSenderTxs, 1)))
 }
 
-func TestTxSortedMapFirst(t *testing.T) {
-	m := NewTxSortedMap()
-	first := utils.NewRandomTransaction(100)
-	m.Add(first)
-	m.Add(utils.NewRandomTransaction(10))
-	m.Add(utils.NewRandomTransaction(10))
-	m.Add(utils.NewRandomTransaction(10))
-	m.Add(utils.NewRandomTransaction(10))
-	assert.Equal(t, first, m.First())
+func TestTxPoolReset(t *testing.T) {
+	alice, bob := newPoolKey(t), newPoolKey(t)
+	aliceKey := crypto.PublicKey{PublicKey: &alice.PublicKey}
+	aliceAddress, err := aliceKey.Address()
+	assert.Nil(t, err)
+	bobKey := crypto.PublicKey{PublicKey: &bob.PublicKey}
+	bobAddress, err := bobKey.Address()
+	assert.Nil(t, err)
+
+	state := &poolState{
+		nonces:   map[types.Address]uint64{},
+		balances: map[types.Address]uint64{aliceAddress: 100, bobAddress: 100},
+	}
+	p := network.NewTxPool(10)
+	p.SetStateSource(state)
+
+	alice0 := newPoolTx(t, alice, 0, 10)
+	alice1 := newPoolTx(t, alice, 1, 10)
+	alice2 := newPoolTx(t, alice, 2, 10)
+	bob0 := newPoolTx(t, bob, 0, 50)
+	for _, tx := range []*core.Transaction{alice0, alice1, alice2, bob0} {
+		assert.Nil(t, p.Add(tx))
+	}
+
+	// alice0 is mined and alice spent most of her balance elsewhere, so she
+	// can only pay for alice1 now.
+	state.nonces[aliceAddress] = 1
+	state.balances[aliceAddress] = 15
+	b, err := core.NewBlock(&core.Header{Height: 1}, []*core.Transaction{alice0})
+	assert.Nil(t, err)
+
+	dropped := p.Reset(core.ChainEvent{Connected: []*core.Block{b}})
+	assert.Equal(t, []*core.Transaction{alice2}, dropped)
+	assert.Equal(t, []*core.Transaction{bob0, alice1}, p.Pending(10))
+
+	// A reorg brings alice0 back.
+	state.nonces[aliceAddress] = 0
+	state.balances[aliceAddress] = 100
+	assert.Empty(t, p.Reset(core.ChainEvent{Orphaned: []*core.Transaction{alice0}}))
+	assert.Equal(t, []*core.Transaction{bob0, alice0, alice1}, p.Pending(10))
 }
 
-func TestTxSortedMapAdd(t *testing.T) {
-	m := NewTxSortedMap()
-	n := 100
+func TestTxPoolFollowsChain(t *testing.T) {
+	key := newPoolKey(t)
+	bc, err := core.NewBlockchain(core.NewMemStore(), log.NewNopLogger(), core.NewAccountState(), newTestBlock(t, nil, nil, blockOpts{}))
+	assert.Nil(t, err)
+
+	p := network.NewTxPool(10)
+	p.SetStateSource(bc)
+	bc.Subscribe(func(ev core.ChainEvent) {
+		p.Reset(ev)
+	})
+
+	tx0 := newPoolTx(t, key, 0, 0)
+	tx1 := newPoolTx(t, key, 1, 0)
+	assert.Nil(t, p.Add(tx0))
+	assert.Nil(t, p.Add(tx1))
 
-	for i := 0; i < n; i++ {
-		tx := utils.NewRandomTransaction(100)
-		m.Add(tx)
-		// cannot add the same twice
-		m.Add(tx)
+	assert.Nil(t, bc.AddBlock(newTestBlock(t, bc, nil, blockOpts{stateRoot: true}, tx0)))
+	assert.False(t, p.Contains(tx0.Hash(core.TxHasher{})))
+	assert.Equal(t, []*core.Transaction{tx1}, p.Pending(10))
+}
 
-		assert.Equal(t, m.Count(), i+1)
-		assert.True(t, m.Contains(tx.Hash(core.TxHasher{})))
-		assert.Equal(t, len(m.lookup), m.txx.Len())
-		assert.Equal(t, m.Get(tx.Hash(core.TxHasher{})), tx)
+func assertRejected(t *testing.T, err error, reason network.RejectReason) {
+	var rejected *network.TxRejectedError
+	if assert.ErrorAs(t, err, &rejected) {
+		assert.Equal(t, reason, rejected.Reason)
+		assert.Equal(t, reason.String(), rejected.RejectReason())
 	}
+}
+
+type poolState struct {
+	nonces   map[types.Address]uint64
+	balances map[types.Address]uint64
+}
+
+func (s *poolState) NextNonce(address types.Address) uint64 {
+	return s.nonces[address]
+}
 
-	m.Clear()
-	assert.Equal(t, m.Count(), 0)
-	assert.Equal(t, len(m.lookup), 0)
-	assert.Equal(t, m.txx.Len(), 0)
+func (s *poolState) Balance(address types.Address) uint64 {
+	return s.balances[address]
 }
 
-func TestTxSortedMapRemove(t *testing.T) {
-	m := NewTxSortedMap()
+func newPoolKey(t *testing.T) *crypto.PrivateKey {
+	privKey, _, err := crypto.GenerateKeyPair()
+	assert.Nil(t, err)
 
-	tx := utils.NewRandomTransaction(100)
-	m.Add(tx)
-	assert.Equal(t, m.Count(), 1)
+	return privKey
+}
+
+func newPoolTx(t *testing.T, privKey *crypto.PrivateKey, nonce, fee uint64) *core.Transaction {
+	tx := core.NewTransaction(nil)
+	tx.Nonce = nonce
+	tx.Fee = fee
+	assert.Nil(t, tx.Sign(privKey))
 
-	m.Remove(tx.Hash(core.TxHasher{}))
-	assert.Equal(t, m.Count(), 0)
-	assert.False(t, m.Contains(tx.Hash(core.TxHasher{})))
-}
\ No newline at end of file
+	return tx
+}
@@ -1,32 +1,207 @@
 package tests
 
 import (
+	"crypto/sha256"
+	"encoding/binary"
+	"math"
 	"testing"
 
+	"github.com/blu-fi-tech-inc/blufi-network/core"
+	"github.com/blu-fi-tech-inc/blufi-network/types"
 	"github.com/stretchr/testify/assert"
 )
 
 func TestStack(t *testing.T) {
-	s := NewStack(128)
+	s := core.NewStack(128)
 
-	s.Push(1)
-	s.Push(2)
+	assert.Nil(t, s.Push(1))
+	assert.Nil(t, s.Push(2))
 
-	value := s.Pop()
+	value, err := s.Pop()
+	assert.Nil(t, err)
+	assert.Equal(t, value, 2)
+
+	value, err = s.Pop()
+	assert.Nil(t, err)
 	assert.Equal(t, value, 1)
 
-	value = s.Pop()
-	assert.Equal(t, value, 2)
+	_, err = s.Pop()
+	assert.ErrorIs(t, err, core.ErrStackUnderflow)
 }
 
 func TestVM(t *testing.T) {
-	data := []byte{0x03, 0x0a, 0x46, 0x0c, 0x4f, 0x0c, 0x4f, 0x0c, 0x0d, 0x05, 0x0a, 0x0f}
-	contractState := NewState()
-	vm := NewVM(data, contractState)
+	code := concat(
+		pushInt(5),
+		pushBytes("FOO"),
+		instr(core.InstrStore),
+	)
+	contractState := core.NewState()
+	vm := core.NewVM(code, contractState, core.CallContext{}, 100_000)
 	assert.Nil(t, vm.Run())
 
-	valueBytes, err := contractState.Get([]byte("FOO"))
-	value := deserializeInt64(valueBytes)
+	valueBytes, err := contractState.GetStorage(types.Address{}, []byte("FOO"))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(5), int64(binary.LittleEndian.Uint64(valueBytes)))
+
+	// Packing more bytes than the stack holds fails before allocating them.
+	code = concat(pushInt(1<<40), instr(core.InstrPack))
+	err = core.NewVM(code, core.NewState(), core.CallContext{}, math.MaxUint64).Run()
+	assert.ErrorIs(t, err, core.ErrStackUnderflow)
+}
+
+func TestVMLoop(t *testing.T) {
+	// Sums 1..10 into "sum" by looping with a counter on the stack.
+	code := concat(
+		pushInt(10), // counter
+		instr(core.InstrJumpDest),
+		// sum += counter
+		instr(core.InstrDup), []byte{1},
+		pushBytes("sum"),
+		instr(core.InstrGet),
+		instr(core.InstrAdd),
+		pushBytes("sum"),
+		instr(core.InstrStore),
+		// counter -= 1
+		pushInt(1),
+		instr(core.InstrSwap), []byte{1},
+		instr(core.InstrSub),
+		// loop while counter > 0
+		instr(core.InstrDup), []byte{1},
+		pushInt(0),
+		instr(core.InstrSwap), []byte{1},
+		instr(core.InstrGt),
+		pushInt(9),
+		instr(core.InstrJumpIf),
+	)
+	contractState := core.NewState()
+	vm := core.NewVM(code, contractState, core.CallContext{}, 1_000_000)
+	assert.Nil(t, vm.Run())
+
+	valueBytes, err := contractState.GetStorage(types.Address{}, []byte("sum"))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(55), int64(binary.LittleEndian.Uint64(valueBytes)))
+}
+
+func TestVMArithmetic(t *testing.T) {
+	cases := []struct {
+		instr    core.Instruction
+		a, b     int64
+		expected int64
+	}{
+		{core.InstrAdd, 7, 3, 10},
+		{core.InstrSub, 7, 3, 4},
+		{core.InstrMul, 7, 3, 21},
+		{core.InstrDiv, 7, 3, 2},
+		{core.InstrMod, 7, 3, 1},
+		{core.InstrLt, 7, 3, 0},
+		{core.InstrGt, 7, 3, 1},
+		{core.InstrEq, 7, 7, 1},
+		{core.InstrAnd, 7, 0, 0},
+		{core.InstrOr, 7, 0, 1},
+		// Ints are 64 bit and wrap around.
+		{core.InstrAdd, math.MaxInt64, 1, math.MinInt64},
+		{core.InstrMul, 1 << 32, 1 << 32, 0},
+		{core.InstrGt, 1 << 40, -1, 1},
+		{core.InstrDiv, math.MinInt64, -1, math.MinInt64},
+	}
+
+	for _, c := range cases {
+		// b is pushed first so that a is on top.
+		code := concat(pushInt(c.b), pushInt(c.a), instr(c.instr), pushBytes("r"), instr(core.InstrStore))
+		contractState := core.NewState()
+		assert.Nil(t, core.NewVM(code, contractState, core.CallContext{}, 100_000).Run())
+
+		valueBytes, err := contractState.GetStorage(types.Address{}, []byte("r"))
+		assert.Nil(t, err)
+		assert.Equal(t, c.expected, int64(binary.LittleEndian.Uint64(valueBytes)), "instruction %d", c.instr)
+	}
+
+	code := concat(pushInt(0), pushInt(1), instr(core.InstrDiv))
+	assert.ErrorIs(t, core.NewVM(code, core.NewState(), core.CallContext{}, 100_000).Run(), core.ErrDivisionByZero)
+}
+
+func TestVMInvalidJump(t *testing.T) {
+	// Offset 1 is inside the immediate of the first push, even though the byte
+	// there is an InstrJumpDest.
+	code := concat(
+		instr(core.InstrPushInt), []byte{byte(core.InstrJumpDest), 0, 0, 0, 0, 0, 0, 0},
+		pushInt(1),
+		instr(core.InstrJump),
+	)
+	err := core.NewVM(code, core.NewState(), core.CallContext{}, 100_000).Run()
+	assert.ErrorIs(t, err, core.ErrInvalidJump)
+
+	for _, dest := range []int64{-1, math.MaxInt64, 1 << 32} {
+		err = core.NewVM(concat(pushInt(dest), instr(core.InstrJump)), core.NewState(), core.CallContext{}, 100_000).Run()
+		assert.ErrorIs(t, err, core.ErrInvalidJump)
+	}
+
+	err = core.NewVM([]byte{byte(core.InstrPushInt), 0}, core.NewState(), core.CallContext{}, 100_000).Run()
+	assert.ErrorIs(t, err, core.ErrTruncatedImmediate)
+}
+
+func TestVMHashAndContext(t *testing.T) {
+	caller := types.Address{0x01, 0x02}
+	ctx := core.CallContext{Caller: caller, Value: 42, Height: 7}
+
+	code := concat(
+		// "height" = height + value
+		instr(core.InstrHeight),
+		instr(core.InstrCallValue),
+		instr(core.InstrAdd),
+		pushBytes("height"),
+		instr(core.InstrStore),
+		// "caller" = sha256(caller) == expected
+		pushBytes(string(sha256Sum(caller.ToSlice()))),
+		instr(core.InstrCaller),
+		instr(core.InstrSha256),
+		instr(core.InstrEq),
+		pushBytes("caller"),
+		instr(core.InstrStore),
+		instr(core.InstrStop),
+		instr(core.InstrAdd), // never reached
+	)
+	contractState := core.NewState()
+	assert.Nil(t, core.NewVM(code, contractState, ctx, 1_000_000).Run())
+
+	valueBytes, err := contractState.GetStorage(types.Address{}, []byte("height"))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(49), int64(binary.LittleEndian.Uint64(valueBytes)))
+
+	valueBytes, err = contractState.GetStorage(types.Address{}, []byte("caller"))
 	assert.Nil(t, err)
-	assert.Equal(t, value, int64(5))
-}
\ No newline at end of file
+	assert.Equal(t, int64(1), int64(binary.LittleEndian.Uint64(valueBytes)))
+}
+
+func instr(i core.Instruction) []byte {
+	return []byte{byte(i)}
+}
+
+func pushInt(v int64) []byte {
+	code := make([]byte, 9)
+	code[0] = byte(core.InstrPushInt)
+	binary.BigEndian.PutUint64(code[1:], uint64(v))
+	return code
+}
+
+// pushBytes pushes s as a byte slice using InstrPushByte and InstrPack.
+func pushBytes(s string) []byte {
+	code := []byte{}
+	for i := len(s) - 1; i >= 0; i-- {
+		code = append(code, byte(core.InstrPushByte), s[i])
+	}
+	return concat(code, pushInt(int64(len(s))), instr(core.InstrPack))
+}
+
+func concat(parts ...[]byte) []byte {
+	code := []byte{}
+	for _, part := range parts {
+		code = append(code, part...)
+	}
+	return code
+}
+
+func sha256Sum(b []byte) []byte {
+	hash := sha256.Sum256(b)
+	return hash[:]
+}
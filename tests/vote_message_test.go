@@ -0,0 +1,49 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/gob"
+	"testing"
+
+	"github.com/blu-fi-tech-inc/blufi-network/consensus"
+	"github.com/blu-fi-tech-inc/blufi-network/crypto"
+	"github.com/blu-fi-tech-inc/blufi-network/network"
+	"github.com/blu-fi-tech-inc/blufi-network/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecodeVoteMessage(t *testing.T) {
+	privKey, _, err := crypto.GenerateKeyPair()
+	assert.Nil(t, err)
+
+	for _, tc := range []struct {
+		typ      network.MessageType
+		voteType consensus.VoteType
+		ok       bool
+	}{
+		{network.MessageTypePrevote, consensus.VotePrevote, true},
+		{network.MessageTypePrecommit, consensus.VotePrecommit, true},
+		{network.MessageTypePrecommit, consensus.VotePrevote, false},
+		{network.MessageTypePrevote, consensus.VotePrecommit, false},
+	} {
+		vote := consensus.NewVote(tc.voteType, 7, 1, types.Hash{1})
+		assert.Nil(t, vote.Sign(privKey))
+
+		buf := &bytes.Buffer{}
+		assert.Nil(t, gob.NewEncoder(buf).Encode(&network.VoteMessage{Vote: vote}))
+
+		msg, err := network.DefaultRPCDecodeFunc(network.RPC{
+			Type:    tc.typ,
+			Payload: buf,
+		})
+		if !tc.ok {
+			assert.NotNil(t, err)
+			continue
+		}
+		assert.Nil(t, err)
+
+		decoded := msg.Data.(*network.VoteMessage).Vote
+		assert.Nil(t, decoded.Verify())
+		assert.Equal(t, vote.Hash(), decoded.Hash())
+	}
+}
@@ -8,6 +8,11 @@ import (
 // Address represents a 20-byte address.
 type Address [20]byte
 
+// IsZero checks if the Address is all zeros.
+func (a Address) IsZero() bool {
+	return a == Address{}
+}
+
 // ToSlice converts the Address to a byte slice.
 func (a Address) ToSlice() []byte {
 	return a[:]
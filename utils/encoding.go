@@ -3,6 +3,8 @@ package utils
 import (
 	"encoding/gob"
 	"io"
+
+	"github.com/blu-fi-tech-inc/blufi-network/core"
 )
 
 // Encoder is an interface for encoding a type to an io.Writer.
@@ -26,7 +28,7 @@ func NewGobTxEncoder(w io.Writer) *GobTxEncoder {
 	}
 }
 
-func (enc *GobTxEncoder) Encode(tx *Transaction) error {
+func (enc *GobTxEncoder) Encode(tx *core.Transaction) error {
 	return gob.NewEncoder(enc.w).Encode(tx)
 }
 
@@ -41,7 +43,7 @@ func NewGobTxDecoder(r io.Reader) *GobTxDecoder {
 	}
 }
 
-func (dec *GobTxDecoder) Decode(tx *Transaction) error {
+func (dec *GobTxDecoder) Decode(tx *core.Transaction) error {
 	return gob.NewDecoder(dec.r).Decode(tx)
 }
 
@@ -56,7 +58,7 @@ func NewGobBlockEncoder(w io.Writer) *GobBlockEncoder {
 	}
 }
 
-func (enc *GobBlockEncoder) Encode(b *Block) error {
+func (enc *GobBlockEncoder) Encode(b *core.Block) error {
 	return gob.NewEncoder(enc.w).Encode(b)
 }
 
@@ -71,6 +73,6 @@ func NewGobBlockDecoder(r io.Reader) *GobBlockDecoder {
 	}
 }
 
-func (dec *GobBlockDecoder) Decode(b *Block) error {
+func (dec *GobBlockDecoder) Decode(b *core.Block) error {
 	return gob.NewDecoder(dec.r).Decode(b)
 }
@@ -20,16 +20,26 @@ func RandomBytes(size int) []byte {
 
 // RandomHash generates a random Hash.
 func RandomHash() types.Hash {
-	return types.HashFromBytes(RandomBytes(32))
+	hash, _ := types.HashFromBytes(RandomBytes(32))
+	return hash
+}
+
+// RandomAddress generates a random Address.
+func RandomAddress() types.Address {
+	address, _ := types.AddressFromBytes(RandomBytes(20))
+	return address
 }
 
 // NewRandomTransaction creates a new random transaction without signature.
 func NewRandomTransaction(size int) *core.Transaction {
-	return core.NewTransaction(types.Address(RandomHash()), types.Address(RandomHash()), uint64(rand.Intn(1000)), RandomBytes(size))
+	tx := core.NewTransaction(RandomBytes(size))
+	tx.To = RandomAddress()
+	tx.Value = uint64(rand.Intn(1000))
+	return tx
 }
 
 // NewRandomTransactionWithSignature creates a new random transaction and signs it with the provided private key.
-func NewRandomTransactionWithSignature(t *testing.T, privKey crypto.PrivateKey, size int) *core.Transaction {
+func NewRandomTransactionWithSignature(t *testing.T, privKey *crypto.PrivateKey, size int) *core.Transaction {
 	tx := NewRandomTransaction(size)
 	assert.Nil(t, tx.Sign(privKey))
 	return tx
@@ -37,7 +47,8 @@ func NewRandomTransactionWithSignature(t *testing.T, privKey crypto.PrivateKey,
 
 // NewRandomBlock creates a new random block with a single random signed transaction.
 func NewRandomBlock(t *testing.T, height uint32, prevBlockHash types.Hash) *core.Block {
-	txSigner := crypto.GeneratePrivateKey()
+	txSigner, _, err := crypto.GenerateKeyPair()
+	assert.Nil(t, err)
 	tx := NewRandomTransactionWithSignature(t, txSigner, 100)
 	header := &core.Header{
 		Version:       1,
@@ -48,15 +59,13 @@ func NewRandomBlock(t *testing.T, height uint32, prevBlockHash types.Hash) *core
 	b, err := core.NewBlock(header, []*core.Transaction{tx})
 	assert.Nil(t, err)
 
-	dataHash, err := core.CalculateDataHash(b.Transactions)
-	assert.Nil(t, err)
-	b.Header.DataHash = dataHash
+	b.Header.DataHash = core.CalculateTxRoot(b.Transactions)
 
 	return b
 }
 
 // NewRandomBlockWithSignature creates a new random block and signs it with the provided private key.
-func NewRandomBlockWithSignature(t *testing.T, pk crypto.PrivateKey, height uint32, prevHash types.Hash) *core.Block {
+func NewRandomBlockWithSignature(t *testing.T, pk *crypto.PrivateKey, height uint32, prevHash types.Hash) *core.Block {
 	b := NewRandomBlock(t, height, prevHash)
 	assert.Nil(t, b.Sign(pk))
 	return b